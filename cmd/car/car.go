@@ -3,8 +3,9 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 
-	"github.com/multiformats/go-multicodec"
+	carv2 "github.com/ipld/go-car/v2"
 	"github.com/urfave/cli/v2"
 )
 
@@ -16,8 +17,12 @@ func main1() int {
 		Usage: "Utility for working with car files",
 		Commands: []*cli.Command{
 			{
-				Name:   "compile",
-				Usage:  "compile a car file from a debug patch",
+				Name:  "compile",
+				Usage: "compile a car file from a debug patch",
+				Description: describe("A debug patch, as written by car debug, is compiled back into a car, such that cars can be edited by hand.",
+					"car debug -o file.patch file.car",
+					"car compile -o file.car file.patch",
+				),
 				Action: CompileCar,
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -29,31 +34,67 @@ func main1() int {
 				},
 			},
 			{
-				Name:    "create",
-				Usage:   "Create a car file",
+				Name:  "create",
+				Usage: "Create a car file",
+				Description: describe("The files and directories given are encoded as UnixFS and written to a car, wrapped in a directory unless --no-wrap is set. The root of the car is that of the resulting DAG.",
+					"car create --file=out.car foo.txt dir",
+					"car create --version=1 --file=- dir > out.car",
+					"car create --file=padded.car --piece-size=2KiB --commp foo.txt",
+				),
 				Aliases: []string{"c"},
 				Action:  CreateCar,
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:      "file",
 						Aliases:   []string{"f", "output", "o"},
-						Usage:     "The car file to write to",
+						Usage:     "The car file to write to, or '-' to stream a CARv1 to stdout",
 						TakesFile: true,
 					},
 					&cli.BoolFlag{
 						Name:  "no-wrap",
 						Usage: "Do not wrap the files in a directory",
 					},
-					&cli.IntFlag{
-						Name:  "version",
-						Value: 2,
-						Usage: "Write output as a v1 or v2 format car",
+					&cli.BoolFlag{
+						Name:  "no-hidden",
+						Usage: "Exclude files and directories whose name starts with a dot",
 					},
-				},
+					&cli.BoolFlag{
+						Name:  "follow-symlinks",
+						Usage: "Encode the targets of symbolic links rather than the links themselves, which are otherwise encoded as UnixFS symlinks",
+					},
+					&cli.StringFlag{
+						Name:      "from-car-blocks",
+						Usage:     "Build a file from the raw blocks of the given car, in order, writing only the missing UnixFS nodes; the argument, if any, names the file",
+						TakesFile: true,
+					},
+					&cli.BoolFlag{
+						Name:  "preserve-mode",
+						Usage: "Record the permissions of files and directories as UnixFS 1.5 metadata",
+					},
+					&cli.BoolFlag{
+						Name:  "preserve-mtime",
+						Usage: "Record the modification time of files and directories as UnixFS 1.5 metadata",
+					},
+					&cli.StringFlag{
+						Name:  "ignore-file",
+						Usage: "Name of the file at the root of source directories listing gitignore-style patterns to exclude, e.g. .carignore",
+					},
+					&cli.StringFlag{
+						Name:  "piece-size",
+						Usage: "Pad the car with zeros to fill a Filecoin piece of the given padded size, e.g. 32GiB; readers of a padded CARv1 must treat zero-length sections as its end",
+					},
+					&cli.BoolFlag{
+						Name:  "commp",
+						Usage: "Print the piece CID (commP) of the car, padded to --piece-size or else to the smallest piece fitting it",
+					},
+				}, writeFlags()...),
 			},
 			{
-				Name:   "debug",
-				Usage:  "debug a car file",
+				Name:  "debug",
+				Usage: "debug a car file",
+				Description: describe("The car is written as a human readable patch, which can be edited and compiled back into a car with car compile.",
+					"car debug -o file.patch file.car",
+				),
 				Action: DebugCar,
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -65,19 +106,68 @@ func main1() int {
 				},
 			},
 			{
-				Name:   "detach-index",
-				Usage:  "Detach an index to a detached file",
+				Name:  "detach-index",
+				Usage: "Detach an index to a detached file",
+				Description: describe("The index of a CARv2 is written to the given file, or to stdout if none is given. Its offsets are relative to the data payload, unless --absolute is set.",
+					"car detach-index file.car file.car.idx",
+					"car detach-index list file.car.idx",
+				),
 				Action: DetachCar,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "absolute",
+						Usage: "Rebase offsets to the start of the CARv2 rather than its data payload",
+					},
+				},
 				Subcommands: []*cli.Command{{
-					Name:   "list",
-					Usage:  "List a detached index",
+					Name:  "list",
+					Usage: "List a detached index",
+					Description: describe("The multihash and offset of each record of a detached index are listed, one per line.",
+						"car detach-index list file.car.idx",
+					),
 					Action: DetachCarList,
 				}},
 			},
 			{
-				Name:      "extract",
-				Aliases:   []string{"x"},
-				Usage:     "Extract the contents of a car when the car encodes UnixFS data",
+				Name:  "diff",
+				Usage: "List the differences between two cars",
+				Description: describe("The blocks in only one of the cars are listed, or with --dag the paths added, removed or changed between the DAGs under their roots. The command exits with an error if the cars differ.",
+					"car diff a.car b.car",
+					"car diff --dag a.car b.car",
+				),
+				Action:    DiffCar,
+				ArgsUsage: "<a.car> <b.car>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dag",
+						Usage: "Compare the DAGs under the roots of the cars, listing the paths added, removed or changed, rather than the blocks they contain",
+					},
+				},
+			},
+			{
+				Name:      "docs",
+				Usage:     "Write the manual of car, generated from its commands",
+				Hidden:    true,
+				Action:    DocsCar,
+				ArgsUsage: "[output file]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "man",
+						Usage: "The format of the manual, 'man' or 'markdown'",
+					},
+				},
+			},
+			{
+				Name:    "extract",
+				Aliases: []string{"x"},
+				Usage:   "Extract the contents of a car when the car encodes UnixFS data",
+				Description: describe("The UnixFS files and directories under the roots of the car are written to the output directory, which defaults to the current directory. A single file may be written to stdout with '-'.\n\nA range of the bytes of a single file may be extracted with --range, in which case only the blocks holding the range are read, making use of the index of the car if it has one.",
+					"car extract -f file.car out",
+					"car extract -f file.car -p /dir/foo.txt -",
+					"car extract -f file.car -p /dir/foo.txt --range 100-199 -",
+					"car extract --dry-run -f file.car",
+				),
 				Action:    ExtractCar,
 				ArgsUsage: "[output directory|-]",
 				Flags: []cli.Flag{
@@ -99,14 +189,44 @@ func main1() int {
 						Aliases: []string{"v"},
 						Usage:   "Include verbose information about extracted contents",
 					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List the files and directories that would be extracted, and their sizes, without writing anything",
+					},
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "Verify that the data of each block matches its CID while extracting, failing on a mismatch",
+					},
+					&cli.StringFlag{
+						Name:  "mode",
+						Value: "preserve",
+						Usage: "Permissions of extracted entries: 'preserve' those recorded in the car, 'ignore' them, or an octal mode to apply to all files",
+					},
+					&cli.StringFlag{
+						Name:  "mtime",
+						Value: "preserve",
+						Usage: "Modification time of extracted entries: 'preserve' the one recorded in the car, 'ignore' it, or an RFC 3339 time to apply to all entries",
+					},
+					&cli.StringFlag{
+						Name:  "owner",
+						Usage: "Numeric `UID[:GID]` to change the owner of extracted entries to",
+					},
+					&cli.StringFlag{
+						Name:  "range",
+						Usage: "Only extract the bytes from START up to and including END of a single file, or up to its end if END is omitted, as `START-[END]`",
+					},
 				},
 			},
 			{
 				Name:    "filter",
 				Aliases: []string{"f"},
 				Usage:   "Filter the CIDs in a car",
-				Action:  FilterCar,
-				Flags: []cli.Flag{
+				Description: describe("The blocks of the input car whose CIDs are listed in --cid-file, or on stdin, are written to the output car; with --inverse, all other blocks are.",
+					"car filter --cid-file cids.txt file.car filtered.car",
+					"car filter --inverse --cid-file cids.txt file.car filtered.car",
+				),
+				Action: FilterCar,
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:      "cid-file",
 						Usage:     "A file to read CIDs from",
@@ -121,82 +241,187 @@ func main1() int {
 						Usage: "Inverse the filter (this will remove cids from the car file)",
 						Value: false,
 					},
-					&cli.IntFlag{
-						Name:  "version",
-						Value: 2,
-						Usage: "Write output as a v1 or v2 format car",
-					},
-				},
+					trustedFlag(),
+				}, writeFlags()...),
 			},
 			{
 				Name:    "get-block",
 				Aliases: []string{"gb"},
 				Usage:   "Get a block out of a car",
-				Action:  GetCarBlock,
+				Description: describe("The data of the block with the given CID is written to the output file, or to stdout if none is given.",
+					"car get-block file.car <cid> block.bin",
+					"car get-block --car-path-from-index-dir cars <cid>",
+				),
+				Action: GetCarBlock,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "car-path-from-index-dir",
+						Usage:     "Search the cars in the given directory, using their detached indexes where present, instead of taking a car path argument",
+						TakesFile: true,
+					},
+				},
 			},
 			{
 				Name:    "get-dag",
 				Aliases: []string{"gd"},
 				Usage:   "Get a dag out of a car",
-				Action:  GetCarDag,
-				Flags: []cli.Flag{
+				Description: describe("The blocks of the DAG under the given root, which defaults to the only root of the car, and matching the selector, which defaults to the whole DAG, are written to the output car.",
+					"car get-dag file.car out.car",
+					"car get-dag --preset all file.car <root cid> out.car",
+					"car get-dag --selector-file selector.json file.car out.car",
+				),
+				Action: GetCarDag,
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "selector",
 						Aliases: []string{"s"},
 						Usage:   "A selector over the dag",
 					},
+					&cli.StringFlag{
+						Name:      "selector-file",
+						Usage:     "A file holding a selector over the dag, in JSON",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:  "preset",
+						Usage: "A named selector over the dag, one of " + strings.Join(carv2.SelectorPresets(), ", "),
+					},
 					&cli.BoolFlag{
 						Name:  "strict",
 						Usage: "Fail if the selector finds links to blocks not in the original car",
 					},
-					&cli.IntFlag{
-						Name:  "version",
-						Value: 2,
-						Usage: "Write output as a v1 or v2 format car",
+					&cli.StringFlag{
+						Name:      "exclude-cids",
+						Usage:     "A file listing CIDs one per line, a detached index or a car, whose blocks are omitted from the output",
+						TakesFile: true,
 					},
-				},
+				}, writeFlags()...),
 			},
 			{
 				Name:    "index",
 				Aliases: []string{"i"},
 				Usage:   "write out the car with an index",
-				Action:  IndexCar,
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "codec",
-						Aliases: []string{"c"},
-						Usage:   "The type of index to write",
-						Value:   multicodec.CarMultihashIndexSorted.String(),
+				Description: describe("The car is written to the output file, or to stdout if none is given, with an index of the given codec.",
+					"car index file.car indexed.car",
+					"car index --version=1 file.car out.car",
+				),
+				Action: IndexCar,
+				Flags:  writeFlags(),
+				Subcommands: []*cli.Command{
+					{
+						Name:  "attach",
+						Usage: "Attach a detached index to a car, rebasing its offsets as needed",
+						Description: describe("The resulting CARv2 is written to the output car, or in place of the input car with --in-place. Either may be '-' for stdin or stdout.",
+							"car index attach --from file.car.idx file.car indexed.car",
+							"car index attach --in-place --from file.car.idx file.car",
+						),
+						Action:    AttachIndex,
+						ArgsUsage: "[input car|-] [output car|-]",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{
+								Name:      "from",
+								Usage:     "The detached index to attach",
+								TakesFile: true,
+								Required:  true,
+							},
+							&cli.BoolFlag{
+								Name:  "in-place",
+								Usage: "Replace the input car with the resulting CARv2",
+							},
+						}, writeFlags()...),
+					},
+					{
+						Name:  "create",
+						Usage: "Write out a detached index",
+						Description: describe("An index of the given codec is generated for the car, and written to the output file or to stdout.",
+							"car index create file.car file.car.idx",
+							"car index --index-codec car-index-sorted create file.car file.car.idx",
+						),
+						Action: CreateIndex,
 					},
-					&cli.IntFlag{
-						Name:  "version",
-						Value: 2,
-						Usage: "Write output as a v1 or v2 format car",
+					{
+						Name:  "stat",
+						Usage: "Report on the buckets of the index of a car, or of a detached index",
+						Description: describe("For each bucket of the index, the number of records and the width of their digests is reported.",
+							"car index stat file.car",
+							"car index stat --validate-sorted file.car.idx",
+						),
+						Action:    IndexStat,
+						ArgsUsage: "<file.car|file.idx>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "validate-sorted",
+								Usage: "Check that the records of each bucket are sorted and free of duplicates",
+							},
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "Check an index against one regenerated from the data payload of a car",
+						Description: describe("The index of the car, or the detached index given, is compared with one of the same codec regenerated from the data payload of the car. Records missing from the index are listed with '-', and records it holds in excess with '+', and the command fails if there are any. The offsets of a detached index may be relative to the data payload or to the start of the CARv2.",
+							"car index verify file.car",
+							"car index verify --from file.car.idx file.car",
+						),
+						Action:    IndexVerify,
+						ArgsUsage: "<file.car>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:      "from",
+								Usage:     "A detached index to verify, instead of the index of the car",
+								TakesFile: true,
+							},
+						},
 					},
 				},
-				Subcommands: []*cli.Command{{
-					Name:   "create",
-					Usage:  "Write out a detached index",
-					Action: CreateIndex,
-				}},
 			},
 			{
-				Name:   "inspect",
-				Usage:  "verifies a car and prints a basic report about its contents",
+				Name:    "inspect",
+				Aliases: []string{"stats"},
+				Usage:   "verifies a car and prints a basic report about its contents",
+				Description: describe("The car is read from the given file or from stdin, and its version, roots, index, and blocks by codec and multihash type are reported.",
+					"car inspect file.car",
+					"car inspect --full --codec-breakdown file.car",
+					"car inspect --sample 10% --seed 42 file.car",
+				),
 				Action: InspectCar,
 				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "codec-breakdown",
+						Usage: "Include the total block bytes per codec",
+					},
+					&cli.BoolFlag{
+						Name:  "size-histogram",
+						Usage: "Include a histogram of block lengths in power-of-two buckets",
+					},
 					&cli.BoolFlag{
 						Name:  "full",
 						Value: false,
 						Usage: "Check that the block data hash digests match the CIDs",
 					},
+					&cli.StringFlag{
+						Name:  "sample",
+						Usage: "Check that the hash digests of a random sample of blocks match their CIDs, given as a number of blocks or a percentage, e.g. 10%",
+					},
+					&cli.Int64Flag{
+						Name:  "seed",
+						Usage: "Seed used to select the sampled blocks; random by default",
+					},
+					&cli.BoolFlag{
+						Name:  "header-only",
+						Usage: "Only report on the header and index, without reading the data payload",
+					},
 				},
 			},
 			{
 				Name:    "list",
 				Aliases: []string{"l", "ls"},
 				Usage:   "List the CIDs in a car",
-				Action:  ListCar,
+				Description: describe("The CIDs of the blocks of the car, read from the given file or from stdin, are listed to the given output file or to stdout.",
+					"car list file.car",
+					"car list --roots-only file.car",
+					"car ls --tree --size file.car",
+				),
+				Action: ListCar,
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
 						Name:    "verbose",
@@ -211,18 +436,170 @@ func main1() int {
 						Name:  "unixfs-blocks",
 						Usage: "List blocks of unixfs objects in the car",
 					},
+					&cli.BoolFlag{
+						Name:  "tree",
+						Usage: "Render the unixfs filesystem from the root of the car as a tree",
+					},
+					&cli.BoolFlag{
+						Name:  "color",
+						Usage: "Colorize directories and symlinks in --tree output",
+					},
+					&cli.BoolFlag{
+						Name:  "size",
+						Usage: "Annotate files with their size in --tree output",
+					},
+					&cli.BoolFlag{
+						Name:  "roots-only",
+						Usage: "Only list the root CIDs, one per line, for use in scripts",
+					},
+					&cli.BoolFlag{
+						Name:  "cids-only",
+						Usage: "Only list the block CIDs, one per line, for use in scripts",
+					},
+					&cli.StringFlag{
+						Name:  "codec",
+						Usage: "Only list the blocks of the given codec, such as dag-cbor, printing their count to stderr",
+					},
+					&cli.StringFlag{
+						Name:  "mh",
+						Usage: "Only list the blocks whose CID uses the given multihash type, such as identity, printing their count to stderr",
+					},
+				},
+			},
+			{
+				Name:  "repl",
+				Usage: "Interactively explore the contents of a car",
+				Description: describe("A prompt is opened to list, inspect and follow the links of the blocks of the car. Type help at the prompt for its commands.",
+					"car repl file.car",
+				),
+				Action:    Repl,
+				ArgsUsage: "<file.car>",
+			},
+			{
+				Name:  "root",
+				Usage: "Get or replace the root CIDs of a car",
+				Description: describe("The root CIDs of the car are printed, one per line.\n\nWith --from-file, the roots of the car are instead replaced in place with the CIDs listed in the given file, one per line, or read from stdin if it is \"-\". If the new header is larger than the old one, a CARv2 absorbs the difference in the padding before its data payload where possible; otherwise, the car is rewritten.",
+					"car root file.car",
+					"car root --from-file roots.txt file.car",
+				),
+				Action:    CarRoot,
+				ArgsUsage: "<file.car>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "from-file",
+						Usage: "Replace the roots with the CIDs listed in a file, or stdin if \"-\"",
+					},
+				},
+			},
+			{
+				Name:  "sign",
+				Usage: "Sign the data payload of a car",
+				Description: describe("A signature over the data payload of the car is embedded in the padding of its CARv2, or written to a detached signature file with --output.",
+					"car sign --key private.pem file.car",
+					"car sign --key private.pem -o file.car.sig file.car",
+				),
+				Action:    SignCar,
+				ArgsUsage: "<file.car>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "key",
+						Usage:     "A PEM encoded PKCS #8 Ed25519 private key to sign with",
+						Required:  true,
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:      "output",
+						Aliases:   []string{"o"},
+						Usage:     "Write a detached signature to this file, or '-' for stdout",
+						TakesFile: true,
+					},
 				},
 			},
 			{
-				Name:   "root",
-				Usage:  "Get the root CID of a car",
-				Action: CarRoot,
+				Name:  "unwrap",
+				Usage: "Extract the CARv1 payload of a CARv2",
+				Description: describe("The CARv1 data payload of the input car is written to the output car, or in place of the input car with --in-place. Either may be '-' for stdin or stdout.",
+					"car unwrap file.car payload.car",
+					"car unwrap --in-place file.car",
+				),
+				Action:    UnwrapCar,
+				ArgsUsage: "[input car|-] [output car|-]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "in-place",
+						Usage: "Replace the input car with its CARv1 payload",
+					},
+				},
 			},
 			{
 				Name:    "verify",
 				Aliases: []string{"v"},
 				Usage:   "Verify a CAR is wellformed",
-				Action:  VerifyCar,
+				Description: describe("The header, the data of every block, the roots and the index of the car are checked, listing the problems found. With --fix, a repaired copy of the car is written.",
+					"car verify file.car",
+					"car verify --json file.car",
+					"car verify --fix file.car fixed.car",
+					"car verify --compare other.car file.car",
+				),
+				Action:    VerifyCar,
+				ArgsUsage: "<file.car> [fixed.car]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print a report of the checks made and the problems found as JSON",
+					},
+					&cli.BoolFlag{
+						Name:  "fix",
+						Usage: "Repair trailing garbage, a corrupt or missing index and a wrong data size, writing to fixed.car",
+					},
+					&cli.BoolFlag{
+						Name:  "in-place",
+						Usage: "With --fix, replace the input car with the repaired car",
+					},
+					&cli.StringFlag{
+						Name:      "compare",
+						Usage:     "Instead of verifying the car, check that its data payload is byte for byte identical to that of another car, reporting where they first differ",
+						TakesFile: true,
+					},
+				},
+			},
+			{
+				Name:  "verify-signature",
+				Usage: "Verify the signature over the data payload of a car",
+				Description: describe("The signature embedded in the car, or the detached signature given, is checked against its data payload.",
+					"car verify-signature --public-key public.pem file.car",
+					"car verify-signature --signature file.car.sig file.car",
+				),
+				Action:    VerifyCarSignature,
+				ArgsUsage: "<file.car>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "public-key",
+						Usage:     "A PEM encoded PKIX Ed25519 public key the car must be signed by; otherwise only the integrity of the payload is verified",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:      "signature",
+						Usage:     "A detached signature to verify, instead of the one embedded in the car",
+						TakesFile: true,
+					},
+				},
+			},
+			{
+				Name:  "wrap",
+				Usage: "Wrap a CARv1 as a CARv2 with an index",
+				Description: describe("The wrapped CARv2 is written to the output car, or in place of the input car with --in-place. Either may be '-' for stdin or stdout.",
+					"car wrap file.car wrapped.car",
+					"car wrap --no-index --in-place file.car",
+				),
+				Action:    WrapCar,
+				ArgsUsage: "[input car|-] [output car|-]",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "in-place",
+						Usage: "Replace the input car with the wrapped CARv2",
+					},
+				}, writeFlags()...),
 			},
 		},
 	}
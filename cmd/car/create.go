@@ -1,19 +1,31 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/dustin/go-humanize"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
 	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipld/go-car/cmd/car/lib"
 	"github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/storage/deferred"
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
@@ -23,7 +35,8 @@ import (
 // CreateCar creates a car
 func CreateCar(c *cli.Context) error {
 	var err error
-	if c.Args().Len() == 0 {
+	fromBlocks := c.String("from-car-blocks")
+	if c.Args().Len() == 0 && fromBlocks == "" {
 		return fmt.Errorf("a source location to build the car from must be specified")
 	}
 
@@ -47,14 +60,47 @@ func CreateCar(c *cli.Context) error {
 	}
 	proxyRoot := cid.NewCidV1(uint64(multicodec.DagPb), hash)
 
-	options := []car.Option{}
-	switch c.Int("version") {
-	case 1:
-		options = []car.Option{blockstore.WriteAsCarV1(true)}
-	case 2:
-		// already the default
-	default:
-		return fmt.Errorf("invalid CAR version %d", c.Int("version"))
+	options, err := writeOptions(c)
+	if err != nil {
+		return err
+	}
+	// A CARv1 without an index is streamed rather than written via a
+	// blockstore, which indexes the blocks it writes.
+	stream := c.String("file") == "-" || noIndex(c) && c.Int("version") == 1
+
+	walk := walkOptions{
+		hidden:         !c.Bool("no-hidden"),
+		followSymlinks: c.Bool("follow-symlinks"),
+		ignoreFile:     c.String("ignore-file"),
+		mode:           c.Bool("preserve-mode"),
+		mtime:          c.Bool("preserve-mtime"),
+	}
+
+	if fromBlocks != "" {
+		if c.Args().Len() > 1 {
+			return fmt.Errorf("only the name of the file may be specified with from-car-blocks")
+		}
+		if c.Args().Len() == 0 && !c.Bool("no-wrap") {
+			return fmt.Errorf("a name for the file must be specified unless no-wrap is set")
+		}
+		if stream {
+			return fmt.Errorf("from-car-blocks cannot be streamed")
+		}
+		if walk.mode || walk.mtime {
+			return fmt.Errorf("from-car-blocks cannot record file metadata")
+		}
+	}
+
+	piece, err := pieceFlags(c)
+	if err != nil {
+		return err
+	}
+
+	if stream {
+		if c.Int("version") != 1 {
+			return fmt.Errorf("cannot stream carv2's; set --version 1")
+		}
+		return streamCar(c, walk, piece, options)
 	}
 
 	cdest, err := blockstore.OpenReadWrite(c.String("file"), []cid.Cid{proxyRoot}, options...)
@@ -63,7 +109,13 @@ func CreateCar(c *cli.Context) error {
 	}
 
 	// Write the unixfs blocks into the store.
-	root, err := writeFiles(c.Context, c.Bool("no-wrap"), cdest, c.Args().Slice()...)
+	ls := blockstoreLinkSystem(c.Context, cdest)
+	var root cid.Cid
+	if fromBlocks != "" {
+		root, err = writeFileFromCarBlocks(c.Context, fromBlocks, c.Bool("no-wrap"), c.Args().First(), cdest, &ls)
+	} else {
+		root, err = writeFiles(c.Bool("no-wrap"), walk, &ls, c.Args().Slice()...)
+	}
 	if err != nil {
 		return err
 	}
@@ -72,10 +124,196 @@ func CreateCar(c *cli.Context) error {
 		return err
 	}
 	// re-open/finalize with the final root.
-	return car.ReplaceRootsInFile(c.String("file"), []cid.Cid{root})
+	if err := car.ReplaceRootsInFile(c.String("file"), []cid.Cid{root}); err != nil {
+		return err
+	}
+	return piece.finishFile(c.App.Writer, c.String("file"))
+}
+
+// pieceOptions are the options of CreateCar preparing the car as a Filecoin
+// piece.
+type pieceOptions struct {
+	// size is the padded size of the piece to pad the car to, if set.
+	size  uint64
+	commP bool
+}
+
+func pieceFlags(c *cli.Context) (pieceOptions, error) {
+	p := pieceOptions{commP: c.Bool("commp")}
+	if c.IsSet("piece-size") {
+		size, err := humanize.ParseBytes(c.String("piece-size"))
+		if err != nil {
+			return p, fmt.Errorf("invalid piece size: %w", err)
+		}
+		if err := lib.CheckPieceSize(size); err != nil {
+			return p, err
+		}
+		p.size = size
+	}
+	return p, nil
+}
+
+// pieceSize returns the padded size of the piece holding a car of the given
+// size.
+func (p pieceOptions) pieceSize(carSize uint64) (uint64, error) {
+	if p.size == 0 {
+		return lib.MinPieceSize(carSize), nil
+	}
+	if payload := lib.PiecePayloadSize(p.size); carSize > payload {
+		return 0, fmt.Errorf("car of %d bytes exceeds the %d bytes which fit a piece of size %d", carSize, payload, p.size)
+	}
+	return p.size, nil
+}
+
+// finishFile pads the car written to path to the piece size, and prints its
+// piece CID to w, as requested.
+func (p pieceOptions) finishFile(w io.Writer, path string) error {
+	if p.size == 0 && !p.commP {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	carSize := uint64(fi.Size())
+	pieceSize, err := p.pieceSize(carSize)
+	if err != nil {
+		return err
+	}
+	if p.size != 0 {
+		if err := f.Truncate(int64(lib.PiecePayloadSize(pieceSize))); err != nil {
+			return err
+		}
+	}
+	if p.commP {
+		// The zero padding is implied by the piece size.
+		h := lib.NewPieceHasher()
+		if _, err := io.Copy(h, io.LimitReader(f, int64(carSize))); err != nil {
+			return err
+		}
+		if err := printPiece(w, h, pieceSize); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func printPiece(w io.Writer, h lib.PieceHasher, pieceSize uint64) error {
+	piece, err := h.Sum(pieceSize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Piece CID: %s\n", piece)
+	fmt.Fprintf(w, "Piece size: %d\n", pieceSize)
+	return nil
+}
+
+// pieceStream tees a car streamed to an io.Writer into a PieceHasher, such that
+// it can be padded and its piece CID computed once written.
+type pieceStream struct {
+	io.Writer
+	opts    pieceOptions
+	hasher  lib.PieceHasher
+	hashErr error
+	written uint64
+}
+
+func (p pieceOptions) stream(w io.Writer) *pieceStream {
+	ps := &pieceStream{Writer: w, opts: p}
+	if p.commP {
+		ps.hasher = lib.NewPieceHasher()
+	}
+	return ps
+}
+
+func (ps *pieceStream) Write(b []byte) (int, error) {
+	n, err := ps.Writer.Write(b)
+	ps.written += uint64(n)
+	if ps.hasher != nil && ps.hashErr == nil {
+		_, ps.hashErr = ps.hasher.Write(b[:n])
+	}
+	return n, err
 }
 
-func writeFiles(ctx context.Context, noWrap bool, bs *blockstore.ReadWrite, paths ...string) (cid.Cid, error) {
+// finish pads the streamed car to the piece size, and prints its piece CID to
+// log, as requested.
+func (ps *pieceStream) finish(log io.Writer) error {
+	pieceSize, err := ps.opts.pieceSize(ps.written)
+	if err != nil {
+		return err
+	}
+	if ps.opts.size != 0 {
+		zeros := make([]byte, 32<<10)
+		for remaining := lib.PiecePayloadSize(pieceSize) - ps.written; remaining > 0; {
+			n, err := ps.Writer.Write(zeros[:min(remaining, uint64(len(zeros)))])
+			if err != nil {
+				return err
+			}
+			remaining -= uint64(n)
+		}
+	}
+	if ps.hashErr != nil {
+		return ps.hashErr
+	}
+	if ps.hasher != nil {
+		return printPiece(log, ps.hasher, pieceSize)
+	}
+	return nil
+}
+
+// streamCar writes a CARv1 without seeking or indexing, so that it can be
+// streamed, e.g. to stdout. Since the header must carry the root, which is only
+// known once the DAG is built, the sources are walked twice: once to compute
+// the root without storing any blocks, and once more to write the blocks.
+func streamCar(c *cli.Context, walk walkOptions, piece pieceOptions, options []car.Option) error {
+	ls := cidlink.DefaultLinkSystem()
+	ls.TrustedStorage = true
+	ls.StorageWriteOpener = func(_ ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error { return nil }, nil
+	}
+	root, err := writeFiles(c.Bool("no-wrap"), walk, &ls, c.Args().Slice()...)
+	if err != nil {
+		return err
+	}
+
+	var dcw *deferred.DeferredCarWriter
+	var stdout *pieceStream
+	if c.String("file") == "-" {
+		// Hide any io.WriterAt implementation, e.g. of os.Stdout, since stdout
+		// may be a pipe that cannot be written at an offset.
+		stdout = piece.stream(c.App.Writer)
+		dcw = deferred.NewDeferredCarWriterForStream(stdout, []cid.Cid{root}, options...)
+	} else {
+		dcw = deferred.NewDeferredCarWriterForPath(c.String("file"), []cid.Cid{root}, options...)
+	}
+	ls.StorageWriteOpener = dcw.BlockWriteOpener()
+	written, err := writeFiles(c.Bool("no-wrap"), walk, &ls, c.Args().Slice()...)
+	if err != nil {
+		dcw.Close()
+		return err
+	}
+	if !written.Equals(root) {
+		dcw.Close()
+		return fmt.Errorf("sources changed while being written: expected root %s, got %s", root, written)
+	}
+	if err := dcw.Close(); err != nil {
+		return err
+	}
+	if stdout != nil {
+		// The piece CID goes to stderr, since stdout carries the car.
+		return stdout.finish(c.App.ErrWriter)
+	}
+	return piece.finishFile(c.App.Writer, c.String("file"))
+}
+
+// blockstoreLinkSystem returns a link system that reads and writes blocks from
+// and to bs.
+func blockstoreLinkSystem(ctx context.Context, bs *blockstore.ReadWrite) ipld.LinkSystem {
 	ls := cidlink.DefaultLinkSystem()
 	ls.TrustedStorage = true
 	ls.StorageReadOpener = func(_ ipld.LinkContext, l ipld.Link) (io.Reader, error) {
@@ -104,10 +342,13 @@ func writeFiles(ctx context.Context, noWrap bool, bs *blockstore.ReadWrite, path
 			return nil
 		}, nil
 	}
+	return ls
+}
 
+func writeFiles(noWrap bool, walk walkOptions, ls *ipld.LinkSystem, paths ...string) (cid.Cid, error) {
 	topLevel := make([]dagpb.PBLink, 0, len(paths))
 	for _, p := range paths {
-		l, size, err := builder.BuildUnixFSRecursive(p, &ls)
+		l, size, err := walk.buildRecursive(p, ls)
 		if err != nil {
 			return cid.Undef, err
 		}
@@ -128,7 +369,7 @@ func writeFiles(ctx context.Context, noWrap bool, bs *blockstore.ReadWrite, path
 
 	// make a directory for the file(s).
 
-	root, _, err := builder.BuildUnixFSDirectory(topLevel, &ls)
+	root, _, err := builder.BuildUnixFSDirectory(topLevel, ls)
 	if err != nil {
 		return cid.Undef, nil
 	}
@@ -139,3 +380,522 @@ func writeFiles(ctx context.Context, noWrap bool, bs *blockstore.ReadWrite, path
 
 	return rcl.Cid, nil
 }
+
+// writeFileFromCarBlocks writes a UnixFS file whose data is made of the raw
+// blocks of the CAR at the given path, in the order they appear in it, to bs.
+// The raw blocks are copied as they are and only the interior nodes of the
+// file are built, using the same balanced layout as `car create`, such that
+// the leaves of a file created by `car create` result in the same file. Blocks
+// of other codecs are ignored.
+//
+// Unless noWrap is set, the file is wrapped in a directory under the given
+// name. The root of the file, or of the directory, is returned.
+func writeFileFromCarBlocks(ctx context.Context, path string, noWrap bool, name string, bs *blockstore.ReadWrite, ls *ipld.LinkSystem) (cid.Cid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer f.Close()
+	br, err := car.NewBlockReader(bufio.NewReader(f))
+	if err != nil {
+		return cid.Undef, err
+	}
+	leaves := func() (fileShard, error) {
+		for {
+			blk, err := br.Next()
+			if err != nil {
+				return fileShard{}, err
+			}
+			if blk.Cid().Prefix().Codec != cid.Raw {
+				continue
+			}
+			if err := bs.Put(ctx, blk); err != nil {
+				return fileShard{}, err
+			}
+			size := uint64(len(blk.RawData()))
+			return fileShard{c: blk.Cid(), byteSize: size, storedSize: size}, nil
+		}
+	}
+
+	var prev []fileShard
+	var root fileShard
+	for depth := 1; ; depth++ {
+		next, err := fileTree(ctx, depth, prev, leaves, bs)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if !next.c.Defined() {
+			return cid.Undef, fmt.Errorf("no raw blocks found in %s", path)
+		}
+		if prev != nil && prev[0].c.Equals(next.c) {
+			root = next
+			break
+		}
+		prev = []fileShard{next}
+	}
+	if noWrap {
+		return root.c, nil
+	}
+
+	entry, err := builder.BuildUnixFSDirectoryEntry(name, int64(root.storedSize), cidlink.Link{Cid: root.c})
+	if err != nil {
+		return cid.Undef, err
+	}
+	dir, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry}, ls)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return dir.(cidlink.Link).Cid, nil
+}
+
+// fileShard describes a node of a UnixFS file.
+type fileShard struct {
+	c cid.Cid
+	// byteSize is the size of the file data under the node.
+	byteSize uint64
+	// storedSize is the size of the blocks of the node and its descendants.
+	storedSize uint64
+}
+
+// fileTree mirrors the balanced layout of builder.BuildUnixFSFile, taking its
+// leaves from next rather than from a chunker, and returns the node of the
+// file at the given depth, adding to the given children. A zero fileShard is
+// returned once next is exhausted.
+func fileTree(ctx context.Context, depth int, children []fileShard, next func() (fileShard, error), bs *blockstore.ReadWrite) (fileShard, error) {
+	if depth == 1 {
+		leaf, err := next()
+		if err == io.EOF {
+			return fileShard{}, nil
+		}
+		return leaf, err
+	}
+
+	for len(children) < builder.DefaultLinksPerBlock {
+		child, err := fileTree(ctx, depth-1, nil, next, bs)
+		if err != nil {
+			return fileShard{}, err
+		}
+		if !child.c.Defined() {
+			break
+		}
+		children = append(children, child)
+	}
+	switch len(children) {
+	case 0:
+		return fileShard{}, nil
+	case 1:
+		return children[0], nil
+	}
+
+	var shard fileShard
+	links := make([]dagpb.PBLink, 0, len(children))
+	sizes := make([]uint64, 0, len(children))
+	for _, child := range children {
+		link, err := builder.BuildUnixFSDirectoryEntry("", int64(child.storedSize), cidlink.Link{Cid: child.c})
+		if err != nil {
+			return fileShard{}, err
+		}
+		links = append(links, link)
+		sizes = append(sizes, child.byteSize)
+		shard.byteSize += child.byteSize
+		shard.storedSize += child.storedSize
+	}
+	ufs, err := builder.BuildUnixFS(func(b *builder.Builder) {
+		builder.FileSize(b, shard.byteSize)
+		builder.BlockSizes(b, sizes)
+	})
+	if err != nil {
+		return fileShard{}, err
+	}
+	pbn, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(int64(len(links)), func(la datamodel.ListAssembler) {
+			for _, link := range links {
+				qp.ListEntry(la, qp.Node(link))
+			}
+		}))
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(ufs)))
+	})
+	if err != nil {
+		return fileShard{}, err
+	}
+	var buf bytes.Buffer
+	if err := dagpb.Encode(pbn, &buf); err != nil {
+		return fileShard{}, err
+	}
+	c, err := cid.NewPrefixV1(cid.DagProtobuf, multihash.SHA2_256).Sum(buf.Bytes())
+	if err != nil {
+		return fileShard{}, err
+	}
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		return fileShard{}, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return fileShard{}, err
+	}
+	shard.c = c
+	shard.storedSize += uint64(buf.Len())
+	return shard, nil
+}
+
+// walkOptions controls which files are included when walking a directory tree,
+// and how symbolic links are encoded.
+type walkOptions struct {
+	// hidden includes files and directories whose name starts with a dot.
+	hidden bool
+	// followSymlinks encodes the target of symbolic links instead of the links.
+	followSymlinks bool
+	// ignoreFile, if set, is the name of the file, looked up at the root of
+	// each walked directory, holding gitignore-style patterns of paths to
+	// exclude.
+	ignoreFile string
+	// mode and mtime record the permissions and modification time of files and
+	// directories as UnixFS 1.5 metadata.
+	mode, mtime bool
+}
+
+// buildRecursive builds a UnixFS DAG from the file or directory at root.
+// Unlike builder.BuildUnixFSRecursive, entries within directories are subject
+// to the walk options.
+func (w walkOptions) buildRecursive(root string, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	var ignore carIgnore
+	if info, err := os.Stat(root); err == nil && info.IsDir() && w.ignoreFile != "" {
+		if ignore, err = readCarIgnore(filepath.Join(root, w.ignoreFile)); err != nil {
+			return nil, 0, err
+		}
+	}
+	return w.build(root, "", ignore, nil, ls)
+}
+
+func (w walkOptions) build(p, rel string, ignore carIgnore, visited []string, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	stat := os.Lstat
+	if w.followSymlinks {
+		stat = os.Stat
+	}
+	info, err := stat(p)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m := info.Mode()
+	switch {
+	case m.IsDir():
+		if w.followSymlinks {
+			// Guard against symbolic links pointing to one of their own ancestors.
+			real, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, v := range visited {
+				if v == real {
+					return nil, 0, fmt.Errorf("symlink cycle detected at %s", p)
+				}
+			}
+			visited = append(visited, real)
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		var tsize uint64
+		lnks := make([]dagpb.PBLink, 0, len(entries))
+		for _, e := range entries {
+			name := e.Name()
+			if !w.hidden && strings.HasPrefix(name, ".") {
+				continue
+			}
+			erel := path.Join(rel, name)
+			ep := filepath.Join(p, name)
+			if ignore != nil {
+				isDir := e.IsDir()
+				if w.followSymlinks && e.Type() == fs.ModeSymlink {
+					if info, err := os.Stat(ep); err == nil {
+						isDir = info.IsDir()
+					}
+				}
+				if ignore.ignored(erel, isDir) {
+					continue
+				}
+			}
+			lnk, sz, err := w.build(ep, erel, ignore, visited, ls)
+			if err != nil {
+				return nil, 0, err
+			}
+			tsize += sz
+			entry, err := builder.BuildUnixFSDirectoryEntry(name, int64(sz), lnk)
+			if err != nil {
+				return nil, 0, err
+			}
+			lnks = append(lnks, entry)
+		}
+		return w.withMetadata(info, ls, func(ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+			return builder.BuildUnixFSDirectory(lnks, ls)
+		})
+	case m.Type() == fs.ModeSymlink:
+		content, err := os.Readlink(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		return builder.BuildUnixFSSymlink(content, ls)
+	case m.IsRegular():
+		fp, err := os.Open(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer fp.Close()
+		return w.withMetadata(info, ls, func(ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+			return builder.BuildUnixFSFile(fp, "", ls)
+		})
+	default:
+		return nil, 0, fmt.Errorf("cannot encode non regular file: %s", p)
+	}
+}
+
+// withMetadata builds a DAG using build, then rewrites its root node to record
+// the metadata of info as per the walk options, returning the link to the new
+// root and the size of the DAG. A file made of a single raw block is wrapped in
+// a node of its own, as raw blocks cannot carry metadata.
+func (w walkOptions) withMetadata(info fs.FileInfo, ls *ipld.LinkSystem, build func(*ipld.LinkSystem) (ipld.Link, uint64, error)) (ipld.Link, uint64, error) {
+	if !w.mode && !w.mtime {
+		return build(ls)
+	}
+
+	// The root is normally the last block written, which is kept such that it
+	// need not be read back, e.g. when blocks are discarded as they are written.
+	var last []byte
+	var lastLink ipld.Link
+	capturing := *ls
+	capturing.StorageWriteOpener = func(lc ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		bw, commit, err := ls.StorageWriteOpener(lc)
+		if err != nil {
+			return nil, nil, err
+		}
+		var buf bytes.Buffer
+		return io.MultiWriter(bw, &buf), func(l ipld.Link) error {
+			last, lastLink = buf.Bytes(), l
+			return commit(l)
+		}, nil
+	}
+	lnk, size, err := build(&capturing)
+	if err != nil {
+		return nil, 0, err
+	}
+	raw := last
+	if lastLink != lnk {
+		if raw, err = ls.LoadRaw(ipld.LinkContext{}, lnk); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var links []dagpb.PBLink
+	var ufs data.UnixFSData
+	if lnk.(cidlink.Link).Prefix().Codec == cid.Raw {
+		entry, err := builder.BuildUnixFSDirectoryEntry("", int64(len(raw)), lnk)
+		if err != nil {
+			return nil, 0, err
+		}
+		links = []dagpb.PBLink{entry}
+		if ufs, err = builder.BuildUnixFS(func(b *builder.Builder) {
+			builder.FileSize(b, uint64(len(raw)))
+			builder.BlockSizes(b, []uint64{uint64(len(raw))})
+		}); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		nb := dagpb.Type.PBNode.NewBuilder()
+		if err := dagpb.DecodeBytes(nb, raw); err != nil {
+			return nil, 0, err
+		}
+		pbn := nb.Build().(dagpb.PBNode)
+		for itr := pbn.FieldLinks().Iterator(); !itr.Done(); {
+			_, l := itr.Next()
+			links = append(links, l)
+		}
+		if ufs, err = data.DecodeUnixFSData(pbn.FieldData().Must().Bytes()); err != nil {
+			return nil, 0, err
+		}
+		// The node is replaced rather than added to.
+		size -= uint64(len(raw))
+	}
+
+	// Copy the UnixFS data, adding the metadata to it.
+	withMetadata, err := qp.BuildMap(data.Type.UnixFSData, -1, func(ma datamodel.MapAssembler) {
+		for itr := ufs.MapIterator(); !itr.Done(); {
+			k, v, err := itr.Next()
+			if err != nil {
+				panic(err)
+			}
+			name, _ := k.AsString()
+			if v.IsAbsent() || name == data.Field__Mode || name == data.Field__Mtime {
+				continue
+			}
+			qp.MapEntry(ma, name, qp.Node(v))
+		}
+		if w.mode {
+			qp.MapEntry(ma, data.Field__Mode, qp.Int(unixPermissions(info.Mode())))
+		}
+		if w.mtime {
+			qp.MapEntry(ma, data.Field__Mtime, qp.Map(-1, func(tb datamodel.MapAssembler) {
+				builder.Time(tb, info.ModTime())
+			}))
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	pbn, err := qp.BuildMap(dagpb.Type.PBNode, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "Links", qp.List(int64(len(links)), func(la datamodel.ListAssembler) {
+			for _, link := range links {
+				qp.ListEntry(la, qp.Node(link))
+			}
+		}))
+		qp.MapEntry(ma, "Data", qp.Bytes(data.EncodeUnixFSData(withMetadata.(data.UnixFSData))))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	var buf bytes.Buffer
+	if err := dagpb.Encode(pbn, &buf); err != nil {
+		return nil, 0, err
+	}
+	c, err := cid.NewPrefixV1(cid.DagProtobuf, multihash.SHA2_256).Sum(buf.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
+	bw, commit, err := ls.StorageWriteOpener(ipld.LinkContext{})
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := bw.Write(buf.Bytes()); err != nil {
+		return nil, 0, err
+	}
+	root := cidlink.Link{Cid: c}
+	if err := commit(root); err != nil {
+		return nil, 0, err
+	}
+	return root, size + uint64(buf.Len()), nil
+}
+
+// unixPermissions returns the Unix permission bits of mode, as recorded in
+// UnixFS data.
+func unixPermissions(mode fs.FileMode) int64 {
+	perm := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+	return perm
+}
+
+// carIgnore is a list of gitignore-style rules, where later rules take
+// precedence over earlier ones.
+type carIgnore []carIgnoreRule
+
+type carIgnoreRule struct {
+	// segments of the slash separated pattern.
+	segments []string
+	negate   bool
+	dirOnly  bool
+	// anchored patterns are matched against the path relative to the root,
+	// rather than against the name of the file at any level.
+	anchored bool
+}
+
+// readCarIgnore reads the ignore rules at the given path, returning no rules
+// if it does not exist.
+func readCarIgnore(p string) (carIgnore, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseCarIgnore(f)
+}
+
+func parseCarIgnore(r io.Reader) (carIgnore, error) {
+	var rules carIgnore
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule carIgnoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\") {
+			// allow escaping of a leading '#' or '!'
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		for _, seg := range rule.segments {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid ignore pattern %q: %w", scanner.Text(), err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored reports whether the given slash separated path, relative to the root
+// of the walk, should be excluded.
+func (ci carIgnore) ignored(rel string, isDir bool) bool {
+	var ignored bool
+	for _, rule := range ci {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		var matched bool
+		if rule.anchored {
+			matched = matchSegments(rule.segments, strings.Split(rel, "/"))
+		} else {
+			matched, _ = path.Match(rule.segments[0], path.Base(rel))
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path segments against pattern segments, where a "**"
+// pattern segment matches zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
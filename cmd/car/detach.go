@@ -11,7 +11,8 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
-// DetachCar is a command to output the index part of a car.
+// DetachCar is a command to output the index part of a car, optionally with
+// offsets relative to the start of the car rather than its data payload.
 func DetachCar(c *cli.Context) error {
 	r, err := carv2.OpenReader(c.Args().Get(0))
 	if err != nil {
@@ -36,7 +37,31 @@ func DetachCar(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(outStream, ir)
+	if !c.Bool("absolute") {
+		_, err = io.Copy(outStream, ir)
+		return err
+	}
+
+	// Rebase the offsets, which are relative to the data payload, to the
+	// start of the CARv2.
+	idx, err := index.ReadFrom(ir)
+	if err != nil {
+		return err
+	}
+	records, err := indexRecords(idx)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		records[i].Offset += r.Header.DataOffset
+	}
+	if idx, err = index.New(idx.Codec()); err != nil {
+		return err
+	}
+	if err := index.LoadSizedRecords(idx, records); err != nil {
+		return err
+	}
+	_, err = index.WriteTo(idx, outStream)
 	return err
 }
 
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/urfave/cli/v2"
+)
+
+// DiffCar compares two cars, either by the blocks they contain or, with --dag,
+// by the DAGs under their roots. Differences are listed one per line, prefixed
+// with "-" if only in the first car, "+" if only in the second, or "~" if
+// changed, and the command exits with status 1 if any are found.
+func DiffCar(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return fmt.Errorf("two car files must be specified")
+	}
+	var differ bool
+	var err error
+	if c.Bool("dag") {
+		differ, err = diffDags(c, c.Args().Get(0), c.Args().Get(1))
+	} else {
+		differ, err = diffBlocks(c, c.Args().Get(0), c.Args().Get(1))
+	}
+	if err != nil {
+		return err
+	}
+	if differ {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// diffBlocks lists the CIDs of the blocks only present in either car, in the
+// order in which they appear.
+func diffBlocks(c *cli.Context, pathA, pathB string) (bool, error) {
+	a, err := readBlockCids(pathA)
+	if err != nil {
+		return false, err
+	}
+	b, err := readBlockCids(pathB)
+	if err != nil {
+		return false, err
+	}
+	inA := make(map[cid.Cid]struct{}, len(a))
+	for _, k := range a {
+		inA[k] = struct{}{}
+	}
+	inB := make(map[cid.Cid]struct{}, len(b))
+	for _, k := range b {
+		inB[k] = struct{}{}
+	}
+
+	var differ bool
+	for _, k := range a {
+		if _, ok := inB[k]; !ok {
+			fmt.Fprintf(c.App.Writer, "- %s\n", k)
+			differ = true
+		}
+	}
+	for _, k := range b {
+		if _, ok := inA[k]; !ok {
+			fmt.Fprintf(c.App.Writer, "+ %s\n", k)
+			differ = true
+		}
+	}
+	return differ, nil
+}
+
+func readBlockCids(p string) ([]cid.Cid, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd, err := carv2.NewBlockReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var cids []cid.Cid
+	for {
+		blk, err := rd.SkipNext()
+		if err == io.EOF {
+			return cids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, blk.Cid)
+	}
+}
+
+// diffDags walks the DAGs under the roots of both cars, pairing the roots in
+// order, and lists the paths which were added, removed or changed.
+func diffDags(c *cli.Context, pathA, pathB string) (bool, error) {
+	a, rootsA, err := openDiffLinkSystem(pathA)
+	if err != nil {
+		return false, err
+	}
+	b, rootsB, err := openDiffLinkSystem(pathB)
+	if err != nil {
+		return false, err
+	}
+	if len(rootsA) != len(rootsB) {
+		return false, fmt.Errorf("cannot compare the DAGs of cars with %d and %d roots", len(rootsA), len(rootsB))
+	}
+
+	d := &dagDiff{ctx: c.Context, a: a, b: b, out: c.App.Writer, logger: c.App.ErrWriter}
+	for i := range rootsA {
+		if len(rootsA) > 1 {
+			// Paths are qualified by the index of their root.
+			d.prefix = fmt.Sprintf("%d:", i)
+		}
+		err := d.nodes("/", basicnode.NewLink(cidlink.Link{Cid: rootsA[i]}), basicnode.NewLink(cidlink.Link{Cid: rootsB[i]}))
+		if err != nil {
+			return false, err
+		}
+	}
+	return d.differ, nil
+}
+
+func openDiffLinkSystem(p string) (*ipld.LinkSystem, []cid.Cid, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := carstorage.OpenReadable(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	ls := cidlink.DefaultLinkSystem()
+	ls.TrustedStorage = true
+	ls.SetReadStorage(store)
+	return &ls, store.Roots(), nil
+}
+
+// dagDiff compares two DAGs, descending into UnixFS directories, as well as
+// into the maps, lists and links of other codecs such as dag-cbor. Since
+// identical CIDs identify identical DAGs, only the links which differ are
+// followed.
+type dagDiff struct {
+	ctx    context.Context
+	a, b   *ipld.LinkSystem
+	out    io.Writer
+	logger io.Writer
+	prefix string
+	differ bool
+}
+
+func (d *dagDiff) report(sign, p string) {
+	fmt.Fprintf(d.out, "%s %s%s\n", sign, d.prefix, p)
+	d.differ = true
+}
+
+func (d *dagDiff) nodes(p string, a, b datamodel.Node) error {
+	if a.Kind() != b.Kind() {
+		d.report("~", p)
+		return nil
+	}
+
+	switch a.Kind() {
+	case datamodel.Kind_Link:
+		la, err := a.AsLink()
+		if err != nil {
+			return err
+		}
+		lb, err := b.AsLink()
+		if err != nil {
+			return err
+		}
+		if la.String() == lb.String() {
+			return nil
+		}
+		na, descendA, err := d.load(d.a, p, la)
+		if err != nil {
+			return err
+		}
+		nb, descendB, err := d.load(d.b, p, lb)
+		if err != nil {
+			return err
+		}
+		if !descendA || !descendB {
+			d.report("~", p)
+			return nil
+		}
+		return d.nodes(p, na, nb)
+	case datamodel.Kind_Map:
+		entriesA, err := mapEntries(a)
+		if err != nil {
+			return err
+		}
+		entriesB, err := mapEntries(b)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(entriesA)+len(entriesB))
+		for k := range entriesA {
+			keys = append(keys, k)
+		}
+		for k := range entriesB {
+			if _, ok := entriesA[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			va, inA := entriesA[k]
+			vb, inB := entriesB[k]
+			switch {
+			case !inB:
+				d.report("-", path.Join(p, k))
+			case !inA:
+				d.report("+", path.Join(p, k))
+			default:
+				if err := d.nodes(path.Join(p, k), va, vb); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case datamodel.Kind_List:
+		lenA, lenB := a.Length(), b.Length()
+		for i := int64(0); i < max(lenA, lenB); i++ {
+			ip := path.Join(p, fmt.Sprint(i))
+			switch {
+			case i >= lenB:
+				d.report("-", ip)
+			case i >= lenA:
+				d.report("+", ip)
+			default:
+				va, err := a.LookupByIndex(i)
+				if err != nil {
+					return err
+				}
+				vb, err := b.LookupByIndex(i)
+				if err != nil {
+					return err
+				}
+				if err := d.nodes(ip, va, vb); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	default:
+		if !datamodel.DeepEqual(a, b) {
+			d.report("~", p)
+		}
+		return nil
+	}
+}
+
+// load loads the block at l, reifying it as UnixFS if it is a dag-pb block.
+// Whether the node may be descended into is returned along with it; this is
+// not the case of UnixFS files or symlinks, nor of raw blocks, whose contents
+// are only reported as changed, nor of blocks missing from the car.
+func (d *dagDiff) load(ls *ipld.LinkSystem, p string, l ipld.Link) (datamodel.Node, bool, error) {
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return nil, false, fmt.Errorf("%s: unsupported link type %T", p, l)
+	}
+	var proto datamodel.NodePrototype = basicnode.Prototype.Any
+	switch cl.Prefix().Codec {
+	case cid.Raw:
+		return nil, false, nil
+	case cid.DagProtobuf:
+		proto = dagpb.Type.PBNode
+	}
+
+	lctx := ipld.LinkContext{Ctx: d.ctx}
+	n, err := ls.Load(lctx, cl, proto)
+	if err != nil {
+		if nf, ok := err.(interface{ NotFound() bool }); ok && nf.NotFound() {
+			fmt.Fprintf(d.logger, "data for entry not found: %s%s\n", d.prefix, p)
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if cl.Prefix().Codec != cid.DagProtobuf {
+		return n, true, nil
+	}
+	rn, err := unixfsnode.Reify(lctx, n, ls)
+	if err != nil {
+		return nil, false, err
+	}
+	// Only directories are reified as maps other than the dag-pb node itself.
+	_, isPBNode := rn.(dagpb.PBNode)
+	return rn, rn.Kind() == datamodel.Kind_Map && !isPBNode, nil
+}
+
+func mapEntries(n datamodel.Node) (map[string]datamodel.Node, error) {
+	entries := make(map[string]datamodel.Node)
+	for mi := n.MapIterator(); !mi.Done(); {
+		k, v, err := mi.Next()
+		if err != nil {
+			return nil, err
+		}
+		ks, err := k.AsString()
+		if err != nil {
+			return nil, err
+		}
+		entries[ks] = v
+	}
+	return entries, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cpuguy83/go-md2man/v2/md2man"
+	"github.com/urfave/cli/v2"
+)
+
+// describe returns the long-form description of a command, shown by `car help
+// <command>` and in the manual written by `car docs`, followed by examples of
+// its use. Paragraphs of text are separated by blank lines.
+func describe(text string, examples ...string) string {
+	var b strings.Builder
+	b.WriteString(text)
+	if len(examples) > 0 {
+		b.WriteString("\n\nExamples:\n")
+		for _, example := range examples {
+			// Indented, such that markdown renders examples as code.
+			b.WriteString("\n    ")
+			b.WriteString(example)
+		}
+	}
+	return b.String()
+}
+
+// DocsCar is a command to write the manual of car, generated from the metadata
+// of its commands, as a man page or as markdown.
+func DocsCar(c *cli.Context) error {
+	var b strings.Builder
+	writeMarkdown(&b, c.App)
+
+	var doc []byte
+	switch format := c.String("format"); format {
+	case "man":
+		doc = md2man.Render([]byte(b.String()))
+	case "markdown":
+		doc = []byte(b.String())
+	default:
+		return fmt.Errorf("invalid format %q; expected man or markdown", format)
+	}
+
+	if c.Args().Present() {
+		return os.WriteFile(c.Args().First(), doc, 0o644)
+	}
+	_, err := c.App.Writer.Write(doc)
+	return err
+}
+
+// writeMarkdown writes the manual of app as markdown in the dialect of
+// go-md2man. Unlike cli.App.ToMarkdown, it includes the descriptions of the
+// commands.
+func writeMarkdown(w io.Writer, app *cli.App) {
+	fmt.Fprintf(w, "%% %s 1\n\n", app.Name)
+	fmt.Fprintf(w, "# NAME\n\n%s - %s\n\n", app.Name, app.Usage)
+	fmt.Fprintf(w, "# SYNOPSIS\n\n**%s** [*global options*] *command* [*command options*] [*arguments...*]\n\n", app.Name)
+	if flags := app.VisibleFlags(); len(flags) > 0 {
+		fmt.Fprint(w, "# GLOBAL OPTIONS\n\n")
+		writeFlagDocs(w, flags)
+	}
+	fmt.Fprint(w, "# COMMANDS\n\n")
+	// go-md2man renders headings of level 3 and deeper as subsections.
+	writeCommandDocs(w, app.Name, "", app.VisibleCommands(), 3)
+}
+
+// writeCommandDocs writes the documentation of commands, which are the
+// subcommands of the command at path, if any, of the app with the given name.
+func writeCommandDocs(w io.Writer, app, path string, commands []*cli.Command, level int) {
+	for _, cmd := range commands {
+		if cmd.Name == "help" {
+			continue
+		}
+		name := strings.TrimSpace(path + " " + cmd.Name)
+		fmt.Fprintf(w, "%s %s\n\n", strings.Repeat("#", level), strings.TrimSpace(path+" "+strings.Join(cmd.Names(), ", ")))
+		fmt.Fprintf(w, "%s\n\n", cmd.Usage)
+		synopsis := "**" + app + " " + name + "**"
+		if len(cmd.VisibleFlags()) > 0 {
+			synopsis += " [*command options*]"
+		}
+		if cmd.ArgsUsage != "" {
+			synopsis += " " + escapeMarkdown(cmd.ArgsUsage)
+		}
+		fmt.Fprintf(w, "%s\n\n", synopsis)
+		if cmd.Description != "" {
+			fmt.Fprintf(w, "%s\n\n", cmd.Description)
+		}
+		writeFlagDocs(w, cmd.VisibleFlags())
+		writeCommandDocs(w, app, name, cmd.VisibleCommands(), level+1)
+	}
+}
+
+func writeFlagDocs(w io.Writer, flags []cli.Flag) {
+	for _, flag := range flags {
+		names := make([]string, 0, len(flag.Names()))
+		for _, name := range flag.Names() {
+			if len(name) == 1 {
+				names = append(names, "-"+name)
+			} else {
+				names = append(names, "--"+name)
+			}
+		}
+		fmt.Fprintf(w, "**%s**", strings.Join(names, ", "))
+		if df, ok := flag.(cli.DocGenerationFlag); ok {
+			if df.TakesValue() {
+				fmt.Fprint(w, "=*value*")
+			}
+			fmt.Fprintf(w, "\n: %s", df.GetUsage())
+			if v := df.GetDefaultText(); v != "" && v != "false" {
+				fmt.Fprintf(w, " (default: %s)", v)
+			} else if v := df.GetValue(); df.TakesValue() && v != "" && v != `""` {
+				fmt.Fprintf(w, " (default: %s)", v)
+			}
+		}
+		fmt.Fprint(w, "\n\n")
+	}
+}
+
+// escapeMarkdown escapes the characters of s which markdown would otherwise
+// interpret, such as in the <file.car> of argument usages.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "<", `\<`, ">", `\>`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`).Replace(s)
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/cmd/car/lib"
@@ -68,7 +70,8 @@ func ExtractCar(c *cli.Context) error {
 	}
 
 	ls := cidlink.DefaultLinkSystem()
-	ls.TrustedStorage = true
+	// Unless verifying, blocks are not re-hashed on load.
+	ls.TrustedStorage = !c.Bool("verify")
 	ls.SetReadStorage(store)
 
 	path, err := pathSegments(c.String("path"))
@@ -76,9 +79,24 @@ func ExtractCar(c *cli.Context) error {
 		return err
 	}
 
+	opts := lib.ExtractOptions{Verbose: c.IsSet("verbose")}
+	if c.Bool("dry-run") {
+		opts.DryRun = c.App.Writer
+	}
+	if err := metadataOptions(c, &opts); err != nil {
+		return err
+	}
+	if c.IsSet("range") {
+		br, err := parseByteRange(c.String("range"))
+		if err != nil {
+			return err
+		}
+		opts.Range = &br
+	}
+
 	var extractedFiles int
 	for _, root := range roots {
-		count, err := lib.ExtractToDir(c.Context, &ls, root, outputDir, path, c.IsSet("verbose"), c.App.ErrWriter)
+		count, err := lib.ExtractToDirWithOptions(c.Context, &ls, root, outputDir, path, opts, c.App.ErrWriter)
 		if err != nil {
 			return err
 		}
@@ -86,6 +104,8 @@ func ExtractCar(c *cli.Context) error {
 	}
 	if extractedFiles == 0 {
 		return cli.Exit("no files extracted", 1)
+	} else if opts.DryRun != nil {
+		fmt.Fprintf(c.App.ErrWriter, "would extract %d file(s)\n", extractedFiles)
 	} else {
 		fmt.Fprintf(c.App.ErrWriter, "extracted %d file(s)\n", extractedFiles)
 	}
@@ -93,6 +113,72 @@ func ExtractCar(c *cli.Context) error {
 	return nil
 }
 
+// metadataOptions sets the policies for the metadata of extracted entries from
+// the --mode, --mtime and --owner flags.
+func metadataOptions(c *cli.Context, opts *lib.ExtractOptions) error {
+	switch mode := c.String("mode"); mode {
+	case "preserve":
+		opts.Mode = lib.MetadataPreserve
+	case "ignore":
+		opts.Mode = lib.MetadataIgnore
+	default:
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0o777 {
+			return fmt.Errorf("invalid mode %q: must be preserve, ignore or an octal permission", mode)
+		}
+		opts.Mode = lib.MetadataOverride
+		opts.FileMode = os.FileMode(m)
+	}
+
+	switch mtime := c.String("mtime"); mtime {
+	case "preserve":
+		opts.Mtime = lib.MetadataPreserve
+	case "ignore":
+		opts.Mtime = lib.MetadataIgnore
+	default:
+		t, err := time.Parse(time.RFC3339Nano, mtime)
+		if err != nil {
+			return fmt.Errorf("invalid mtime %q: must be preserve, ignore or an RFC 3339 time", mtime)
+		}
+		opts.Mtime = lib.MetadataOverride
+		opts.ModTime = t
+	}
+
+	if owner := c.String("owner"); owner != "" {
+		uid, gid, hasGID := strings.Cut(owner, ":")
+		o := &lib.Owner{GID: -1}
+		var err error
+		if o.UID, err = strconv.Atoi(uid); err != nil {
+			return fmt.Errorf("invalid owner %q: must be a numeric UID[:GID]", owner)
+		}
+		if hasGID {
+			if o.GID, err = strconv.Atoi(gid); err != nil {
+				return fmt.Errorf("invalid owner %q: must be a numeric UID[:GID]", owner)
+			}
+		}
+		opts.Owner = o
+	}
+	return nil
+}
+
+// parseByteRange parses a range of bytes given in the START-END form of HTTP
+// byte ranges, where END is inclusive and may be omitted to read to the end of
+// the file.
+func parseByteRange(s string) (lib.ByteRange, error) {
+	start, end, ok := strings.Cut(s, "-")
+	br := lib.ByteRange{End: -1}
+	var err error
+	if br.Start, err = strconv.ParseInt(start, 10, 64); !ok || err != nil || br.Start < 0 {
+		return br, fmt.Errorf("invalid range %q: must be START-END or START-", s)
+	}
+	if end != "" {
+		if br.End, err = strconv.ParseInt(end, 10, 64); err != nil || br.End < br.Start {
+			return br, fmt.Errorf("invalid range %q: must be START-END or START-, with END at least START", s)
+		}
+	}
+	return br, nil
+}
+
 // TODO: dedupe this with lassie, probably into go-unixfsnode
 func pathSegments(path string) ([]string, error) {
 	segments := strings.Split(path, "/")
@@ -118,6 +204,7 @@ var _ storage.ReadableStorage = (*stdinReadStorage)(nil)
 type stdinReadStorage struct {
 	blocks map[string][]byte
 	done   bool
+	err    error
 	lk     *sync.RWMutex
 	cond   *sync.Cond
 }
@@ -144,7 +231,13 @@ func NewStdinReadStorage(reader io.Reader) (*stdinReadStorage, []cid.Cid, error)
 				return
 			}
 			if err != nil {
-				panic(err)
+				// Blocks that fail to read or verify are reported by Get.
+				srs.lk.Lock()
+				srs.done = true
+				srs.err = err
+				srs.cond.Broadcast()
+				srs.lk.Unlock()
+				return
 			}
 			srs.lk.Lock()
 			srs.blocks[string(blk.Cid().Hash())] = blk.RawData()
@@ -174,6 +267,9 @@ func (srs *stdinReadStorage) Get(ctx context.Context, key string) ([]byte, error
 		if data, ok := srs.blocks[string(c.Hash())]; ok {
 			return data, nil
 		}
+		if srs.err != nil {
+			return nil, srs.err
+		}
 		if srs.done {
 			return nil, carstorage.ErrNotFound{Cid: c}
 		}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/cmd/car/lib"
+	carv2 "github.com/ipld/go-car/v2"
 	"github.com/urfave/cli/v2"
 )
 
@@ -18,7 +19,12 @@ func FilterCar(c *cli.Context) error {
 		return fmt.Errorf("an output filename must be provided")
 	}
 
-	var err error
+	opts, err := writeOptions(c)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, carv2.WithTrustedCAR(c.Bool("trusted")))
+
 	// Get the set of CIDs from stdin.
 	inStream := os.Stdin
 	if c.IsSet("cid-file") {
@@ -38,7 +44,7 @@ func FilterCar(c *cli.Context) error {
 		fmt.Printf("filtering to %d cids\n", len(cidMap))
 	}
 
-	return lib.FilterCar(c.Context, c.Args().First(), c.Args().Get(1), cidMap, c.Bool("inverse"), c.Int("version"), c.Bool("append"))
+	return lib.FilterCar(c.Context, c.Args().First(), c.Args().Get(1), cidMap, c.Bool("inverse"), c.Int("version"), c.Bool("append"), opts...)
 }
 
 func parseCIDS(r io.Reader) (map[cid.Cid]struct{}, error) {
@@ -3,10 +3,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
 	dagpb "github.com/ipld/go-codec-dagpb"
 	"github.com/ipld/go-ipld-prime"
@@ -16,11 +19,13 @@ import (
 	_ "github.com/ipld/go-ipld-prime/codec/json"
 	_ "github.com/ipld/go-ipld-prime/codec/raw"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	ipldfmt "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-unixfsnode"
-	"github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
@@ -28,34 +33,45 @@ import (
 	"github.com/ipld/go-ipld-prime/traversal"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
 	selectorParser "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v2"
 )
 
 // GetCarBlock is a command to get a block out of a car
 func GetCarBlock(c *cli.Context) error {
-	if c.Args().Len() < 2 {
-		return fmt.Errorf("usage: car get-block <file.car> <block cid> [output file]")
+	args := c.Args().Slice()
+	dir := c.String("car-path-from-index-dir")
+	if dir != "" {
+		// Prepend a placeholder for the car path, which is not given.
+		args = append([]string{""}, args...)
 	}
-
-	bs, err := blockstore.OpenReadOnly(c.Args().Get(0))
-	if err != nil {
-		return err
+	if len(args) < 2 {
+		return fmt.Errorf("usage: car get-block [--car-path-from-index-dir dir] <file.car> <block cid> [output file]")
 	}
 
 	// string to CID
-	blkCid, err := cid.Parse(c.Args().Get(1))
+	blkCid, err := cid.Parse(args[1])
 	if err != nil {
 		return err
 	}
 
-	blk, err := bs.Get(c.Context, blkCid)
+	var blk blocks.Block
+	if dir != "" {
+		blk, err = getBlockFromDir(c.Context, dir, blkCid)
+	} else {
+		var bs *blockstore.ReadOnly
+		if bs, err = blockstore.OpenReadOnly(args[0]); err != nil {
+			return err
+		}
+		blk, err = bs.Get(c.Context, blkCid)
+	}
 	if err != nil {
 		return err
 	}
 
 	outStream := os.Stdout
-	if c.Args().Len() >= 3 {
-		outStream, err = os.Create(c.Args().Get(2))
+	if len(args) >= 3 {
+		outStream, err = os.Create(args[2])
 		if err != nil {
 			return err
 		}
@@ -66,10 +82,93 @@ func GetCarBlock(c *cli.Context) error {
 	return err
 }
 
+// getBlockFromDir returns the block with the given CID from the first of the
+// cars in dir holding it, in lexical order of their names. A car is looked up
+// via its detached index if it has one, named either after the car with an
+// added .idx extension, as in `car index create x.car x.car.idx`, or with its
+// .car extension replaced by .carindex. Otherwise, it is opened as it would be
+// by get-block, using its own index if it has one, or generating one.
+func getBlockFromDir(ctx context.Context, dir string, c cid.Cid) (blocks.Block, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.car"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		idx, err := readDetachedIndex(p)
+		if err != nil {
+			return nil, err
+		}
+		if idx != nil {
+			err := idx.GetAll(c, func(uint64) bool { return false })
+			if errors.Is(err, index.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+		}
+		blk, err := getBlockFromCar(ctx, p, idx, c)
+		if ipldfmt.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		return blk, nil
+	}
+	return nil, fmt.Errorf("block %s not found in any car in %s", c, dir)
+}
+
+// readDetachedIndex reads the detached index of the car at the given path, as
+// described by getBlockFromDir, or returns nil if it has none.
+func readDetachedIndex(carPath string) (index.Index, error) {
+	for _, p := range []string{carPath + ".idx", strings.TrimSuffix(carPath, ".car") + ".carindex"} {
+		f, err := os.Open(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		idx, err := index.ReadFrom(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		return idx, nil
+	}
+	return nil, nil
+}
+
+// getBlockFromCar gets the block with the given CID from the car at the given
+// path, using idx to locate it if not nil.
+func getBlockFromCar(ctx context.Context, path string, idx index.Index, c cid.Cid) (blocks.Block, error) {
+	if idx == nil {
+		bs, err := blockstore.OpenReadOnly(path)
+		if err != nil {
+			return nil, err
+		}
+		defer bs.Close()
+		return bs.Get(ctx, c)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	bs, err := blockstore.NewReadOnly(f, idx)
+	if err != nil {
+		return nil, err
+	}
+	return bs.Get(ctx, c)
+}
+
 // GetCarDag is a command to get a dag out of a car
 func GetCarDag(c *cli.Context) error {
 	if c.Args().Len() < 2 {
-		return fmt.Errorf("usage: car get-dag [-s selector] <file.car> [root cid] <output file>")
+		return fmt.Errorf("usage: car get-dag [-s selector | --selector-file file | --preset name] <file.car> [root cid] <output file>")
+	}
+
+	opts, err := writeOptions(c)
+	if err != nil {
+		return err
 	}
 
 	// if root cid is emitted we'll read it from the root of file.car.
@@ -103,28 +202,116 @@ func GetCarDag(c *cli.Context) error {
 	// selector traversal, default to ExploreAllRecursively which only explores the DAG blocks
 	// because we only care about the blocks loaded during the walk, not the nodes matched
 	sel := selectorParser.CommonSelector_MatchAllRecursively
-	if c.IsSet("selector") {
+	linkVisitOnlyOnce := true
+	switch {
+	case countSet(c, "selector", "selector-file", "preset") > 1:
+		return fmt.Errorf("only one of --selector, --selector-file and --preset may be set")
+	case c.IsSet("selector"):
 		sel, err = selectorParser.ParseJSONSelector(c.String("selector"))
 		if err != nil {
 			return err
 		}
+		linkVisitOnlyOnce = false // if using a custom selector, this isn't as safe
+	case c.IsSet("selector-file"):
+		b, err := os.ReadFile(c.String("selector-file"))
+		if err != nil {
+			return err
+		}
+		sel, err = selectorParser.ParseJSONSelector(string(b))
+		if err != nil {
+			return fmt.Errorf("invalid selector in %s: %w", c.String("selector-file"), err)
+		}
+		linkVisitOnlyOnce = false
+	case c.IsSet("preset"):
+		sel, err = carv2.SelectorPreset(c.String("preset"))
+		if err != nil {
+			return err
+		}
 	}
-	linkVisitOnlyOnce := !c.IsSet("selector") // if using a custom selector, this isn't as safe
 
-	switch c.Int("version") {
-	case 2:
-		return writeCarV2(c.Context, rootCid, output, bs, strict, sel, linkVisitOnlyOnce)
-	case 1:
-		return writeCarV1(rootCid, output, bs, strict, sel, linkVisitOnlyOnce)
-	default:
-		return fmt.Errorf("invalid CAR version %d", c.Int("version"))
+	var exclude map[string]struct{}
+	if c.IsSet("exclude-cids") {
+		if exclude, err = readExcludedHashes(c.String("exclude-cids")); err != nil {
+			return fmt.Errorf("invalid --exclude-cids: %w", err)
+		}
 	}
+
+	return writeCar(c.Context, rootCid, output, bs, strict, sel, linkVisitOnlyOnce, exclude, opts...)
 }
 
-func writeCarV2(ctx context.Context, rootCid cid.Cid, output string, bs *blockstore.ReadOnly, strict bool, sel datamodel.Node, linkVisitOnlyOnce bool) error {
+// readExcludedHashes reads the multihashes of the blocks to omit from the
+// output of get-dag from the file at path, which is either a CAR, whose blocks
+// are omitted, a detached index, whose indexed blocks are omitted, or a list of
+// CIDs, one per line.
+func readExcludedHashes(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exclude := make(map[string]struct{})
+
+	if br, err := carv2.NewBlockReader(f); err == nil {
+		for {
+			md, err := br.SkipNext()
+			if err == io.EOF {
+				return exclude, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			exclude[string(md.Cid.Hash())] = struct{}{}
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if idx, err := index.ReadFrom(f); err == nil {
+		iidx, ok := idx.(index.IterableIndex)
+		if !ok {
+			return nil, fmt.Errorf("index of codec %s is not iterable", idx.Codec())
+		}
+		if err := iidx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+			exclude[string(mh)] = struct{}{}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return exclude, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cids, err := parseCIDS(f)
+	if err != nil {
+		return nil, err
+	}
+	for c := range cids {
+		exclude[string(c.Hash())] = struct{}{}
+	}
+	return exclude, nil
+}
+
+// countSet returns how many of the named flags are set.
+func countSet(c *cli.Context, names ...string) int {
+	var n int
+	for _, name := range names {
+		if c.IsSet(name) {
+			n++
+		}
+	}
+	return n
+}
+
+// writeCar writes the blocks of the dag at rootCid matched by sel to a new car
+// at output, omitting those whose multihash is in exclude, which are still
+// traversed through.
+func writeCar(ctx context.Context, rootCid cid.Cid, output string, bs *blockstore.ReadOnly, strict bool, sel datamodel.Node, linkVisitOnlyOnce bool, exclude map[string]struct{}, opts ...carv2.Option) error {
 	_ = os.Remove(output)
 
-	outStore, err := blockstore.OpenReadWrite(output, []cid.Cid{rootCid}, blockstore.AllowDuplicatePuts(false))
+	outStore, err := blockstore.OpenReadWrite(output, []cid.Cid{rootCid}, append([]carv2.Option{blockstore.AllowDuplicatePuts(false)}, opts...)...)
 	if err != nil {
 		return err
 	}
@@ -144,8 +331,10 @@ func writeCarV2(ctx context.Context, rootCid cid.Cid, output string, bs *blockst
 				}
 				return nil, err
 			}
-			if err := outStore.Put(ctx, blk); err != nil {
-				return nil, err
+			if _, ok := exclude[string(cl.Cid.Hash())]; !ok {
+				if err := outStore.Put(ctx, blk); err != nil {
+					return nil, err
+				}
 			}
 			return bytes.NewBuffer(blk.RawData()), nil
 		}
@@ -198,18 +387,3 @@ func writeCarV2(ctx context.Context, rootCid cid.Cid, output string, bs *blockst
 
 	return outStore.Finalize()
 }
-
-func writeCarV1(rootCid cid.Cid, output string, bs *blockstore.ReadOnly, _ bool, sel datamodel.Node, linkVisitOnlyOnce bool) error {
-	opts := make([]car.Option, 0)
-	if linkVisitOnlyOnce {
-		opts = append(opts, car.TraverseLinksOnlyOnce())
-	}
-	sc := car.NewSelectiveCar(context.Background(), bs, []car.Dag{{Root: rootCid, Selector: sel}}, opts...)
-	f, err := os.Create(output)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	return sc.Write(f)
-}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"os"
 
 	"github.com/ipfs/go-cid"
@@ -11,6 +14,7 @@ import (
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 	"github.com/urfave/cli/v2"
 )
@@ -24,7 +28,7 @@ func IndexCar(c *cli.Context) error {
 	defer r.Close()
 
 	if c.Int("version") == 1 {
-		if c.IsSet("codec") && c.String("codec") != "none" {
+		if c.IsSet("index-codec") && !noIndex(c) {
 			return fmt.Errorf("'none' is the only supported codec for a v1 car")
 		}
 		outStream := os.Stdout
@@ -48,12 +52,17 @@ func IndexCar(c *cli.Context) error {
 		return fmt.Errorf("invalid CAR version %d", c.Int("version"))
 	}
 
+	mc, err := indexCodec(c)
+	if err != nil {
+		return err
+	}
+	opts, err := writeOptions(c)
+	if err != nil {
+		return err
+	}
+	storeIdentityCIDs := carv2.ApplyOptions(opts...).StoreIdentityCIDs
 	var idx index.Index
-	if c.String("codec") != "none" {
-		var mc multicodec.Code
-		if err := mc.Set(c.String("codec")); err != nil {
-			return err
-		}
+	if mc != index.CarIndexNone {
 		idx, err = index.New(mc)
 		if err != nil {
 			return err
@@ -82,7 +91,7 @@ func IndexCar(c *cli.Context) error {
 		r.Header.DataSize = uint64(fi.Size())
 	}
 	v2Header := carv2.NewHeader(r.Header.DataSize)
-	if c.String("codec") == "none" {
+	if idx == nil {
 		v2Header.IndexOffset = 0
 		if _, err := outStream.Write(carv2.Pragma); err != nil {
 			return err
@@ -113,7 +122,7 @@ func IndexCar(c *cli.Context) error {
 		return err
 	}
 
-	records := make([]index.Record, 0)
+	records := make([]index.SizedRecord, 0)
 	var sectionOffset int64
 	if sectionOffset, err = v1r.Seek(0, io.SeekCurrent); err != nil {
 		return err
@@ -145,7 +154,9 @@ func IndexCar(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		records = append(records, index.Record{Cid: c, Offset: uint64(sectionOffset)})
+		if storeIdentityCIDs || c.Prefix().MhType != multihash.IDENTITY {
+			records = append(records, index.SizedRecord{Record: index.Record{Cid: c, Offset: uint64(sectionOffset)}, Size: sectionLen - uint64(cidLen)})
+		}
 		if _, err := c.WriteBytes(outStream); err != nil {
 			return err
 		}
@@ -159,7 +170,7 @@ func IndexCar(c *cli.Context) error {
 		sectionOffset += int64(sectionLen) + int64(varint.UvarintSize(sectionLen))
 	}
 
-	if err := idx.Load(records); err != nil {
+	if err := index.LoadSizedRecords(idx, records); err != nil {
 		return err
 	}
 
@@ -184,8 +195,15 @@ func CreateIndex(c *cli.Context) error {
 	}
 	defer outStream.Close()
 
-	var mc multicodec.Code
-	if err := mc.Set(c.String("codec")); err != nil {
+	mc, err := indexCodec(c)
+	if err != nil {
+		return err
+	}
+	if mc == index.CarIndexNone {
+		return fmt.Errorf("an index codec is required to create an index")
+	}
+	opts, err := writeOptions(c)
+	if err != nil {
 		return err
 	}
 	idx, err := index.New(mc)
@@ -198,7 +216,7 @@ func CreateIndex(c *cli.Context) error {
 		return err
 	}
 
-	if err := carv2.LoadIndex(idx, dr); err != nil {
+	if err := carv2.LoadIndex(idx, dr, opts...); err != nil {
 		return err
 	}
 
@@ -208,3 +226,468 @@ func CreateIndex(c *cli.Context) error {
 
 	return nil
 }
+
+// attachSampleSize is the maximum number of index records checked against the
+// data payload of the car by AttachIndex.
+const attachSampleSize = 16
+
+// AttachIndex is a command to attach a detached index to a car, replacing any
+// index it already has, and write out the resulting CARv2.
+//
+// The offsets of the index may either be relative to the data payload, as
+// written by `car index create` and `car detach-index`, or to the start of the
+// CARv2, as written by `car detach-index --absolute`. Which one is determined
+// by checking a sample of records against the data payload, and the offsets
+// are rebased accordingly.
+func AttachIndex(c *cli.Context) error {
+	src, dst, err := wrapPaths(c)
+	if err != nil {
+		return err
+	}
+	if c.Int("version") != 2 || noIndex(c) {
+		return fmt.Errorf("an index can only be attached to a CARv2 written with one")
+	}
+
+	idxFile, err := os.Open(c.String("from"))
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+	idx, err := index.ReadFrom(idxFile)
+	if err != nil {
+		return err
+	}
+
+	in, cleanup, err := openSeekableInput(src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	r, err := carv2.NewReader(in)
+	if err != nil {
+		return err
+	}
+	header := r.Header
+	if r.Version == 1 {
+		size, err := in.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		header = carv2.NewHeader(uint64(size))
+	}
+	dr, err := r.DataReader()
+	if err != nil {
+		return err
+	}
+
+	if idx, err = rebaseIndex(idx, dr, header.DataOffset); err != nil {
+		return err
+	}
+	// The attached index keeps its codec unless another one is asked for.
+	if c.IsSet("index-codec") {
+		mc, err := indexCodec(c)
+		if err != nil {
+			return err
+		}
+		if idx, err = recodeIndex(idx, mc); err != nil {
+			return err
+		}
+	}
+
+	header.IndexOffset = header.DataOffset + header.DataSize
+	return writeOutput(dst, func(w io.Writer) error {
+		if _, err := w.Write(carv2.Pragma); err != nil {
+			return err
+		}
+		if _, err := header.WriteTo(w); err != nil {
+			return err
+		}
+		// Keep any padding between the header and the data payload.
+		padding := int64(header.DataOffset) - (carv2.PragmaSize + carv2.HeaderSize)
+		if _, err := io.Copy(w, io.NewSectionReader(in, carv2.PragmaSize+carv2.HeaderSize, padding)); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(dr, 0, int64(header.DataSize))); err != nil {
+			return err
+		}
+		_, err := index.WriteTo(idx, w)
+		return err
+	})
+}
+
+// rebaseIndex returns the given detached index with its offsets made relative
+// to the data payload read by dr, if they are relative to the start of the
+// CARv2 with the given data offset instead; see indexOffsetShift.
+func rebaseIndex(idx index.Index, dr io.ReaderAt, dataOffset uint64) (index.Index, error) {
+	records, err := indexRecords(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	shift, err := indexOffsetShift(dr, records, dataOffset)
+	if err != nil || shift == 0 {
+		return idx, err
+	}
+	for i := range records {
+		records[i].Offset -= shift
+	}
+	if idx, err = index.New(idx.Codec()); err != nil {
+		return nil, err
+	}
+	if err := index.LoadSizedRecords(idx, records); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// recodeIndex returns the given index in the given codec, which is idx itself
+// if it is of that codec already.
+func recodeIndex(idx index.Index, codec multicodec.Code) (index.Index, error) {
+	if idx.Codec() == codec {
+		return idx, nil
+	}
+	records, err := indexRecords(idx)
+	if err != nil {
+		return nil, err
+	}
+	recoded, err := index.New(codec)
+	if err != nil {
+		return nil, err
+	}
+	// The sizes of the blocks are only known if idx records them.
+	_, fromSized := idx.(index.SizedIndex)
+	if _, toSized := recoded.(index.SizedIndex); toSized && !fromSized {
+		return nil, fmt.Errorf("cannot convert an index of codec %s to %s, which records block sizes", idx.Codec(), codec)
+	}
+	if err := index.LoadSizedRecords(recoded, records); err != nil {
+		return nil, err
+	}
+	return recoded, nil
+}
+
+// indexRecords returns the records of idx, along with their block sizes if it
+// records them, such that an index loaded from them records the same.
+func indexRecords(idx index.Index) ([]index.SizedRecord, error) {
+	iidx, ok := idx.(index.IterableIndex)
+	if !ok {
+		return nil, fmt.Errorf("index of codec %s is not iterable", idx.Codec())
+	}
+	var records []index.SizedRecord
+	sized, _ := idx.(index.SizedIndex)
+	err := iidx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		r := index.SizedRecord{Record: index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset}}
+		if sized != nil {
+			// Look the size up by the offset, which tells apart duplicate blocks.
+			if err := sized.GetAllSized(r.Cid, func(o, size uint64) bool {
+				if o == offset {
+					r.Size = size
+					return false
+				}
+				return true
+			}); err != nil {
+				return err
+			}
+		}
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+// IndexVerify is a command to check the index of a car, or a detached index
+// given via --from, against an index regenerated from its data payload. The
+// records missing from the index are listed with "-", and those it holds in
+// excess with "+", and the command exits with status 1 if any are found.
+func IndexVerify(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("a car file must be specified")
+	}
+	r, err := carv2.OpenReader(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dr, err := r.DataReader()
+	if err != nil {
+		return err
+	}
+
+	var idx index.Index
+	if from := c.String("from"); from != "" {
+		idxFile, err := os.Open(from)
+		if err != nil {
+			return err
+		}
+		defer idxFile.Close()
+		if idx, err = index.ReadFrom(idxFile); err != nil {
+			return err
+		}
+		// Offsets relative to the start of a CARv1 wrapped as a CARv2 are
+		// rebased as they would be by attaching the index.
+		dataOffset := r.Header.DataOffset
+		if r.Version == 1 {
+			dataOffset = carv2.PragmaSize + carv2.HeaderSize
+		}
+		if idx, err = rebaseIndex(idx, dr, dataOffset); err != nil {
+			return err
+		}
+	} else {
+		if r.Version != 2 || !r.Header.HasIndex() {
+			return fmt.Errorf("car has no index; verify a detached index with --from")
+		}
+		ir, err := r.IndexReader()
+		if err != nil {
+			return err
+		}
+		if idx, err = index.ReadFrom(ir); err != nil {
+			return err
+		}
+	}
+	iidx, ok := idx.(index.IterableIndex)
+	if !ok {
+		return fmt.Errorf("index of codec %s is not iterable", idx.Codec())
+	}
+
+	regenerated, err := index.New(idx.Codec())
+	if err != nil {
+		return err
+	}
+	var opts []carv2.Option
+	if r.Version == 2 && r.Header.Characteristics.IsFullyIndexed() {
+		opts = append(opts, carv2.StoreIdentityCIDs(true))
+	}
+	if err := carv2.LoadIndex(regenerated, dr, opts...); err != nil {
+		return err
+	}
+
+	var differ bool
+	if err := index.Diff(regenerated.(index.IterableIndex), iidx, func(d index.Difference) error {
+		differ = true
+		sign := "-"
+		if d.Added {
+			sign = "+"
+		}
+		_, err := fmt.Fprintf(c.App.Writer, "%s %s %d\n", sign, d.Multihash.B58String(), d.Offset)
+		return err
+	}); err != nil {
+		return err
+	}
+	if differ {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// indexOffsetShift returns the amount by which the offsets of the given index
+// records exceed offsets relative to the data payload read by dr: zero if they
+// are already relative to it, or dataOffset if they are relative to the start
+// of the CARv2. A sample of the records is checked against the data payload,
+// and an error is returned if it matches neither.
+func indexOffsetShift(dr io.ReaderAt, records []index.SizedRecord, dataOffset uint64) (uint64, error) {
+	step := max(len(records)/attachSampleSize, 1)
+	for _, shift := range []uint64{0, dataOffset} {
+		matches := true
+		for i := 0; i < len(records) && matches; i += step {
+			r := records[i]
+			matches = r.Offset >= shift && sectionHasMultihash(dr, r.Offset-shift, r.Cid.Hash())
+		}
+		if matches {
+			return shift, nil
+		}
+	}
+	return 0, fmt.Errorf("index does not match the car data payload")
+}
+
+// sectionHasMultihash reports whether the section at the given offset of the
+// data payload read by dr has a CID with the given multihash.
+func sectionHasMultihash(dr io.ReaderAt, offset uint64, mh multihash.Multihash) bool {
+	br := bufio.NewReader(io.NewSectionReader(dr, int64(offset), math.MaxInt64-int64(offset)))
+	if _, err := varint.ReadUvarint(br); err != nil {
+		return false
+	}
+	_, c, err := cid.CidFromReader(br)
+	return err == nil && bytes.Equal(c.Hash(), mh)
+}
+
+// indexBucket is a bucket of a sorted index, holding the records of a single
+// width, and of a single multihash code for car-multihash-index-sorted.
+type indexBucket struct {
+	code    multicodec.Code
+	hasCode bool
+	width   uint32
+	data    []byte
+}
+
+func (b indexBucket) String() string {
+	if b.hasCode {
+		return fmt.Sprintf("%s (width %d)", b.code, b.width)
+	}
+	return fmt.Sprintf("width %d", b.width)
+}
+
+// before reports whether the bucket b must be serialized before other.
+func (b indexBucket) before(other indexBucket) bool {
+	if b.code != other.code {
+		return b.code < other.code
+	}
+	return b.width < other.width
+}
+
+// IndexStat is a command to report on the buckets of the index of a CARv2, or
+// of a detached index, as written by `car index create`.
+//
+// With --validate-sorted, the records of each bucket are checked to be sorted
+// by digest, as lookups rely upon, with no duplicate (digest, offset) pairs,
+// and the buckets to be ordered; the first violation found is returned.
+func IndexStat(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: car index stat [--validate-sorted] <file.car|file.idx>")
+	}
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Anything other than a car is read as a detached index.
+	var ir io.Reader = f
+	_, verr := carv2.ReadVersion(f)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if verr == nil {
+		r, err := carv2.NewReader(f)
+		if err != nil {
+			return err
+		}
+		if ir, err = r.IndexReader(); err != nil {
+			return err
+		}
+		if ir == nil {
+			return fmt.Errorf("car has no index")
+		}
+	}
+	br := bufio.NewReader(ir)
+	codec, err := index.ReadCodec(br)
+	if err != nil {
+		return err
+	}
+
+	var buckets []indexBucket
+	switch codec {
+	case multicodec.CarIndexSorted:
+		if buckets, err = readIndexBuckets(br, 0, false); err != nil {
+			return err
+		}
+	case multicodec.CarMultihashIndexSorted:
+		var count int32
+		if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+			return fmt.Errorf("malformed index: %w", err)
+		}
+		for i := int32(0); i < count; i++ {
+			var code uint64
+			if err := binary.Read(br, binary.LittleEndian, &code); err != nil {
+				return fmt.Errorf("malformed index: %w", err)
+			}
+			coded, err := readIndexBuckets(br, multicodec.Code(code), true)
+			if err != nil {
+				return err
+			}
+			buckets = append(buckets, coded...)
+		}
+	default:
+		return fmt.Errorf("cannot stat index of type %s", codec)
+	}
+
+	var violation error
+	var records uint64
+	fmt.Fprintf(c.App.Writer, "Index type: %s\n", codec)
+	fmt.Fprintf(c.App.Writer, "Buckets:\n")
+	for i, b := range buckets {
+		count := uint64(len(b.data)) / uint64(b.width)
+		records += count
+		fmt.Fprintf(c.App.Writer, "\t%s: %d records\n", b, count)
+		if !c.Bool("validate-sorted") || violation != nil {
+			continue
+		}
+		if i > 0 && !buckets[i-1].before(b) {
+			violation = fmt.Errorf("bucket %s follows bucket %s", b, buckets[i-1])
+			continue
+		}
+		violation = validateIndexBucket(b)
+	}
+	fmt.Fprintf(c.App.Writer, "Record count: %d\n", records)
+	if violation != nil {
+		return fmt.Errorf("index is not sorted: %w", violation)
+	}
+	if c.Bool("validate-sorted") {
+		fmt.Fprintf(c.App.Writer, "Sorted: Yes\n")
+	}
+	return nil
+}
+
+// readIndexBuckets reads the buckets of a car-index-sorted index, which are
+// also those of each multihash code of a car-multihash-index-sorted index.
+func readIndexBuckets(r io.Reader, code multicodec.Code, hasCode bool) ([]indexBucket, error) {
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("malformed index: %w", err)
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("malformed index: negative bucket count %d", count)
+	}
+	buckets := make([]indexBucket, 0, count)
+	for i := int32(0); i < count; i++ {
+		b := indexBucket{code: code, hasCode: hasCode}
+		var dataLen uint64
+		if err := binary.Read(r, binary.LittleEndian, &b.width); err != nil {
+			return nil, fmt.Errorf("malformed index: %w", err)
+		}
+		if err := binary.Read(r, binary.LittleEndian, &dataLen); err != nil {
+			return nil, fmt.Errorf("malformed index: %w", err)
+		}
+		if b.width < 8 {
+			return nil, fmt.Errorf("malformed index: bucket %s is narrower than its offsets", b)
+		}
+		if dataLen%uint64(b.width) != 0 {
+			return nil, fmt.Errorf("malformed index: bucket %s has a length of %d bytes", b, dataLen)
+		}
+		if dataLen > math.MaxInt32 {
+			return nil, fmt.Errorf("malformed index: bucket %s is too large", b)
+		}
+		b.data = make([]byte, dataLen)
+		if _, err := io.ReadFull(r, b.data); err != nil {
+			return nil, fmt.Errorf("malformed index: %w", err)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// validateIndexBucket checks that the records of b are sorted by digest, and
+// that no (digest, offset) pair appears twice.
+func validateIndexBucket(b indexBucket) error {
+	width := int(b.width)
+	var prev []byte
+	// The offsets of the run of records sharing the digest prev, which may
+	// legitimately differ when the car holds duplicate blocks.
+	offsets := make(map[uint64]struct{})
+	for i := 0; i < len(b.data)/width; i++ {
+		record := b.data[i*width : (i+1)*width]
+		digest, offset := record[:width-8], binary.LittleEndian.Uint64(record[width-8:])
+		if i > 0 {
+			switch cmp := bytes.Compare(prev, digest); {
+			case cmp > 0:
+				return fmt.Errorf("bucket %s: record %d with digest %x follows digest %x", b, i, digest, prev)
+			case cmp < 0:
+				clear(offsets)
+			}
+		}
+		if _, ok := offsets[offset]; ok {
+			return fmt.Errorf("bucket %s: record %d duplicates digest %x at offset %d", b, i, digest, offset)
+		}
+		offsets[offset] = struct{}{}
+		prev = digest
+	}
+	return nil
+}
@@ -2,9 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ipld/go-car/cmd/car/lib"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
 	"github.com/urfave/cli/v2"
 )
 
@@ -18,10 +27,102 @@ func InspectCar(c *cli.Context) (err error) {
 		}
 	}
 
+	if c.Bool("header-only") {
+		for _, f := range []string{"full", "sample", "seed", "codec-breakdown", "size-histogram"} {
+			if c.IsSet(f) {
+				return fmt.Errorf("--header-only cannot be combined with --%s", f)
+			}
+		}
+		rep, err := lib.InspectCarHeader(inStream)
+		if err != nil {
+			return err
+		}
+		fmt.Print(rep.String())
+		return nil
+	}
+
 	rep, err := lib.InspectCar(inStream, c.Bool("full"))
 	if err != nil {
 		return err
 	}
+
+	if c.Bool("codec-breakdown") || c.Bool("size-histogram") {
+		if _, err := inStream.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("breakdowns require a seekable input: %w", err)
+		}
+		codecBytes, histogram, err := blockBreakdown(inStream)
+		if err != nil {
+			return err
+		}
+		if c.Bool("codec-breakdown") {
+			rep.CodecBytes = codecBytes
+		}
+		if c.Bool("size-histogram") {
+			rep.BlkLengthHistogram = histogram
+		}
+	}
+
+	if c.IsSet("sample") {
+		sampleSize, err := parseSampleSize(c.String("sample"), rep.BlockCount)
+		if err != nil {
+			return err
+		}
+		if _, err := inStream.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("sampling requires a seekable input: %w", err)
+		}
+		seed := c.Int64("seed")
+		if !c.IsSet("seed") {
+			seed = time.Now().UnixNano()
+		}
+		rep.Sample, err = lib.SampleCar(inStream, rep.BlockCount, sampleSize, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			return err
+		}
+	}
+
 	fmt.Print(rep.String())
 	return nil
 }
+
+// blockBreakdown sums the block lengths of each codec, and counts blocks by
+// length as per lib.Histogram, in the CAR read from inStream.
+func blockBreakdown(inStream io.Reader) (lib.Counts, lib.Histogram, error) {
+	br, err := carv2.NewBlockReader(inStream, carv2.ZeroLengthSectionAsEOF(true))
+	if err != nil {
+		return nil, nil, err
+	}
+	codecBytes := lib.Counts{}
+	var histogram lib.Histogram
+	for {
+		md, err := br.SkipNext()
+		if err == io.EOF {
+			return codecBytes, histogram, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		codecBytes[multicodec.Code(md.Prefix().Codec)] += md.Size
+		bucket := bits.Len64(md.Size)
+		for len(histogram) <= bucket {
+			histogram = append(histogram, 0)
+		}
+		histogram[bucket]++
+	}
+}
+
+// parseSampleSize parses a sample size given either as a number of blocks or
+// as a percentage of the given block count, e.g. "10%".
+func parseSampleSize(sample string, blockCount uint64) (uint64, error) {
+	if pct, ok := strings.CutSuffix(sample, "%"); ok {
+		p, err := strconv.ParseFloat(pct, 64)
+		if err != nil || p < 0 || p > 100 {
+			return 0, fmt.Errorf("invalid sample percentage: %s", sample)
+		}
+		return uint64(math.Ceil(float64(blockCount) * p / 100)), nil
+	}
+	n, err := strconv.ParseUint(sample, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample size: %s", sample)
+	}
+	return n, nil
+}
@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// PieceHasher computes the Filecoin piece commitment, or commP, of the data
+// written to it.
+type PieceHasher interface {
+	// Write adds data to the piece. It fails once more data is written than
+	// fits the largest supported piece.
+	Write(p []byte) (int, error)
+	// Sum returns the piece CID of the data written, zero padded to the given
+	// padded piece size.
+	Sum(pieceSize uint64) (cid.Cid, error)
+}
+
+// NewPieceHasher returns the PieceHasher used to compute piece commitments.
+// It defaults to a plain Go implementation, and may be replaced by programs
+// embedding this package with a faster one, e.g. backed by
+// go-fil-commp-hashhash, without this package depending on it.
+var NewPieceHasher = func() PieceHasher {
+	return &commpHasher{}
+}
+
+// PiecePayloadSize returns the number of bytes of data which fit a piece of
+// the given padded size, once expanded by fr32 padding, which spreads every
+// 127 bytes over 128.
+func PiecePayloadSize(pieceSize uint64) uint64 {
+	return pieceSize - pieceSize/128
+}
+
+// CheckPieceSize checks that the given padded piece size is valid, i.e. a
+// power of two of at least 128 bytes.
+func CheckPieceSize(pieceSize uint64) error {
+	if pieceSize < 128 || bits.OnesCount64(pieceSize) != 1 {
+		return fmt.Errorf("invalid piece size %d: must be a power of two of at least 128 bytes", pieceSize)
+	}
+	return nil
+}
+
+// MinPieceSize returns the smallest padded piece size which fits the given
+// number of bytes of data.
+func MinPieceSize(payloadSize uint64) uint64 {
+	padded := (payloadSize + 126) / 127 * 128
+	if padded <= 128 {
+		return 128
+	}
+	return 1 << bits.Len64(padded-1)
+}
+
+// maxPieceLevels is the depth of the tree of the largest piece supported,
+// 2^(5+maxPieceLevels) bytes, i.e. 64 GiB, which is the largest sector size.
+const maxPieceLevels = 31
+
+// zeroNodes holds the root of the tree of an all-zero piece at each level,
+// leaves being level zero.
+var zeroNodes = func() (z [maxPieceLevels + 1][32]byte) {
+	for l := 1; l <= maxPieceLevels; l++ {
+		z[l] = hashPieceNodes(&z[l-1], &z[l-1])
+	}
+	return z
+}()
+
+// hashPieceNodes hashes two sibling nodes of a piece tree into their parent,
+// via sha2-256-trunc254-padded.
+func hashPieceNodes(left, right *[32]byte) [32]byte {
+	h := sha256.New()
+	h.Write(left[:])
+	h.Write(right[:])
+	var out [32]byte
+	h.Sum(out[:0])
+	out[31] &= 0x3f
+	return out
+}
+
+// commpHasher is the plain Go PieceHasher. It fr32 pads the data written to it
+// in chunks of 127 bytes, each yielding four 32-byte leaves, and folds the
+// leaves into the tree as they come, holding one pending node per level.
+type commpHasher struct {
+	buf      [127]byte
+	buffered int
+	leaves   uint64
+	levels   [maxPieceLevels + 1]*[32]byte
+}
+
+func (h *commpHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		c := copy(h.buf[h.buffered:], p)
+		h.buffered += c
+		p = p[c:]
+		if h.buffered == len(h.buf) {
+			if err := h.flushChunk(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flushChunk fr32 pads the buffered chunk, zero filled, into four leaves.
+func (h *commpHasher) flushChunk() error {
+	if h.leaves+4 > 1<<maxPieceLevels {
+		return fmt.Errorf("data exceeds the largest piece size of %d bytes", uint64(1)<<(maxPieceLevels+5))
+	}
+	clear(h.buf[h.buffered:])
+	var out [128]byte
+	fr32Pad(&h.buf, &out)
+	for i := 0; i < 4; i++ {
+		leaf := [32]byte(out[i*32 : (i+1)*32])
+		h.push(0, &leaf)
+	}
+	h.leaves += 4
+	h.buffered = 0
+	return nil
+}
+
+// push adds a node at the given level, hashing it with any pending left
+// sibling into their parent.
+func (h *commpHasher) push(level int, node *[32]byte) {
+	for h.levels[level] != nil {
+		parent := hashPieceNodes(h.levels[level], node)
+		h.levels[level] = nil
+		node = &parent
+		level++
+	}
+	h.levels[level] = node
+}
+
+func (h *commpHasher) Sum(pieceSize uint64) (cid.Cid, error) {
+	if err := CheckPieceSize(pieceSize); err != nil {
+		return cid.Undef, err
+	}
+	depth := bits.TrailingZeros64(pieceSize) - 5
+	if depth > maxPieceLevels {
+		return cid.Undef, fmt.Errorf("piece size %d exceeds the largest piece size of %d bytes", pieceSize, uint64(1)<<(maxPieceLevels+5))
+	}
+	// Work on a copy, such that more data may still be written.
+	c := *h
+	if c.buffered > 0 {
+		if err := c.flushChunk(); err != nil {
+			return cid.Undef, err
+		}
+	}
+	if c.leaves > pieceSize/32 {
+		return cid.Undef, fmt.Errorf("data does not fit a piece of size %d", pieceSize)
+	}
+
+	// Complete the tree with zero subtrees on the right of the data.
+	root := &zeroNodes[depth]
+	if c.leaves > 0 {
+		for l := 0; l < depth; l++ {
+			if c.levels[l] != nil {
+				parent := hashPieceNodes(c.levels[l], &zeroNodes[l])
+				c.levels[l] = nil
+				c.push(l+1, &parent)
+			}
+		}
+		root = c.levels[depth]
+	}
+
+	mh, err := multihash.Encode(root[:], uint64(multicodec.Sha2_256Trunc254Padded))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(uint64(multicodec.FilCommitmentUnsealed), mh), nil
+}
+
+// fr32Pad spreads the 1016 bits of in over the four 32-byte nodes of out,
+// 254 bits each, leaving the two most significant bits of each node zero such
+// that it is a valid element of the BLS12-381 scalar field.
+func fr32Pad(in *[127]byte, out *[128]byte) {
+	copy(out[:31], in[:31])
+	t := in[31] >> 6
+	out[31] = in[31] & 0x3f
+
+	var v byte
+	for i := 32; i < 64; i++ {
+		v = in[i]
+		out[i] = v<<2 | t
+		t = v >> 6
+	}
+	t = v >> 4
+	out[63] &= 0x3f
+
+	for i := 64; i < 96; i++ {
+		v = in[i]
+		out[i] = v<<4 | t
+		t = v >> 4
+	}
+	t = v >> 2
+	out[95] &= 0x3f
+
+	for i := 96; i < 127; i++ {
+		v = in[i]
+		out[i] = v<<6 | t
+		t = v >> 2
+	}
+	out[127] = t & 0x3f
+}
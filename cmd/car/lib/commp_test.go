@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+// pattern returns n bytes of a fixed, non-repeating within 256 bytes, pattern.
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + 3)
+	}
+	return b
+}
+
+func TestPieceHasherKnownAnswers(t *testing.T) {
+	// The all-zero pieces are the unsealed zero piece commitments published by
+	// Lotus. The others were computed with a bit-wise reference implementation
+	// of fr32 padding, independent of fr32Pad.
+	tests := []struct {
+		name      string
+		data      []byte
+		pieceSize uint64
+		want      string
+	}{
+		{
+			name:      "empty piece",
+			pieceSize: 128,
+			want:      "baga6ea4seaqdomn3tgwgrh3g532zopskstnbrd2n3sxfqbze7rxt7vqn7veigmy",
+		},
+		{
+			name:      "empty piece of 2KiB",
+			pieceSize: 2048,
+			want:      "baga6ea4seaqpy7usqklokfx2vxuynmupslkeutzexe2uqurdg5vhtebhxqmpqmy",
+		},
+		{
+			name:      "less than one chunk",
+			data:      pattern(100),
+			pieceSize: 128,
+			want:      "baga6ea4seaqj2eaoqnxpzg677u54bz74yttg5koeoqskcmndrbkuyojdsz5cqii",
+		},
+		{
+			name:      "one full chunk",
+			data:      bytes.Repeat([]byte{0xff}, 127),
+			pieceSize: 128,
+			want:      "baga6ea4seaqglc5ree3ir5ctv5iixkmc7fxgcupq2wxawjqyyfbyikv3z2jdwoi",
+		},
+		{
+			name:      "several full chunks",
+			data:      pattern(4 * 127),
+			pieceSize: 512,
+			want:      "baga6ea4seaqbohe36w6uvyr34wdmje67fmn6bov7u2hcjwxgkpagyze6yqfv2ba",
+		},
+		{
+			name:      "several chunks, last partial",
+			data:      pattern(1000),
+			pieceSize: 1024,
+			want:      "baga6ea4seaqow2othftdeqfscocoq37vwhrqear25hf37ycm7nel4rnjzz53qgi",
+		},
+		{
+			name:      "padded to a larger piece",
+			data:      pattern(100),
+			pieceSize: 2048,
+			want:      "baga6ea4seaqm7mc677i2jrtsdsorx6siznfh4ritudb63bzpunr4lenfymnb2pq",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Write in uneven slices, to cover chunks spanning writes.
+			h := NewPieceHasher()
+			for data := tt.data; len(data) > 0; {
+				n := min(len(data), 50)
+				if _, err := h.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			got, err := h.Sum(tt.pieceSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPieceHasherSumErrors(t *testing.T) {
+	h := NewPieceHasher()
+	if _, err := h.Write(pattern(200)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Sum(128); err == nil {
+		t.Fatal("expected an error for data larger than the piece")
+	}
+	if _, err := h.Sum(384); err == nil {
+		t.Fatal("expected an error for a piece size which is not a power of two")
+	}
+	// Sum does not consume the data written.
+	if _, err := h.Sum(256); err != nil {
+		t.Fatal(err)
+	}
+}
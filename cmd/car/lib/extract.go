@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-unixfsnode"
@@ -46,7 +48,81 @@ func ExtractFromFile(c context.Context, carPath string, outputDir string, logger
 	return nil
 }
 
+// ExtractOptions controls the behavior of ExtractToDirWithOptions.
+type ExtractOptions struct {
+	// Verbose logs the path of each extracted entry to the logger.
+	Verbose bool
+	// DryRun, when set, prevents anything from being written to disk. Instead,
+	// the entries that would be written are listed to it along with their
+	// sizes. File data is still read in its entirety in order to report
+	// accurate sizes.
+	DryRun io.Writer
+	// Mode is the policy for the permissions of extracted files and
+	// directories. When overriding, FileMode is applied to files, while
+	// directories keep the default permissions.
+	Mode     MetadataPolicy
+	FileMode fs.FileMode
+	// Mtime is the policy for the modification time of extracted files and
+	// directories. When overriding, ModTime is applied to all of them.
+	Mtime   MetadataPolicy
+	ModTime time.Time
+	// Owner, when set, is the owner extracted entries are changed to. UnixFS
+	// does not record ownership, so entries are otherwise owned by the user
+	// extracting them.
+	Owner *Owner
+	// Range, when set, limits the extraction of a file to the given range of
+	// its bytes. Only the blocks holding the range are loaded, which requires
+	// a single file to be extracted, i.e. a path to it unless the root is one.
+	Range *ByteRange
+}
+
+// ByteRange is the range of bytes of a file from Start up to and including
+// End, or up to the end of the file if End is negative.
+type ByteRange struct {
+	Start, End int64
+}
+
+// String returns the range in the START-END form of HTTP byte ranges, with END
+// omitted if the range extends to the end of the file.
+func (br ByteRange) String() string {
+	if br.End < 0 {
+		return fmt.Sprintf("%d-", br.Start)
+	}
+	return fmt.Sprintf("%d-%d", br.Start, br.End)
+}
+
+// MetadataPolicy controls how a piece of UnixFS metadata, such as the mode or
+// modification time recorded in UnixFS 1.5 DAGs, is applied when extracting.
+type MetadataPolicy int
+
+const (
+	// MetadataPreserve applies the metadata recorded in the DAG, if any.
+	MetadataPreserve MetadataPolicy = iota
+	// MetadataIgnore ignores the metadata recorded in the DAG, leaving the
+	// defaults of the system in place.
+	MetadataIgnore
+	// MetadataOverride applies the value given in ExtractOptions instead of the
+	// metadata recorded in the DAG.
+	MetadataOverride
+)
+
+// Owner is the numeric user and group ID to change the owner of extracted
+// entries to; an ID of -1 is left unchanged.
+type Owner struct {
+	UID, GID int
+}
+
+// ExtractToDir extracts the UnixFS DAG at root into outputDir, or to stdout if
+// outputDir is "-", returning the number of extracted files.
 func ExtractToDir(c context.Context, ls *ipld.LinkSystem, root cid.Cid, outputDir string, path []string, verbose bool, logger io.Writer) (int, error) {
+	return ExtractToDirWithOptions(c, ls, root, outputDir, path, ExtractOptions{Verbose: verbose}, logger)
+}
+
+// ExtractToDirWithOptions is similar to ExtractToDir, with its behavior
+// controlled by the given options.
+func ExtractToDirWithOptions(c context.Context, ls *ipld.LinkSystem, root cid.Cid, outputDir string, path []string, opts ExtractOptions, logger io.Writer) (int, error) {
+	e := &extractor{ls: ls, opts: opts, logger: logger}
+	verbose := opts.Verbose
 	if root.Prefix().Codec == cid.Raw {
 		if verbose {
 			fmt.Fprintf(logger, "skipping raw root %s\n", root)
@@ -66,7 +142,9 @@ func ExtractToDir(c context.Context, ls *ipld.LinkSystem, root cid.Cid, outputDi
 	}
 
 	var outputResolvedDir string
-	if outputDir != "-" {
+	if outputDir != "-" && opts.DryRun != nil {
+		outputResolvedDir = filepath.Clean(outputDir)
+	} else if outputDir != "-" {
 		outputResolvedDir, err = filepath.EvalSymlinks(outputDir)
 		if err != nil {
 			return 0, err
@@ -78,7 +156,7 @@ func ExtractToDir(c context.Context, ls *ipld.LinkSystem, root cid.Cid, outputDi
 		}
 	}
 
-	count, err := extractDir(c, ls, ufn, outputResolvedDir, "/", path, verbose, logger)
+	count, err := e.extractDir(c, ufn, outputResolvedDir, "/", path)
 	if err != nil {
 		if !errors.Is(err, ErrNotDir) {
 			return 0, fmt.Errorf("%s: %w", root, err)
@@ -102,7 +180,10 @@ func ExtractToDir(c context.Context, ls *ipld.LinkSystem, root cid.Cid, outputDi
 			outputName = filepath.Join(outputResolvedDir, "unknown")
 		}
 		if ufsNode.DataType.Int() == data.Data_File || ufsNode.DataType.Int() == data.Data_Raw {
-			if err := extractFile(c, ls, pbnode, outputName); err != nil {
+			if err := e.extractFile(c, pbnode, outputName); err != nil {
+				return 0, err
+			}
+			if err := e.applyMetadata(outputName, ufsNode); err != nil {
 				return 0, err
 			}
 		}
@@ -112,6 +193,25 @@ func ExtractToDir(c context.Context, ls *ipld.LinkSystem, root cid.Cid, outputDi
 	return count, nil
 }
 
+// extractor holds the state shared while extracting a UnixFS DAG.
+type extractor struct {
+	ls     *ipld.LinkSystem
+	opts   ExtractOptions
+	logger io.Writer
+}
+
+func (e *extractor) resolvePath(root, pth string) (string, error) {
+	if e.opts.DryRun != nil {
+		// nothing is written, so there is no risk of redirecting through symlinks.
+		rp, err := filepath.Rel("/", pth)
+		if err != nil {
+			return "", fmt.Errorf("couldn't check relative-ness of %s: %w", pth, err)
+		}
+		return path.Join(root, rp), nil
+	}
+	return resolvePath(root, pth)
+}
+
 func resolvePath(root, pth string) (string, error) {
 	rp, err := filepath.Rel("/", pth)
 	if err != nil {
@@ -130,14 +230,19 @@ func resolvePath(root, pth string) (string, error) {
 	return joined, nil
 }
 
-func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot, outputPath string, matchPath []string, verbose bool, logger io.Writer) (int, error) {
+func (e *extractor) extractDir(c context.Context, n ipld.Node, outputRoot, outputPath string, matchPath []string) (int, error) {
+	ls, verbose, logger := e.ls, e.opts.Verbose, e.logger
 	if outputRoot != "" {
-		dirPath, err := resolvePath(outputRoot, outputPath)
+		dirPath, err := e.resolvePath(outputRoot, outputPath)
 		if err != nil {
 			return 0, err
 		}
 		// make the directory.
-		if err := os.MkdirAll(dirPath, 0755); err != nil {
+		if e.opts.DryRun != nil {
+			if n.Kind() == ipld.Kind_Map {
+				fmt.Fprintf(e.opts.DryRun, "%s/\n", dirPath)
+			}
+		} else if err := os.MkdirAll(dirPath, 0755); err != nil {
 			return 0, err
 		}
 	}
@@ -155,7 +260,7 @@ func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot,
 		var nextRes string
 		if outputRoot != "" {
 			var err error
-			nextRes, err = resolvePath(outputRoot, path.Join(outputPath, name))
+			nextRes, err = e.resolvePath(outputRoot, path.Join(outputPath, name))
 			if err != nil {
 				return 0, err
 			}
@@ -182,7 +287,10 @@ func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot,
 		}
 		// degenerate files are handled here.
 		if dest.Kind() == ipld.Kind_Bytes {
-			if err := extractFile(c, ls, dest, nextRes); err != nil {
+			if err := e.extractFile(c, dest, nextRes); err != nil {
+				return 0, err
+			}
+			if err := e.applyMetadata(nextRes, nil); err != nil {
 				return 0, err
 			}
 			return 1, nil
@@ -215,9 +323,21 @@ func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot,
 			if err != nil {
 				return 0, err
 			}
-			return extractDir(c, ls, ufn, outputRoot, path.Join(outputPath, name), subPath, verbose, logger)
+			count, err := e.extractDir(c, ufn, outputRoot, path.Join(outputPath, name), subPath)
+			if err != nil {
+				return 0, err
+			}
+			// Applied once the directory is filled, as writing to it would
+			// otherwise change its modification time, or be denied by its mode.
+			if err := e.applyMetadata(nextRes, ufsNode); err != nil {
+				return 0, err
+			}
+			return count, nil
 		case data.Data_File, data.Data_Raw:
-			if err := extractFile(c, ls, pbnode, nextRes); err != nil {
+			if err := e.extractFile(c, pbnode, nextRes); err != nil {
+				return 0, err
+			}
+			if err := e.applyMetadata(nextRes, ufsNode); err != nil {
 				return 0, err
 			}
 			return 1, nil
@@ -226,9 +346,16 @@ func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot,
 				return 0, fmt.Errorf("cannot extract a symlink to stdout")
 			}
 			data := ufsNode.Data.Must().Bytes()
+			if e.opts.DryRun != nil {
+				fmt.Fprintf(e.opts.DryRun, "%s -> %s\n", nextRes, data)
+				return 1, nil
+			}
 			if err := os.Symlink(string(data), nextRes); err != nil {
 				return 0, err
 			}
+			if err := e.applyMetadata(nextRes, ufsNode); err != nil {
+				return 0, err
+			}
 			return 1, nil
 		default:
 			return 0, fmt.Errorf("unknown unixfs type: %d", ufsNode.DataType.Int())
@@ -244,6 +371,9 @@ func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot,
 		return extractElement(matchPath[0], val)
 	}
 
+	if e.opts.Range != nil {
+		return 0, fmt.Errorf("a byte range can only be extracted from a single file, use a path to extract a specific file")
+	}
 	if outputPath == "-" && len(matchPath) == 0 {
 		return 0, fmt.Errorf("cannot extract a directory to stdout, use a path to extract a specific file")
 	}
@@ -277,8 +407,8 @@ func extractDir(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputRoot,
 	return count, nil
 }
 
-func extractFile(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputName string) error {
-	node, err := file.NewUnixFSFile(c, n, ls)
+func (e *extractor) extractFile(c context.Context, n ipld.Node, outputName string) error {
+	node, err := file.NewUnixFSFile(c, n, e.ls)
 	if err != nil {
 		return err
 	}
@@ -286,6 +416,23 @@ func extractFile(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputName
 	if err != nil {
 		return err
 	}
+	var r io.Reader = nlr
+	if e.opts.Range != nil {
+		if r, err = fileRange(nlr, *e.opts.Range); err != nil {
+			return err
+		}
+	}
+	if e.opts.DryRun != nil {
+		size, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return err
+		}
+		if outputName == "" {
+			outputName = "-"
+		}
+		fmt.Fprintf(e.opts.DryRun, "%s (%d bytes)\n", outputName, size)
+		return nil
+	}
 	var f *os.File
 	if outputName == "" {
 		f = os.Stdout
@@ -296,6 +443,100 @@ func extractFile(c context.Context, ls *ipld.LinkSystem, n ipld.Node, outputName
 		}
 		defer f.Close()
 	}
-	_, err = io.Copy(f, nlr)
+	_, err = io.Copy(f, r)
 	return err
 }
+
+// fileRange returns a reader of the given range of the bytes of a file read by
+// rs. Seeking is resolved from the sizes recorded in the UnixFS DAG, such that
+// the blocks before the range are skipped rather than loaded, and those after
+// it are never read.
+func fileRange(rs io.ReadSeeker, br ByteRange) (io.Reader, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if br.Start < 0 || br.Start >= size || (br.End >= 0 && br.End < br.Start) {
+		return nil, fmt.Errorf("byte range %s is not satisfiable by a file of %d bytes", br, size)
+	}
+	if _, err := rs.Seek(br.Start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if br.End < 0 || br.End >= size {
+		br.End = size - 1
+	}
+	return io.LimitReader(rs, br.End-br.Start+1), nil
+}
+
+// applyMetadata applies the owner, mode and modification time of the extracted
+// entry at name as per the options, where ufsNode is its UnixFS data, or nil
+// for files without any. Only the owner of symlinks is changed, as following
+// them could change files outside of the output directory.
+func (e *extractor) applyMetadata(name string, ufsNode data.UnixFSData) error {
+	if name == "" || e.opts.DryRun != nil {
+		return nil
+	}
+	if o := e.opts.Owner; o != nil {
+		if err := os.Lchown(name, o.UID, o.GID); err != nil {
+			return err
+		}
+	}
+	var isDir bool
+	if ufsNode != nil {
+		switch ufsNode.FieldDataType().Int() {
+		case data.Data_Symlink:
+			return nil
+		case data.Data_Directory, data.Data_HAMTShard:
+			isDir = true
+		}
+	}
+
+	// The mode is set after the owner, since changing the owner may clear the
+	// setuid and setgid bits.
+	switch e.opts.Mode {
+	case MetadataPreserve:
+		if ufsNode != nil && ufsNode.FieldMode().Exists() {
+			if err := os.Chmod(name, unixFSFileMode(ufsNode.Permissions())); err != nil {
+				return err
+			}
+		}
+	case MetadataOverride:
+		if !isDir {
+			if err := os.Chmod(name, e.opts.FileMode); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch e.opts.Mtime {
+	case MetadataPreserve:
+		if ufsNode != nil && ufsNode.FieldMtime().Exists() {
+			mtime := ufsNode.FieldMtime().Must()
+			var nsec int64
+			if mtime.FieldFractionalNanoseconds().Exists() {
+				nsec = mtime.FieldFractionalNanoseconds().Must().Int()
+			}
+			t := time.Unix(mtime.FieldSeconds().Int(), nsec)
+			return os.Chtimes(name, t, t)
+		}
+	case MetadataOverride:
+		return os.Chtimes(name, e.opts.ModTime, e.opts.ModTime)
+	}
+	return nil
+}
+
+// unixFSFileMode converts the Unix permission bits recorded in UnixFS data to
+// an fs.FileMode.
+func unixFSFileMode(mode int) fs.FileMode {
+	m := fs.FileMode(mode) & fs.ModePerm
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
@@ -11,13 +11,17 @@ import (
 	"github.com/ipld/go-car/v2/blockstore"
 )
 
-func FilterCar(ctx context.Context, infile, outfile string, cidMap map[cid.Cid]struct{}, invert bool, outVersion int, appendOutFile bool) error {
+// FilterCar writes the blocks of the car at infile which match cidMap, or
+// those which do not if invert is set, to the car at outfile. The options
+// apply to both the reading of infile and the writing of outfile, on top of
+// the version given by outVersion.
+func FilterCar(ctx context.Context, infile, outfile string, cidMap map[cid.Cid]struct{}, invert bool, outVersion int, appendOutFile bool, opts ...carv2.Option) error {
 	fd, err := os.Open(infile)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
-	rd, err := carv2.NewBlockReader(fd)
+	rd, err := carv2.NewBlockReader(fd, opts...)
 	if err != nil {
 		return err
 	}
@@ -29,10 +33,10 @@ func FilterCar(ctx context.Context, infile, outfile string, cidMap map[cid.Cid]s
 		}
 	}
 
-	options := []carv2.Option{}
+	options := append([]carv2.Option{}, opts...)
 	switch outVersion {
 	case 1:
-		options = []carv2.Option{blockstore.WriteAsCarV1(true)}
+		options = append(options, blockstore.WriteAsCarV1(true))
 	case 2:
 		// already the default
 	default:
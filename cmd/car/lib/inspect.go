@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
 	"sort"
 	"strings"
 
 	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
 type Stat struct {
@@ -59,6 +63,22 @@ func (cs Counts) String() string {
 	return codecs.String()
 }
 
+// Histogram counts blocks by length in power-of-two buckets: bucket 0 counts
+// empty blocks, and bucket i > 0 counts blocks with a length in [2^(i-1), 2^i).
+type Histogram []uint64
+
+func (h Histogram) String() string {
+	var buckets strings.Builder
+	for i, count := range h {
+		if i == 0 {
+			buckets.WriteString(fmt.Sprintf("\n\t0: %d", count))
+			continue
+		}
+		buckets.WriteString(fmt.Sprintf("\n\t[%d, %d): %d", uint64(1)<<(i-1), uint64(1)<<i, count))
+	}
+	return buckets.String()
+}
+
 type Report struct {
 	Characteristics []byte
 	DataOffset      uint64
@@ -73,6 +93,47 @@ type Report struct {
 	CidLength       Stat
 	Codecs          Counts
 	Hashes          Counts
+	// CodecBytes and BlkLengthHistogram are only included in String if set.
+	CodecBytes         Counts
+	BlkLengthHistogram Histogram
+	Sample             *SampleReport
+}
+
+// SampleReport describes the outcome of hash-validating a random sample of
+// the blocks in a CAR.
+type SampleReport struct {
+	Sampled uint64
+	Corrupt uint64
+}
+
+// CorruptRatio is the estimated ratio of corrupt blocks in the CAR.
+func (s *SampleReport) CorruptRatio() float64 {
+	if s.Sampled == 0 {
+		return 0
+	}
+	return float64(s.Corrupt) / float64(s.Sampled)
+}
+
+// CorruptRatioUpperBound is the upper bound of the 95% confidence interval of
+// the ratio of corrupt blocks in the CAR, computed as a Wilson score interval.
+// It remains meaningful when no corrupt blocks were found in the sample.
+func (s *SampleReport) CorruptRatioUpperBound() float64 {
+	if s.Sampled == 0 {
+		return 1
+	}
+	const z = 1.96
+	n := float64(s.Sampled)
+	p := s.CorruptRatio()
+	centre := p + z*z/(2*n)
+	spread := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	return math.Min(1, (centre+spread)/(1+z*z/n))
+}
+
+func (s *SampleReport) String() string {
+	return fmt.Sprintf(`Sampled blocks: %d
+Corrupt sampled blocks: %d
+Estimated corrupt block ratio: %.4f%% (95%% upper bound: %.4f%%)
+`, s.Sampled, s.Corrupt, 100*s.CorruptRatio(), 100*s.CorruptRatioUpperBound())
 }
 
 func (r *Report) String() string {
@@ -101,6 +162,17 @@ Block count per codec:%s
 CID count per multihash:%s
 `
 
+	var extra string
+	if r.CodecBytes != nil {
+		extra += fmt.Sprintf("Block bytes per codec:%s\n", r.CodecBytes.String())
+	}
+	if r.BlkLengthHistogram != nil {
+		extra += fmt.Sprintf("Block length histogram (bytes):%s\n", r.BlkLengthHistogram.String())
+	}
+	if r.Sample != nil {
+		extra += r.Sample.String()
+	}
+
 	return fmt.Sprintf(
 		pfmt,
 		r.Version,
@@ -112,7 +184,7 @@ CID count per multihash:%s
 		r.CidLength.String(),
 		r.Codecs.String(),
 		r.Hashes.String(),
-	)
+	) + extra
 }
 
 func InspectCar(inStream *os.File, verifyHashes bool) (*Report, error) {
@@ -165,3 +237,130 @@ func InspectCar(inStream *os.File, verifyHashes bool) (*Report, error) {
 
 	return &rep, nil
 }
+
+// HeaderReport describes a CAR from its headers and index alone, as produced
+// by InspectCarHeader.
+type HeaderReport struct {
+	Version         int
+	Roots           Roots
+	Characteristics []byte
+	DataOffset      uint64
+	DataLength      uint64
+	IndexOffset     uint64
+	IndexType       string
+	// IndexRecords is the number of records in the index, or -1 if the CAR
+	// has no index or its records cannot be enumerated.
+	IndexRecords int64
+}
+
+func (r *HeaderReport) String() string {
+	var v2s string
+	if r.Version == 2 {
+		v2s = fmt.Sprintf(`Characteristics: %x
+Data offset: %d
+Data (payload) length: %d
+Index offset: %d
+Index type: %s
+`, r.Characteristics, r.DataOffset, r.DataLength, r.IndexOffset, r.IndexType)
+		if r.IndexRecords >= 0 {
+			v2s += fmt.Sprintf("Index record count: %d\n", r.IndexRecords)
+		}
+	}
+	return fmt.Sprintf("Version: %d\n%sRoots:%s\n", r.Version, v2s, r.Roots.String())
+}
+
+// InspectCarHeader reports on the CAR read from inStream by reading its
+// headers and, for a CARv2, its index only, without scanning the data payload.
+func InspectCarHeader(inStream io.ReaderAt) (*HeaderReport, error) {
+	rd, err := carv2.NewReader(inStream)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := rd.Roots()
+	if err != nil {
+		return nil, err
+	}
+	rep := HeaderReport{Version: int(rd.Version), Roots: []string{}, IndexRecords: -1}
+	for _, c := range roots {
+		rep.Roots = append(rep.Roots, c.String())
+	}
+	if rd.Version != 2 {
+		return &rep, nil
+	}
+
+	var buf bytes.Buffer
+	rd.Header.Characteristics.WriteTo(&buf)
+	rep.Characteristics = buf.Bytes()
+	rep.DataOffset = rd.Header.DataOffset
+	rep.DataLength = rd.Header.DataSize
+	rep.IndexOffset = rd.Header.IndexOffset
+	rep.IndexType = "(none)"
+	if !rd.Header.HasIndex() {
+		return &rep, nil
+	}
+	ir, err := rd.IndexReader()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.ReadFrom(ir)
+	if err != nil {
+		return nil, err
+	}
+	rep.IndexType = idx.Codec().String()
+	if iter, ok := idx.(index.IterableIndex); ok {
+		rep.IndexRecords = 0
+		if err := iter.ForEach(func(_ multihash.Multihash, _ uint64) error {
+			rep.IndexRecords++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &rep, nil
+}
+
+// SampleCar hash-validates a random sample of sampleSize blocks out of the
+// blockCount blocks in the CAR read from inStream, starting at its current
+// position. Blocks that are not sampled are skipped over without being read
+// whenever inStream is seekable.
+func SampleCar(inStream io.Reader, blockCount, sampleSize uint64, rng *rand.Rand) (*SampleReport, error) {
+	if sampleSize > blockCount {
+		sampleSize = blockCount
+	}
+	// Select the sampled block positions using Floyd's algorithm, which
+	// requires memory proportional to the sample size only.
+	selected := make(map[uint64]struct{}, sampleSize)
+	for j := blockCount - sampleSize; j < blockCount; j++ {
+		t := uint64(rng.Int63n(int64(j + 1)))
+		if _, ok := selected[t]; ok {
+			t = j
+		}
+		selected[t] = struct{}{}
+	}
+
+	// Trust the CAR so that the block reader does not fail on the first
+	// corrupt block; validation is performed on sampled blocks below.
+	br, err := carv2.NewBlockReader(inStream, carv2.ZeroLengthSectionAsEOF(true), carv2.WithTrustedCAR(true))
+	if err != nil {
+		return nil, err
+	}
+	var rep SampleReport
+	for i := uint64(0); rep.Sampled < sampleSize; i++ {
+		if _, ok := selected[i]; !ok {
+			if _, err := br.SkipNext(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		blk, err := br.Next()
+		if err != nil {
+			return nil, err
+		}
+		rep.Sampled++
+		got, err := blk.Cid().Prefix().Sum(blk.RawData())
+		if err != nil || !got.Equals(blk.Cid()) {
+			rep.Corrupt++
+		}
+	}
+	return &rep, nil
+}
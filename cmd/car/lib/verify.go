@@ -1,9 +1,12 @@
 package lib
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
@@ -11,97 +14,183 @@ import (
 	"github.com/multiformats/go-multihash"
 )
 
+// VerifyReport describes the outcome of Verify.
+type VerifyReport struct {
+	Version uint64 `json:"version"`
+	// Blocks is the number of blocks whose data was checked against their CID.
+	Blocks uint64 `json:"blocks"`
+	// IndexCodec is the codec of the index of a CARv2, if it has one.
+	IndexCodec string `json:"indexCodec,omitempty"`
+	// IndexRecords is the number of index records checked against the section
+	// at their offset, which requires the index to be iterable.
+	IndexRecords uint64 `json:"indexRecords"`
+	// Problems lists the problems found, if any.
+	Problems []string `json:"problems"`
+}
+
+// Err returns an error listing the problems found, or nil if there are none.
+func (r VerifyReport) Err() error {
+	errs := make([]error, len(r.Problems))
+	for i, p := range r.Problems {
+		errs[i] = errors.New(p)
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyCar checks that the car at file is wellformed, returning an error
+// listing the problems found otherwise; see Verify.
 func VerifyCar(file string) error {
-	// header
-	rx, err := carv2.OpenReader(file)
+	report, err := Verify(file)
 	if err != nil {
 		return err
 	}
+	return report.Err()
+}
+
+// Verify checks that the car at file is wellformed, namely that:
+//
+// • its header lists roots, and the offsets of a CARv2 header are consistent;
+//
+// • the data of every block matches its CID, as per Inspect;
+//
+// • every root is present as a block;
+//
+// • the index of a CARv2, if any, holds every block at its offset, and each of
+// its records points at the section of a block with the same multihash.
+//
+// The problems found are listed in the returned report. An error is only
+// returned if the car cannot be read at all.
+func Verify(file string) (VerifyReport, error) {
+	rx, err := carv2.OpenReader(file)
+	if err != nil {
+		return VerifyReport{}, err
+	}
 	defer rx.Close()
+
+	report := VerifyReport{Version: rx.Version, Problems: []string{}}
+	problemf := func(format string, args ...any) {
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	// header
 	roots, err := rx.Roots()
 	if err != nil {
-		return err
+		return VerifyReport{}, err
 	}
 	if len(roots) == 0 {
-		return fmt.Errorf("no roots listed in car header")
+		problemf("no roots listed in car header")
 	}
-	rootMap := make(map[cid.Cid]struct{})
-	for _, r := range roots {
-		rootMap[r] = struct{}{}
-	}
-
 	if rx.Version == 2 {
 		if rx.Header.DataSize == 0 {
-			return fmt.Errorf("size of wrapped v1 car listed as '0'")
+			problemf("size of wrapped v1 car listed as '0'")
 		}
 
 		flen, err := os.Stat(file)
 		if err != nil {
-			return err
+			return VerifyReport{}, err
 		}
 		lengthToIndex := carv2.PragmaSize + carv2.HeaderSize + rx.Header.DataSize
 		if uint64(flen.Size()) > lengthToIndex && rx.Header.IndexOffset == 0 {
-			return fmt.Errorf("header claims no index, but extra bytes in file beyond data size")
+			problemf("header claims no index, but extra bytes in file beyond data size")
 		}
 		if rx.Header.DataOffset < carv2.PragmaSize+carv2.HeaderSize {
-			return fmt.Errorf("data offset places data within carv2 header")
+			problemf("data offset places data within carv2 header")
 		}
 		if rx.Header.IndexOffset < lengthToIndex {
-			return fmt.Errorf("index offset overlaps with data. data ends at %d. index offset of %d", lengthToIndex, rx.Header.IndexOffset)
+			problemf("index offset overlaps with data. data ends at %d. index offset of %d", lengthToIndex, rx.Header.IndexOffset)
 		}
 	}
 
 	// blocks
+	stats, err := rx.Inspect(true)
+	if err != nil {
+		problemf("%s", err)
+		return report, nil
+	}
+	report.Blocks = stats.BlockCount
+
+	// Walk the sections again, without reading block data, for their offsets.
 	fd, err := os.Open(file)
 	if err != nil {
-		return err
+		return VerifyReport{}, err
 	}
+	defer fd.Close()
 	rd, err := carv2.NewBlockReader(fd)
 	if err != nil {
-		return err
+		return VerifyReport{}, err
 	}
-
-	cidList := make([]cid.Cid, 0)
+	rootMap := make(map[cid.Cid]struct{})
+	for _, r := range roots {
+		rootMap[r] = struct{}{}
+	}
+	var sections []*carv2.BlockMetadata
 	for {
-		blk, err := rd.Next()
+		md, err := rd.SkipNext()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			problemf("%s", err)
+			return report, nil
 		}
-		delete(rootMap, blk.Cid())
-		cidList = append(cidList, blk.Cid())
+		delete(rootMap, md.Cid)
+		sections = append(sections, md)
 	}
-
 	if len(rootMap) > 0 {
-		return fmt.Errorf("header lists root(s) not present as a block: %v", rootMap)
+		missing := make([]string, 0, len(rootMap))
+		for r := range rootMap {
+			missing = append(missing, r.String())
+		}
+		sort.Strings(missing)
+		problemf("header lists root(s) not present as a block: %v", missing)
 	}
 
 	// index
-	if rx.Version == 2 && rx.Header.HasIndex() {
-		ir, err := rx.IndexReader()
-		if err != nil {
-			return err
+	if rx.Version != 2 || !rx.Header.HasIndex() {
+		return report, nil
+	}
+	ir, err := rx.IndexReader()
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	idx, err := index.ReadFrom(ir)
+	if err != nil {
+		problemf("invalid index: %s", err)
+		return report, nil
+	}
+	report.IndexCodec = idx.Codec().String()
+
+	fullyIndexed := rx.Header.Characteristics.IsFullyIndexed()
+	byOffset := make(map[uint64]multihash.Multihash, len(sections))
+	for _, md := range sections {
+		byOffset[md.Offset] = md.Cid.Hash()
+		if !fullyIndexed && md.Cid.Prefix().MhType == multihash.IDENTITY {
+			continue
 		}
-		idx, err := index.ReadFrom(ir)
-		if err != nil {
-			return err
+		var found bool
+		if err := idx.GetAll(md.Cid, func(offset uint64) bool {
+			found = offset == md.Offset
+			return !found
+		}); err != nil {
+			problemf("could not look up known cid %s in index: %s", md.Cid, err)
+		} else if !found {
+			problemf("index does not hold block %s at its offset %d", md.Cid, md.Offset)
 		}
-		for _, c := range cidList {
-			cidHash, err := multihash.Decode(c.Hash())
-			if err != nil {
-				return err
-			}
-			if cidHash.Code == multihash.IDENTITY {
-				continue
-			}
-			if err := idx.GetAll(c, func(_ uint64) bool {
-				return true
-			}); err != nil {
-				return fmt.Errorf("could not look up known cid %s in index: %w", c, err)
+	}
+
+	if iterable, ok := idx.(index.IterableIndex); ok {
+		if err := iterable.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			report.IndexRecords++
+			switch got, ok := byOffset[offset]; {
+			case !ok:
+				problemf("index record of %s points at offset %d, where no section starts", mh.B58String(), offset)
+			case !bytes.Equal(mh, got):
+				problemf("index record of %s points at offset %d, which holds %s", mh.B58String(), offset, got.B58String())
 			}
+			return nil
+		}); err != nil {
+			problemf("invalid index: %s", err)
 		}
 	}
-	return nil
+	return report, nil
 }
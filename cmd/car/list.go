@@ -20,9 +20,24 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// listFormattingFlags are the flags of ListCar which change its output, and
+// which cannot be combined with --roots-only or --cids-only.
+var listFormattingFlags = []string{"verbose", "unixfs", "unixfs-blocks", "tree", "color", "size"}
+
 // ListCar is a command to output the cids in a car.
+//
+// The output of --roots-only and --cids-only is one CID per line, in their
+// default string encoding, with nothing else; it is meant to be consumed by
+// scripts and will remain stable.
 func ListCar(c *cli.Context) error {
-	var err error
+	if err := checkPlumbingFlags(c); err != nil {
+		return err
+	}
+	filter, err := listFilter(c)
+	if err != nil {
+		return err
+	}
+
 	outStream := os.Stdout
 	if c.Args().Len() >= 2 {
 		outStream, err = os.Create(c.Args().Get(1))
@@ -32,7 +47,7 @@ func ListCar(c *cli.Context) error {
 	}
 	defer outStream.Close()
 
-	if c.Bool("unixfs") || c.Bool("unixfs-blocks") {
+	if c.Bool("unixfs") || c.Bool("unixfs-blocks") || c.Bool("tree") {
 		return listUnixfs(c, outStream)
 	}
 
@@ -50,6 +65,14 @@ func ListCar(c *cli.Context) error {
 		return err
 	}
 
+	if c.Bool("roots-only") {
+		for _, r := range rd.Roots {
+			fmt.Fprintf(outStream, "%s\n", r)
+		}
+		return nil
+	}
+
+	var matched int
 	for {
 		blk, err := rd.Next()
 		if err != nil {
@@ -58,6 +81,10 @@ func ListCar(c *cli.Context) error {
 			}
 			return err
 		}
+		if filter != nil && !filter(blk.Cid()) {
+			continue
+		}
+		matched++
 		if c.Bool("verbose") {
 			fmt.Fprintf(outStream, "%s: %s\n",
 				multicodec.Code(blk.Cid().Prefix().Codec).String(),
@@ -118,10 +145,61 @@ func ListCar(c *cli.Context) error {
 			fmt.Fprintf(outStream, "%s\n", blk.Cid())
 		}
 	}
+	if filter != nil {
+		// The count goes to stderr, such that the output remains a listing.
+		fmt.Fprintf(c.App.ErrWriter, "%d blocks matched\n", matched)
+	}
 
 	return err
 }
 
+// listFilter returns a function matching the CIDs of the blocks to list,
+// according to --codec and --mh, or nil if neither is set.
+func listFilter(c *cli.Context) (func(cid.Cid) bool, error) {
+	if !c.IsSet("codec") && !c.IsSet("mh") {
+		return nil, nil
+	}
+	for _, f := range []string{"unixfs", "unixfs-blocks", "tree", "roots-only"} {
+		if c.Bool(f) {
+			return nil, fmt.Errorf("--codec and --mh cannot be combined with --%s", f)
+		}
+	}
+	var codec, mh multicodec.Code
+	if c.IsSet("codec") {
+		if err := codec.Set(c.String("codec")); err != nil {
+			return nil, err
+		}
+	}
+	if c.IsSet("mh") {
+		if err := mh.Set(c.String("mh")); err != nil {
+			return nil, err
+		}
+	}
+	return func(k cid.Cid) bool {
+		prefix := k.Prefix()
+		if c.IsSet("codec") && prefix.Codec != uint64(codec) {
+			return false
+		}
+		return !c.IsSet("mh") || prefix.MhType == uint64(mh)
+	}, nil
+}
+
+// checkPlumbingFlags checks that --roots-only and --cids-only are not combined
+// with each other, or with flags that change the output.
+func checkPlumbingFlags(c *cli.Context) error {
+	for _, plumbing := range []string{"roots-only", "cids-only"} {
+		if !c.Bool(plumbing) {
+			continue
+		}
+		for _, f := range append([]string{"roots-only", "cids-only"}, listFormattingFlags...) {
+			if f != plumbing && c.IsSet(f) {
+				return fmt.Errorf("--%s cannot be combined with --%s", plumbing, f)
+			}
+		}
+	}
+	return nil
+}
+
 func listUnixfs(c *cli.Context, outStream io.Writer) error {
 	if c.Args().Len() == 0 {
 		return fmt.Errorf("must provide file to read from. unixfs reading requires random access")
@@ -150,6 +228,13 @@ func listUnixfs(c *cli.Context, outStream io.Writer) error {
 		return err
 	}
 	for _, r := range roots {
+		if c.Bool("tree") {
+			fmt.Fprintf(outStream, "%s\n", r)
+			if err := printUnixFSTree(c, "", r, &ls, outStream); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := printUnixFSNode(c, "", r, &ls, outStream); err != nil {
 			return err
 		}
@@ -157,76 +242,164 @@ func listUnixfs(c *cli.Context, outStream io.Writer) error {
 	return nil
 }
 
-func printUnixFSNode(c *cli.Context, prefix string, node cid.Cid, ls *ipld.LinkSystem, outStream io.Writer) error {
+// unixfsEntry is a named entry of a UnixFS directory.
+type unixfsEntry struct {
+	name string
+	cid  cid.Cid
+}
+
+// loadUnixFSNode loads the given UnixFS node, returning ok as false if it is a
+// raw node, which carries no UnixFS data.
+func loadUnixFSNode(node cid.Cid, ls *ipld.LinkSystem) (pbn dagpb.PBNode, ufd data.UnixFSData, ok bool, err error) {
 	// it might be a raw file (bytes) node. if so, not actually an error.
 	if node.Prefix().Codec == cid.Raw {
-		return nil
+		return nil, nil, false, nil
 	}
 
-	pbn, err := ls.Load(ipld.LinkContext{}, cidlink.Link{Cid: node}, dagpb.Type.PBNode)
+	n, err := ls.Load(ipld.LinkContext{}, cidlink.Link{Cid: node}, dagpb.Type.PBNode)
 	if err != nil {
-		return err
+		return nil, nil, false, err
 	}
+	pbn = n.(dagpb.PBNode)
 
-	pbnode := pbn.(dagpb.PBNode)
-
-	ufd, err := data.DecodeUnixFSData(pbnode.Data.Must().Bytes())
+	ufd, err = data.DecodeUnixFSData(pbn.Data.Must().Bytes())
 	if err != nil {
-		return err
+		return nil, nil, false, err
+	}
+	return pbn, ufd, true, nil
+}
+
+// unixfsChildren returns the entries of the given UnixFS node if it is a
+// directory or a HAMT shard, and nil otherwise.
+func unixfsChildren(c *cli.Context, node cid.Cid, ls *ipld.LinkSystem) ([]unixfsEntry, error) {
+	pbn, ufd, ok, err := loadUnixFSNode(node, ls)
+	if err != nil || !ok {
+		return nil, err
 	}
 
-	if ufd.FieldDataType().Int() == data.Data_Directory {
-		i := pbnode.Links.Iterator()
+	var entries []unixfsEntry
+	switch ufd.FieldDataType().Int() {
+	case data.Data_Directory:
+		i := pbn.Links.Iterator()
 		for !i.Done() {
 			_, l := i.Next()
-			name := path.Join(prefix, l.Name.Must().String())
-			if c.Bool("unixfs-blocks") {
-				cidL, _ := l.Hash.AsLink()
-				fmt.Fprintf(outStream, "%s %s\n", cidL.(cidlink.Link).Cid, name)
-			} else {
-				fmt.Fprintf(outStream, "%s\n", name)
-			}
-			// recurse into the file/directory
 			cl, err := l.Hash.AsLink()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if cidl, ok := cl.(cidlink.Link); ok {
-				if err := printUnixFSNode(c, name, cidl.Cid, ls, outStream); err != nil {
-					return err
-				}
+				entries = append(entries, unixfsEntry{l.Name.Must().String(), cidl.Cid})
 			}
-
 		}
-	} else if ufd.FieldDataType().Int() == data.Data_HAMTShard {
+	case data.Data_HAMTShard:
 		hn, err := hamt.AttemptHAMTShardFromNode(c.Context, pbn, ls)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		i := hn.Iterator()
 		for !i.Done() {
 			n, l := i.Next()
-			if c.Bool("unixfs-blocks") {
-				cl, _ := l.AsLink()
-				fmt.Fprintf(outStream, "%s %s\n", cl.(cidlink.Link).Cid, path.Join(prefix, n.String()))
-			} else {
-				fmt.Fprintf(outStream, "%s\n", path.Join(prefix, n.String()))
-			}
-			// recurse into the file/directory
 			cl, err := l.AsLink()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if cidl, ok := cl.(cidlink.Link); ok {
-				if err := printUnixFSNode(c, path.Join(prefix, n.String()), cidl.Cid, ls, outStream); err != nil {
-					return err
-				}
+				entries = append(entries, unixfsEntry{n.String(), cidl.Cid})
 			}
 		}
-	} else {
+	default:
 		// file, file chunk, symlink, other un-named entities.
-		return nil
+		return nil, nil
+	}
+	return entries, nil
+}
+
+func printUnixFSNode(c *cli.Context, prefix string, node cid.Cid, ls *ipld.LinkSystem, outStream io.Writer) error {
+	entries, err := unixfsChildren(c, node, ls)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(prefix, e.name)
+		if c.Bool("unixfs-blocks") {
+			fmt.Fprintf(outStream, "%s %s\n", e.cid, name)
+		} else {
+			fmt.Fprintf(outStream, "%s\n", name)
+		}
+		// recurse into the file/directory
+		if err := printUnixFSNode(c, name, e.cid, ls, outStream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	colorReset   = "\x1b[0m"
+	colorDir     = "\x1b[1;34m"
+	colorSymlink = "\x1b[1;36m"
+)
+
+// printUnixFSTree prints the entries below the given UnixFS node in the
+// manner of the tree command, each line of the subtree starting with indent.
+func printUnixFSTree(c *cli.Context, indent string, node cid.Cid, ls *ipld.LinkSystem, outStream io.Writer) error {
+	entries, err := unixfsChildren(c, node, ls)
+	if err != nil {
+		return err
 	}
+	for i, e := range entries {
+		branch, childIndent := "├── ", indent+"│   "
+		if i == len(entries)-1 {
+			branch, childIndent = "└── ", indent+"    "
+		}
+
+		label := e.name
+		_, ufd, ok, err := loadUnixFSNode(e.cid, ls)
+		if err != nil {
+			return err
+		}
+		isDir := ok && (ufd.FieldDataType().Int() == data.Data_Directory || ufd.FieldDataType().Int() == data.Data_HAMTShard)
+		if c.Bool("color") {
+			if isDir {
+				label = colorDir + label + colorReset
+			} else if ok && ufd.FieldDataType().Int() == data.Data_Symlink {
+				label = colorSymlink + label + colorReset
+			}
+		}
+		if c.Bool("size") && !isDir {
+			size, err := unixfsFileSize(c, e.cid, ufd, ok, ls)
+			if err != nil {
+				return err
+			}
+			label = fmt.Sprintf("[%s] %s", humanize.Bytes(size), label)
+		}
+		fmt.Fprintf(outStream, "%s%s%s\n", indent, branch, label)
 
+		if isDir {
+			if err := printUnixFSTree(c, childIndent, e.cid, ls, outStream); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// unixfsFileSize returns the size of the content of a UnixFS file, given its
+// decoded UnixFS data, or the size of the block if it is a raw node.
+func unixfsFileSize(c *cli.Context, node cid.Cid, ufd data.UnixFSData, ok bool, ls *ipld.LinkSystem) (uint64, error) {
+	if !ok {
+		r, err := ls.StorageReadOpener(ipld.LinkContext{Ctx: c.Context}, cidlink.Link{Cid: node})
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.Copy(io.Discard, r)
+		return uint64(n), err
+	}
+	if ufd.FieldFileSize().Exists() {
+		return uint64(ufd.FieldFileSize().Must().Int()), nil
+	}
+	if ufd.FieldData().Exists() {
+		return uint64(len(ufd.FieldData().Must().Bytes())), nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-car/cmd/car/lib"
+	"github.com/ipld/go-car/v2/blockstore"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/polydawn/refmt/json"
+	"github.com/urfave/cli/v2"
+)
+
+var errReplExit = errors.New("exit")
+
+const replHelp = `Commands:
+  roots                  list the roots of the car
+  pwd                    print the current path
+  ls [path]              list the entries of a directory, map or list
+  cd [path]              change the current path; "/" lists the roots
+  show [path]            print a node as dag-json
+  cat <path>             print the content of a UnixFS file
+  extract [path] [dir]   extract UnixFS files and directories into dir
+  help                   print this help
+  exit                   leave the repl
+Paths are made of "/"-separated entry names, map keys or list indices, and
+may start with "/<cid>" to start from any block of the car.
+`
+
+// Repl is a command to interactively explore the contents of a car.
+func Repl(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: car repl <file.car>")
+	}
+	bs, err := blockstore.OpenReadOnly(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer bs.Close()
+
+	r, err := newRepl(c.Context, bs, c.App.Writer, c.App.ErrWriter)
+	if err != nil {
+		return err
+	}
+
+	interactive := false
+	if f, ok := c.App.Reader.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && (stat.Mode()&os.ModeCharDevice) != 0 {
+			interactive = true
+		}
+	}
+	if interactive {
+		fmt.Fprint(c.App.Writer, "Type \"help\" for a list of commands.\n")
+	}
+
+	scanner := bufio.NewScanner(c.App.Reader)
+	for {
+		if interactive {
+			fmt.Fprintf(c.App.Writer, "%s> ", r.pwd())
+		}
+		if !scanner.Scan() {
+			break
+		}
+		if err := r.exec(strings.Fields(scanner.Text())); err != nil {
+			if err == errReplExit {
+				return nil
+			}
+			fmt.Fprintf(c.App.ErrWriter, "error: %s\n", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// replFrame is a node along the current path of the repl.
+type replFrame struct {
+	name string
+	// c is the CID of the block holding the node.
+	c cid.Cid
+	// node is the node, reified as UnixFS where possible.
+	node datamodel.Node
+	// inBlock is set if the node is nested in the block rather than its root.
+	inBlock bool
+}
+
+type repl struct {
+	ctx    context.Context
+	out    io.Writer
+	logger io.Writer
+	ls     ipld.LinkSystem
+	roots  []cid.Cid
+	// cwd is the current path; empty when at "/", which lists the roots.
+	cwd []replFrame
+}
+
+func newRepl(ctx context.Context, bs *blockstore.ReadOnly, out, logger io.Writer) (*repl, error) {
+	roots, err := bs.Roots()
+	if err != nil {
+		return nil, err
+	}
+	r := &repl{ctx: ctx, out: out, logger: logger, roots: roots}
+	r.ls = cidlink.DefaultLinkSystem()
+	r.ls.TrustedStorage = true
+	r.ls.StorageReadOpener = func(_ ipld.LinkContext, l ipld.Link) (io.Reader, error) {
+		cl, ok := l.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("not a cidlink")
+		}
+		blk, err := bs.Get(ctx, cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(blk.RawData()), nil
+	}
+	unixfsnode.AddUnixFSReificationToLinkSystem(&r.ls)
+
+	// start at the root if there is only one.
+	if len(roots) == 1 {
+		if r.cwd, err = r.resolve(roots[0].String()); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *repl) exec(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "help":
+		fmt.Fprint(r.out, replHelp)
+	case "exit", "quit":
+		return errReplExit
+	case "roots":
+		for _, root := range r.roots {
+			fmt.Fprintln(r.out, root)
+		}
+	case "pwd":
+		fmt.Fprintln(r.out, r.pwd())
+	case "ls":
+		return r.list(args)
+	case "cd":
+		return r.cd(args)
+	case "show":
+		return r.show(args)
+	case "cat":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: cat <path>")
+		}
+		return r.extract([]string{args[0], "-"})
+	case "extract":
+		return r.extract(args)
+	default:
+		return fmt.Errorf("unknown command %q; type \"help\" for a list of commands", cmd)
+	}
+	return nil
+}
+
+func (r *repl) pwd() string {
+	return formatPath(r.cwd)
+}
+
+func formatPath(frames []replFrame) string {
+	var sb strings.Builder
+	for _, f := range frames {
+		sb.WriteString("/")
+		sb.WriteString(f.name)
+	}
+	if sb.Len() == 0 {
+		return "/"
+	}
+	return sb.String()
+}
+
+// optionalPath returns the frames of the path given as the only argument, or
+// of the current path if there is no argument.
+func (r *repl) optionalPath(cmd string, args []string) ([]replFrame, error) {
+	switch len(args) {
+	case 0:
+		return r.cwd, nil
+	case 1:
+		return r.resolve(args[0])
+	default:
+		return nil, fmt.Errorf("usage: %s [path]", cmd)
+	}
+}
+
+// resolve returns the frames of the given path, relative to the current path
+// unless it starts with "/".
+func (r *repl) resolve(pth string) ([]replFrame, error) {
+	frames := r.cwd
+	if strings.HasPrefix(pth, "/") {
+		frames = nil
+	}
+	frames = append([]replFrame(nil), frames...)
+	for _, seg := range strings.Split(pth, "/") {
+		switch seg {
+		case "", ".":
+		case "..":
+			if len(frames) > 0 {
+				frames = frames[:len(frames)-1]
+			}
+		default:
+			f, err := r.step(frames, seg)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, f)
+		}
+	}
+	return frames, nil
+}
+
+// step returns the frame of the entry named seg below the given path.
+func (r *repl) step(frames []replFrame, seg string) (replFrame, error) {
+	if len(frames) == 0 {
+		c, err := cid.Parse(seg)
+		if err != nil {
+			return replFrame{}, fmt.Errorf("%s: not a CID", seg)
+		}
+		n, err := r.load(c)
+		if err != nil {
+			return replFrame{}, err
+		}
+		return replFrame{name: seg, c: c, node: n}, nil
+	}
+
+	parent := frames[len(frames)-1]
+	if k := parent.node.Kind(); k != datamodel.Kind_Map && k != datamodel.Kind_List {
+		return replFrame{}, fmt.Errorf("%s: %w", seg, ErrNotDir)
+	}
+	n, err := parent.node.LookupBySegment(datamodel.ParsePathSegment(seg))
+	if err != nil {
+		return replFrame{}, fmt.Errorf("%s: no such entry", seg)
+	}
+	if n.Kind() != datamodel.Kind_Link {
+		return replFrame{name: seg, c: parent.c, node: n, inBlock: true}, nil
+	}
+	l, err := n.AsLink()
+	if err != nil {
+		return replFrame{}, err
+	}
+	cl, ok := l.(cidlink.Link)
+	if !ok {
+		return replFrame{}, fmt.Errorf("%s: unsupported link type %T", seg, l)
+	}
+	if n, err = r.load(cl.Cid); err != nil {
+		return replFrame{}, err
+	}
+	return replFrame{name: seg, c: cl.Cid, node: n}, nil
+}
+
+// load loads the block with the given CID, reifying it as UnixFS if it is a
+// dag-pb block.
+func (r *repl) load(c cid.Cid) (datamodel.Node, error) {
+	n, err := r.loadBlock(c)
+	if err != nil || c.Prefix().Codec != cid.DagProtobuf {
+		return n, err
+	}
+	return unixfsnode.Reify(ipld.LinkContext{Ctx: r.ctx}, n, &r.ls)
+}
+
+// loadBlock loads the block with the given CID as decoded by its codec.
+func (r *repl) loadBlock(c cid.Cid) (datamodel.Node, error) {
+	var proto datamodel.NodePrototype = basicnode.Prototype.Any
+	if c.Prefix().Codec == cid.DagProtobuf {
+		proto = dagpb.Type.PBNode
+	}
+	return r.ls.Load(ipld.LinkContext{Ctx: r.ctx}, cidlink.Link{Cid: c}, proto)
+}
+
+func (r *repl) list(args []string) error {
+	frames, err := r.optionalPath("ls", args)
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		for _, root := range r.roots {
+			fmt.Fprintln(r.out, root)
+		}
+		return nil
+	}
+
+	n := frames[len(frames)-1].node
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		it := n.MapIterator()
+		for !it.Done() {
+			k, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			name, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(r.out, "%s\t%s\n", name, describeNode(v))
+		}
+	case datamodel.Kind_List:
+		it := n.ListIterator()
+		for !it.Done() {
+			i, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(r.out, "%d\t%s\n", i, describeNode(v))
+		}
+	default:
+		return ErrNotDir
+	}
+	return nil
+}
+
+// describeNode returns the CID of a link, or otherwise the kind of a node.
+func describeNode(n datamodel.Node) string {
+	if n.Kind() == datamodel.Kind_Link {
+		if l, err := n.AsLink(); err == nil {
+			return l.String()
+		}
+	}
+	return n.Kind().String()
+}
+
+func (r *repl) cd(args []string) error {
+	frames, err := r.optionalPath("cd", args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		// like a shell, go back to where the repl started.
+		frames = nil
+		if len(r.roots) == 1 {
+			if frames, err = r.resolve("/" + r.roots[0].String()); err != nil {
+				return err
+			}
+		}
+	}
+	if len(frames) > 0 {
+		if k := frames[len(frames)-1].node.Kind(); k != datamodel.Kind_Map && k != datamodel.Kind_List {
+			return ErrNotDir
+		}
+	}
+	r.cwd = frames
+	return nil
+}
+
+func (r *repl) show(args []string) error {
+	frames, err := r.optionalPath("show", args)
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("usage: show <path>")
+	}
+
+	// show blocks as decoded by their codec, rather than their UnixFS form.
+	f := frames[len(frames)-1]
+	n := f.node
+	if !f.inBlock {
+		if n, err = r.loadBlock(f.c); err != nil {
+			return err
+		}
+	}
+	opts := dagjson.EncodeOptions{
+		EncodeLinks: true,
+		EncodeBytes: true,
+		MapSortMode: codec.MapSortMode_Lexical,
+	}
+	return dagjson.Marshal(n, json.NewEncoder(r.out, json.EncodeOptions{Line: []byte{'\n'}, Indent: []byte{'\t'}}), opts)
+}
+
+func (r *repl) extract(args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("usage: extract [path] [dir]")
+	}
+	frames := r.cwd
+	outputDir := "."
+	if len(args) > 0 {
+		var err error
+		if frames, err = r.resolve(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		outputDir = args[1]
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("usage: extract <path> [dir]")
+	}
+
+	// extract named entries via their parent directory, so that they keep
+	// their name.
+	f := frames[len(frames)-1]
+	root, path := f.c, []string(nil)
+	if len(frames) > 1 && !f.inBlock && !frames[len(frames)-2].inBlock && frames[len(frames)-2].c.Prefix().Codec == cid.DagProtobuf {
+		root, path = frames[len(frames)-2].c, []string{f.name}
+	} else if f.inBlock || f.c.Prefix().Codec != cid.DagProtobuf {
+		return fmt.Errorf("%s: not a UnixFS file or directory", formatPath(frames))
+	}
+
+	if outputDir != "-" {
+		if err := os.MkdirAll(outputDir, 0o755); err != nil {
+			return err
+		}
+	}
+	count, err := lib.ExtractToDir(r.ctx, &r.ls, root, outputDir, path, false, r.logger)
+	if err != nil {
+		return err
+	}
+	if outputDir != "-" {
+		fmt.Fprintf(r.out, "extracted %d file(s)\n", count)
+	}
+	return nil
+}
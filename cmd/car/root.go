@@ -1,14 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/cmd/car/lib"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
 	"github.com/urfave/cli/v2"
 )
 
-// CarRoot prints the root CID in a car
+// CarRoot prints the root CID in a car, or replaces the roots of the car with
+// the CIDs listed in the file given via --from-file.
 func CarRoot(c *cli.Context) (err error) {
+	if c.IsSet("from-file") {
+		return replaceRootsFromFile(c)
+	}
+
 	roots, err := lib.CarRoot(c.Args().First())
 	if err != nil {
 		return err
@@ -19,3 +32,211 @@ func CarRoot(c *cli.Context) (err error) {
 
 	return nil
 }
+
+func replaceRootsFromFile(c *cli.Context) error {
+	path := c.Args().First()
+	if path == "" || path == "-" {
+		return fmt.Errorf("replacing roots requires a car file to modify")
+	}
+
+	in := os.Stdin
+	if from := c.String("from-file"); from != "-" {
+		f, err := os.Open(from)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	roots, err := parseCIDList(in)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no roots given")
+	}
+	return replaceRoots(path, roots)
+}
+
+// parseCIDList reads one CID per line from r, in order, skipping blank lines.
+func parseCIDList(r io.Reader) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		c, err := cid.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		cids = append(cids, c)
+	}
+	return cids, scanner.Err()
+}
+
+// replaceRoots replaces the roots of the car at path. The header is rewritten
+// in place if its size is unchanged, or if the car is a CARv2 whose padding
+// before its data payload can absorb the change, in which case the data offset
+// is moved instead. Otherwise, the car is rewritten as a whole.
+func replaceRoots(path string, roots []cid.Cid) error {
+	rd, err := carv2.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	oldRoots, err := rd.Roots()
+	if err != nil {
+		return err
+	}
+	oldHeader, err := carv2.EncodeV1Header(1, oldRoots)
+	if err != nil {
+		return err
+	}
+	newHeader, err := carv2.EncodeV1Header(1, roots)
+	if err != nil {
+		return err
+	}
+	if len(oldHeader) == len(newHeader) {
+		rd.Close()
+		return carv2.ReplaceRootsInFile(path, roots)
+	}
+
+	// The payload is only moved relative to the header if the header is known
+	// to be exactly as long as its canonical encoding.
+	dr, err := rd.DataReader()
+	if err != nil {
+		return err
+	}
+	found := make([]byte, len(oldHeader))
+	if _, err := io.ReadFull(dr, found); err != nil {
+		return err
+	}
+	if !bytes.Equal(found, oldHeader) {
+		return fmt.Errorf("the header of %s is not canonically encoded; cannot resize it", path)
+	}
+	delta := int64(len(newHeader) - len(oldHeader))
+
+	if rd.Version == 1 {
+		return writeOutput(path, func(w io.Writer) error {
+			if _, err := w.Write(newHeader); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, dr)
+			return err
+		})
+	}
+
+	// The sections move by delta relative to the start of the data payload in
+	// either case, so the index is rebased alike.
+	var newIndex []byte
+	if rd.Header.HasIndex() {
+		ir, err := rd.IndexReader()
+		if err != nil {
+			return err
+		}
+		idx, err := index.ReadFrom(ir)
+		if err != nil {
+			return err
+		}
+		records, err := indexRecords(idx)
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			records[i].Offset = uint64(int64(records[i].Offset) + delta)
+		}
+		if idx, err = index.New(idx.Codec()); err != nil {
+			return err
+		}
+		if err := index.LoadSizedRecords(idx, records); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if _, err := index.WriteTo(idx, &buf); err != nil {
+			return err
+		}
+		newIndex = buf.Bytes()
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := rd.Header
+	padding := int64(header.DataOffset) - carv2.PragmaSize - carv2.HeaderSize
+	oldIndexLen := int64(0)
+	if header.HasIndex() {
+		oldIndexLen = fi.Size() - int64(header.IndexOffset)
+	}
+	if delta <= padding && int64(len(newIndex)) == oldIndexLen {
+		return replaceRootsInPadding(f, header, newHeader, delta, newIndex)
+	}
+
+	header.DataSize = uint64(int64(header.DataSize) + delta)
+	if header.HasIndex() {
+		header.IndexOffset = uint64(int64(header.IndexOffset) + delta)
+	}
+	return writeOutput(path, func(w io.Writer) error {
+		if _, err := w.Write(carv2.Pragma); err != nil {
+			return err
+		}
+		if _, err := header.WriteTo(w); err != nil {
+			return err
+		}
+		// Keep the padding, and whatever is embedded in it, as it is.
+		if _, err := io.Copy(w, io.NewSectionReader(f, carv2.PragmaSize+carv2.HeaderSize, padding)); err != nil {
+			return err
+		}
+		if _, err := w.Write(newHeader); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, dr); err != nil {
+			return err
+		}
+		if !header.HasIndex() {
+			return nil
+		}
+		indexPadding := int64(rd.Header.IndexOffset) - int64(rd.Header.DataOffset+rd.Header.DataSize)
+		if _, err := io.Copy(w, io.NewSectionReader(f, int64(rd.Header.DataOffset+rd.Header.DataSize), indexPadding)); err != nil {
+			return err
+		}
+		_, err := w.Write(newIndex)
+		return err
+	})
+}
+
+// replaceRootsInPadding writes newHeader delta bytes before the sections of the
+// CARv2 f, moving its data offset by as much, and overwrites its index with
+// newIndex, of the same length, whose offsets are rebased accordingly.
+func replaceRootsInPadding(f *os.File, header carv2.Header, newHeader []byte, delta int64, newIndex []byte) error {
+	oldDataOffset := int64(header.DataOffset)
+	header.DataOffset = uint64(oldDataOffset - delta)
+	header.DataSize = uint64(int64(header.DataSize) + delta)
+	if _, err := f.WriteAt(newHeader, int64(header.DataOffset)); err != nil {
+		return err
+	}
+	if delta < 0 {
+		// Zero the start of the old header, which is now part of the padding.
+		if _, err := f.WriteAt(make([]byte, -delta), oldDataOffset); err != nil {
+			return err
+		}
+	}
+	if header.HasIndex() {
+		if _, err := f.WriteAt(newIndex, int64(header.IndexOffset)); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := header.WriteTo(&buf); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(buf.Bytes(), carv2.PragmaSize)
+	return err
+}
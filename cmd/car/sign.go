@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/urfave/cli/v2"
+)
+
+// signaturePadding is the data padding a CARv2 is rewritten with when it does
+// not have enough room to embed a signature.
+const signaturePadding = 256
+
+// SignCar signs the data payload of a car, embedding the signature in the
+// padding of a CARv2 unless a detached signature is written.
+func SignCar(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("a car file must be specified")
+	}
+	path := c.Args().First()
+	key, err := readPrivateKey(c.String("key"))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sig, err := carv2.SignPayload(f, key)
+	if err != nil {
+		return err
+	}
+
+	if out := c.String("output"); out != "" {
+		encoded, err := sig.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return writeOutput(out, func(w io.Writer) error {
+			_, err := w.Write(encoded)
+			return err
+		})
+	}
+
+	version, err := carv2.ReadVersion(f)
+	if err != nil {
+		return err
+	}
+	if version != 2 {
+		return fmt.Errorf("cannot embed a signature in a CARv1; write a detached signature with --output")
+	}
+	err = embedSignature(path, sig)
+	if !errors.Is(err, carv2.ErrNotEnoughPadding) {
+		return err
+	}
+	// Make room for the signature by rewriting the car with more padding.
+	if err := padCar(path, signaturePadding); err != nil {
+		return err
+	}
+	return embedSignature(path, sig)
+}
+
+func embedSignature(path string, sig carv2.PayloadSignature) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := carv2.EmbedSignature(f, sig); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// padCar rewrites the CARv2 at path such that its data payload is preceded by
+// the given number of bytes of padding.
+func padCar(path string, padding uint64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	r, err := carv2.NewReader(src)
+	if err != nil {
+		return err
+	}
+
+	header := r.Header
+	header.DataOffset = carv2.PragmaSize + carv2.HeaderSize + padding
+	if header.HasIndex() {
+		header.IndexOffset = r.Header.IndexOffset - r.Header.DataOffset + header.DataOffset
+	}
+	return writeOutput(path, func(w io.Writer) error {
+		if _, err := w.Write(carv2.Pragma); err != nil {
+			return err
+		}
+		if _, err := header.WriteTo(w); err != nil {
+			return err
+		}
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+		// Copy the data payload along with any index that follows it.
+		if _, err := src.Seek(int64(r.Header.DataOffset), io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
+
+// VerifyCarSignature verifies the signature over the data payload of a car,
+// either embedded in it or detached.
+func VerifyCarSignature(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("a car file must be specified")
+	}
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var sig carv2.PayloadSignature
+	if path := c.String("signature"); path != "" {
+		encoded, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := sig.UnmarshalBinary(encoded); err != nil {
+			return err
+		}
+	} else if sig, err = carv2.ReadEmbeddedSignature(f); err != nil {
+		return err
+	}
+
+	var key crypto.PublicKey
+	if path := c.String("public-key"); path != "" {
+		if key, err = readPublicKey(path); err != nil {
+			return err
+		}
+	}
+	if err := sig.Verify(f, key); err != nil {
+		return err
+	}
+	if key == nil {
+		fmt.Fprintf(c.App.Writer, "valid %s signature by untrusted key %s\n", sig.Algorithm, hex.EncodeToString(sig.PublicKey))
+	} else {
+		fmt.Fprintf(c.App.Writer, "valid %s signature by %s\n", sig.Algorithm, hex.EncodeToString(sig.PublicKey))
+	}
+	return nil
+}
+
+// readPrivateKey reads a PEM encoded PKCS #8 Ed25519 private key, as generated
+// by `openssl genpkey -algorithm ed25519`.
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return signer, nil
+}
+
+// readPublicKey reads a PEM encoded PKIX Ed25519 public key, as generated by
+// `openssl pkey -pubout`.
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("unsupported public key type: %T", key)
+	}
+	return key, nil
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded %s", path, blockType)
+	}
+	return block, nil
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/ipld/go-car/cmd/car/lib"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
 	"github.com/urfave/cli/v2"
 )
 
@@ -12,6 +18,183 @@ func VerifyCar(c *cli.Context) error {
 	if c.Args().Len() == 0 {
 		return fmt.Errorf("usage: car verify <file.car>")
 	}
+	if c.IsSet("compare") {
+		if c.Bool("fix") || c.Bool("json") {
+			return fmt.Errorf("--compare cannot be used with --fix or --json")
+		}
+		return compareCarPayloads(c.Args().First(), c.String("compare"))
+	}
+	if !c.Bool("fix") {
+		if c.Bool("in-place") {
+			return fmt.Errorf("--in-place requires --fix")
+		}
+		return verifyCar(c, c.Args().First())
+	}
+
+	src := c.Args().First()
+	dst := c.Args().Get(1)
+	if c.Bool("in-place") {
+		if dst != "" && dst != src {
+			return fmt.Errorf("--in-place requires a single car file argument")
+		}
+		dst = src
+	} else if dst == "" {
+		return fmt.Errorf("usage: car verify --fix <file.car> <fixed.car>, or car verify --fix --in-place <file.car>")
+	} else if dst == src {
+		return fmt.Errorf("refusing to overwrite %s without --in-place", src)
+	}
+
+	// Ask for an index, which verify requires, even if the car has none.
+	report, err := carv2.RecoverFile(src, dst, carv2.UseIndexCodec(multicodec.CarMultihashIndexSorted))
+	if err != nil {
+		return err
+	}
+	if report.DiscardedBytes > 0 {
+		fmt.Fprintf(c.App.Writer, "discarded %d trailing bytes\n", report.DiscardedBytes)
+	}
+	if report.DataSizeFixed {
+		fmt.Fprintf(c.App.Writer, "fixed data size: %d\n", report.DataSize)
+	}
+	if report.IndexRegenerated {
+		fmt.Fprintf(c.App.Writer, "regenerated index\n")
+	}
+	if !report.Repaired() {
+		fmt.Fprintf(c.App.Writer, "nothing to fix\n")
+	}
+	return verifyCar(c, dst)
+}
+
+// verifyCar verifies the car at path, printing the report as JSON if asked
+// to, and returns an error listing the problems found, if any.
+func verifyCar(c *cli.Context, path string) error {
+	if !c.Bool("json") {
+		return lib.VerifyCar(path)
+	}
+	report, err := lib.Verify(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(c.App.Writer)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// compareCarPayloads checks that the two cars hold identical CARv1 payloads,
+// unwrapping any CARv2, and otherwise reports the offset in the payload at
+// which they first diverge along with the section it falls in.
+func compareCarPayloads(pathA, pathB string) error {
+	a, err := carv2.OpenReader(pathA)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	b, err := carv2.OpenReader(pathB)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	da, err := a.DataReader()
+	if err != nil {
+		return err
+	}
+	db, err := b.DataReader()
+	if err != nil {
+		return err
+	}
+
+	offset, err := firstDivergence(da, db)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return nil
+	}
+	if _, err := da.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := db.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	section, cidA := payloadSection(bufio.NewReader(da), offset)
+	_, cidB := payloadSection(bufio.NewReader(db), offset)
+	var where string
+	switch {
+	case section < 0:
+		where = "the header"
+	case cidA == cidB:
+		where = fmt.Sprintf("block section %d (%s)", section, orUnreadable(cidA))
+	default:
+		where = fmt.Sprintf("block section %d (%s in %s, %s in %s)", section, orUnreadable(cidA), pathA, orUnreadable(cidB), pathB)
+	}
+	return fmt.Errorf("payloads differ at offset %d, in %s", offset, where)
+}
 
-	return lib.VerifyCar(c.Args().First())
+// firstDivergence returns the offset of the first byte at which a and b
+// differ, which is the length of the shorter one if it is a prefix of the
+// other, or -1 if they are identical.
+func firstDivergence(a, b io.Reader) (int64, error) {
+	bufA := make([]byte, 32<<10)
+	bufB := make([]byte, len(bufA))
+	var offset int64
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return 0, errA
+		}
+		nb, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return 0, errB
+		}
+		n := min(na, nb)
+		for i := 0; i < n; i++ {
+			if bufA[i] != bufB[i] {
+				return offset + int64(i), nil
+			}
+		}
+		if na != nb {
+			return offset + int64(n), nil
+		}
+		if errA != nil {
+			return -1, nil
+		}
+		offset += int64(n)
+	}
+}
+
+// payloadSection returns the index of the block section of the payload read
+// from r which contains offset, along with the CID of its block, or -1 if the
+// offset falls in the header. The CID is empty if the section cannot be
+// parsed.
+func payloadSection(r io.Reader, offset int64) (int, string) {
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return -1, ""
+	}
+	section, c, end := -1, "", int64(0)
+	for {
+		md, err := br.SkipNext()
+		if err != nil {
+			if section >= 0 && offset < end {
+				return section, c
+			}
+			return section + 1, ""
+		}
+		if int64(md.SourceOffset) > offset {
+			return section, c
+		}
+		section++
+		c = md.Cid.String()
+		l := uint64(md.Cid.ByteLen()) + md.Size
+		end = int64(md.SourceOffset) + int64(varint.UvarintSize(l)) + int64(l)
+	}
+}
+
+func orUnreadable(c string) string {
+	if c == "" {
+		return "unreadable"
+	}
+	return c
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/urfave/cli/v2"
+)
+
+// WrapCar is a command to wrap a CARv1 as a CARv2 with an index.
+func WrapCar(c *cli.Context) error {
+	src, dst, err := wrapPaths(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Int("version") != 2 {
+		return fmt.Errorf("wrap only writes a CARv2; got --version %d", c.Int("version"))
+	}
+	opts, err := writeOptions(c)
+	if err != nil {
+		return err
+	}
+
+	in, cleanup, err := openSeekableInput(src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	version, err := carv2.ReadVersion(in)
+	if err != nil {
+		return err
+	}
+	if version != 1 {
+		return fmt.Errorf("source version must be 1; got: %d", version)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return writeOutput(dst, func(w io.Writer) error {
+		if noIndex(c) {
+			return wrapV1WithoutIndex(in, w)
+		}
+		return carv2.WrapV1(in, w, opts...)
+	})
+}
+
+// UnwrapCar is a command to extract the CARv1 payload of a CARv2.
+func UnwrapCar(c *cli.Context) error {
+	src, dst, err := wrapPaths(c)
+	if err != nil {
+		return err
+	}
+
+	if src != "-" && dst != "-" {
+		err := carv2.ExtractV1File(src, dst)
+		if errors.Is(err, carv2.ErrAlreadyV1) {
+			return fmt.Errorf("%s is already a CARv1", src)
+		}
+		return err
+	}
+
+	in, cleanup, err := openSeekableInput(src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	r, err := carv2.NewReader(in)
+	if err != nil {
+		return err
+	}
+	if r.Version != 2 {
+		return fmt.Errorf("source version must be 2; got: %d", r.Version)
+	}
+	dr, err := r.DataReader()
+	if err != nil {
+		return err
+	}
+	return writeOutput(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, dr)
+		return err
+	})
+}
+
+// wrapPaths returns the source and destination of a wrap or unwrap command,
+// where "-" stands for stdin and stdout respectively.
+func wrapPaths(c *cli.Context) (string, string, error) {
+	src, dst := "-", "-"
+	if c.Args().Len() >= 1 {
+		src = c.Args().Get(0)
+	}
+	if c.Args().Len() >= 2 {
+		dst = c.Args().Get(1)
+	}
+	if c.Bool("in-place") {
+		if src == "-" || (c.Args().Len() >= 2 && dst != src) {
+			return "", "", fmt.Errorf("--in-place requires a single car file argument")
+		}
+		dst = src
+	}
+	return src, dst, nil
+}
+
+// openSeekableInput opens the file at the given path, or copies stdin to a
+// temporary file if the path is "-", so that it can be read more than once.
+func openSeekableInput(path string) (*os.File, func(), error) {
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	}
+	f, err := os.CreateTemp("", "car-stdin-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
+
+// writeOutput calls write with stdout if path is "-", or with a temporary
+// file which is then renamed to path, so that path may also be the input.
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// wrapV1WithoutIndex wraps the CARv1 read from src as a CARv2 with no index.
+func wrapV1WithoutIndex(src io.ReadSeeker, dst io.Writer) error {
+	v1Size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	v2Header := carv2.NewHeader(uint64(v1Size))
+	v2Header.IndexOffset = 0
+	if _, err := dst.Write(carv2.Pragma); err != nil {
+		return err
+	}
+	if _, err := v2Header.WriteTo(dst); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
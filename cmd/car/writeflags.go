@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/urfave/cli/v2"
+)
+
+// writeFlags returns the flags shared by the commands which write a car, so
+// that they are all tuned the same way. They are mapped to library options via
+// writeOptions.
+func writeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "version",
+			Aliases: []string{"car-version"},
+			Value:   2,
+			Usage:   "Write output as a v1 or v2 format car",
+		},
+		&cli.StringFlag{
+			Name:    "index-codec",
+			Aliases: []string{"codec", "c"},
+			Value:   multicodec.CarMultihashIndexSorted.String(),
+			Usage:   "The type of index to write in a v2 car, or 'none'",
+		},
+		&cli.BoolFlag{
+			Name:  "no-index",
+			Usage: "Do not write an index, as with --index-codec none; a v1 car is then written without seeking",
+		},
+		&cli.StringFlag{
+			Name:  "identity-cids",
+			Value: carv2.IdentityCIDsInlined.String(),
+			Usage: "Whether blocks with identity CIDs are '" + carv2.IdentityCIDsInlined.String() + "' in their CIDs only, or '" + carv2.IdentityCIDsStored.String() + "' in the car",
+		},
+	}
+}
+
+// trustedFlag returns the flag of the commands which verify the blocks read
+// from an input car, to skip verifying them. It is kept out of writeFlags, since
+// most writing commands read no blocks, and maps to carv2.WithTrustedCAR.
+func trustedFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "trusted",
+		Usage: "Do not verify the blocks read from the input car against their CIDs",
+	}
+}
+
+// writeOptions maps the flags returned by writeFlags to the options of the
+// car writers.
+func writeOptions(c *cli.Context) ([]carv2.Option, error) {
+	var opts []carv2.Option
+	switch c.Int("version") {
+	case 1:
+		opts = append(opts, blockstore.WriteAsCarV1(true))
+	case 2:
+		// already the default
+	default:
+		return nil, fmt.Errorf("invalid CAR version %d", c.Int("version"))
+	}
+
+	mc, err := indexCodec(c)
+	if err != nil {
+		return nil, err
+	}
+	if mc == index.CarIndexNone {
+		opts = append(opts, carv2.WithoutIndex())
+	} else {
+		opts = append(opts, carv2.UseIndexCodec(mc))
+	}
+
+	switch c.String("identity-cids") {
+	case carv2.IdentityCIDsInlined.String():
+	case carv2.IdentityCIDsStored.String():
+		opts = append(opts, carv2.StoreIdentityCIDs(true))
+	default:
+		return nil, fmt.Errorf("invalid identity CID policy %q; expected %q or %q", c.String("identity-cids"), carv2.IdentityCIDsInlined, carv2.IdentityCIDsStored)
+	}
+
+	return opts, nil
+}
+
+// indexCodec returns the codec of the index the flags returned by writeFlags
+// ask for, or index.CarIndexNone if they ask for no index.
+func indexCodec(c *cli.Context) (multicodec.Code, error) {
+	if noIndex(c) {
+		return index.CarIndexNone, nil
+	}
+	var mc multicodec.Code
+	if err := mc.Set(c.String("index-codec")); err != nil {
+		return 0, fmt.Errorf("invalid index codec: %w", err)
+	}
+	if _, err := index.New(mc); err != nil {
+		return 0, fmt.Errorf("invalid index codec: %w", err)
+	}
+	return mc, nil
+}
+
+// noIndex returns whether the flags returned by writeFlags ask for no index to
+// be written.
+func noIndex(c *cli.Context) bool {
+	return c.Bool("no-index") || c.String("index-codec") == "none"
+}
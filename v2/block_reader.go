@@ -4,12 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"slices"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multihash"
+	mhreg "github.com/multiformats/go-multihash/core"
 	"github.com/multiformats/go-varint"
 )
 
@@ -27,6 +31,23 @@ type BlockReader struct {
 	v1offset   uint64
 	readerSize int64
 	opts       Options
+	// The multihashes yielded so far, if SkipDuplicateBlocks is enabled.
+	seen map[string]struct{}
+	// The buffer used by VerifyNext to stream blocks into hash functions.
+	verifyBuf []byte
+	// The error which ended the last iteration over Blocks, if any.
+	iterErr error
+	// Set when a Reset fails, so that the reader is not used with the partially
+	// initialized state of the new stream or the stale state of the old one.
+	resetErr error
+	// The parts yet to be read, if instantiated via NewMultiBlockReader.
+	parts []io.Reader
+	// The number of parts read so far, for error messages.
+	partCount int
+	// The logical offset minus the position in the part being read, which
+	// differ once past the first part since the headers of subsequent parts
+	// are skipped.
+	partBase uint64
 }
 
 // NewBlockReader instantiates a new BlockReader facilitating iteration over blocks in CARv1 or
@@ -35,20 +56,88 @@ type BlockReader struct {
 //
 // See BlockReader.Next
 func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
-	options := ApplyOptions(opts...)
+	br := &BlockReader{opts: ApplyOptions(opts...)}
+	if err := br.init(r); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// NewMultiBlockReader instantiates a new BlockReader which reads a sequence of
+// CARv1 streams, such as the parts of a CAR split into chunks for upload, as
+// one logical CARv1 stream. The parts are read in order, each one only once
+// the previous one is exhausted.
+//
+// The header of each part after the first is validated and skipped over: it
+// must be that of a CARv1, and any roots it lists which are not already
+// known are appended to BlockReader.Roots as the part is reached. The offsets
+// of blocks are those within the logical stream, i.e. the concatenation of
+// the parts minus the headers of all but the first, as if the blocks had been
+// written after the header of the first part.
+//
+// The reader is instantiated with the default options. Options which apply
+// across the blocks of a stream, such as SkipDuplicateBlocks, apply across
+// all parts.
+func NewMultiBlockReader(rs ...io.Reader) (*BlockReader, error) {
+	if len(rs) == 0 {
+		return nil, errors.New("no car parts to read")
+	}
+	br, err := NewBlockReader(rs[0])
+	if err != nil {
+		return nil, err
+	}
+	if br.Version != 1 {
+		return nil, fmt.Errorf("invalid car version of part 0: expected 1, got %d", br.Version)
+	}
+	br.parts = rs[1:]
+	br.partCount = 1
+	return br, nil
+}
+
+// Reset re-initializes the BlockReader to read from r, retaining the options it
+// was instantiated with. This allows a BlockReader to be pooled and reused
+// across many CAR streams, avoiding the allocations of NewBlockReader.
+//
+// Any state of the previous stream is discarded, regardless of whether it was
+// read to the end. If the header of r cannot be read, the error is returned and
+// subsequent calls to Next and SkipNext return the same error until Reset
+// succeeds.
+func (br *BlockReader) Reset(r io.Reader) error {
+	if r == nil {
+		br.resetErr = errors.New("cannot reset block reader to a nil reader")
+	} else {
+		br.resetErr = br.init(r)
+	}
+	if br.resetErr != nil {
+		br.Version = 0
+		br.Roots = nil
+		br.r = nil
+	}
+	return br.resetErr
+}
+
+// init reads the header of r, populating the version, roots and offsets of br
+// according to it.
+func (br *BlockReader) init(r io.Reader) error {
+	options := br.opts
+	br.offset = 0
+	br.v1offset = 0
+	br.parts = nil
+	br.partCount = 0
+	br.partBase = 0
+	if options.SkipDuplicateBlocks {
+		br.seen = make(map[string]struct{})
+	}
 
 	// Read CARv1 header or CARv2 pragma.
 	// Both are a valid CARv1 header, therefore are read as such.
 	pragmaOrV1Header, err := carv1.ReadHeader(r, options.MaxAllowedHeaderSize)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Populate the block reader version and options.
-	br := &BlockReader{
-		Version: pragmaOrV1Header.Version,
-		opts:    options,
-	}
+	// Populate the block reader version.
+	br.Version = pragmaOrV1Header.Version
 
 	// Expect either version 1 or 2.
 	switch br.Version {
@@ -69,7 +158,7 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 		// Read CARv2-specific header.
 		v2h := Header{}
 		if _, err := v2h.ReadFrom(r); err != nil {
-			return nil, err
+			return err
 		}
 
 		// Skip to the beginning of inner CARv1 data payload.
@@ -80,7 +169,7 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 		// dataOffset.
 		rs := internalio.ToByteReadSeeker(r)
 		if _, err := rs.Seek(int64(v2h.DataOffset)-PragmaSize-HeaderSize, io.SeekCurrent); err != nil {
-			return nil, err
+			return err
 		}
 		br.v1offset = uint64(v2h.DataOffset)
 		br.offset = br.v1offset
@@ -92,20 +181,49 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 		// Populate br.Roots by reading the inner CARv1 data payload header.
 		header, err := carv1.ReadHeader(br.r, options.MaxAllowedHeaderSize)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		// Assert that the data payload header is exactly 1, i.e. the header represents a CARv1.
 		if header.Version != 1 {
-			return nil, fmt.Errorf("invalid data payload header version; expected 1, got %v", header.Version)
+			return fmt.Errorf("invalid data payload header version; expected 1, got %v", header.Version)
 		}
 		br.Roots = header.Roots
 		hs, _ := carv1.HeaderSize(header)
 		br.offset += hs
 	default:
-		// Otherwise, error out with invalid version since only versions 1 or 2 are expected.
-		return nil, fmt.Errorf("invalid car version: %d", br.Version)
+		// Otherwise, defer to the handler of the version if there is one, or
+		// error out with invalid version since only versions 1 or 2 are expected.
+		h, ok := options.VersionHandlers[br.Version]
+		if !ok {
+			return fmt.Errorf("invalid car version: %d", br.Version)
+		}
+		payload, err := h(r)
+		if err != nil {
+			return err
+		}
+		header, err := carv1.ReadHeader(payload, options.MaxAllowedHeaderSize)
+		if err != nil {
+			return err
+		}
+		if header.Version != 1 {
+			return fmt.Errorf("invalid data payload header version; expected 1, got %v", header.Version)
+		}
+		br.Roots = header.Roots
+		br.r = payload
+		br.readerSize = -1
+		hs, _ := carv1.HeaderSize(header)
+		// If the payload is seeked over, it may not start at position zero,
+		// e.g. if it is the CAR itself.
+		if ps, ok := payload.(io.Seeker); ok {
+			pos, err := ps.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			br.v1offset = uint64(pos) - hs
+		}
+		br.offset = br.v1offset + hs
 	}
-	return br, nil
+	return nil
 }
 
 // Next iterates over blocks in the underlying CAR payload with an io.EOF error indicating the end
@@ -119,11 +237,109 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 // Note, in a case where ZeroLengthSectionAsEOF Option is enabled, io.EOF is returned
 // immediately upon encountering a zero-length section without reading any further bytes from the
 // underlying io.Reader.
+//
+// If the SkipDuplicateBlocks Option is enabled, sections whose multihash was
+// already yielded are skipped over.
 func (br *BlockReader) Next() (blocks.Block, error) {
-	c, data, err := util.ReadNode(br.r, br.opts.ZeroLengthSectionAsEOF, br.opts.MaxAllowedSectionSize)
+	for {
+		blk, err := br.next()
+		if err == io.EOF && len(br.parts) > 0 {
+			if err := br.nextPart(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !br.isDuplicate(blk.Cid()) {
+			return blk, nil
+		}
+	}
+}
+
+// nextPart advances to the next part of a multi-part stream, once the current
+// one is exhausted, validating and skipping over its header. It returns io.EOF
+// if there are no more parts.
+func (br *BlockReader) nextPart() error {
+	if len(br.parts) == 0 {
+		return io.EOF
+	}
+	r := br.parts[0]
+	br.parts = br.parts[1:]
+	part := br.partCount
+	br.partCount++
+
+	header, err := carv1.ReadHeader(r, br.opts.MaxAllowedHeaderSize)
+	if err != nil {
+		return fmt.Errorf("invalid header of part %d: %w", part, err)
+	}
+	if header.Version != 1 {
+		return fmt.Errorf("invalid car version of part %d: expected 1, got %d", part, header.Version)
+	}
+	for _, root := range header.Roots {
+		if !slices.ContainsFunc(br.Roots, root.Equals) {
+			br.Roots = append(br.Roots, root)
+		}
+	}
+	hs, _ := carv1.HeaderSize(header)
+	br.r = r
+	br.readerSize = -1
+	br.partBase = br.offset - hs
+	return nil
+}
+
+// isDuplicate reports whether a block with the multihash of c was already
+// yielded, if SkipDuplicateBlocks is enabled, and records it otherwise.
+func (br *BlockReader) isDuplicate(c cid.Cid) bool {
+	if br.seen == nil {
+		return false
+	}
+	key := string(c.Hash())
+	if _, ok := br.seen[key]; ok {
+		return true
+	}
+	br.seen[key] = struct{}{}
+	return false
+}
+
+func (br *BlockReader) next() (blocks.Block, error) {
+	if br.resetErr != nil {
+		return nil, br.resetErr
+	}
+	for {
+		section, err := util.LdRead(br.r, br.opts.ZeroLengthSectionAsEOF, br.opts.MaxAllowedSectionSize)
+		if err != nil {
+			return nil, err
+		}
+		sectionLen := uint64(varint.UvarintSize(uint64(len(section)))) + uint64(len(section))
+
+		blk, err := br.decodeSection(section)
+		if err != nil {
+			if !br.opts.SkipCorruptSections {
+				return nil, err
+			}
+			// The length prefix is intact, so reading carries on with the
+			// next section.
+			if br.opts.OnCorruptSection != nil {
+				br.opts.OnCorruptSection(br.offset-br.v1offset, sectionLen, err)
+			}
+			br.offset += sectionLen
+			continue
+		}
+		br.offset += sectionLen
+		return blk, nil
+	}
+}
+
+// decodeSection decodes the CID and data of a section read without its length
+// prefix, verifying the data against the CID unless the CAR is trusted.
+func (br *BlockReader) decodeSection(section []byte) (blocks.Block, error) {
+	n, c, err := cid.CidFromBytes(section)
 	if err != nil {
 		return nil, err
 	}
+	data := section[n:]
 
 	if !br.opts.TrustedCAR {
 		hashed, err := c.Prefix().Sum(data)
@@ -135,12 +351,127 @@ func (br *BlockReader) Next() (blocks.Block, error) {
 			return nil, fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, hashed)
 		}
 	}
-
-	ss := uint64(c.ByteLen()) + uint64(len(data))
-	br.offset += uint64(varint.UvarintSize(ss)) + ss
 	return blocks.NewBlockWithCid(data, c)
 }
 
+// VerifyNext reads over the next block, hashing its data as it is read and
+// comparing the result to its CID, and returns metadata about it like
+// SkipNext. Unlike Next, the block data is never held in memory in its
+// entirety, but streamed through the hash function using a buffer of the size
+// set via WithStreamingVerification, which also lifts the limit set via
+// MaxAllowedSectionSize. Blocks are verified regardless of WithTrustedCAR.
+// Like Next it will return an io.EOF once it has reached the end.
+func (br *BlockReader) VerifyNext() (*BlockMetadata, error) {
+	for {
+		md, err := br.verifyNext()
+		if err == io.EOF && len(br.parts) > 0 {
+			if err := br.nextPart(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !br.isDuplicate(md.Cid) {
+			return md, nil
+		}
+	}
+}
+
+func (br *BlockReader) verifyNext() (*BlockMetadata, error) {
+	if br.resetErr != nil {
+		return nil, br.resetErr
+	}
+	maxSectionSize := br.opts.MaxAllowedSectionSize
+	if br.opts.StreamingVerificationBufferSize > 0 {
+		maxSectionSize = math.MaxUint64
+	}
+	sectionSize, err := util.LdReadSize(br.r, br.opts.ZeroLengthSectionAsEOF, maxSectionSize)
+	if err != nil {
+		return nil, err
+	}
+	if sectionSize == 0 {
+		_, _, err := cid.CidFromBytes([]byte{}) // generate zero-byte CID error
+		return nil, err
+	}
+	cidSize, c, err := cid.CidFromReader(io.LimitReader(br.r, int64(sectionSize)))
+	if err != nil {
+		return nil, err
+	}
+	blockSize := sectionSize - uint64(cidSize)
+
+	if br.verifyBuf == nil {
+		br.verifyBuf = make([]byte, streamingVerificationBufferSize(br.opts))
+	}
+	hashed, n, err := sumCidStream(io.LimitReader(br.r, int64(blockSize)), c.Prefix(), br.verifyBuf)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) < blockSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if !hashed.Equals(c) {
+		return nil, fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, hashed)
+	}
+
+	blockOffset := br.offset
+	br.offset += uint64(varint.UvarintSize(sectionSize)) + sectionSize
+	return &BlockMetadata{
+		Cid:          c,
+		Offset:       blockOffset - br.v1offset,
+		SourceOffset: blockOffset,
+		Size:         blockSize,
+	}, nil
+}
+
+func streamingVerificationBufferSize(o Options) int {
+	if o.StreamingVerificationBufferSize > 0 {
+		return o.StreamingVerificationBufferSize
+	}
+	return DefaultStreamingVerificationBufferSize
+}
+
+// sumCidStream hashes the data read from r as the given CID prefix does, and
+// returns the resulting CID along with the number of bytes hashed. The data is
+// copied into the hash function through buf, such that no more than len(buf)
+// bytes of it are held in memory at once, except for identity multihashes.
+func sumCidStream(r io.Reader, prefix cid.Prefix, buf []byte) (cid.Cid, int64, error) {
+	length := prefix.MhLength
+	if prefix.MhType == multihash.IDENTITY {
+		length = -1
+	}
+	hasher, err := mhreg.GetVariableHasher(prefix.MhType, length)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	// Hide any io.ReaderFrom or io.WriterTo implementations, which would
+	// bypass buf.
+	n, err := io.CopyBuffer(struct{ io.Writer }{hasher}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		return cid.Undef, n, err
+	}
+	sum := hasher.Sum(nil)
+	if length < 0 {
+		length = len(sum)
+	}
+	if len(sum) < length {
+		return cid.Undef, n, multihash.ErrLenTooLarge
+	}
+	mh, err := multihash.Encode(sum[:length], prefix.MhType)
+	if err != nil {
+		return cid.Undef, n, err
+	}
+	switch prefix.Version {
+	case 0:
+		return cid.NewCidV0(mh), n, nil
+	case 1:
+		return cid.NewCidV1(prefix.Codec, mh), n, nil
+	default:
+		return cid.Undef, n, fmt.Errorf("invalid cid version: %d", prefix.Version)
+	}
+}
+
 // BlockMetadata contains metadata about a block's section in a CAR file/stream.
 //
 // There are two offsets for the block section which will be the same if the
@@ -170,7 +501,31 @@ type BlockMetadata struct {
 //
 // If the underlying reader used by the BlockReader is actually a ReadSeeker, this method will attempt to
 // seek over the underlying data rather than reading it into memory.
+//
+// If the SkipDuplicateBlocks Option is enabled, sections whose multihash was
+// already yielded by Next or SkipNext are skipped over.
 func (br *BlockReader) SkipNext() (*BlockMetadata, error) {
+	for {
+		md, err := br.skipNext()
+		if err == io.EOF && len(br.parts) > 0 {
+			if err := br.nextPart(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !br.isDuplicate(md.Cid) {
+			return md, nil
+		}
+	}
+}
+
+func (br *BlockReader) skipNext() (*BlockMetadata, error) {
+	if br.resetErr != nil {
+		return nil, br.resetErr
+	}
 	sectionSize, err := util.LdReadSize(br.r, br.opts.ZeroLengthSectionAsEOF, br.opts.MaxAllowedSectionSize)
 	if err != nil {
 		return nil, err
@@ -218,7 +573,7 @@ func (br *BlockReader) SkipNext() (*BlockMetadata, error) {
 		if err != nil {
 			return nil, err
 		}
-		if finalOffset != int64(br.offset)+int64(lenSize)+int64(sectionSize) {
+		if finalOffset != int64(br.offset-br.partBase)+int64(lenSize)+int64(sectionSize) {
 			return nil, errors.New("unexpected length")
 		}
 		if finalOffset > br.readerSize {
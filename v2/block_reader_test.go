@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	mh "github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
@@ -24,6 +26,54 @@ func TestBlockReaderFailsOnUnknownVersion(t *testing.T) {
 	require.EqualError(t, err, "invalid car version: 42")
 }
 
+func TestBlockReaderWithVersionHandler(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	v3 := requireCarV3(t)
+	handler := func(r io.Reader) (io.Reader, error) { return r, nil }
+
+	// Unhandled versions are still rejected.
+	_, err = carv2.NewBlockReader(bytes.NewReader(v3), carv2.WithVersionHandler(4, handler))
+	require.EqualError(t, err, "invalid car version: 3")
+
+	subject, err := carv2.NewBlockReader(bytes.NewReader(v3), carv2.WithVersionHandler(3, handler))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), subject.Version)
+	wantReader, err := carv2.NewBlockReader(requireReaderFromPath(t, "testdata/sample-v1.car"))
+	require.NoError(t, err)
+	require.Equal(t, wantReader.Roots, subject.Roots)
+	for {
+		want, wantErr := wantReader.SkipNext()
+		got, gotErr := subject.SkipNext()
+		require.Equal(t, wantErr, gotErr)
+		if wantErr == io.EOF {
+			break
+		}
+		// Offsets are relative to the data payload, but source offsets are
+		// positions in the CAR, past the version header.
+		require.Equal(t, want.Cid, got.Cid)
+		require.Equal(t, want.Offset, got.Offset)
+		require.Equal(t, want.Size, got.Size)
+		require.Equal(t, want.SourceOffset+uint64(len(v3))-uint64(len(v1)), got.SourceOffset)
+	}
+
+	_, err = carv2.NewBlockReader(bytes.NewReader(v3), carv2.WithVersionHandler(3, func(r io.Reader) (io.Reader, error) {
+		return nil, errors.New("unsupported")
+	}))
+	require.EqualError(t, err, "unsupported")
+}
+
+// requireCarV3 returns a CAR of a made-up version 3, which holds the CARv1 data
+// payload of sample-v1.car right after its version header.
+func requireCarV3(t *testing.T) []byte {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Version: 3, Roots: []cid.Cid{}}, &buf))
+	buf.Write(v1)
+	return buf.Bytes()
+}
+
 func TestBlockReaderFailsOnCorruptPragma(t *testing.T) {
 	r := requireReaderFromPath(t, "testdata/sample-corrupt-pragma.car")
 	_, err := carv2.NewBlockReader(r)
@@ -384,3 +434,330 @@ func requireReaderFromPath(t *testing.T, path string) io.Reader {
 	t.Cleanup(func() { require.NoError(t, f.Close()) })
 	return f
 }
+
+func TestVerifyNext(t *testing.T) {
+	readAll := func(t *testing.T, next func() (*carv2.BlockMetadata, error)) []carv2.BlockMetadata {
+		var got []carv2.BlockMetadata
+		for {
+			md, err := next()
+			if err == io.EOF {
+				return got
+			}
+			require.NoError(t, err)
+			got = append(got, *md)
+		}
+	}
+	for _, path := range []string{"testdata/sample-v1.car", "testdata/sample-wrapped-v2.car"} {
+		t.Run(path, func(t *testing.T) {
+			br, err := carv2.NewBlockReader(requireReaderFromPath(t, path))
+			require.NoError(t, err)
+			want := readAll(t, br.SkipNext)
+
+			br, err = carv2.NewBlockReader(requireReaderFromPath(t, path))
+			require.NoError(t, err)
+			require.Equal(t, want, readAll(t, br.VerifyNext))
+
+			// Sections larger than the maximum are only verified once
+			// streaming verification is enabled.
+			br, err = carv2.NewBlockReader(requireReaderFromPath(t, path), carv2.MaxAllowedSectionSize(64))
+			require.NoError(t, err)
+			err = readAllErr(br.VerifyNext)
+			require.ErrorContains(t, err, "length of read beyond allowable maximum")
+			br, err = carv2.NewBlockReader(requireReaderFromPath(t, path), carv2.MaxAllowedSectionSize(64), carv2.WithStreamingVerification(7))
+			require.NoError(t, err)
+			require.Equal(t, want, readAll(t, br.VerifyNext))
+		})
+	}
+
+	//                                  header                             cid                                                                          data
+	corrupt, _ := hex.DecodeString("11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681caffffffffffffffffffff")
+	br, err := carv2.NewBlockReader(bytes.NewReader(corrupt), carv2.WithTrustedCAR(true))
+	require.NoError(t, err)
+	_, err = br.VerifyNext()
+	require.ErrorContains(t, err, "mismatch in content integrity")
+
+	br, err = carv2.NewBlockReader(bytes.NewReader(corrupt[:len(corrupt)-1]))
+	require.NoError(t, err)
+	_, err = br.VerifyNext()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func readAllErr(next func() (*carv2.BlockMetadata, error)) error {
+	for {
+		if _, err := next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func TestSkipDuplicateBlocks(t *testing.T) {
+	// headerHex is the zero-roots CARv1 header
+	const headerHex = "11a265726f6f7473806776657273696f6e01"
+	headerBytes, _ := hex.DecodeString(headerHex)
+	a := blocks.NewBlock([]byte("a"))
+	b := blocks.NewBlock([]byte("b"))
+	// A CID with a different codec but the same multihash as a.
+	aPb := cid.NewCidV1(cid.DagProtobuf, a.Cid().Hash())
+
+	var buf bytes.Buffer
+	buf.Write(headerBytes)
+	for _, section := range []struct {
+		c    cid.Cid
+		data []byte
+	}{{a.Cid(), a.RawData()}, {b.Cid(), b.RawData()}, {a.Cid(), a.RawData()}, {aPb, a.RawData()}, {b.Cid(), b.RawData()}} {
+		buf.Write(varint.ToUvarint(uint64(len(section.c.Bytes()) + len(section.data))))
+		buf.Write(section.c.Bytes())
+		buf.Write(section.data)
+	}
+
+	readAll := func(t *testing.T, br *carv2.BlockReader, skip bool) []cid.Cid {
+		var got []cid.Cid
+		for {
+			var c cid.Cid
+			var err error
+			if skip {
+				var md *carv2.BlockMetadata
+				if md, err = br.SkipNext(); err == nil {
+					c = md.Cid
+				}
+			} else {
+				var blk blocks.Block
+				if blk, err = br.Next(); err == nil {
+					c = blk.Cid()
+				}
+			}
+			if err == io.EOF {
+				return got
+			}
+			require.NoError(t, err)
+			got = append(got, c)
+		}
+	}
+
+	for _, skip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip=%t", skip), func(t *testing.T) {
+			br, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			require.Len(t, readAll(t, br, skip), 5)
+
+			br, err = carv2.NewBlockReader(bytes.NewReader(buf.Bytes()), carv2.SkipDuplicateBlocks(true))
+			require.NoError(t, err)
+			require.Equal(t, []cid.Cid{a.Cid(), b.Cid()}, readAll(t, br, skip))
+
+			// Blocks seen before a reset are yielded again after it.
+			require.NoError(t, br.Reset(bytes.NewReader(buf.Bytes())))
+			require.Equal(t, []cid.Cid{a.Cid(), b.Cid()}, readAll(t, br, skip))
+		})
+	}
+}
+
+func TestBlockReaderSkipCorruptSections(t *testing.T) {
+	// headerHex is the zero-roots CARv1 header
+	const headerHex = "11a265726f6f7473806776657273696f6e01"
+	headerBytes, _ := hex.DecodeString(headerHex)
+	a := blocks.NewBlock([]byte("a"))
+	b := blocks.NewBlock([]byte("b"))
+
+	type skip struct {
+		offset, length uint64
+	}
+	var buf bytes.Buffer
+	buf.Write(headerBytes)
+	var wantSkips []skip
+	for _, section := range []struct {
+		cid     []byte
+		data    []byte
+		corrupt bool
+	}{
+		{cid: a.Cid().Bytes(), data: a.RawData()},
+		// Data not matching its CID.
+		{cid: a.Cid().Bytes(), data: []byte("not a"), corrupt: true},
+		// A CID which cannot be decoded.
+		{cid: []byte{0x01, 0x55, 0x12, 0x20, 0x00}, data: []byte("garbage"), corrupt: true},
+		{cid: b.Cid().Bytes(), data: b.RawData()},
+	} {
+		offset := uint64(buf.Len())
+		buf.Write(varint.ToUvarint(uint64(len(section.cid) + len(section.data))))
+		buf.Write(section.cid)
+		buf.Write(section.data)
+		if section.corrupt {
+			wantSkips = append(wantSkips, skip{offset, uint64(buf.Len()) - offset})
+		}
+	}
+
+	br, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	_, err = br.Next()
+	require.NoError(t, err)
+	_, err = br.Next()
+	require.ErrorContains(t, err, "mismatch in content integrity")
+
+	var gotSkips []skip
+	br, err = carv2.NewBlockReader(bytes.NewReader(buf.Bytes()), carv2.SkipCorruptSections(func(offset, length uint64, err error) {
+		require.Error(t, err)
+		gotSkips = append(gotSkips, skip{offset, length})
+	}))
+	require.NoError(t, err)
+	var got []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, blk.Cid())
+	}
+	require.Equal(t, []cid.Cid{a.Cid(), b.Cid()}, got)
+	require.Equal(t, wantSkips, gotSkips)
+}
+
+func TestBlockReaderReset(t *testing.T) {
+	v2Path := "testdata/sample-wrapped-v2.car"
+	v1Path := "testdata/sample-v1.car"
+
+	subject, err := carv2.NewBlockReader(requireReaderFromPath(t, v2Path))
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), subject.Version)
+	// Leave the first stream partially read.
+	_, err = subject.Next()
+	require.NoError(t, err)
+
+	requireMatchesV1 := func(t *testing.T) {
+		wantReader := requireNewCarV1ReaderFromV1File(t, v1Path, false)
+		require.Equal(t, uint64(1), subject.Version)
+		require.Equal(t, wantReader.Header.Roots, subject.Roots)
+		for {
+			gotBlock, gotErr := subject.Next()
+			wantBlock, wantErr := wantReader.Next()
+			require.Equal(t, wantBlock, gotBlock)
+			require.Equal(t, wantErr, gotErr)
+			if gotErr == io.EOF {
+				break
+			}
+		}
+	}
+
+	require.NoError(t, subject.Reset(requireReaderFromPath(t, v1Path)))
+	requireMatchesV1(t)
+
+	// A failed reset leaves the reader unusable until it is reset successfully.
+	err = subject.Reset(requireReaderFromPath(t, "testdata/sample-rootless-v42.car"))
+	require.EqualError(t, err, "invalid car version: 42")
+	require.Nil(t, subject.Roots)
+	_, err = subject.Next()
+	require.EqualError(t, err, "invalid car version: 42")
+	_, err = subject.SkipNext()
+	require.EqualError(t, err, "invalid car version: 42")
+	require.Error(t, subject.Reset(nil))
+
+	require.NoError(t, subject.Reset(requireReaderFromPath(t, v1Path)))
+	requireMatchesV1(t)
+}
+
+func TestMultiBlockReader(t *testing.T) {
+	rootA := blocks.NewBlock([]byte("root a")).Cid()
+	rootB := blocks.NewBlock([]byte("root b")).Cid()
+	blks := make([]blocks.Block, 9)
+	for i := range blks {
+		blks[i] = randBlock(100 + i)
+	}
+	writePart := func(roots []cid.Cid, blks []blocks.Block) []byte {
+		buf := new(bytes.Buffer)
+		require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, buf))
+		for _, blk := range blks {
+			require.NoError(t, util.LdWrite(buf, blk.Cid().Bytes(), blk.RawData()))
+		}
+		return buf.Bytes()
+	}
+	whole := writePart([]cid.Cid{rootA}, blks)
+	parts := [][]byte{
+		writePart([]cid.Cid{rootA}, blks[:3]),
+		writePart([]cid.Cid{rootA, rootB}, blks[3:5]),
+		writePart(nil, nil),
+		writePart([]cid.Cid{rootB}, blks[5:]),
+	}
+
+	wantReader, err := carv2.NewBlockReader(bytes.NewReader(whole))
+	require.NoError(t, err)
+	var want []*carv2.BlockMetadata
+	for {
+		md, err := wantReader.SkipNext()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		want = append(want, md)
+	}
+
+	for _, seekable := range []bool{false, true} {
+		readers := func() []io.Reader {
+			rs := make([]io.Reader, len(parts))
+			for i, p := range parts {
+				if seekable {
+					rs[i] = bytes.NewReader(p)
+				} else {
+					rs[i] = &readerOnly{bytes.NewReader(p)}
+				}
+			}
+			return rs
+		}
+		t.Run(fmt.Sprintf("seekable=%t", seekable), func(t *testing.T) {
+			subject, err := carv2.NewMultiBlockReader(readers()...)
+			require.NoError(t, err)
+			require.Equal(t, uint64(1), subject.Version)
+			require.Equal(t, []cid.Cid{rootA}, subject.Roots)
+			for i, wantBlk := range blks {
+				gotBlk, err := subject.Next()
+				require.NoError(t, err)
+				require.Equal(t, wantBlk.Cid(), gotBlk.Cid(), "block %d", i)
+				require.Equal(t, wantBlk.RawData(), gotBlk.RawData(), "block %d", i)
+			}
+			_, err = subject.Next()
+			require.Equal(t, io.EOF, err)
+			require.Equal(t, []cid.Cid{rootA, rootB}, subject.Roots)
+
+			subject, err = carv2.NewMultiBlockReader(readers()...)
+			require.NoError(t, err)
+			for _, wantMd := range want {
+				gotMd, err := subject.SkipNext()
+				require.NoError(t, err)
+				require.Equal(t, wantMd, gotMd)
+			}
+			_, err = subject.SkipNext()
+			require.Equal(t, io.EOF, err)
+
+			subject, err = carv2.NewMultiBlockReader(readers()...)
+			require.NoError(t, err)
+			for _, wantMd := range want {
+				gotMd, err := subject.VerifyNext()
+				require.NoError(t, err)
+				require.Equal(t, wantMd, gotMd)
+			}
+			_, err = subject.VerifyNext()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := carv2.NewMultiBlockReader()
+		require.EqualError(t, err, "no car parts to read")
+
+		_, err = carv2.NewMultiBlockReader(requireReaderFromPath(t, "testdata/sample-wrapped-v2.car"))
+		require.EqualError(t, err, "invalid car version of part 0: expected 1, got 2")
+
+		subject, err := carv2.NewMultiBlockReader(bytes.NewReader(parts[0]), requireReaderFromPath(t, "testdata/sample-wrapped-v2.car"))
+		require.NoError(t, err)
+		require.EqualError(t, readAllErr(subject.SkipNext), "invalid car version of part 1: expected 1, got 2")
+
+		subject, err = carv2.NewMultiBlockReader(bytes.NewReader(parts[0]), bytes.NewReader(nil))
+		require.NoError(t, err)
+		require.ErrorContains(t, readAllErr(subject.SkipNext), "invalid header of part 1")
+
+		// A part ending in the middle of a section is not continued by the next.
+		subject, err = carv2.NewMultiBlockReader(bytes.NewReader(parts[0][:len(parts[0])-1]), bytes.NewReader(parts[1]))
+		require.NoError(t, err)
+		require.Equal(t, io.ErrUnexpectedEOF, readAllErr(subject.SkipNext))
+	})
+}
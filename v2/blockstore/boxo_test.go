@@ -0,0 +1,13 @@
+package blockstore
+
+import (
+	boxoblockstore "github.com/ipfs/boxo/blockstore"
+)
+
+// The blockstores only depend on boxo in tests, to check that they satisfy its
+// interfaces structurally.
+var (
+	_ boxoblockstore.Blockstore = (*ReadOnly)(nil)
+	_ boxoblockstore.Blockstore = (*ReadWrite)(nil)
+	_ boxoblockstore.Blockstore = Blockstore(nil)
+)
@@ -1,6 +1,11 @@
 // Package blockstore implements the IPFS blockstore interface backed by a CAR file.
 // This package provides two flavours of blockstore: ReadOnly and ReadWrite.
 //
+// Both satisfy the context-first Blockstore interface of
+// github.com/ipfs/boxo/blockstore, returning format.ErrNotFound of
+// github.com/ipfs/go-ipld-format for missing blocks, without this package
+// depending on boxo or on the legacy go-ipfs-blockstore.
+//
 // The ReadOnly blockstore provides a read-only random access from a given data payload either in
 // unindexed CARv1 format or indexed/unindexed v2 format:
 //   - ReadOnly.NewReadOnly can be used to instantiate a new read-only blockstore for a given CARv1
@@ -18,11 +23,14 @@
 //
 // Note that the blockstore implementations in this package behave similarly to IPFS IdStore wrapper
 // when given CIDs with multihash.IDENTITY code.
-// More specifically, for CIDs with multhash.IDENTITY code:
+// More specifically, for CIDs with multhash.IDENTITY code, unless car.StoreIdentityCIDs is enabled:
 // * blockstore.Has will always return true.
 // * blockstore.Get will always succeed, returning the multihash digest of the given CID.
 // * blockstore.GetSize will always succeed, returning the multihash digest length of the given CID.
-// * blockstore.Put and blockstore.PutMany will always succeed without performing any operation unless car.StoreIdentityCIDs is enabled.
+// * blockstore.Put and blockstore.PutMany will always succeed without performing any operation.
+//
+// When car.StoreIdentityCIDs is enabled, blocks with identity CIDs are written and looked up like any
+// other. The policy in effect is reported by the IdentityCIDPolicy method of each blockstore.
 //
 // See: https://pkg.go.dev/github.com/ipfs/boxo/blockstore#NewIdStore
 package blockstore
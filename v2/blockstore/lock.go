@@ -0,0 +1,49 @@
+package blockstore
+
+import (
+	"io"
+	"os"
+
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// lockedFile is a file locked by a FileLocker, which is unlocked upon closing
+// the file.
+type lockedFile struct {
+	*os.File
+	locker carv2.FileLocker
+}
+
+// lockFile locks f, exclusively or shared, using the FileLocker set in o. The
+// returned closer releases the lock and closes f; it is f itself if locking is
+// disabled.
+func lockFile(f *os.File, exclusive bool, o carv2.Options) (io.Closer, error) {
+	if o.FileLocker == nil {
+		return f, nil
+	}
+	if err := o.FileLocker.Lock(f, exclusive, o.WaitForFileLock); err != nil {
+		return nil, err
+	}
+	return &lockedFile{File: f, locker: o.FileLocker}, nil
+}
+
+func (f *lockedFile) Close() error {
+	uerr := f.locker.Unlock(f.File)
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return uerr
+}
+
+// closers closes each of its closers in turn, returning the first error.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var err error
+	for _, c := range cs {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
 	"sync"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -13,8 +15,10 @@ import (
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"github.com/ipld/go-car/v2/internal/store"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 	"golang.org/x/exp/mmap"
 )
@@ -34,10 +38,33 @@ type Blockstore interface {
 
 var _ Blockstore = (*ReadOnly)(nil)
 
+// Stat summarizes the contents of a blockstore, as returned by Stat.
+type Stat struct {
+	// Keys is the number of blocks in the blockstore, which may include
+	// duplicate blocks and, depending on the StoreIdentityCIDs option, may
+	// exclude blocks with IDENTITY CIDs.
+	Keys uint64
+	// Size is the total size in bytes of the sections of the CARv1 data
+	// payload, i.e. the size of the block data along with the CID and length
+	// prefix of each block.
+	Size uint64
+}
+
+// StatBlockstore is a Blockstore which can cheaply summarize its contents,
+// such as for providing systems which need an estimate of the number and total
+// size of the blocks held without enumerating them.
+type StatBlockstore interface {
+	Blockstore
+	Stat(ctx context.Context) (Stat, error)
+}
+
+var _ StatBlockstore = (*ReadOnly)(nil)
+
 var (
 	errZeroLengthSection = fmt.Errorf("zero-length carv2 section not allowed by default; see WithZeroLengthSectionAsEOF option")
 	errReadOnly          = fmt.Errorf("called write method on a read-only carv2 blockstore")
 	errClosed            = fmt.Errorf("cannot use a carv2 blockstore after closing")
+	errIteratorClosed    = fmt.Errorf("cannot use a carv2 blockstore key iterator after closing")
 )
 
 // ReadOnly provides a read-only CAR Block Store.
@@ -64,6 +91,14 @@ type ReadOnly struct {
 	// If we called carv2.NewReaderMmap, remember to close it too.
 	carv2Closer io.Closer
 
+	// The cursor used to serve sequential lookups without the index; nil
+	// unless the UseSequentialCursor option is set.
+	cursor *store.Cursor
+
+	// The index populated incrementally as lookups miss; nil unless the
+	// UseLazyIndex option is set and the backing carries no index.
+	lazy *store.LazyIndex
+
 	opts carv2.Options
 }
 
@@ -72,6 +107,10 @@ type contextKey string
 const asyncErrHandlerKey contextKey = "asyncErrorHandlerKey"
 
 var UseWholeCIDs = carv2.UseWholeCIDs
+var UseSequentialCursor = carv2.UseSequentialCursor
+var UseLazyIndex = carv2.UseLazyIndex
+var SkipCorruptSections = carv2.SkipCorruptSections
+var WithSize = carv2.WithSize
 
 // NewReadOnly creates a new ReadOnly blockstore from the backing with a optional index as idx.
 // This function accepts both CARv1 and CARv2 backing.
@@ -81,11 +120,23 @@ var UseWholeCIDs = carv2.UseWholeCIDs
 // * For a CARv1 backing an index is generated.
 // * For a CARv2 backing an index is only generated if Header.HasIndex returns false.
 //
+// If the UseLazyIndex option is set, an index that would otherwise be generated
+// up front is instead populated incrementally as lookups miss.
+//
+// If the WithSize option is set, the backing is only read within the given
+// size, such that it need not support seeking nor reads past its end.
+//
 // There is no need to call ReadOnly.Close on instances returned by this function.
 func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*ReadOnly, error) {
 	b := &ReadOnly{
 		opts: carv2.ApplyOptions(opts...),
 	}
+	b.initCursor()
+	if b.opts.BackingSize > 0 {
+		// A section reader is read from its start, whatever the position of
+		// the backing, and reports its size to the readers below.
+		backing = io.NewSectionReader(backing, 0, b.opts.BackingSize)
+	}
 
 	version, err := readVersion(backing, opts...)
 	if err != nil {
@@ -93,12 +144,18 @@ func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*R
 	}
 	switch version {
 	case 1:
+		b.backing = backing
+		if idx == nil && b.opts.BlockstoreLazyIndex {
+			if err := b.initLazyIndex(); err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
 		if idx == nil {
 			if idx, err = generateIndex(backing, opts...); err != nil {
 				return nil, err
 			}
 		}
-		b.backing = backing
 		b.idx = idx
 		return b, nil
 	case 2:
@@ -116,6 +173,14 @@ func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*R
 				if err != nil {
 					return nil, err
 				}
+			} else if b.opts.BlockstoreLazyIndex {
+				if b.backing, err = v2r.DataReader(); err != nil {
+					return nil, err
+				}
+				if err := b.initLazyIndex(); err != nil {
+					return nil, err
+				}
+				return b, nil
 			} else {
 				dr, err := v2r.DataReader()
 				if err != nil {
@@ -137,6 +202,16 @@ func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*R
 	}
 }
 
+func (b *ReadOnly) initLazyIndex() error {
+	lazy, err := store.NewLazyIndex(b.backing, b.opts.MaxAllowedHeaderSize, b.opts.ReadAheadSize)
+	if err != nil {
+		return err
+	}
+	b.lazy = lazy
+	b.idx = lazy.Index()
+	return nil
+}
+
 func readVersion(at io.ReaderAt, opts ...carv2.Option) (uint64, error) {
 	var rr io.Reader
 	switch r := at.(type) {
@@ -177,23 +252,48 @@ func generateIndex(at io.ReaderAt, opts ...carv2.Option) (index.Index, error) {
 // Note, the generated index if the index does not exist is ephemeral and only stored in memory.
 // See car.GenerateIndex and Index.Attach for persisting index onto a CAR file.
 func OpenReadOnly(path string, opts ...carv2.Option) (*ReadOnly, error) {
+	var c closers
+	if o := carv2.ApplyOptions(opts...); o.FileLocker != nil {
+		// The mapping cannot be locked, so the lock is held on a file of its own.
+		lf, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		closer, err := lockFile(lf, false, o)
+		if err != nil {
+			lf.Close()
+			return nil, fmt.Errorf("could not lock read-only file: %w", err)
+		}
+		c = append(c, closer)
+	}
+
 	f, err := mmap.Open(path)
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
+	c = append(closers{f}, c...)
 
 	robs, err := NewReadOnly(f, nil, opts...)
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
-	robs.carv2Closer = f
+	robs.carv2Closer = c
 
 	return robs, nil
 }
 
 // Index gives direct access to the index.
 // You should never add records on your own there.
+//
+// If the UseLazyIndex option is set, the index is a snapshot of the records of
+// the sections scanned so far, which is not updated by subsequent lookups, such
+// that it is safe to use concurrently with them.
 func (b *ReadOnly) Index() index.Index {
+	if b.lazy != nil {
+		return b.lazy.Snapshot()
+	}
 	return b.idx
 }
 
@@ -211,15 +311,13 @@ func (b *ReadOnly) DeleteBlock(_ context.Context, _ cid.Cid) error {
 // and StoreIdentityCIDs is on, then the index will contain identity CIDs and
 // this will always return true.
 func (b *ReadOnly) Has(ctx context.Context, key cid.Cid) (bool, error) {
-	if !b.opts.StoreIdentityCIDs {
-		// If we don't store identity CIDs then we can return them straight away as if they are here,
-		// otherwise we need to check for their existence.
-		// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
-		if _, ok, err := store.IsIdentity(key); err != nil {
-			return false, err
-		} else if ok {
-			return true, nil
-		}
+	// If we don't store identity CIDs then we can return them straight away as if they are here,
+	// otherwise we need to check for their existence.
+	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
+	if _, ok, err := store.InlineIdentity(key, b.opts.StoreIdentityCIDs); err != nil {
+		return false, err
+	} else if ok {
+		return true, nil
 	}
 
 	b.mu.RLock()
@@ -229,15 +327,7 @@ func (b *ReadOnly) Has(ctx context.Context, key cid.Cid) (bool, error) {
 		return false, errClosed
 	}
 
-	_, _, size, err := store.FindCid(
-		b.backing,
-		b.idx,
-		key,
-		b.opts.BlockstoreUseWholeCIDs,
-		b.opts.ZeroLengthSectionAsEOF,
-		b.opts.MaxAllowedSectionSize,
-		false,
-	)
+	_, _, size, err := b.findCid(key, false)
 	if errors.Is(err, index.ErrNotFound) {
 		return false, nil
 	} else if err != nil {
@@ -255,15 +345,13 @@ func (b *ReadOnly) Has(ctx context.Context, key cid.Cid) (bool, error) {
 // CARv1 and StoreIdentityCIDs is on, then the index will contain identity CIDs
 // and this will always return true.
 func (b *ReadOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
-	if !b.opts.StoreIdentityCIDs {
-		// If we don't store identity CIDs then we can return them straight away as if they are here,
-		// otherwise we need to check for their existence.
-		// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
-		if digest, ok, err := store.IsIdentity(key); err != nil {
-			return nil, err
-		} else if ok {
-			return blocks.NewBlockWithCid(digest, key)
-		}
+	// If we don't store identity CIDs then we can return them straight away as if they are here,
+	// otherwise we need to check for their existence.
+	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
+	if digest, ok, err := store.InlineIdentity(key, b.opts.StoreIdentityCIDs); err != nil {
+		return nil, err
+	} else if ok {
+		return blocks.NewBlockWithCid(digest, key)
 	}
 
 	b.mu.RLock()
@@ -273,15 +361,7 @@ func (b *ReadOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
 		return nil, errClosed
 	}
 
-	data, _, _, err := store.FindCid(
-		b.backing,
-		b.idx,
-		key,
-		b.opts.BlockstoreUseWholeCIDs,
-		b.opts.ZeroLengthSectionAsEOF,
-		b.opts.MaxAllowedSectionSize,
-		true,
-	)
+	data, _, _, err := b.findCid(key, true)
 	if errors.Is(err, index.ErrNotFound) {
 		return nil, format.ErrNotFound{Cid: key}
 	} else if err != nil {
@@ -291,10 +371,14 @@ func (b *ReadOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
 }
 
 // GetSize gets the size of an item corresponding to the given key.
+// As with Has and Get, the size of a block with multihash.IDENTITY code is
+// the size of its digest unless the StoreIdentityCIDs option is on, in which
+// case it will defer to the index.
+// If the index implements index.SizedIndex, and the UseWholeCIDs option is
+// not set, the size is answered from the index without reading the payload.
 func (b *ReadOnly) GetSize(ctx context.Context, key cid.Cid) (int, error) {
-	// Check if the given CID has multihash.IDENTITY code
 	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
-	if digest, ok, err := store.IsIdentity(key); err != nil {
+	if digest, ok, err := store.InlineIdentity(key, b.opts.StoreIdentityCIDs); err != nil {
 		return 0, err
 	} else if ok {
 		return len(digest), nil
@@ -307,21 +391,110 @@ func (b *ReadOnly) GetSize(ctx context.Context, key cid.Cid) (int, error) {
 		return 0, errClosed
 	}
 
-	_, _, size, err := store.FindCid(
+	// Answer from the index alone if it records sizes. Only multihashes are
+	// recorded by such indices, so whole CIDs still require reading the CID
+	// from the payload.
+	if sized, ok := b.idx.(index.SizedIndex); ok && !b.opts.BlockstoreUseWholeCIDs {
+		size := -1
+		err := sized.GetAllSized(key, func(_, s uint64) bool {
+			size = int(s)
+			return false
+		})
+		if errors.Is(err, index.ErrNotFound) {
+			return -1, format.ErrNotFound{Cid: key}
+		} else if err != nil {
+			return -1, err
+		}
+		return size, nil
+	}
+
+	_, _, size, err := b.findCid(key, false)
+	if errors.Is(err, index.ErrNotFound) {
+		return -1, format.ErrNotFound{Cid: key}
+	} else if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+// GetRange gets length bytes of the data of the block corresponding to the
+// given key, starting at offset, or fewer if the block ends before. Only the
+// bytes of the range are read from the CAR, which suits serving parts of large
+// blocks, such as for HTTP range requests over the raw leaves of files.
+//
+// An offset equal to the size of the block yields no bytes, while an offset
+// beyond it is an error. Since the block is only partially read, its data is
+// not checked against its CID. As with Get, blocks with multihash.IDENTITY
+// code are answered from their digest unless the StoreIdentityCIDs option is
+// on.
+func (b *ReadOnly) GetRange(ctx context.Context, key cid.Cid, offset, length int64) ([]byte, error) {
+	if digest, ok, err := store.InlineIdentity(key, b.opts.StoreIdentityCIDs); err != nil {
+		return nil, err
+	} else if ok {
+		length, err := store.ClipRange(len(digest), offset, length)
+		if err != nil {
+			return nil, err
+		}
+		return digest[offset : offset+length], nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, errClosed
+	}
+
+	_, dataOffset, size, err := b.findCid(key, false)
+	if errors.Is(err, index.ErrNotFound) {
+		return nil, format.ErrNotFound{Cid: key}
+	} else if err != nil {
+		return nil, err
+	}
+	return store.ReadRange(b.backing, dataOffset, size, offset, length)
+}
+
+func (b *ReadOnly) initCursor() {
+	if b.opts.BlockstoreSequentialCursor {
+		b.cursor = store.NewCursor()
+	}
+}
+
+// findCid looks up the given key, trying the section at the cursor position
+// first if the UseSequentialCursor option is set.
+func (b *ReadOnly) findCid(key cid.Cid, readBytes bool) ([]byte, int64, int, error) {
+	if b.cursor != nil {
+		if data, offset, size, ok := b.cursor.Find(
+			b.backing,
+			key,
+			b.opts.BlockstoreUseWholeCIDs,
+			b.opts.ZeroLengthSectionAsEOF,
+			b.opts.MaxAllowedSectionSize,
+			readBytes,
+		); ok {
+			return data, offset, size, nil
+		}
+	}
+	if b.lazy != nil {
+		data, offset, size, err := b.lazy.FindCid(b.backing, key, b.opts, readBytes)
+		if err == nil && b.cursor != nil {
+			b.cursor.Advance(offset, size)
+		}
+		return data, offset, size, err
+	}
+	data, offset, size, err := store.FindCid(
 		b.backing,
 		b.idx,
 		key,
 		b.opts.BlockstoreUseWholeCIDs,
 		b.opts.ZeroLengthSectionAsEOF,
 		b.opts.MaxAllowedSectionSize,
-		false,
+		readBytes,
 	)
-	if errors.Is(err, index.ErrNotFound) {
-		return -1, format.ErrNotFound{Cid: key}
-	} else if err != nil {
-		return -1, err
+	if err == nil && b.cursor != nil {
+		b.cursor.Advance(offset, size)
 	}
-	return size, nil
+	return data, offset, size, err
 }
 
 // Put is not supported and always returns an error.
@@ -346,12 +519,71 @@ func WithAsyncErrorHandler(ctx context.Context, errHandler func(error)) context.
 // retrieval of CIDs will be passed to the error handler function set in context.
 // Otherwise, errors will terminate the asynchronous operation silently.
 //
-// See WithAsyncErrorHandler
+// See WithAsyncErrorHandler, as well as AllKeys, which returns the errors, and
+// SkipCorruptSections, which skips over damaged sections instead.
 func (b *ReadOnly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
-	// We release the lock when the channel-sending goroutine stops.
+	it, err := b.AllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: document this choice of 5, or use simpler buffering like 0 or 1.
+	ch := make(chan cid.Cid, 5)
+
+	go func() {
+		defer it.Close()
+		defer close(ch)
+
+		for {
+			c, err := it.Next()
+			if err != nil {
+				if err != io.EOF {
+					maybeReportError(ctx, err)
+				}
+				return
+			}
+
+			select {
+			case ch <- c:
+			case <-ctx.Done():
+				maybeReportError(ctx, ctx.Err())
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// KeyIterator iterates over the keys of a read-only blockstore in the order in
+// which their blocks appear in the CAR; see ReadOnly.AllKeys. It is not safe
+// for concurrent use.
+type KeyIterator struct {
+	b    *ReadOnly
+	ctx  context.Context
+	pos  int64
+	size int64
+	// sized is set if the size of the payload is known, rather than detected by
+	// reading past its end.
+	sized bool
+	err   error
+	// unlock releases the read lock of the blockstore held by the iterator.
+	unlock func()
+}
+
+// AllKeys returns an iterator over the keys of the blockstore, like
+// AllKeysChan, except that the error which terminates the iteration is
+// returned by KeyIterator.Next rather than only passed to the handler set via
+// WithAsyncErrorHandler. A section of the CAR that cannot be read is reported
+// as such an error, unless the SkipCorruptSections option is set.
+//
+// The blockstore is locked for reading until the iterator is closed, or until
+// Next returns an error, such that Close must not be called on the blockstore
+// until then.
+func (b *ReadOnly) AllKeys(ctx context.Context) (*KeyIterator, error) {
+	// We release the lock when the iterator stops.
 	// Note that we can't use a deferred unlock here,
 	// because if we return a nil error,
-	// we only want to unlock once the async goroutine has stopped.
+	// we only want to unlock once the iterator is done.
 	b.mu.RLock()
 
 	if b.closed {
@@ -362,6 +594,7 @@ func (b *ReadOnly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 	// TODO we may use this walk for populating the index, and we need to be able to iterate keys in this way somewhere for index generation. In general though, when it's asked for all keys from a blockstore with an index, we should iterate through the index when possible rather than linear reads through the full car.
 	rdr, err := internalio.NewOffsetReadSeeker(b.backing, 0)
 	if err != nil {
+		b.mu.RUnlock() // don't hold the mutex forever
 		return nil, err
 	}
 	header, err := carv1.ReadHeader(rdr, b.opts.MaxAllowedHeaderSize)
@@ -375,67 +608,205 @@ func (b *ReadOnly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 		return nil, err
 	}
 
-	// TODO: document this choice of 5, or use simpler buffering like 0 or 1.
-	ch := make(chan cid.Cid, 5)
-
-	// Seek to the end of header.
-	if _, err = rdr.Seek(int64(headerSize), io.SeekStart); err != nil {
-		b.mu.RUnlock() // don't hold the mutex forever
-		return nil, err
+	size, sized := readerAtSize(b.backing)
+	if !sized {
+		size = math.MaxInt64
 	}
+	return &KeyIterator{
+		b:      b,
+		ctx:    ctx,
+		pos:    int64(headerSize),
+		size:   size,
+		sized:  sized,
+		unlock: sync.OnceFunc(b.mu.RUnlock),
+	}, nil
+}
 
-	go func() {
-		defer b.mu.RUnlock()
-		defer close(ch)
+// Next returns the next key, or io.EOF once all keys have been returned. Any
+// other error terminates the iteration, and is returned by subsequent calls.
+func (it *KeyIterator) Next() (cid.Cid, error) {
+	for it.err == nil {
+		if err := it.ctx.Err(); err != nil {
+			it.fail(err)
+			break
+		}
 
-		for {
-			length, err := varint.ReadUvarint(rdr)
-			if err != nil {
-				if err != io.EOF {
-					maybeReportError(ctx, err)
-				}
-				return
+		c, next, err := it.readSection()
+		if err == nil {
+			it.pos = next
+			// If we're just using multihashes, flatten to the "raw" codec.
+			if !it.b.opts.BlockstoreUseWholeCIDs {
+				c = cid.NewCidV1(cid.Raw, c.Hash())
 			}
+			return c, nil
+		}
+		if err == io.EOF || !it.b.opts.SkipCorruptSections {
+			it.fail(err)
+			break
+		}
 
-			// Null padding; by default it's an error.
-			if length == 0 {
-				if b.opts.ZeroLengthSectionAsEOF {
-					break
-				} else {
-					maybeReportError(ctx, errZeroLengthSection)
-					return
-				}
-			}
+		opts := it.b.opts
+		resume := util.NextValidSection(it.b.backing, it.pos+1, it.size, opts.MaxAllowedSectionSize, opts.MaxIndexCidSize)
+		if opts.OnCorruptSection != nil {
+			opts.OnCorruptSection(uint64(it.pos), uint64(resume-it.pos), err)
+		}
+		it.pos = resume
+	}
+	return cid.Undef, it.err
+}
 
-			thisItemForNxt, err := rdr.Seek(0, io.SeekCurrent)
-			if err != nil {
-				maybeReportError(ctx, err)
-				return
-			}
-			_, c, err := cid.CidFromReader(rdr)
-			if err != nil {
-				maybeReportError(ctx, err)
-				return
-			}
-			if _, err := rdr.Seek(thisItemForNxt+int64(length), io.SeekStart); err != nil {
-				maybeReportError(ctx, err)
-				return
-			}
+// readSection reads the length prefix and CID of the section at the position
+// of the iterator, returning the CID and the position of the next section.
+func (it *KeyIterator) readSection() (cid.Cid, int64, error) {
+	if it.pos >= it.size {
+		return cid.Undef, 0, io.EOF
+	}
+	rdr, err := internalio.NewOffsetReadSeeker(it.b.backing, it.pos)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	length, err := varint.ReadUvarint(rdr)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
 
-			// If we're just using multihashes, flatten to the "raw" codec.
-			if !b.opts.BlockstoreUseWholeCIDs {
-				c = cid.NewCidV1(cid.Raw, c.Hash())
+	// Null padding; by default it's an error.
+	if length == 0 {
+		if it.b.opts.ZeroLengthSectionAsEOF {
+			return cid.Undef, 0, io.EOF
+		}
+		return cid.Undef, 0, errZeroLengthSection
+	}
+
+	cidLen, c, err := cid.CidFromReader(rdr)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if uint64(cidLen) > length {
+		return cid.Undef, 0, fmt.Errorf("section of length %d holds a CID of length %d", length, cidLen)
+	}
+
+	// Make sure the section is complete, so that a truncated CAR is not mistaken
+	// for a valid one.
+	next := it.pos + int64(varint.UvarintSize(length)) + int64(length)
+	if next > it.size || next < it.pos {
+		return cid.Undef, 0, io.ErrUnexpectedEOF
+	}
+	if !it.sized {
+		var last [1]byte
+		if _, err := it.b.backing.ReadAt(last[:], next-1); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
 			}
+			return cid.Undef, 0, err
+		}
+	}
+	return c, next, nil
+}
 
-			select {
-			case ch <- c:
-			case <-ctx.Done():
-				maybeReportError(ctx, ctx.Err())
-				return
+func (it *KeyIterator) fail(err error) {
+	it.err = err
+	it.unlock()
+}
+
+// Close stops the iteration, releasing the blockstore. It is safe to call
+// Close more than once, and after Next has returned an error.
+func (it *KeyIterator) Close() error {
+	if it.err == nil {
+		it.err = errIteratorClosed
+	}
+	it.unlock()
+	return nil
+}
+
+// Stat returns the number of blocks in the blockstore and their total size,
+// counting the records of the index and deriving the size from that of the
+// data payload, so that block data is not read.
+//
+// If the index cannot be iterated, the size of the data payload is not known
+// or the UseLazyIndex option is set, the length prefix and CID of every section
+// are read instead.
+func (b *ReadOnly) Stat(ctx context.Context) (Stat, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return Stat{}, errClosed
+	}
+
+	rdr, err := internalio.NewOffsetReadSeeker(b.backing, 0)
+	if err != nil {
+		return Stat{}, err
+	}
+	header, err := carv1.ReadHeader(rdr, b.opts.MaxAllowedHeaderSize)
+	if err != nil {
+		return Stat{}, fmt.Errorf("error reading car header: %w", err)
+	}
+	headerSize, err := carv1.HeaderSize(header)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	iterable, ok := b.idx.(index.IterableIndex)
+	payloadSize, sized := readerAtSize(b.backing)
+	if ok && sized && b.lazy == nil {
+		var stat Stat
+		if err := iterable.ForEach(func(multihash.Multihash, uint64) error {
+			stat.Keys++
+			return nil
+		}); err != nil {
+			return Stat{}, err
+		}
+		stat.Size = uint64(payloadSize) - headerSize
+		return stat, nil
+	}
+
+	var stat Stat
+	for {
+		if err := ctx.Err(); err != nil {
+			return Stat{}, err
+		}
+		length, err := varint.ReadUvarint(rdr)
+		if err == io.EOF {
+			return stat, nil
+		}
+		if err != nil {
+			return Stat{}, err
+		}
+		if length == 0 {
+			if b.opts.ZeroLengthSectionAsEOF {
+				return stat, nil
 			}
+			return Stat{}, errZeroLengthSection
 		}
-	}()
-	return ch, nil
+		cidLen, c, err := cid.CidFromReader(rdr)
+		if err != nil {
+			return Stat{}, err
+		}
+		if _, err := rdr.Seek(int64(length)-int64(cidLen), io.SeekCurrent); err != nil {
+			return Stat{}, err
+		}
+		// Like a generated index, only count IDENTITY CIDs if they are stored.
+		if b.opts.StoreIdentityCIDs || c.Prefix().MhType != multihash.IDENTITY {
+			stat.Keys++
+		}
+		stat.Size += uint64(varint.UvarintSize(length)) + length
+	}
+}
+
+// readerAtSize returns the size of r, if it is known.
+func readerAtSize(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Size() (int64, error) }:
+		size, err := r.Size()
+		return size, err == nil
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	default:
+		return 0, false
+	}
 }
 
 // maybeReportError checks if an error handler is present in context associated to the key
@@ -465,6 +836,12 @@ func (b *ReadOnly) Roots() ([]cid.Cid, error) {
 	return header.Roots, nil
 }
 
+// IdentityCIDPolicy returns how this blockstore treats blocks with identity
+// CIDs, as set via the StoreIdentityCIDs option.
+func (b *ReadOnly) IdentityCIDPolicy() carv2.IdentityCIDPolicy {
+	return store.IdentityPolicy(b.opts.StoreIdentityCIDs)
+}
+
 // Close closes the underlying reader if it was opened by OpenReadOnly.
 // After this call, the blockstore can no longer be used.
 //
@@ -3,8 +3,12 @@ package blockstore
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,11 +17,14 @@ import (
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	"github.com/ipld/go-car/v2/internal/store"
 )
 
@@ -123,11 +130,16 @@ func TestReadOnly(t *testing.T) {
 					require.True(t, has)
 				}
 
-				// Assert size matches block raw data length.
+				// Assert size matches block raw data length, or that the block
+				// is not found, consistently with Has.
 				gotSize, err := subject.GetSize(ctx, key)
-				wantSize := len(wantBlock.RawData())
-				require.NoError(t, err)
-				require.Equal(t, wantSize, gotSize)
+				if has {
+					wantSize := len(wantBlock.RawData())
+					require.NoError(t, err)
+					require.Equal(t, wantSize, gotSize)
+				} else {
+					require.IsType(t, format.ErrNotFound{}, err)
+				}
 
 				// Assert block itself matches v1 payload block.
 				if has {
@@ -393,3 +405,710 @@ func TestReadOnlyIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestReadOnlyWithSequentialCursor(t *testing.T) {
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-wrapped-v2.car"} {
+		t.Run(path, func(t *testing.T) {
+			subject, err := OpenReadOnly(path, UseSequentialCursor(true))
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			require.NotNil(t, subject.cursor)
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { f.Close() })
+			br, err := carv2.NewBlockReader(f)
+			require.NoError(t, err)
+
+			var wantBlocks []blocks.Block
+			for {
+				wantBlock, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				wantBlocks = append(wantBlocks, wantBlock)
+
+				// Blocks are requested in CAR order, which is served by the cursor
+				// after the first lookup.
+				has, err := subject.Has(context.TODO(), wantBlock.Cid())
+				require.NoError(t, err)
+				require.True(t, has)
+				gotBlock, err := subject.Get(context.TODO(), wantBlock.Cid())
+				require.NoError(t, err)
+				require.Equal(t, wantBlock.RawData(), gotBlock.RawData())
+				gotSize, err := subject.GetSize(context.TODO(), wantBlock.Cid())
+				require.NoError(t, err)
+				require.Equal(t, len(wantBlock.RawData()), gotSize)
+			}
+
+			// Out of order lookups fall back on the index.
+			for i := len(wantBlocks) - 1; i >= 0; i-- {
+				gotBlock, err := subject.Get(context.TODO(), wantBlocks[i].Cid())
+				require.NoError(t, err)
+				require.Equal(t, wantBlocks[i].RawData(), gotBlock.RawData())
+			}
+
+			nonExistingKey := blocks.NewBlock([]byte("lobstermuncher")).Cid()
+			_, err = subject.Get(context.TODO(), nonExistingKey)
+			require.Equal(t, format.ErrNotFound{Cid: nonExistingKey}, err)
+		})
+	}
+}
+
+func TestReadOnlyLazyIndexSnapshot(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car", UseLazyIndex(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+	f, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	br, err := carv2.NewBlockReader(f)
+	require.NoError(t, err)
+	var cids []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		cids = append(cids, blk.Cid())
+	}
+
+	// Iterating over the index while lookups populate it does not race.
+	before := subject.Index()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			_ = subject.Index().(index.IterableIndex).ForEach(func(multihash.Multihash, uint64) error { return nil })
+		}
+	}()
+	for i := len(cids) - 1; i >= 0; i-- {
+		has, err := subject.Has(context.TODO(), cids[i])
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+	wg.Wait()
+
+	// Snapshots are not updated by later lookups.
+	last := cids[len(cids)-1]
+	require.ErrorIs(t, before.GetAll(last, func(uint64) bool { return false }), index.ErrNotFound)
+	require.NoError(t, subject.Index().GetAll(last, func(uint64) bool { return false }))
+}
+
+func TestReadOnlyWithLazyIndex(t *testing.T) {
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-v2-indexless.car"} {
+		t.Run(path, func(t *testing.T) {
+			subject, err := OpenReadOnly(path, UseLazyIndex(true))
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			require.NotNil(t, subject.lazy)
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { f.Close() })
+			br, err := carv2.NewBlockReader(f)
+			require.NoError(t, err)
+
+			var wantBlocks []blocks.Block
+			for {
+				wantBlock, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				wantBlocks = append(wantBlocks, wantBlock)
+			}
+
+			// Looking up a block in the middle of the payload only indexes the
+			// sections up to it.
+			mid := wantBlocks[len(wantBlocks)/2]
+			gotBlock, err := subject.Get(context.TODO(), mid.Cid())
+			require.NoError(t, err)
+			require.Equal(t, mid.RawData(), gotBlock.RawData())
+			err = subject.Index().GetAll(wantBlocks[len(wantBlocks)-1].Cid(), func(uint64) bool { return false })
+			require.ErrorIs(t, err, index.ErrNotFound)
+
+			for i := len(wantBlocks) - 1; i >= 0; i-- {
+				has, err := subject.Has(context.TODO(), wantBlocks[i].Cid())
+				require.NoError(t, err)
+				require.True(t, has)
+				gotSize, err := subject.GetSize(context.TODO(), wantBlocks[i].Cid())
+				require.NoError(t, err)
+				require.Equal(t, len(wantBlocks[i].RawData()), gotSize)
+			}
+
+			nonExistingKey := blocks.NewBlock([]byte("lobstermuncher")).Cid()
+			_, err = subject.Get(context.TODO(), nonExistingKey)
+			require.Equal(t, format.ErrNotFound{Cid: nonExistingKey}, err)
+		})
+	}
+}
+
+func TestReadOnlyReadAhead(t *testing.T) {
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-v2-indexless.car"} {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		br, err := carv2.NewBlockReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		var wantBlocks []blocks.Block
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			wantBlocks = append(wantBlocks, blk)
+		}
+		last := wantBlocks[len(wantBlocks)-1]
+
+		for name, lazy := range map[string]bool{"Index": false, "LazyIndex": true} {
+			t.Run(filepath.Base(path)+"/"+name, func(t *testing.T) {
+				// Scanning the whole payload, either to generate the index or to
+				// lazily index up to the last block, takes far fewer reads.
+				scan := func(opts ...carv2.Option) int {
+					counting := &countingReaderAt{ReaderAt: bytes.NewReader(data)}
+					subject, err := NewReadOnly(counting, nil, append(opts, UseLazyIndex(lazy))...)
+					require.NoError(t, err)
+					got, err := subject.Get(context.TODO(), last.Cid())
+					require.NoError(t, err)
+					require.Equal(t, last.RawData(), got.RawData())
+					for _, blk := range wantBlocks {
+						got, err := subject.Get(context.TODO(), blk.Cid())
+						require.NoError(t, err)
+						require.Equal(t, blk.RawData(), got.RawData())
+					}
+					return counting.reads
+				}
+				unbatched := scan()
+				batched := scan(carv2.WithReadAhead(64 << 10))
+				require.Less(t, batched*2, unbatched)
+			})
+		}
+	}
+}
+
+// sizedIndex records the size of each block alongside the wrapped index.
+type sizedIndex struct {
+	index.Index
+	sizes map[string]uint64
+}
+
+func (s *sizedIndex) GetAllSized(c cid.Cid, fn func(offset, size uint64) bool) error {
+	return s.GetAll(c, func(offset uint64) bool {
+		return fn(offset, s.sizes[string(c.Hash())])
+	})
+}
+
+func (s *sizedIndex) LoadSized(records []index.SizedRecord) error {
+	for _, r := range records {
+		s.sizes[string(r.Hash())] = r.Size
+		if err := s.Load([]index.Record{r.Record}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingReaderAt counts the calls to ReadAt, and the bytes they read.
+type countingReaderAt struct {
+	io.ReaderAt
+	reads     int
+	readBytes int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	n, err := c.ReaderAt.ReadAt(p, off)
+	c.readBytes += n
+	return n, err
+}
+
+func TestReadOnlyGetSizeFromSizedIndex(t *testing.T) {
+	f, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	idx, err := carv2.GenerateIndex(f)
+	require.NoError(t, err)
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	br, err := carv2.NewBlockReader(f)
+	require.NoError(t, err)
+	sized := &sizedIndex{Index: idx, sizes: make(map[string]uint64)}
+	var wantBlocks []blocks.Block
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		sized.sizes[string(blk.Cid().Hash())] = uint64(len(blk.RawData()))
+		wantBlocks = append(wantBlocks, blk)
+	}
+
+	backing := &countingReaderAt{ReaderAt: f}
+	subject, err := NewReadOnly(backing, sized)
+	require.NoError(t, err)
+	backing.reads = 0
+	for _, blk := range wantBlocks {
+		gotSize, err := subject.GetSize(context.TODO(), blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, len(blk.RawData()), gotSize)
+	}
+	require.Zero(t, backing.reads)
+
+	nonExistingKey := blocks.NewBlock([]byte("lobstermuncher")).Cid()
+	_, err = subject.GetSize(context.TODO(), nonExistingKey)
+	require.Equal(t, format.ErrNotFound{Cid: nonExistingKey}, err)
+
+	// Whole CIDs are still checked against the payload.
+	subject, err = NewReadOnly(backing, sized, UseWholeCIDs(true))
+	require.NoError(t, err)
+	backing.reads = 0
+	gotSize, err := subject.GetSize(context.TODO(), wantBlocks[0].Cid())
+	require.NoError(t, err)
+	require.Equal(t, len(wantBlocks[0].RawData()), gotSize)
+	require.NotZero(t, backing.reads)
+}
+
+func TestReadOnlyGetSizeFromIndexWithSize(t *testing.T) {
+	v1, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	var v2 bytes.Buffer
+	require.NoError(t, carv2.WrapV1(bytes.NewReader(v1), &v2, carv2.UseIndexCodec(index.CarMultihashIndexSortedWithSize)))
+
+	br, err := carv2.NewBlockReader(bytes.NewReader(v1))
+	require.NoError(t, err)
+	var wantBlocks []blocks.Block
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		wantBlocks = append(wantBlocks, blk)
+	}
+
+	// The index attached to the CARv2 is read back with the block sizes.
+	backing := &countingReaderAt{ReaderAt: bytes.NewReader(v2.Bytes())}
+	subject, err := NewReadOnly(backing, nil)
+	require.NoError(t, err)
+	backing.reads = 0
+	for _, blk := range wantBlocks {
+		gotSize, err := subject.GetSize(context.TODO(), blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, len(blk.RawData()), gotSize)
+	}
+	require.Zero(t, backing.reads)
+}
+
+func TestReadOnlyStat(t *testing.T) {
+	ctx := context.Background()
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-wrapped-v2.car"} {
+		// Count the sections independently of Stat.
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		br, err := carv2.NewBlockReader(f)
+		require.NoError(t, err)
+		var want Stat
+		for {
+			md, err := br.SkipNext()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			section := uint64(md.Cid.ByteLen()) + md.Size
+			// IDENTITY CIDs are not indexed by default.
+			if md.Cid.Prefix().MhType != multihash.IDENTITY {
+				want.Keys++
+			}
+			want.Size += uint64(varint.UvarintSize(section)) + section
+		}
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		for name, open := range map[string]func() (*ReadOnly, error){
+			"Index":     func() (*ReadOnly, error) { return OpenReadOnly(path) },
+			"LazyIndex": func() (*ReadOnly, error) { return OpenReadOnly(path, UseLazyIndex(true)) },
+			// A backing of unknown size is scanned.
+			"Unsized": func() (*ReadOnly, error) { return NewReadOnly(unsizedReaderAt{bytes.NewReader(data)}, nil) },
+		} {
+			t.Run(filepath.Base(path)+"/"+name, func(t *testing.T) {
+				subject, err := open()
+				require.NoError(t, err)
+				got, err := subject.Stat(ctx)
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+				require.NoError(t, subject.Close())
+				_, err = subject.Stat(ctx)
+				require.ErrorIs(t, err, errClosed)
+			})
+		}
+	}
+}
+
+func TestReadOnlyAllKeysCorruptSections(t *testing.T) {
+	ctx := context.Background()
+	data, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	// Damage the CID of a section in the middle, and truncate the last one.
+	header, err := carv1.ReadHeader(bytes.NewReader(data), carv2.DefaultMaxAllowedHeaderSize)
+	require.NoError(t, err)
+	headerSize, err := carv1.HeaderSize(header)
+	require.NoError(t, err)
+	br, err := carv2.NewBlockReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	offsets := []int64{int64(headerSize)}
+	var keys []cid.Cid
+	for {
+		md, err := br.SkipNext()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		section := uint64(md.Cid.ByteLen()) + md.Size
+		offsets = append(offsets, offsets[len(offsets)-1]+int64(varint.UvarintSize(section))+int64(section))
+		keys = append(keys, cid.NewCidV1(cid.Raw, md.Cid.Hash()))
+	}
+	offsets = offsets[:len(keys)]
+	damaged, last := len(offsets)/2, len(offsets)-1
+	corrupt := bytes.Clone(data[:len(data)-1])
+	_, n, err := varint.FromUvarint(corrupt[offsets[damaged]:])
+	require.NoError(t, err)
+	corrupt[offsets[damaged]+int64(n)] = 0x05 // not a valid CID version
+
+	for name, backing := range map[string]func() io.ReaderAt{
+		"Sized":   func() io.ReaderAt { return bytes.NewReader(corrupt) },
+		"Unsized": func() io.ReaderAt { return unsizedReaderAt{bytes.NewReader(corrupt)} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			subject, err := NewReadOnly(backing(), index.NewInsertionIndex())
+			require.NoError(t, err)
+
+			// The iteration stops at the damaged section with an error.
+			it, err := subject.AllKeys(ctx)
+			require.NoError(t, err)
+			var got []cid.Cid
+			for {
+				c, err := it.Next()
+				if err != nil {
+					require.NotErrorIs(t, err, io.EOF)
+					_, again := it.Next()
+					require.Equal(t, err, again)
+					break
+				}
+				got = append(got, c)
+			}
+			require.Equal(t, keys[:damaged], got)
+			require.NoError(t, it.Close())
+
+			// As does AllKeysChan, reporting the error to the handler.
+			var asyncErr error
+			ch, err := subject.AllKeysChan(WithAsyncErrorHandler(ctx, func(err error) { asyncErr = err }))
+			require.NoError(t, err)
+			got = nil
+			for c := range ch {
+				got = append(got, c)
+			}
+			require.Equal(t, keys[:damaged], got)
+			require.Error(t, asyncErr)
+
+			// Unless the damaged sections are skipped, reporting each skip.
+			type skip struct{ offset, length uint64 }
+			var skips []skip
+			subject, err = NewReadOnly(backing(), index.NewInsertionIndex(), SkipCorruptSections(func(offset, length uint64, err error) {
+				require.Error(t, err)
+				skips = append(skips, skip{offset, length})
+			}))
+			require.NoError(t, err)
+			ch, err = subject.AllKeysChan(WithAsyncErrorHandler(ctx, func(err error) {
+				require.Fail(t, "unexpected error", err)
+			}))
+			require.NoError(t, err)
+			got = nil
+			for c := range ch {
+				got = append(got, c)
+			}
+			want := append(slices.Clone(keys[:damaged]), keys[damaged+1:last]...)
+			require.Equal(t, want, got)
+			require.Equal(t, []skip{
+				{uint64(offsets[damaged]), uint64(offsets[damaged+1] - offsets[damaged])},
+				{uint64(offsets[last]), uint64(int64(len(corrupt)) - offsets[last])},
+			}, skips)
+
+			// An iterator closed early releases the blockstore.
+			it, err = subject.AllKeys(ctx)
+			require.NoError(t, err)
+			_, err = it.Next()
+			require.NoError(t, err)
+			require.NoError(t, it.Close())
+			_, err = it.Next()
+			require.Error(t, err)
+			require.NoError(t, subject.Close())
+		})
+	}
+}
+
+type unsizedReaderAt struct{ r io.ReaderAt }
+
+func (u unsizedReaderAt) ReadAt(p []byte, off int64) (int, error) { return u.r.ReadAt(p, off) }
+
+// remoteReaderAt fails on reads past its end, like a reader of a remote CAR
+// served via HTTP range requests.
+type remoteReaderAt struct{ data []byte }
+
+func (r remoteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, errors.New("range not satisfiable")
+	}
+	n := copy(p, r.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestReadOnlyWithSize(t *testing.T) {
+	ctx := context.Background()
+	allKeys := func(t *testing.T, bs *ReadOnly) []cid.Cid {
+		it, err := bs.AllKeys(ctx)
+		require.NoError(t, err)
+		var keys []cid.Cid
+		for {
+			key, err := it.Next()
+			if err == io.EOF {
+				return keys
+			}
+			require.NoError(t, err)
+			keys = append(keys, key)
+		}
+	}
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-wrapped-v2.car"} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			want, err := OpenReadOnly(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { want.Close() })
+			wantKeys := allKeys(t, want)
+
+			subject, err := NewReadOnly(remoteReaderAt{data}, nil, WithSize(int64(len(data))))
+			require.NoError(t, err)
+			require.Equal(t, wantKeys, allKeys(t, subject))
+			for _, key := range wantKeys {
+				wantBlock, err := want.Get(ctx, key)
+				require.NoError(t, err)
+				got, err := subject.Get(ctx, key)
+				require.NoError(t, err)
+				require.Equal(t, wantBlock, got)
+			}
+		})
+	}
+
+	// Without the size, the end of a CARv1 is only found by reading past it,
+	// such that its index cannot be generated.
+	data, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	_, err = NewReadOnly(remoteReaderAt{data}, nil)
+	require.ErrorContains(t, err, "range not satisfiable")
+
+	// Index offsets past the end of the payload are reported as such.
+	c, err := carv2.NewBlockReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	blk, err := c.Next()
+	require.NoError(t, err)
+	idx, err := index.New(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	require.NoError(t, idx.Load([]index.Record{{Cid: blk.Cid(), Offset: uint64(len(data)) + 1}}))
+	subject, err := NewReadOnly(remoteReaderAt{data}, idx, WithSize(int64(len(data))))
+	require.NoError(t, err)
+	_, err = subject.Get(ctx, blk.Cid())
+	require.ErrorContains(t, err, "beyond the end of the data payload")
+}
+
+func TestOpenReadOnlyShared(t *testing.T) {
+	const path = "../testdata/sample-wrapped-v2.car"
+	ctx := context.Background()
+	want, err := OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { want.Close() })
+	keys, err := want.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var wantCids []cid.Cid
+	for k := range keys {
+		wantCids = append(wantCids, k)
+	}
+
+	sharedRefs := func() int {
+		sharedFiles.Lock()
+		defer sharedFiles.Unlock()
+		require.LessOrEqual(t, len(sharedFiles.byPath), 1)
+		for _, f := range sharedFiles.byPath {
+			return f.refs
+		}
+		return 0
+	}
+
+	// Many blockstores opened concurrently share one file.
+	const n = 50
+	subjects := make([]*ReadOnly, n)
+	var wg sync.WaitGroup
+	for i := range subjects {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			subject, err := OpenReadOnlyShared(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			subjects[i] = subject
+			for _, c := range wantCids {
+				wantBlk, err := want.Get(ctx, c)
+				assert.NoError(t, err)
+				gotBlk, err := subject.Get(ctx, c)
+				assert.NoError(t, err)
+				assert.Equal(t, wantBlk, gotBlk)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, n, sharedRefs())
+
+	// Handles report the size of the shared file.
+	h, err := openSharedFile(path, carv2.Options{})
+	require.NoError(t, err)
+	wantStat, err := os.Stat(path)
+	require.NoError(t, err)
+	size, err := h.Size()
+	require.NoError(t, err)
+	require.Equal(t, wantStat.Size(), size)
+	require.NoError(t, h.Close())
+	_, err = h.Size()
+	require.ErrorIs(t, err, os.ErrClosed)
+
+	// Closing one leaves the others usable, even if closed twice.
+	require.NoError(t, subjects[0].Close())
+	require.NoError(t, subjects[0].Close())
+	require.Equal(t, n-1, sharedRefs())
+	_, err = subjects[1].Get(ctx, wantCids[0])
+	require.NoError(t, err)
+
+	// The file is closed along with the last blockstore.
+	for _, subject := range subjects[1:] {
+		require.NoError(t, subject.Close())
+	}
+	require.Equal(t, 0, sharedRefs())
+
+	// A failed open does not leak the file.
+	_, err = OpenReadOnlyShared("../testdata/sample-rootless-v42.car")
+	require.Error(t, err)
+	require.Equal(t, 0, sharedRefs())
+}
+
+// blockingFileLocker blocks locking until release is closed.
+type blockingFileLocker struct {
+	locking chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingFileLocker) Lock(*os.File, bool, bool) error {
+	close(l.locking)
+	<-l.release
+	return nil
+}
+
+func (l *blockingFileLocker) Unlock(*os.File) error { return nil }
+
+func TestOpenReadOnlySharedLocksOutsideGlobalMutex(t *testing.T) {
+	locker := &blockingFileLocker{locking: make(chan struct{}), release: make(chan struct{})}
+	type result struct {
+		bs  *ReadOnly
+		err error
+	}
+	blocked := make(chan result, 2)
+	open := func() {
+		bs, err := OpenReadOnlyShared("../testdata/sample-wrapped-v2.car", WithFileLocker(locker))
+		blocked <- result{bs, err}
+	}
+	go open()
+	<-locker.locking
+
+	// Other files open while the lock is awaited.
+	other, err := OpenReadOnlyShared("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+
+	// Opening the same file waits for the lock too.
+	go open()
+	select {
+	case <-blocked:
+		t.Fatal("opened before the file was locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(locker.release)
+	for i := 0; i < 2; i++ {
+		r := <-blocked
+		require.NoError(t, r.err)
+		t.Cleanup(func() { r.bs.Close() })
+	}
+}
+
+func TestReadOnlyGetRange(t *testing.T) {
+	big := blocks.NewBlock(bytes.Repeat([]byte("0123456789"), 200<<10))
+	small := blocks.NewBlock([]byte("fish"))
+	var buf bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: []cid.Cid{big.Cid()}, Version: 1}, &buf))
+	for _, blk := range []blocks.Block{small, big} {
+		require.NoError(t, util.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData()))
+	}
+	backing := &countingReaderAt{ReaderAt: bytes.NewReader(buf.Bytes())}
+	subject, err := NewReadOnly(backing, nil)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name           string
+		blk            blocks.Block
+		offset, length int64
+		want           []byte
+	}{
+		{"start", big, 0, 10, big.RawData()[:10]},
+		{"middle", big, 1 << 20, 64 << 10, big.RawData()[1<<20 : 1<<20+64<<10]},
+		{"clipped", big, int64(len(big.RawData())) - 5, 100, big.RawData()[len(big.RawData())-5:]},
+		{"end", big, int64(len(big.RawData())), 100, []byte{}},
+		{"small", small, 1, 2, []byte("is")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backing.readBytes = 0
+			got, err := subject.GetRange(context.TODO(), tc.blk.Cid(), tc.offset, tc.length)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+			// Only the section header is read besides the range.
+			require.Less(t, backing.readBytes, len(tc.want)+4<<10)
+		})
+	}
+
+	_, err = subject.GetRange(context.TODO(), small.Cid(), 5, 1)
+	require.ErrorContains(t, err, "beyond the block size")
+	_, err = subject.GetRange(context.TODO(), small.Cid(), -1, 1)
+	require.ErrorContains(t, err, "invalid range")
+	nonExistingKey := blocks.NewBlock([]byte("lobstermuncher")).Cid()
+	_, err = subject.GetRange(context.TODO(), nonExistingKey, 0, 1)
+	require.Equal(t, format.ErrNotFound{Cid: nonExistingKey}, err)
+
+	// Identity CIDs are answered from their digest.
+	idCid, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum([]byte("identity"))
+	require.NoError(t, err)
+	got, err := subject.GetRange(context.TODO(), idCid, 2, 3)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ent"), got)
+
+	require.NoError(t, subject.Close())
+	_, err = subject.GetRange(context.TODO(), small.Cid(), 0, 1)
+	require.Equal(t, errClosed, err)
+}
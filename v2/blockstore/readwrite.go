@@ -1,12 +1,19 @@
 package blockstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"sort"
+	"sync/atomic"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
 
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
@@ -14,9 +21,12 @@ import (
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"github.com/ipld/go-car/v2/internal/store"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
 var _ Blockstore = (*ReadWrite)(nil)
+var _ StatBlockstore = (*ReadWrite)(nil)
 
 var (
 	errFinalized = fmt.Errorf("cannot write in a carv2 blockstore after finalize")
@@ -34,18 +44,42 @@ var (
 type ReadWrite struct {
 	ronly ReadOnly
 
-	f          *os.File
+	rw         ReaderAtWriterAt
 	dataWriter *internalio.OffsetWriteSeeker
 	idx        *index.InsertionIndex
 	header     carv2.Header
 
 	finalized bool // also protected by ronly.mu
 
+	// moveErr is set once moving the sections for detected roots failed part
+	// way, leaving their offsets unknown, such that all later puts and
+	// finalization fail with it; also protected by ronly.mu.
+	moveErr error
+
+	// checkpoint persists the records of idx if the WithIndexCheckpoint
+	// option is set.
+	checkpoint *store.Checkpoint
+
+	// putStats is returned by Stats; protected by ronly.mu.
+	putStats PutStats
+
+	// snapshot serves reads without locking if the UseSnapshotIndex option
+	// is set; it is replaced after each PutMany, and set to nil on close.
+	snapshot atomic.Pointer[store.Snapshot]
+
 	opts carv2.Options
 }
 
 var WriteAsCarV1 = carv2.WriteAsCarV1
+var WithDetachedIndexPath = carv2.WithDetachedIndexPath
+var NormalizeRoots = carv2.NormalizeRoots
 var AllowDuplicatePuts = carv2.AllowDuplicatePuts
+var UseSnapshotIndex = carv2.UseSnapshotIndex
+var AutoDetectRoots = carv2.AutoDetectRoots
+var WithSyncOnFinalize = carv2.WithSyncOnFinalize
+var WithFileLocker = carv2.WithFileLocker
+var WaitForFileLock = carv2.WaitForFileLock
+var WithIndexCheckpoint = carv2.WithIndexCheckpoint
 
 // OpenReadWrite creates a new ReadWrite at the given path with a provided set of root CIDs and options.
 //
@@ -76,11 +110,21 @@ var AllowDuplicatePuts = carv2.AllowDuplicatePuts
 //     Note, if set previously, the blockstore must use the same WithDataPadding option as before,
 //     since this option is used to locate the CARv1 data payload.
 //
+// When writing a CARv1 via WriteAsCarV1, whose payload size is not recorded by any header, a last
+// data section which was only partially written, e.g. due to a crash during ReadWrite.Put, is
+// truncated off the file and written over, rather than failing the resumption.
+//
 // Note, resumption should be used with WithCidDeduplication, so that blocks that are successfully
 // written into the file are not re-written. Unless, the user explicitly wants duplicate blocks.
 //
 // Resuming from finalized files is allowed. However, resumption will regenerate the index
-// regardless by scanning every existing block in file.
+// regardless by scanning every existing block in file, unless it is restored from the sidecar
+// file of the WithIndexCheckpoint option.
+//
+// If a car.FileLocker is set via car.WithFileLocker, the file is locked exclusively until the
+// blockstore is finalized or discarded, such that other processes opening it via OpenReadWrite or
+// OpenReadOnly with a locker fail with car.ErrFileLocked, or wait for the lock if
+// car.WaitForFileLock is set. Files are not locked by default.
 func OpenReadWrite(path string, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666) // TODO: Should the user be able to configure FileMode permissions?
 	if err != nil {
@@ -92,17 +136,21 @@ func OpenReadWrite(path string, roots []cid.Cid, opts ...carv2.Option) (*ReadWri
 			f.Close()
 		}
 	}()
+	closer, err := lockFile(f, true, carv2.ApplyOptions(opts...))
+	if err != nil {
+		return nil, fmt.Errorf("could not lock read/write file: %w", err)
+	}
 	rwbs, err := OpenReadWriteFile(f, roots, opts...)
 	if err != nil {
 		return nil, err
 	}
-	// close the file when finalizing
-	rwbs.ronly.carv2Closer = rwbs.f
+	// unlock and close the file when finalizing
+	rwbs.ronly.carv2Closer = closer
 	return rwbs, nil
 }
 
 // OpenReadWriteFile is similar as OpenReadWrite but lets you control the file lifecycle.
-// You are responsible for closing the given file.
+// You are responsible for closing the given file, as well as for locking it if needed.
 func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
 	stat, err := f.Stat()
 	if err != nil {
@@ -110,18 +158,59 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 		return nil, err
 	}
 	// Try and resume by default if the file size is non-zero.
-	resume := stat.Size() != 0
+	return newReadWrite(f, stat.Size() != 0, roots, opts...)
+}
 
+// ReaderAtWriterAt is the backing storage of a ReadWrite blockstore.
+type ReaderAtWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// NewReadWrite is similar to OpenReadWrite but writes to the given rw, allowing
+// any storage with random read and write access to back the blockstore, such as
+// an in-memory buffer or a staging layer of an object store. You are
+// responsible for the lifecycle of the given rw.
+//
+// As with OpenReadWrite, the blockstore attempts to resume from rw if it is not
+// empty, i.e. if a read at offset zero does not immediately return io.EOF.
+// Resuming a CARv2 additionally requires rw to implement Truncate(int64) error,
+// as implemented by os.File.
+func NewReadWrite(rw ReaderAtWriterAt, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
+	var b [1]byte
+	n, err := rw.ReadAt(b[:], 0)
+	if n == 0 && err != nil && err != io.EOF {
+		return nil, err
+	}
+	return newReadWrite(rw, n != 0, roots, opts...)
+}
+
+func newReadWrite(rw ReaderAtWriterAt, resume bool, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
+	var err error
 	// Instantiate block store.
 	// Set the header fileld before applying options since padding options may modify header.
 	rwbs := &ReadWrite{
-		f:         f,
+		rw:        rw,
 		idx:       index.NewInsertionIndex(),
 		header:    carv2.NewHeader(0),
 		opts:      carv2.ApplyOptions(opts...),
 		finalized: false,
 	}
 	rwbs.ronly.opts = rwbs.opts
+	rwbs.ronly.initCursor()
+	if rwbs.opts.IndexCheckpointPath != "" && store.IsSizedIndexCodec(rwbs.opts.IndexCodec) {
+		// The records of checkpoints do not hold block sizes.
+		return nil, fmt.Errorf("index codec %v cannot be used with an index checkpoint", rwbs.opts.IndexCodec)
+	}
+	if rwbs.opts.BlockstoreAutoDetectRoots && rwbs.opts.BlockstoreSnapshotIndex {
+		// Moving the sections for the detected roots would race with the
+		// reads served from the snapshot without locking.
+		return nil, errors.New("roots cannot be detected when reading from a snapshot index")
+	}
+
+	if rwbs.opts.NormalizeRoots {
+		roots, _ = carv2.SortAndDedupeRoots(roots)
+	}
 
 	if p := rwbs.opts.DataPadding; p > 0 {
 		rwbs.header = rwbs.header.WithDataPadding(p)
@@ -134,21 +223,33 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 	if rwbs.opts.WriteAsCarV1 {
 		offset = 0
 	}
-	rwbs.dataWriter = internalio.NewOffsetWriter(rwbs.f, offset)
+	rwbs.dataWriter = internalio.NewOffsetWriter(rwbs.rw, offset)
 	var v1r internalio.ReadSeekerAt
-	v1r, err = internalio.NewOffsetReadSeeker(rwbs.f, offset)
+	v1r, err = internalio.NewOffsetReadSeeker(rwbs.rw, offset)
 	if err != nil {
 		return nil, err
 	}
 	rwbs.ronly.backing = v1r
 	rwbs.ronly.idx = rwbs.idx
 
+	var scanFrom uint64
+	rewriteCheckpoint := true
 	if resume {
-		if err = store.ResumableVersion(f, rwbs.opts.WriteAsCarV1); err != nil {
+		var rs internalio.ReadSeekerAt
+		if rs, err = internalio.NewOffsetReadSeeker(rwbs.rw, 0); err != nil {
 			return nil, err
 		}
+		if err = store.ResumableVersion(rs, rwbs.opts.WriteAsCarV1); err != nil {
+			return nil, err
+		}
+		if rwbs.opts.IndexCheckpointPath != "" {
+			if rwbs.idx, scanFrom, rewriteCheckpoint, err = store.LoadCheckpoint(rwbs.opts.IndexCheckpointPath, rwbs.ronly.backing); err != nil {
+				return nil, fmt.Errorf("could not load index checkpoint: %w", err)
+			}
+			rwbs.ronly.idx = rwbs.idx
+		}
 		if err = store.Resume(
-			f,
+			rwbs.rw,
 			rwbs.ronly.backing,
 			rwbs.dataWriter,
 			rwbs.idx,
@@ -157,6 +258,7 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 			rwbs.opts.WriteAsCarV1,
 			rwbs.opts.MaxAllowedHeaderSize,
 			rwbs.opts.ZeroLengthSectionAsEOF,
+			scanFrom,
 		); err != nil {
 			return nil, err
 		}
@@ -166,12 +268,36 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 		}
 	}
 
+	if rwbs.opts.IndexCheckpointPath != "" {
+		if rwbs.checkpoint, err = store.OpenCheckpoint(
+			rwbs.opts.IndexCheckpointPath,
+			rwbs.idx,
+			scanFrom,
+			rewriteCheckpoint,
+			rwbs.opts.IndexCheckpointEveryPuts,
+			rwbs.opts.IndexCheckpointInterval,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if rwbs.opts.BlockstoreSnapshotIndex {
+		var records []index.Record
+		if err = rwbs.idx.ForEachCid(func(c cid.Cid, offset uint64) error {
+			records = append(records, index.Record{Cid: c, Offset: offset})
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		rwbs.snapshot.Store((*store.Snapshot)(nil).With(records))
+	}
+
 	return rwbs, nil
 }
 
 func (b *ReadWrite) initWithRoots(v2 bool, roots []cid.Cid) error {
 	if v2 {
-		if _, err := b.f.WriteAt(carv2.Pragma, 0); err != nil {
+		if _, err := b.rw.WriteAt(carv2.Pragma, 0); err != nil {
 			return err
 		}
 	}
@@ -192,7 +318,7 @@ func (b *ReadWrite) Put(ctx context.Context, blk blocks.Block) error {
 
 // PutMany puts a slice of blocks at the same time using batching
 // capabilities of the underlying datastore whenever possible.
-func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
+func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) (err error) {
 	b.ronly.mu.Lock()
 	defer b.ronly.mu.Unlock()
 
@@ -202,6 +328,31 @@ func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
 	if b.finalized {
 		return errFinalized
 	}
+	if b.moveErr != nil {
+		return b.moveErr
+	}
+
+	// Publish the blocks written by this call to readers of the snapshot,
+	// including if writing the remaining blocks fails.
+	var written []index.Record
+	if b.opts.BlockstoreSnapshotIndex {
+		defer func() {
+			if snap := b.snapshot.Load(); snap != nil && len(written) > 0 {
+				b.snapshot.Store(snap.With(written))
+			}
+		}()
+	}
+
+	var puts uint64
+	if b.checkpoint != nil {
+		// Persist the records added by this call, including if writing the
+		// remaining blocks fails.
+		defer func() {
+			if cerr := b.checkpoint.Put(b.idx, puts); err == nil {
+				err = cerr
+			}
+		}()
+	}
 
 	for _, bl := range blks {
 		c := bl.Cid()
@@ -216,6 +367,11 @@ func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
 		); err != nil {
 			return err
 		} else if !should {
+			if _, ok, _ := store.InlineIdentity(c, b.opts.StoreIdentityCIDs); ok {
+				b.putStats.Identity++
+			} else {
+				b.putStats.Deduplicated++
+			}
 			continue
 		}
 
@@ -223,11 +379,58 @@ func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
 		if err := util.LdWrite(b.dataWriter, c.Bytes(), bl.RawData()); err != nil {
 			return err
 		}
-		b.idx.InsertNoReplace(c, n)
+		b.putStats.Written++
+		b.putStats.Bytes += uint64(len(bl.RawData()))
+		if b.putStats.CodecCounts == nil {
+			b.putStats.CodecCounts = make(map[multicodec.Code]uint64)
+		}
+		b.putStats.CodecCounts[multicodec.Code(c.Type())]++
+		if b.checkpoint != nil {
+			if err := b.idx.Add(index.Record{Cid: c, Offset: n}); err != nil {
+				return err
+			}
+			puts++
+		} else {
+			b.idx.InsertSizedNoReplace(c, n, uint64(len(bl.RawData())))
+		}
+		if b.opts.BlockstoreSnapshotIndex {
+			written = append(written, index.Record{Cid: c, Offset: n})
+		}
 	}
 	return nil
 }
 
+// PutStats summarizes the blocks put into a ReadWrite blockstore since it was
+// opened, excluding any blocks of the CAR it resumed from.
+type PutStats struct {
+	// Written is the number of blocks written to the CAR.
+	Written uint64
+	// Deduplicated is the number of blocks not written since they were
+	// already present; see AllowDuplicatePuts and UseWholeCIDs.
+	Deduplicated uint64
+	// Identity is the number of blocks with IDENTITY CIDs not written since
+	// the StoreIdentityCIDs option is not set.
+	Identity uint64
+	// Bytes is the total length of the data of the blocks written, excluding
+	// their CIDs and length prefixes.
+	Bytes uint64
+	// CodecCounts is the number of blocks written by CID codec.
+	CodecCounts map[multicodec.Code]uint64
+}
+
+// Stats returns the statistics of the blocks put so far, which are updated by
+// every Put and PutMany, including ones which fail part-way through. Unlike
+// Stat, which summarizes the blocks held, it may be called at any time,
+// including after the blockstore is finalized.
+func (b *ReadWrite) Stats() PutStats {
+	b.ronly.mu.RLock()
+	defer b.ronly.mu.RUnlock()
+
+	stats := b.putStats
+	stats.CodecCounts = maps.Clone(stats.CodecCounts)
+	return stats
+}
+
 // Discard closes this blockstore without finalizing its header and index.
 // After this call, the blockstore can no longer be used.
 //
@@ -238,13 +441,21 @@ func (b *ReadWrite) Discard() {
 	// The only difference is that our method is called Discard,
 	// to further clarify that we're not properly finalizing and writing a
 	// CARv2 file.
+	b.snapshot.Store(nil)
 	b.ronly.Close()
+
+	b.ronly.mu.Lock()
+	defer b.ronly.mu.Unlock()
+	b.closeCheckpoint(false)
 }
 
 // Finalize finalizes this blockstore by writing the CARv2 header, along with flattened index
 // for more efficient subsequent read.
 // This is the equivalent to calling FinalizeReadOnly and Close.
 // After this call, the blockstore can no longer be used.
+//
+// Unless the WithSyncOnFinalize option is set, the CAR may not be durable yet
+// when Finalize returns, as it may only have been written to the operating system.
 func (b *ReadWrite) Finalize() error {
 	b.ronly.mu.Lock()
 	defer b.ronly.mu.Unlock()
@@ -270,9 +481,25 @@ func (b *ReadWrite) FinalizeReadOnly() error {
 func (b *ReadWrite) finalizeReadOnlyWithoutMutex() error {
 	if b.opts.WriteAsCarV1 {
 		// all blocks are already properly written to the CARv1 inner container and there's
-		// no additional finalization required at the end of the file for a complete v1
+		// no additional finalization required at the end of the file for a complete v1,
+		// other than optionally persisting the index next to it.
+		if !b.finalized {
+			if err := b.writeDetectedRoots(); err != nil {
+				return err
+			}
+		}
+		if !b.finalized && b.opts.DetachedIndexPath != "" && b.opts.IndexCodec != index.CarIndexNone {
+			if err := store.WriteDetachedIndex(b.opts.DetachedIndexPath, b.idx, b.opts.IndexCodec, b.opts.SyncOnFinalize); err != nil {
+				return err
+			}
+		}
+		if !b.finalized && b.opts.SyncOnFinalize {
+			if err := store.Sync(b.rw); err != nil {
+				return err
+			}
+		}
 		b.finalized = true
-		return nil
+		return b.closeCheckpoint(true)
 	}
 
 	if b.ronly.closed {
@@ -284,9 +511,121 @@ func (b *ReadWrite) finalizeReadOnlyWithoutMutex() error {
 		return fmt.Errorf("called Finalize or FinalizeReadOnly on an already finalized blockstore")
 	}
 
+	if err := b.writeDetectedRoots(); err != nil {
+		return err
+	}
+	if err := store.Finalize(b.rw, b.header, b.idx, uint64(b.dataWriter.Position()), b.opts.StoreIdentityCIDs, b.opts.IndexCodec); err != nil {
+		return err
+	}
 	b.finalized = true
+	if b.opts.SyncOnFinalize {
+		if err := store.Sync(b.rw); err != nil {
+			return err
+		}
+	}
+	return b.closeCheckpoint(true)
+}
+
+// closeCheckpoint closes the index checkpoint file, if any, removing it if
+// remove is set, i.e. once the CAR is finalized and no longer needs it.
+func (b *ReadWrite) closeCheckpoint(remove bool) error {
+	if b.checkpoint == nil {
+		return nil
+	}
+	err := b.checkpoint.Close(remove)
+	b.checkpoint = nil
+	return err
+}
+
+// writeDetectedRoots replaces the roots in the CARv1 header with the blocks
+// that are not linked to by any other block if the AutoDetectRoots option is
+// set. If the new header differs in size from the existing one, the sections
+// written so far are moved accordingly and the index is rebased; a shorter
+// header fails if rw cannot be truncated past the moved sections. Once moving
+// the sections fails, the blockstore can only be discarded.
+func (b *ReadWrite) writeDetectedRoots() error {
+	if !b.opts.BlockstoreAutoDetectRoots {
+		return nil
+	}
+	if b.moveErr != nil {
+		return b.moveErr
+	}
+	dataEnd := b.dataWriter.Position()
+	roots, err := store.DetectRoots(io.NewSectionReader(b.ronly.backing, 0, dataEnd), b.opts)
+	if err != nil {
+		return fmt.Errorf("could not detect roots: %w", err)
+	}
+	if b.opts.NormalizeRoots {
+		roots, _ = carv2.SortAndDedupeRoots(roots)
+	}
+
+	hr, err := internalio.NewOffsetReadSeeker(b.ronly.backing, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := carv1.ReadHeader(hr, b.opts.MaxAllowedHeaderSize); err != nil {
+		return err
+	}
+	headerSize, err := hr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	var header bytes.Buffer
+	if err := carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, &header); err != nil {
+		return err
+	}
 
-	return store.Finalize(b.f, b.header, b.idx, uint64(b.dataWriter.Position()), b.opts.StoreIdentityCIDs, b.opts.IndexCodec)
+	offset := int64(b.header.DataOffset)
+	if b.opts.WriteAsCarV1 {
+		offset = 0
+	}
+	if delta := int64(header.Len()) - headerSize; delta != 0 {
+		_, truncatable := b.rw.(interface{ Truncate(size int64) error })
+		if delta < 0 && !truncatable {
+			return fmt.Errorf("detected roots shrink the CARv1 header by %d bytes, but the backing cannot be truncated", -delta)
+		}
+		if err := b.moveSections(header.Bytes(), offset, headerSize, dataEnd, delta); err != nil {
+			// Retrying would move the sections that were moved once more.
+			b.moveErr = fmt.Errorf("could not move sections for the detected roots: %w", err)
+			return b.moveErr
+		}
+		return nil
+	}
+	_, err = b.rw.WriteAt(header.Bytes(), offset)
+	return err
+}
+
+// moveSections moves the sections between headerSize and dataEnd by delta
+// bytes, rebasing the index accordingly, and writes header in front of them.
+func (b *ReadWrite) moveSections(header []byte, offset, headerSize, dataEnd, delta int64) error {
+	if err := store.ShiftSections(b.rw, offset+headerSize, offset+dataEnd, delta); err != nil {
+		return err
+	}
+	if _, err := b.dataWriter.Seek(delta, io.SeekCurrent); err != nil {
+		return err
+	}
+	if delta < 0 {
+		if err := b.rw.(interface{ Truncate(size int64) error }).Truncate(offset + dataEnd + delta); err != nil {
+			return err
+		}
+	}
+
+	var records []index.SizedRecord
+	if err := b.idx.ForEachSizedRecord(func(r index.SizedRecord) error {
+		r.Offset = uint64(int64(r.Offset) + delta)
+		records = append(records, r)
+		return nil
+	}); err != nil {
+		return err
+	}
+	b.idx = index.NewInsertionIndex()
+	if err := b.idx.LoadSized(records); err != nil {
+		return err
+	}
+	b.ronly.idx = b.idx
+	b.ronly.initCursor()
+	_, err := b.rw.WriteAt(header, offset)
+	return err
 }
 
 // Close closes the blockstore.
@@ -308,10 +647,11 @@ func (b *ReadWrite) closeWithoutMutex() error {
 		return fmt.Errorf("called Close on a closed blockstore")
 	}
 
+	b.snapshot.Store(nil)
 	if err := b.ronly.closeWithoutMutex(); err != nil {
 		return err
 	}
-	return nil
+	return b.closeCheckpoint(false)
 }
 
 func (b *ReadWrite) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
@@ -349,7 +689,81 @@ func (b *ReadWrite) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 	return out, nil
 }
 
+// CidsInWriteOrder calls fn with the CID of each block written to the CAR, in
+// the order in which the blocks were written, including any blocks written
+// prior to resumption. Similar to AllKeysChan, the CIDs are only reported with
+// their original codec if the UseWholeCIDs option is set; otherwise the "raw"
+// codec is used.
+//
+// The CIDs are taken from the in-memory index, avoiding a scan of the CAR, and
+// remain available after Finalize. If fn returns a non-nil error, iteration is
+// aborted and the error is returned.
+func (b *ReadWrite) CidsInWriteOrder(fn func(cid.Cid) error) error {
+	b.ronly.mu.RLock()
+	var records []index.Record
+	err := b.idx.ForEachCid(func(c cid.Cid, offset uint64) error {
+		records = append(records, index.Record{Cid: c, Offset: offset})
+		return nil
+	})
+	b.ronly.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Offset < records[j].Offset })
+	for _, r := range records {
+		c := r.Cid
+		if !b.opts.BlockstoreUseWholeCIDs {
+			c = cid.NewCidV1(cid.Raw, c.Hash())
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the number of blocks written to the blockstore and their total
+// size, taken from the in-memory index and the write position, so that the CAR
+// is not read beyond its data payload header.
+func (b *ReadWrite) Stat(_ context.Context) (Stat, error) {
+	b.ronly.mu.RLock()
+	defer b.ronly.mu.RUnlock()
+
+	if b.ronly.closed {
+		return Stat{}, errClosed
+	}
+
+	rdr, err := internalio.NewOffsetReadSeeker(b.ronly.backing, 0)
+	if err != nil {
+		return Stat{}, err
+	}
+	header, err := carv1.ReadHeader(rdr, b.opts.MaxAllowedHeaderSize)
+	if err != nil {
+		return Stat{}, fmt.Errorf("error reading car header: %w", err)
+	}
+	headerSize, err := carv1.HeaderSize(header)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	stat := Stat{Size: uint64(b.dataWriter.Position()) - headerSize}
+	err = b.idx.ForEach(func(multihash.Multihash, uint64) error {
+		stat.Keys++
+		return nil
+	})
+	return stat, err
+}
+
 func (b *ReadWrite) Has(ctx context.Context, key cid.Cid) (bool, error) {
+	if b.opts.BlockstoreSnapshotIndex {
+		snap := b.snapshot.Load()
+		if snap == nil {
+			return false, errClosed
+		}
+		return b.has(snap, key)
+	}
+
 	b.ronly.mu.Lock()
 	defer b.ronly.mu.Unlock()
 
@@ -357,8 +771,13 @@ func (b *ReadWrite) Has(ctx context.Context, key cid.Cid) (bool, error) {
 		return false, errClosed
 	}
 
+	return b.has(b.idx, key)
+}
+
+// has looks up key in idx according to the options of the blockstore.
+func (b *ReadWrite) has(idx store.LookupIndex, key cid.Cid) (bool, error) {
 	return store.Has(
-		b.idx,
+		idx,
 		key,
 		b.opts.MaxIndexCidSize,
 		b.opts.StoreIdentityCIDs,
@@ -368,11 +787,80 @@ func (b *ReadWrite) Has(ctx context.Context, key cid.Cid) (bool, error) {
 }
 
 func (b *ReadWrite) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
-	return b.ronly.Get(ctx, key)
+	if !b.opts.BlockstoreSnapshotIndex {
+		return b.ronly.Get(ctx, key)
+	}
+	snap, err := b.checkSnapshot(key)
+	if err != nil {
+		return nil, err
+	}
+	if digest, ok, err := store.InlineIdentity(key, b.opts.StoreIdentityCIDs); err != nil {
+		return nil, err
+	} else if ok {
+		return blocks.NewBlockWithCid(digest, key)
+	}
+	data, _, err := b.findCidInSnapshot(snap, key, true)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, key)
 }
 
 func (b *ReadWrite) GetSize(ctx context.Context, key cid.Cid) (int, error) {
-	return b.ronly.GetSize(ctx, key)
+	if !b.opts.BlockstoreSnapshotIndex {
+		return b.ronly.GetSize(ctx, key)
+	}
+	snap, err := b.checkSnapshot(key)
+	if err != nil {
+		return -1, err
+	}
+	if digest, ok, err := store.InlineIdentity(key, b.opts.StoreIdentityCIDs); err != nil {
+		return -1, err
+	} else if ok {
+		return len(digest), nil
+	}
+	_, size, err := b.findCidInSnapshot(snap, key, false)
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+// checkSnapshot loads the current index snapshot, and checks that it has key
+// the same way Has does, such that Get and GetSize fail alike for keys which
+// Has rejects or does not find.
+func (b *ReadWrite) checkSnapshot(key cid.Cid) (*store.Snapshot, error) {
+	snap := b.snapshot.Load()
+	if snap == nil {
+		return nil, errClosed
+	}
+	has, err := b.has(snap, key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, format.ErrNotFound{Cid: key}
+	}
+	return snap, nil
+}
+
+// findCidInSnapshot looks up the given key in the given index snapshot,
+// without locking. Only blocks whose writes have completed are in the
+// snapshot, so their sections can be read while other blocks are written.
+func (b *ReadWrite) findCidInSnapshot(snap *store.Snapshot, key cid.Cid, readBytes bool) ([]byte, int, error) {
+	data, _, size, err := store.FindCid(
+		b.ronly.backing,
+		snap,
+		key,
+		b.opts.BlockstoreUseWholeCIDs,
+		b.opts.ZeroLengthSectionAsEOF,
+		b.opts.MaxAllowedSectionSize,
+		readBytes,
+	)
+	if errors.Is(err, index.ErrNotFound) {
+		return nil, -1, format.ErrNotFound{Cid: key}
+	}
+	return data, size, err
 }
 
 func (b *ReadWrite) DeleteBlock(_ context.Context, _ cid.Cid) error {
@@ -386,3 +874,9 @@ func (b *ReadWrite) HashOnRead(enable bool) {
 func (b *ReadWrite) Roots() ([]cid.Cid, error) {
 	return b.ronly.Roots()
 }
+
+// IdentityCIDPolicy returns how this blockstore treats blocks with identity
+// CIDs, as set via the StoreIdentityCIDs option.
+func (b *ReadWrite) IdentityCIDPolicy() carv2.IdentityCIDPolicy {
+	return b.ronly.IdentityCIDPolicy()
+}
@@ -1,8 +1,10 @@
 package blockstore_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -19,6 +21,7 @@ import (
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -26,6 +29,8 @@ import (
 	"github.com/ipld/go-car/v2/blockstore"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
 )
 
 var (
@@ -884,6 +889,55 @@ func TestOpenReadWrite_ErrorsWhenWritingTooLargeOfACid(t *testing.T) {
 	require.Equal(t, &carv2.ErrCidTooLarge{MaxSize: maxAllowedCidSize, CurrentSize: bigCidLen}, err)
 }
 
+func TestReadWrite_LookupsOfTooLargeACid(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("fish")
+	mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(data, cid.NewCidV1(uint64(multicodec.Raw), mh))
+	require.NoError(t, err)
+	maxAllowedCidSize := uint64(blk.Cid().ByteLen())
+	bigMh, err := multihash.Sum(data, multihash.SHA2_512, -1)
+	require.NoError(t, err)
+	bigBlk, err := blocks.NewBlockWithCid(data, cid.NewCidV1(uint64(multicodec.Raw), bigMh))
+	require.NoError(t, err)
+	require.Greater(t, uint64(bigBlk.Cid().ByteLen()), maxAllowedCidSize)
+	wantErr := &carv2.ErrCidTooLarge{MaxSize: maxAllowedCidSize, CurrentSize: uint64(bigBlk.Cid().ByteLen())}
+
+	for _, snapshot := range []bool{false, true} {
+		t.Run(fmt.Sprintf("snapshot=%t", snapshot), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "too-large.car")
+			subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, carv2.MaxIndexCidSize(maxAllowedCidSize), blockstore.UseSnapshotIndex(snapshot), carv2.UseWholeCIDs(true))
+			require.NoError(t, err)
+			t.Cleanup(subject.Discard)
+			require.NoError(t, subject.Put(ctx, blk))
+
+			has, err := subject.Has(ctx, bigBlk.Cid())
+			require.Equal(t, wantErr, err)
+			require.False(t, has)
+
+			// Whole CIDs are matched, not only their multihash.
+			other := cid.NewCidV1(uint64(multicodec.DagCbor), blk.Cid().Hash())
+			has, err = subject.Has(ctx, other)
+			require.NoError(t, err)
+			require.False(t, has)
+
+			if !snapshot {
+				return
+			}
+			_, err = subject.Get(ctx, bigBlk.Cid())
+			require.Equal(t, wantErr, err)
+			_, err = subject.GetSize(ctx, bigBlk.Cid())
+			require.Equal(t, wantErr, err)
+			_, err = subject.Get(ctx, other)
+			require.True(t, format.IsNotFound(err))
+			got, err := subject.Get(ctx, blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), got.RawData())
+		})
+	}
+}
+
 func TestReadWrite_ReWritingCARv1WithIdentityCidIsIdenticalToOriginalWithOptionsEnabled(t *testing.T) {
 	originalCARv1Path := "../testdata/sample-v1.car"
 	originalCarV1, err := os.Open(originalCARv1Path)
@@ -1174,3 +1228,1045 @@ func TestReadWriteIndex(t *testing.T) {
 		require.ElementsMatch(t, wantMh, got)
 	}
 }
+
+func TestReadWriteAsCarV1WithDetachedIndex(t *testing.T) {
+	dir := t.TempDir()
+	carPath := filepath.Join(dir, "detached.car")
+	idxPath := filepath.Join(dir, "detached.car.idx")
+
+	subject, err := blockstore.OpenReadWrite(carPath, []cid.Cid{oneTestBlockWithCidV1.Cid()},
+		blockstore.WriteAsCarV1(true),
+		blockstore.WithDetachedIndexPath(idxPath))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+	require.NoError(t, subject.Put(context.TODO(), anotherTestBlockWithCidV0))
+	require.NoError(t, subject.Finalize())
+
+	f, err := os.Open(idxPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	gotIdx, err := index.ReadFrom(f)
+	require.NoError(t, err)
+	require.Equal(t, multicodec.CarMultihashIndexSorted, gotIdx.Codec())
+
+	wantIdx, err := carv2.GenerateIndexFromFile(carPath)
+	require.NoError(t, err)
+	for _, blk := range []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0} {
+		want, err := index.GetFirst(wantIdx, blk.Cid())
+		require.NoError(t, err)
+		got, err := index.GetFirst(gotIdx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+// memReaderAtWriterAt is an in-memory ReaderAtWriterAt.
+type memReaderAtWriterAt struct {
+	buf []byte
+}
+
+func (m *memReaderAtWriterAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memReaderAtWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(m.buf)) {
+		m.buf = append(m.buf, make([]byte, end-int64(len(m.buf)))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+func TestNewReadWrite(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []carv2.Option
+	}{
+		{"carv2", nil},
+		{"carv1", []carv2.Option{blockstore.WriteAsCarV1(true)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.TODO()
+			backing := &memReaderAtWriterAt{}
+			roots := []cid.Cid{oneTestBlockWithCidV1.Cid()}
+			subject, err := blockstore.NewReadWrite(backing, roots, tc.opts...)
+			require.NoError(t, err)
+			require.NoError(t, subject.Put(ctx, oneTestBlockWithCidV1))
+
+			got, err := subject.Get(ctx, oneTestBlockWithCidV1.Cid())
+			require.NoError(t, err)
+			require.Equal(t, oneTestBlockWithCidV1.RawData(), got.RawData())
+			require.NoError(t, subject.Finalize())
+
+			robs, err := blockstore.NewReadOnly(bytes.NewReader(backing.buf), nil)
+			require.NoError(t, err)
+			gotRoots, err := robs.Roots()
+			require.NoError(t, err)
+			require.Equal(t, roots, gotRoots)
+			got, err = robs.Get(ctx, oneTestBlockWithCidV1.Cid())
+			require.NoError(t, err)
+			require.Equal(t, oneTestBlockWithCidV1.RawData(), got.RawData())
+		})
+	}
+}
+
+func TestNewReadWriteResumesCarV1(t *testing.T) {
+	ctx := context.TODO()
+	backing := &memReaderAtWriterAt{}
+	roots := []cid.Cid{oneTestBlockWithCidV1.Cid()}
+	subject, err := blockstore.NewReadWrite(backing, roots, blockstore.WriteAsCarV1(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, oneTestBlockWithCidV1))
+	require.NoError(t, subject.Finalize())
+
+	resumed, err := blockstore.NewReadWrite(backing, roots, blockstore.WriteAsCarV1(true))
+	require.NoError(t, err)
+	has, err := resumed.Has(ctx, oneTestBlockWithCidV1.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+	require.NoError(t, resumed.Put(ctx, anotherTestBlockWithCidV0))
+	require.NoError(t, resumed.Finalize())
+
+	robs, err := blockstore.NewReadOnly(bytes.NewReader(backing.buf), nil)
+	require.NoError(t, err)
+	for _, blk := range []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0} {
+		got, err := robs.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+
+	// Resuming a CARv2 requires truncation, which the backing does not support.
+	v2backing := &memReaderAtWriterAt{}
+	subject, err = blockstore.NewReadWrite(v2backing, roots)
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, oneTestBlockWithCidV1))
+	require.NoError(t, subject.Finalize())
+	_, err = blockstore.NewReadWrite(v2backing, roots)
+	require.ErrorContains(t, err, "truncate")
+}
+
+func TestReadWriteResumesCarV1WithPartialSection(t *testing.T) {
+	ctx := context.TODO()
+	v1f, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, v1f.Close()) })
+	r, err := carv1.NewCarReader(v1f)
+	require.NoError(t, err)
+	var blks []blocks.Block
+	for i := 0; i < 6; i++ {
+		b, err := r.Next()
+		require.NoError(t, err)
+		blks = append(blks, b)
+	}
+
+	dir := t.TempDir()
+	complete := filepath.Join(dir, "complete.car")
+	subject, err := blockstore.OpenReadWrite(complete, r.Header.Roots, blockstore.WriteAsCarV1(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[:4]))
+	require.NoError(t, subject.Finalize())
+	before, err := os.ReadFile(complete)
+	require.NoError(t, err)
+	last := blks[3]
+	sectionLen := varint.UvarintSize(uint64(last.Cid().ByteLen()+len(last.RawData()))) + last.Cid().ByteLen() + len(last.RawData())
+	sectionStart := len(before) - sectionLen
+
+	// Cut the last section short within its length, CID and data, as a crash
+	// while putting its block would.
+	for _, cut := range []int{1, varint.UvarintSize(uint64(sectionLen)) + 2, sectionLen - 1} {
+		t.Run(fmt.Sprintf("cut=%d", cut), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "partial.car")
+			require.NoError(t, os.WriteFile(path, before[:sectionStart+cut], 0o666))
+
+			subject, err := blockstore.OpenReadWrite(path, r.Header.Roots, blockstore.WriteAsCarV1(true), blockstore.UseWholeCIDs(true))
+			require.NoError(t, err)
+			for i, b := range blks[:4] {
+				has, err := subject.Has(ctx, b.Cid())
+				require.NoError(t, err)
+				require.Equal(t, i < 3, has)
+			}
+			require.NoError(t, subject.PutMany(ctx, blks[3:]))
+			require.NoError(t, subject.Finalize())
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { f.Close() })
+			br, err := carv2.NewBlockReader(f)
+			require.NoError(t, err)
+			for _, want := range blks {
+				got, err := br.Next()
+				require.NoError(t, err)
+				require.Equal(t, want.Cid(), got.Cid())
+				require.Equal(t, want.RawData(), got.RawData())
+			}
+			_, err = br.Next()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+
+	// Without the ability to truncate, the partial section cannot be removed.
+	backing := &memReaderAtWriterAt{buf: before[:len(before)-1]}
+	_, err = blockstore.NewReadWrite(backing, r.Header.Roots, blockstore.WriteAsCarV1(true))
+	require.ErrorContains(t, err, "partially written section")
+}
+
+// syncingReaderAtWriterAt records the size of its buffer whenever it is synced.
+type syncingReaderAtWriterAt struct {
+	memReaderAtWriterAt
+	synced []int
+}
+
+func (s *syncingReaderAtWriterAt) Sync() error {
+	s.synced = append(s.synced, len(s.buf))
+	return nil
+}
+
+func TestReadWriteSyncOnFinalize(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []carv2.Option
+		want int
+	}{
+		{"carv2", nil, 0},
+		{"carv2 synced", []carv2.Option{blockstore.WithSyncOnFinalize(true)}, 1},
+		{"carv1", []carv2.Option{blockstore.WriteAsCarV1(true)}, 0},
+		{"carv1 synced", []carv2.Option{blockstore.WriteAsCarV1(true), blockstore.WithSyncOnFinalize(true)}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backing := &syncingReaderAtWriterAt{}
+			subject, err := blockstore.NewReadWrite(backing, []cid.Cid{oneTestBlockWithCidV1.Cid()}, tc.opts...)
+			require.NoError(t, err)
+			require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+			require.Empty(t, backing.synced)
+			require.NoError(t, subject.Finalize())
+			require.Len(t, backing.synced, tc.want)
+			if tc.want > 0 {
+				// Synced once everything is written.
+				require.Equal(t, len(backing.buf), backing.synced[0])
+			}
+		})
+	}
+
+	// Files and detached indexes are synced along with their directory.
+	dir := t.TempDir()
+	idxPath := filepath.Join(dir, "synced.car.idx")
+	subject, err := blockstore.OpenReadWrite(filepath.Join(dir, "synced.car"), []cid.Cid{oneTestBlockWithCidV1.Cid()},
+		blockstore.WriteAsCarV1(true),
+		blockstore.WithDetachedIndexPath(idxPath),
+		blockstore.WithSyncOnFinalize(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+	require.NoError(t, subject.Finalize())
+	require.FileExists(t, idxPath)
+}
+
+func TestReadWriteNormalizeRoots(t *testing.T) {
+	a, b := oneTestBlockWithCidV1.Cid(), anotherTestBlockWithCidV0.Cid()
+	want, changed := carv2.SortAndDedupeRoots([]cid.Cid{b, a, b})
+	require.True(t, changed)
+	require.Len(t, want, 2)
+
+	path := filepath.Join(t.TempDir(), "normalized.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{b, a, b}, blockstore.NormalizeRoots(true))
+	require.NoError(t, err)
+	got, err := subject.Roots()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.NoError(t, subject.Finalize())
+
+	// Resumption normalizes the given roots before comparing them.
+	subject, err = blockstore.OpenReadWrite(path, []cid.Cid{a, b, a}, blockstore.NormalizeRoots(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Finalize())
+
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { robs.Close() })
+	got, err = robs.Roots()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestReadWriteCidsInWriteOrder(t *testing.T) {
+	var want []cid.Cid
+	var blks []blocks.Block
+	for i := 0; i < 20; i++ {
+		blk := blocks.NewBlock([]byte(fmt.Sprintf("block %d", i)))
+		blks = append(blks, blk)
+		want = append(want, blk.Cid())
+	}
+	collect := func(subject *blockstore.ReadWrite) []cid.Cid {
+		var got []cid.Cid
+		require.NoError(t, subject.CidsInWriteOrder(func(c cid.Cid) error {
+			got = append(got, c)
+			return nil
+		}))
+		return got
+	}
+
+	path := filepath.Join(t.TempDir(), "ordered.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{want[0]}, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(context.TODO(), blks[:10]))
+	require.NoError(t, subject.Finalize())
+	require.Equal(t, want[:10], collect(subject))
+
+	// Resumption reports previously written blocks first.
+	subject, err = blockstore.OpenReadWrite(path, []cid.Cid{want[0]}, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(context.TODO(), blks[10:]))
+	require.Equal(t, want, collect(subject))
+	require.NoError(t, subject.Finalize())
+	require.Equal(t, want, collect(subject))
+
+	errStop := fmt.Errorf("stop")
+	var calls int
+	err = subject.CidsInWriteOrder(func(cid.Cid) error {
+		calls++
+		return errStop
+	})
+	require.Equal(t, errStop, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestReadWriteSnapshotIndex(t *testing.T) {
+	ctx := context.Background()
+	var blks []blocks.Block
+	for i := 0; i < 500; i++ {
+		blks = append(blks, blocks.NewBlock([]byte(fmt.Sprintf("snapshot block %d", i))))
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, blockstore.UseSnapshotIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, blks[0]))
+
+	// Readers only look up blocks whose Put has returned, while more blocks
+	// are written concurrently.
+	var published sync.Map
+	published.Store(0, true)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(r)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				i := rng.Intn(len(blks))
+				if _, ok := published.Load(i); !ok {
+					continue
+				}
+				got, err := subject.Get(ctx, blks[i].Cid())
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, blks[i].RawData(), got.RawData())
+				has, err := subject.Has(ctx, blks[i].Cid())
+				assert.NoError(t, err)
+				assert.True(t, has)
+				size, err := subject.GetSize(ctx, blks[i].Cid())
+				assert.NoError(t, err)
+				assert.Equal(t, len(blks[i].RawData()), size)
+			}
+		}(r)
+	}
+	for i := 1; i < len(blks); i += 7 {
+		end := i + 7
+		if end > len(blks) {
+			end = len(blks)
+		}
+		require.NoError(t, subject.PutMany(ctx, blks[i:end]))
+		for j := i; j < end; j++ {
+			published.Store(j, true)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	missing := blocks.NewBlock([]byte("never written")).Cid()
+	has, err := subject.Has(ctx, missing)
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = subject.Get(ctx, missing)
+	require.True(t, format.IsNotFound(err))
+	_, err = subject.GetSize(ctx, missing)
+	require.True(t, format.IsNotFound(err))
+
+	require.NoError(t, subject.Finalize())
+	_, err = subject.Get(ctx, blks[0].Cid())
+	require.Error(t, err)
+	_, err = subject.Has(ctx, blks[0].Cid())
+	require.Error(t, err)
+
+	// Blocks written prior to resumption are in the initial snapshot.
+	subject, err = blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, blockstore.UseSnapshotIndex(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+	for _, blk := range blks {
+		got, err := subject.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+}
+
+func TestReadWriteStat(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "stat.car")
+	var blks []blocks.Block
+	for i := 0; i < 10; i++ {
+		blks = append(blks, blocks.NewBlock([]byte(fmt.Sprintf("stat block %d", i))))
+	}
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()})
+	require.NoError(t, err)
+
+	got, err := subject.Stat(ctx)
+	require.NoError(t, err)
+	require.Equal(t, blockstore.Stat{}, got)
+
+	var want blockstore.Stat
+	for _, blk := range blks {
+		require.NoError(t, subject.Put(ctx, blk))
+		section := uint64(blk.Cid().ByteLen() + len(blk.RawData()))
+		want.Keys++
+		want.Size += uint64(varint.UvarintSize(section)) + section
+		got, err := subject.Stat(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	require.NoError(t, subject.Finalize())
+	_, err = subject.Stat(ctx)
+	require.Error(t, err)
+}
+
+func TestReadWriteStats(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "stats.car")
+	pb := blocks.NewBlock([]byte("stats dag-pb block"))
+	cborBlk, err := blocks.NewBlockWithCid([]byte{0xa0}, cid.NewCidV1(cid.DagCBOR, pb.Cid().Hash()))
+	require.NoError(t, err)
+	identityMh, err := multihash.Sum([]byte("inline"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	identity, err := blocks.NewBlockWithCid([]byte("inline"), cid.NewCidV1(cid.Raw, identityMh))
+	require.NoError(t, err)
+	other := blocks.NewBlock([]byte("stats other block"))
+
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{pb.Cid()}, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	require.Equal(t, blockstore.PutStats{}, subject.Stats())
+
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{pb, cborBlk, identity, pb}))
+	require.NoError(t, subject.Put(ctx, other))
+	require.NoError(t, subject.Put(ctx, cborBlk))
+	want := blockstore.PutStats{
+		Written:      3,
+		Deduplicated: 2,
+		Identity:     1,
+		Bytes:        uint64(len(pb.RawData()) + len(cborBlk.RawData()) + len(other.RawData())),
+		CodecCounts: map[multicodec.Code]uint64{
+			multicodec.DagPb:   2,
+			multicodec.DagCbor: 1,
+		},
+	}
+	got := subject.Stats()
+	require.Equal(t, want, got)
+	// Modifying the returned stats does not affect the blockstore.
+	got.CodecCounts[multicodec.DagPb] = 0
+
+	require.NoError(t, subject.Finalize())
+	require.Equal(t, want, subject.Stats())
+}
+
+func TestReadWriteResumeKeepsWholeCIDDedupe(t *testing.T) {
+	ctx := context.Background()
+	raw := blocks.NewBlock([]byte("whole cid block"))
+	cbc, err := blocks.NewBlockWithCid(raw.RawData(), cid.NewCidV1(cid.DagCBOR, raw.Cid().Hash()))
+	require.NoError(t, err)
+
+	// Sessions resumed from a checkpoint are discarded rather than finalized,
+	// which would remove it.
+	for _, tc := range []struct {
+		name    string
+		opts    []carv2.Option
+		discard bool
+	}{
+		{name: "v2", opts: []carv2.Option{blockstore.UseWholeCIDs(true)}},
+		{name: "v1", opts: []carv2.Option{blockstore.UseWholeCIDs(true), blockstore.WriteAsCarV1(true)}},
+		{name: "checkpoint", opts: []carv2.Option{blockstore.UseWholeCIDs(true), blockstore.WithIndexCheckpoint(filepath.Join(t.TempDir(), "whole.ckpt"), 1, 0)}, discard: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "whole.car")
+			put := func(blk blocks.Block) uint64 {
+				subject, err := blockstore.OpenReadWrite(path, []cid.Cid{raw.Cid()}, tc.opts...)
+				require.NoError(t, err)
+				require.NoError(t, subject.Put(ctx, blk))
+				written := subject.Stats().Written
+				if tc.discard {
+					subject.Discard()
+				} else {
+					require.NoError(t, subject.Finalize())
+				}
+				return written
+			}
+
+			// Deduplication after resuming is the same as within a session: the
+			// same multihash under another codec is written, the same CID is not.
+			require.Equal(t, uint64(1), put(raw))
+			require.Equal(t, uint64(1), put(cbc))
+			require.Equal(t, uint64(0), put(raw))
+			require.Equal(t, uint64(0), put(cbc))
+			if tc.discard {
+				subject, err := blockstore.OpenReadWrite(path, []cid.Cid{raw.Cid()}, tc.opts...)
+				require.NoError(t, err)
+				require.NoError(t, subject.Finalize())
+			}
+
+			robs, err := blockstore.OpenReadOnly(path, blockstore.UseWholeCIDs(true))
+			require.NoError(t, err)
+			t.Cleanup(func() { robs.Close() })
+			var offsets []uint64
+			require.NoError(t, robs.Index().GetAll(raw.Cid(), func(offset uint64) bool {
+				offsets = append(offsets, offset)
+				return true
+			}))
+			require.Len(t, offsets, 2)
+		})
+	}
+}
+
+func TestReadWriteIndexWithSize(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "sized.car")
+	var blks []blocks.Block
+	for i := 0; i < 10; i++ {
+		blks = append(blks, blocks.NewBlock(bytes.Repeat([]byte{byte(i)}, i*7)))
+	}
+	opts := []carv2.Option{carv2.UseIndexCodec(index.CarMultihashIndexSortedWithSize)}
+
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, opts...)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[:5]))
+	require.NoError(t, subject.Finalize())
+
+	// Resumption recovers the sizes of the blocks written so far.
+	subject, err = blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, opts...)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[5:]))
+	require.NoError(t, subject.Finalize())
+
+	reader, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { reader.Close() })
+	ir, err := reader.IndexReader()
+	require.NoError(t, err)
+	idx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	sized, ok := idx.(index.SizedIndex)
+	require.True(t, ok)
+	for _, blk := range blks {
+		var size uint64
+		require.NoError(t, sized.GetAllSized(blk.Cid(), func(_, s uint64) bool {
+			size = s
+			return false
+		}))
+		require.Equal(t, uint64(len(blk.RawData())), size)
+	}
+
+	// Checkpoints do not record sizes.
+	_, err = blockstore.OpenReadWrite(filepath.Join(t.TempDir(), "checkpointed.car"), nil,
+		append(opts, blockstore.WithIndexCheckpoint(filepath.Join(t.TempDir(), "ckpt"), 1, 0))...)
+	require.Error(t, err)
+}
+
+func TestReadWriteWithoutIndex(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "noindex.car")
+	blk := blocks.NewBlock([]byte("indexless block"))
+
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blk.Cid()}, carv2.WithoutIndex())
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, blk))
+	require.NoError(t, subject.Finalize())
+
+	reader, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	require.Equal(t, uint64(2), reader.Version)
+	require.False(t, reader.Header.HasIndex())
+	ir, err := reader.IndexReader()
+	require.NoError(t, err)
+	require.Nil(t, ir)
+
+	// The index is regenerated when the CAR is read as a blockstore.
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	got, err := robs.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk.RawData(), got.RawData())
+}
+
+func TestReadWriteAutoDetectRoots(t *testing.T) {
+	ctx := context.Background()
+	rawBlock := func(data string) blocks.Block {
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte(data))
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid([]byte(data), c)
+		require.NoError(t, err)
+		return blk
+	}
+	leafA := rawBlock("leaf a")
+	leafB := rawBlock("leaf b")
+	orphan := rawBlock("orphan")
+	middle, err := cbor.WrapObject(map[string]interface{}{"a": leafA.Cid()}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	top, err := cbor.WrapObject(map[string]interface{}{"middle": middle.Cid(), "b": leafB.Cid()}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	blks := []blocks.Block{leafA, leafB, middle, orphan, top}
+	wantRoots := []cid.Cid{orphan.Cid(), top.Cid()}
+
+	placeholders := []cid.Cid{leafA.Cid(), leafB.Cid(), middle.Cid(), orphan.Cid()}
+	tests := []struct {
+		name  string
+		roots []cid.Cid
+		opts  []carv2.Option
+	}{
+		{name: "NoRoots"},
+		{name: "PlaceholderRoots", roots: placeholders},
+		{name: "Padded", opts: []carv2.Option{carv2.UseDataPadding(17)}},
+		{name: "CarV1", opts: []carv2.Option{blockstore.WriteAsCarV1(true)}},
+		{name: "CarV1PlaceholderRoots", roots: placeholders, opts: []carv2.Option{blockstore.WriteAsCarV1(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "auto-roots.car")
+			opts := append([]carv2.Option{blockstore.AutoDetectRoots(true)}, tt.opts...)
+			subject, err := blockstore.OpenReadWrite(path, tt.roots, opts...)
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(ctx, blks))
+			require.NoError(t, subject.FinalizeReadOnly())
+
+			// Blocks remain readable after their sections were moved.
+			gotRoots, err := subject.Roots()
+			require.NoError(t, err)
+			require.Equal(t, wantRoots, gotRoots)
+			for _, blk := range blks {
+				got, err := subject.Get(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, blk.RawData(), got.RawData())
+			}
+			require.NoError(t, subject.Close())
+
+			robs, err := blockstore.OpenReadOnly(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { robs.Close() })
+			gotRoots, err = robs.Roots()
+			require.NoError(t, err)
+			require.Equal(t, wantRoots, gotRoots)
+			for _, blk := range blks {
+				got, err := robs.Get(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, blk.RawData(), got.RawData())
+			}
+
+			cr, err := carv2.OpenReader(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { cr.Close() })
+			stats, err := cr.Inspect(true)
+			require.NoError(t, err)
+			require.EqualValues(t, len(blks), stats.BlockCount)
+			require.True(t, stats.RootsPresent)
+			if cr.Version == 2 {
+				ir, err := cr.IndexReader()
+				require.NoError(t, err)
+				idx, err := index.ReadFrom(ir)
+				require.NoError(t, err)
+				dr, err := cr.DataReader()
+				require.NoError(t, err)
+				for _, blk := range blks {
+					offset, err := index.GetFirst(idx, blk.Cid())
+					require.NoError(t, err)
+					_, err = dr.Seek(int64(offset), io.SeekStart)
+					require.NoError(t, err)
+					c, data, err := util.ReadNode(dr, false, carv1.DefaultMaxAllowedSectionSize)
+					require.NoError(t, err)
+					require.Equal(t, blk.Cid(), c)
+					require.Equal(t, blk.RawData(), data)
+				}
+			}
+		})
+	}
+}
+
+func TestReadWriteAutoDetectRootsRejectsSnapshotIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auto-roots.car")
+	_, err := blockstore.OpenReadWrite(path, nil, blockstore.AutoDetectRoots(true), blockstore.UseSnapshotIndex(true))
+	require.ErrorContains(t, err, "snapshot index")
+}
+
+func TestReadWriteAutoDetectRootsUntruncatable(t *testing.T) {
+	ctx := context.Background()
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("only block"))
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid([]byte("only block"), c)
+	require.NoError(t, err)
+	placeholders := []cid.Cid{oneTestBlockWithCidV1.Cid(), blk.Cid()}
+
+	backing := &memReaderAtWriterAt{}
+	subject, err := blockstore.NewReadWrite(backing, placeholders, blockstore.AutoDetectRoots(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, blk))
+	written := append([]byte(nil), backing.buf...)
+
+	// A shorter header would leave the end of the payload behind, since the
+	// backing cannot be truncated; nothing is written and Finalize may be
+	// retried.
+	for i := 0; i < 2; i++ {
+		require.ErrorContains(t, subject.Finalize(), "cannot be truncated")
+		require.Equal(t, written, backing.buf)
+	}
+}
+
+// failingWriterAt fails the writes at offset failAt while failing is set.
+type failingWriterAt struct {
+	memReaderAtWriterAt
+	failAt  int64
+	failing bool
+}
+
+func (f *failingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if f.failing && off == f.failAt {
+		return 0, errors.New("write failed")
+	}
+	return f.memReaderAtWriterAt.WriteAt(p, off)
+}
+
+func TestReadWriteAutoDetectRootsPartialMove(t *testing.T) {
+	ctx := context.Background()
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("only block"))
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid([]byte("only block"), c)
+	require.NoError(t, err)
+
+	backing := &failingWriterAt{}
+	subject, err := blockstore.NewReadWrite(backing, nil, blockstore.AutoDetectRoots(true), blockstore.WriteAsCarV1(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, blk))
+
+	// Fail writing the header once the sections were moved to make room
+	// for it.
+	backing.failing = true
+	require.ErrorContains(t, subject.FinalizeReadOnly(), "write failed")
+	moved := append([]byte(nil), backing.buf...)
+
+	// The sections are not moved again, even once writes succeed.
+	backing.failing = false
+	require.ErrorContains(t, subject.FinalizeReadOnly(), "could not move sections")
+	require.ErrorContains(t, subject.Put(ctx, blk), "could not move sections")
+	require.Equal(t, moved, backing.buf)
+	subject.Discard()
+}
+
+func TestIdentityCIDPolicyIsConsistent(t *testing.T) {
+	identityCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	stored, err := blocks.NewBlockWithCid([]byte("stored"), identityCid("stored"))
+	require.NoError(t, err)
+	missing := identityCid("missing")
+
+	type identityStore interface {
+		Has(context.Context, cid.Cid) (bool, error)
+		Get(context.Context, cid.Cid) (blocks.Block, error)
+		GetSize(context.Context, cid.Cid) (int, error)
+		IdentityCIDPolicy() carv2.IdentityCIDPolicy
+	}
+	check := func(t *testing.T, bs identityStore, policy carv2.IdentityCIDPolicy) {
+		ctx := context.Background()
+		require.Equal(t, policy, bs.IdentityCIDPolicy())
+
+		has, err := bs.Has(ctx, stored.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+		got, err := bs.Get(ctx, stored.Cid())
+		require.NoError(t, err)
+		require.Equal(t, stored.RawData(), got.RawData())
+		size, err := bs.GetSize(ctx, stored.Cid())
+		require.NoError(t, err)
+		require.Equal(t, len(stored.RawData()), size)
+
+		has, err = bs.Has(ctx, missing)
+		require.NoError(t, err)
+		_, getErr := bs.Get(ctx, missing)
+		size, sizeErr := bs.GetSize(ctx, missing)
+		if policy == carv2.IdentityCIDsInlined {
+			require.True(t, has)
+			require.NoError(t, getErr)
+			require.NoError(t, sizeErr)
+			require.Equal(t, len("missing"), size)
+		} else {
+			require.False(t, has)
+			require.IsType(t, format.ErrNotFound{}, getErr)
+			require.IsType(t, format.ErrNotFound{}, sizeErr)
+		}
+	}
+
+	for _, storeIdentityCIDs := range []bool{false, true} {
+		policy := carv2.IdentityCIDsInlined
+		if storeIdentityCIDs {
+			policy = carv2.IdentityCIDsStored
+		}
+		t.Run(policy.String(), func(t *testing.T) {
+			for _, snapshot := range []bool{false, true} {
+				name := "ReadWrite"
+				if snapshot {
+					name = "ReadWriteSnapshotIndex"
+				}
+				t.Run(name, func(t *testing.T) {
+					path := filepath.Join(t.TempDir(), "identity.car")
+					rw, err := blockstore.OpenReadWrite(path, []cid.Cid{},
+						carv2.StoreIdentityCIDs(storeIdentityCIDs), blockstore.UseSnapshotIndex(snapshot))
+					require.NoError(t, err)
+					require.NoError(t, rw.Put(context.Background(), stored))
+					check(t, rw, policy)
+					require.NoError(t, rw.Finalize())
+
+					t.Run("ReadOnly", func(t *testing.T) {
+						ro, err := blockstore.OpenReadOnly(path, carv2.StoreIdentityCIDs(storeIdentityCIDs))
+						require.NoError(t, err)
+						t.Cleanup(func() { ro.Close() })
+						check(t, ro, policy)
+					})
+				})
+			}
+		})
+	}
+}
+
+func TestReadWriteFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.car")
+	roots := []cid.Cid{oneTestBlockWithCidV1.Cid()}
+	locker := blockstore.WithFileLocker(carv2.AdvisoryFileLocker)
+	subject, err := blockstore.OpenReadWrite(path, roots, locker)
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+
+	// Neither writers nor readers may open the file while it is being written.
+	_, err = blockstore.OpenReadWrite(path, roots, locker)
+	require.ErrorIs(t, err, carv2.ErrFileLocked)
+	_, err = blockstore.OpenReadOnly(path, locker)
+	require.ErrorIs(t, err, carv2.ErrFileLocked)
+	_, err = blockstore.OpenReadOnlyShared(path, locker)
+	require.ErrorIs(t, err, carv2.ErrFileLocked)
+
+	// Unless they do not lock, which is the default, in which case the
+	// unfinalized CARv2 is read as is.
+	_, err = blockstore.OpenReadOnly(path)
+	require.ErrorContains(t, err, "invalid data payload offset")
+
+	require.NoError(t, subject.Finalize())
+
+	// Readers share the lock, which keeps writers out until they are all closed.
+	robs, err := blockstore.OpenReadOnly(path, locker)
+	require.NoError(t, err)
+	shared, err := blockstore.OpenReadOnlyShared(path, locker)
+	require.NoError(t, err)
+	_, err = blockstore.OpenReadWrite(path, roots, locker)
+	require.ErrorIs(t, err, carv2.ErrFileLocked)
+	require.NoError(t, robs.Close())
+	_, err = blockstore.OpenReadWrite(path, roots, locker)
+	require.ErrorIs(t, err, carv2.ErrFileLocked)
+	require.NoError(t, shared.Close())
+
+	// Waiting for the lock blocks until it is released.
+	subject, err = blockstore.OpenReadWrite(path, roots, locker)
+	require.NoError(t, err)
+	opened := make(chan error, 1)
+	go func() {
+		robs, err := blockstore.OpenReadOnly(path, locker, blockstore.WaitForFileLock(true))
+		if err == nil {
+			err = robs.Close()
+		}
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		t.Fatalf("opened while locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, subject.Finalize())
+	select {
+	case err := <-opened:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the lock")
+	}
+}
+
+// recordingFileLocker records the locks taken and released through it.
+type recordingFileLocker struct {
+	mu     sync.Mutex
+	locked []string
+}
+
+func (l *recordingFileLocker) Lock(f *os.File, exclusive, wait bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locked = append(l.locked, fmt.Sprintf("lock %s exclusive=%t wait=%t", filepath.Base(f.Name()), exclusive, wait))
+	return nil
+}
+
+func (l *recordingFileLocker) Unlock(f *os.File) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locked = append(l.locked, fmt.Sprintf("unlock %s", filepath.Base(f.Name())))
+	return nil
+}
+
+func TestReadWriteCustomFileLocker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.car")
+	locker := &recordingFileLocker{}
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{oneTestBlockWithCidV1.Cid()},
+		blockstore.WithFileLocker(locker), blockstore.WaitForFileLock(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+	require.NoError(t, subject.Finalize())
+
+	robs, err := blockstore.OpenReadOnly(path, blockstore.WithFileLocker(locker))
+	require.NoError(t, err)
+	require.NoError(t, robs.Close())
+
+	require.Equal(t, []string{
+		"lock custom.car exclusive=true wait=true",
+		"unlock custom.car",
+		"lock custom.car exclusive=false wait=false",
+		"unlock custom.car",
+	}, locker.locked)
+}
+
+func TestReadWriteIndexCheckpoint(t *testing.T) {
+	ctx := context.TODO()
+	v1f, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, v1f.Close()) })
+	r, err := carv1.NewCarReader(v1f)
+	require.NoError(t, err)
+	var blks []blocks.Block
+	for i := 0; i < 11; i++ {
+		b, err := r.Next()
+		require.NoError(t, err)
+		blks = append(blks, b)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checkpointed.car")
+	checkpointPath := filepath.Join(dir, "checkpointed.car.idx")
+	opts := []carv2.Option{blockstore.UseWholeCIDs(true), blockstore.WithIndexCheckpoint(checkpointPath, 4, 0)}
+	readCheckpoint := func() []index.Record {
+		f, err := os.Open(checkpointPath)
+		require.NoError(t, err)
+		defer f.Close()
+		idx, err := index.ReadRecordLog(f)
+		require.NoError(t, err)
+		var records []index.Record
+		require.NoError(t, idx.ForEachCid(func(c cid.Cid, offset uint64) error {
+			records = append(records, index.Record{Cid: c, Offset: offset})
+			return nil
+		}))
+		return records
+	}
+	requireHasAll := func(subject *blockstore.ReadWrite, blks []blocks.Block) {
+		for _, b := range blks {
+			has, err := subject.Has(ctx, b.Cid())
+			require.NoError(t, err)
+			require.True(t, has, b.Cid())
+		}
+	}
+
+	subject, err := blockstore.OpenReadWrite(path, r.Header.Roots, opts...)
+	require.NoError(t, err)
+	for _, b := range blks[:10] {
+		require.NoError(t, subject.Put(ctx, b))
+	}
+	subject.Discard()
+	// Only the records of complete checkpoints are persisted.
+	require.Len(t, readCheckpoint(), 8)
+
+	// A checkpoint which does not match the CAR is ignored, and rewritten
+	// from a full scan.
+	bogus := index.NewInsertionIndex()
+	require.NoError(t, bogus.Add(index.Record{Cid: oneTestBlockWithCidV1.Cid(), Offset: readCheckpoint()[0].Offset}))
+	f, err := os.Create(checkpointPath)
+	require.NoError(t, err)
+	_, err = bogus.Flush(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	subject, err = blockstore.OpenReadWrite(path, r.Header.Roots, opts...)
+	require.NoError(t, err)
+	requireHasAll(subject, blks[:10])
+	has, err := subject.Has(ctx, oneTestBlockWithCidV1.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	subject.Discard()
+	records := readCheckpoint()
+	require.Len(t, records, 10)
+
+	// Sections covered by the checkpoint are not scanned again on resumption,
+	// which would otherwise fail on the corrupted length of the first one.
+	first := records[0].Offset
+	for _, r := range records {
+		first = min(first, r.Offset)
+	}
+	cf, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = cf.WriteAt([]byte{0}, int64(carv2.PragmaSize+carv2.HeaderSize+first))
+	require.NoError(t, err)
+	require.NoError(t, cf.Close())
+	_, err = blockstore.OpenReadWrite(path, r.Header.Roots, blockstore.UseWholeCIDs(true))
+	require.ErrorContains(t, err, "null padding")
+
+	// Records past the end of the CAR, and a partially written record at the
+	// end of the checkpoint, are dropped while the others are kept.
+	ahead := index.NewInsertionIndex()
+	require.NoError(t, ahead.Add(index.Record{Cid: blks[10].Cid(), Offset: 1 << 20}))
+	f, err = os.OpenFile(checkpointPath, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = ahead.Flush(f)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{5})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	subject, err = blockstore.OpenReadWrite(path, r.Header.Roots, opts...)
+	require.NoError(t, err)
+	requireHasAll(subject, blks[:10])
+	has, err = subject.Has(ctx, blks[10].Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	subject.Discard()
+	require.Equal(t, records, readCheckpoint())
+
+	subject, err = blockstore.OpenReadWrite(path, r.Header.Roots, opts...)
+	require.NoError(t, err)
+	requireHasAll(subject, blks[:10])
+
+	// The checkpoint is removed once the CAR is finalized.
+	require.NoError(t, subject.Put(ctx, blks[10]))
+	requireHasAll(subject, blks)
+	require.NoError(t, subject.Finalize())
+	_, err = os.Stat(checkpointPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
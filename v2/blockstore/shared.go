@@ -0,0 +1,153 @@
+package blockstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// sharedFiles holds the files opened via OpenReadOnlyShared, by absolute path,
+// for as long as any blockstore reads from them.
+var sharedFiles = struct {
+	sync.Mutex
+	byPath map[string]*sharedFile
+}{byPath: make(map[string]*sharedFile)}
+
+// sharedFile is a file read by any number of blockstores via ReadAt, i.e.
+// pread, which is safe for concurrent use.
+type sharedFile struct {
+	*os.File
+	path string
+	refs int
+	// closer closes the file, releasing its lock if any.
+	closer io.Closer
+	// opened is closed once the file is opened and locked, or err is set.
+	// Opening happens outside of sharedFiles, since locking may block.
+	opened chan struct{}
+	err    error
+}
+
+// sharedFileHandle is the backing of a single blockstore onto a sharedFile.
+// Only io.ReaderAt is exposed, such that the position of the shared file is
+// never relied upon.
+type sharedFileHandle struct {
+	f      *sharedFile
+	closed atomic.Bool
+}
+
+// OpenReadOnlyShared is like OpenReadOnly, except that all blockstores opened
+// with it over the same file share a single file handle, read via pread,
+// rather than each mapping the file into memory. This suits servers opening
+// the same CAR from many goroutines, since each blockstore opened then only
+// holds its index.
+//
+// The file is closed once all blockstores opened over it are closed. Note that
+// a file replaced at the same path while blockstores are open over it is only
+// opened anew once they are all closed.
+//
+// The file is locked shared, as with OpenReadOnly, by the options of the first
+// blockstore opened over it, and unlocked once it is closed.
+func OpenReadOnlyShared(path string, opts ...carv2.Option) (*ReadOnly, error) {
+	h, err := openSharedFile(path, carv2.ApplyOptions(opts...))
+	if err != nil {
+		return nil, err
+	}
+	robs, err := NewReadOnly(h, nil, opts...)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	robs.carv2Closer = h
+	return robs, nil
+}
+
+func openSharedFile(path string, o carv2.Options) (*sharedFileHandle, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedFiles.Lock()
+	f, ok := sharedFiles.byPath[abs]
+	if !ok {
+		f = &sharedFile{path: abs, opened: make(chan struct{})}
+		sharedFiles.byPath[abs] = f
+	}
+	f.refs++
+	sharedFiles.Unlock()
+
+	if !ok {
+		f.err = f.open(o)
+		if f.err != nil {
+			// Let later opens try afresh.
+			sharedFiles.Lock()
+			delete(sharedFiles.byPath, abs)
+			sharedFiles.Unlock()
+		}
+		close(f.opened)
+	}
+	<-f.opened
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sharedFileHandle{f: f}, nil
+}
+
+func (f *sharedFile) open(o carv2.Options) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	closer, err := lockFile(file, false, o)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not lock read-only file: %w", err)
+	}
+	f.File = file
+	f.closer = closer
+	return nil
+}
+
+func (h *sharedFileHandle) ReadAt(p []byte, off int64) (int, error) {
+	if h.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	return h.f.ReadAt(p, off)
+}
+
+// Size returns the current size of the shared file.
+func (h *sharedFileHandle) Size() (int64, error) {
+	if h.closed.Load() {
+		return 0, os.ErrClosed
+	}
+	stat, err := h.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// Close releases the handle, closing the shared file if no other handle onto
+// it remains.
+func (h *sharedFileHandle) Close() error {
+	if h.closed.Swap(true) {
+		return nil
+	}
+
+	sharedFiles.Lock()
+	h.f.refs--
+	last := h.f.refs == 0
+	if last {
+		delete(sharedFiles.byPath, h.f.path)
+	}
+	sharedFiles.Unlock()
+	if !last {
+		return nil
+	}
+	return h.f.closer.Close()
+}
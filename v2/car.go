@@ -1,9 +1,16 @@
 package car
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 )
 
 const (
@@ -155,8 +162,19 @@ func (h Header) WriteTo(w io.Writer) (n int64, err error) {
 	return n, err
 }
 
+// IsIndexOnly indicates whether this header describes a CARv2 holding only an
+// index, with no data payload. See WriteIndexOnly.
+func (h Header) IsIndexOnly() bool {
+	return h.DataSize == 0 && h.HasIndex()
+}
+
 // ReadFrom populates fields of this header from the given r.
+// Headers of index-only CARv2s, which have no data payload, are rejected.
 func (h *Header) ReadFrom(r io.Reader) (int64, error) {
+	return h.readFrom(r, false)
+}
+
+func (h *Header) readFrom(r io.Reader, allowIndexOnly bool) (int64, error) {
 	n, err := h.Characteristics.ReadFrom(r)
 	if err != nil {
 		return n, err
@@ -176,10 +194,11 @@ func (h *Header) ReadFrom(r io.Reader) (int64, error) {
 		return n, fmt.Errorf("invalid data payload offset: %v", dataOffset)
 	}
 	// Assert the data size validity.
-	// It must be larger than zero.
+	// It must be larger than zero, unless the CARv2 only holds an index.
 	// Technically, it should be at least 11 bytes (i.e. a valid CARv1 header with no roots) but
 	// we let further parsing of the header to signal invalid data payload header.
-	if int64(dataSize) <= 0 {
+	// An index-only CARv2 has no data payload, but must have an index.
+	if int64(dataSize) < 0 || (dataSize == 0 && (!allowIndexOnly || indexOffset == 0)) {
 		return n, fmt.Errorf("invalid data payload size: %v", dataSize)
 	}
 	// Assert the index offset validity.
@@ -191,3 +210,83 @@ func (h *Header) ReadFrom(r io.Reader) (int64, error) {
 	h.IndexOffset = indexOffset
 	return n, nil
 }
+
+// SortAndDedupeRoots returns the given roots with duplicates removed and sorted
+// in canonical order, i.e. by the bytes of their binary representation. The
+// returned bool reports whether the result differs from the given roots.
+// The given slice is not modified.
+//
+// See NormalizeRoots.
+func SortAndDedupeRoots(roots []cid.Cid) ([]cid.Cid, bool) {
+	normalized := make([]cid.Cid, len(roots))
+	copy(normalized, roots)
+	sort.SliceStable(normalized, func(i, j int) bool {
+		return bytes.Compare(normalized[i].Bytes(), normalized[j].Bytes()) < 0
+	})
+	deduped := normalized[:0]
+	for i, c := range normalized {
+		if i > 0 && c.Equals(normalized[i-1]) {
+			continue
+		}
+		deduped = append(deduped, c)
+	}
+	if len(deduped) != len(roots) {
+		return deduped, true
+	}
+	for i := range roots {
+		if !roots[i].Equals(deduped[i]) {
+			return deduped, true
+		}
+	}
+	return deduped, false
+}
+
+// NewPragma returns the pragma of a CAR of the given version, i.e. a
+// length-prefixed CARv1-style header holding only the version number and no
+// roots. NewPragma(2) is equal to Pragma.
+//
+// This is mostly useful for tooling that needs to produce pragmas of future or
+// otherwise unsupported versions, e.g. to test version negotiation.
+func NewPragma(version uint64) ([]byte, error) {
+	return EncodeV1Header(version, nil)
+}
+
+// EncodeV1Header returns the length-prefixed CARv1-style header with the
+// given version and roots, as it appears at the start of a CAR. If roots is
+// nil, the roots field is omitted altogether, as in a pragma; an empty,
+// non-nil roots results in an empty roots list.
+//
+// Note that no validation is performed on the given version; see NewPragma.
+func EncodeV1Header(version uint64, roots []cid.Cid) ([]byte, error) {
+	var buf bytes.Buffer
+	if roots != nil {
+		if err := carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: version}, &buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// Encode {"version": <version>} in DAG-CBOR by hand, since CarHeader
+	// always includes roots.
+	hb := []byte{
+		0xa1,                                     // map(1)
+		0x67,                                     // string(7)
+		0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, // "version"
+	}
+	switch {
+	case version < 24:
+		hb = append(hb, byte(version))
+	case version <= math.MaxUint8:
+		hb = append(hb, 0x18, byte(version))
+	case version <= math.MaxUint16:
+		hb = binary.BigEndian.AppendUint16(append(hb, 0x19), uint16(version))
+	case version <= math.MaxUint32:
+		hb = binary.BigEndian.AppendUint32(append(hb, 0x1a), uint32(version))
+	default:
+		hb = binary.BigEndian.AppendUint64(append(hb, 0x1b), version)
+	}
+	if err := util.LdWrite(&buf, hb); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
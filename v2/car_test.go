@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/require"
 
 	carv2 "github.com/ipld/go-car/v2"
@@ -245,3 +247,64 @@ func TestCharacteristics_StoreIdentityCIDs(t *testing.T) {
 	require.Equal(t, int64(16), read)
 	require.False(t, decodedSubjectAgain.IsFullyIndexed())
 }
+
+func TestSortAndDedupeRoots(t *testing.T) {
+	a := blocks.NewBlock([]byte("fish")).Cid()
+	b := blocks.NewBlock([]byte("lobster")).Cid()
+	if bytes.Compare(a.Bytes(), b.Bytes()) > 0 {
+		a, b = b, a
+	}
+	tests := []struct {
+		name        string
+		roots       []cid.Cid
+		want        []cid.Cid
+		wantChanged bool
+	}{
+		{"Empty", []cid.Cid{}, []cid.Cid{}, false},
+		{"AlreadyNormalized", []cid.Cid{a, b}, []cid.Cid{a, b}, false},
+		{"Unsorted", []cid.Cid{b, a}, []cid.Cid{a, b}, true},
+		{"Duplicates", []cid.Cid{a, b, a, b}, []cid.Cid{a, b}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			given := append([]cid.Cid{}, tt.roots...)
+			got, changed := carv2.SortAndDedupeRoots(tt.roots)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantChanged, changed)
+			require.Equal(t, given, tt.roots, "given roots must not be modified")
+		})
+	}
+}
+
+func TestNewPragma(t *testing.T) {
+	got, err := carv2.NewPragma(2)
+	require.NoError(t, err)
+	require.Equal(t, carv2.Pragma, got)
+
+	for _, version := range []uint64{0, 1, 3, 23, 24, 255, 256, 65536, 1 << 40} {
+		pragma, err := carv2.NewPragma(version)
+		require.NoError(t, err)
+		gotVersion, err := carv2.ReadVersion(bytes.NewReader(pragma))
+		require.NoError(t, err)
+		require.Equal(t, version, gotVersion)
+		header, err := carv1.ReadHeader(bytes.NewReader(pragma), carv1.DefaultMaxAllowedHeaderSize)
+		require.NoError(t, err)
+		require.Empty(t, header.Roots)
+	}
+}
+
+func TestEncodeV1Header(t *testing.T) {
+	roots := []cid.Cid{blocks.NewBlock([]byte("fish")).Cid()}
+	got, err := carv2.EncodeV1Header(1, roots)
+	require.NoError(t, err)
+	var want bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, &want))
+	require.Equal(t, want.Bytes(), got)
+
+	got, err = carv2.EncodeV1Header(42, []cid.Cid{})
+	require.NoError(t, err)
+	header, err := carv1.ReadHeader(bytes.NewReader(got), carv1.DefaultMaxAllowedHeaderSize)
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), header.Version)
+	require.Empty(t, header.Roots)
+}
@@ -3,6 +3,12 @@
 // The entire library is geared towards the CARv2 spec,
 // but many of the APIs consuming CAR files also accept CARv1.
 //
-// The blockstore sub-package contains an implementation of the
-// go-ipfs-blockstore interface.
+// The blockstore sub-package contains an implementation of the boxo
+// blockstore interface, which does not depend on boxo or go-ipfs-blockstore.
+//
+// The traversal sub-package exposes the link system wrappers used to write
+// selective CARs, for building custom CAR streamers.
+//
+// The ioutil sub-package exposes the io adapters used to read and write CAR
+// sections at offsets of an underlying file.
 package car
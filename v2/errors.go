@@ -1,13 +1,23 @@
 package car
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrIndexOnly is returned when reading the data payload, or the roots, of a
+// CARv2 that only holds an index. See AllowIndexOnly.
+var ErrIndexOnly = errors.New("car holds only an index and no data payload")
+
 var _ (error) = (*ErrCidTooLarge)(nil)
 
 // ErrCidTooLarge signals that a CID is too large to include in CARv2 index.
 // See: MaxIndexCidSize.
+//
+// It is returned when indexing, e.g. by GenerateIndex, LoadIndex, and the Put
+// methods of the blockstore and storage writers, as well as by their Has
+// methods, since such a CID cannot have been indexed. Since it may be wrapped,
+// test for it with errors.As, or with errors.Is against any *ErrCidTooLarge.
 type ErrCidTooLarge struct {
 	MaxSize     uint64
 	CurrentSize uint64
@@ -16,3 +26,9 @@ type ErrCidTooLarge struct {
 func (e *ErrCidTooLarge) Error() string {
 	return fmt.Sprintf("cid size is larger than max allowed (%d > %d)", e.CurrentSize, e.MaxSize)
 }
+
+// Is reports whether target is an *ErrCidTooLarge, regardless of its sizes.
+func (e *ErrCidTooLarge) Is(target error) bool {
+	_, ok := target.(*ErrCidTooLarge)
+	return ok
+}
@@ -1,6 +1,7 @@
 package car
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,3 +11,12 @@ func TestNewErrCidTooLarge_ErrorContainsSizes(t *testing.T) {
 	subject := &ErrCidTooLarge{MaxSize: 1413, CurrentSize: 1414}
 	require.EqualError(t, subject, "cid size is larger than max allowed (1414 > 1413)")
 }
+
+func TestErrCidTooLarge_Is(t *testing.T) {
+	err := fmt.Errorf("indexing: %w", &ErrCidTooLarge{MaxSize: 1413, CurrentSize: 1414})
+	require.ErrorIs(t, err, &ErrCidTooLarge{})
+	var tooLarge *ErrCidTooLarge
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, uint64(1414), tooLarge.CurrentSize)
+	require.NotErrorIs(t, err, ErrIndexOnly)
+}
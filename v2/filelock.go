@@ -0,0 +1,43 @@
+package car
+
+import (
+	"os"
+
+	"github.com/ipld/go-car/v2/internal/lock"
+)
+
+// ErrFileLocked is returned when opening a CAR file locked by another process
+// in a conflicting way, e.g. one which is being written to, unless waiting for
+// the lock is requested via WaitForFileLock.
+var ErrFileLocked = lock.ErrLocked
+
+// FileLocker coordinates the access to CAR files opened by path across
+// processes, such that a CAR being written is neither written to nor read by
+// others, which would corrupt it or read it partially.
+//
+// See WithFileLocker.
+type FileLocker interface {
+	// Lock locks f, exclusively for writing or shared for reading. Unless wait
+	// is set, ErrFileLocked is returned straight away if a conflicting lock is
+	// held.
+	Lock(f *os.File, exclusive, wait bool) error
+	// Unlock releases the lock on f acquired by Lock.
+	Unlock(f *os.File) error
+}
+
+// AdvisoryFileLocker is a FileLocker which uses advisory locks:
+// flock on unix, and LockFileEx on Windows over a range past the end of the
+// file, such that reads and writes are not blocked. Advisory locks only
+// coordinate processes which lock the file, and are not supported on other
+// platforms, where locking is a no-op. Locking is opt-in; see WithFileLocker.
+var AdvisoryFileLocker FileLocker = advisoryFileLocker{}
+
+type advisoryFileLocker struct{}
+
+func (advisoryFileLocker) Lock(f *os.File, exclusive, wait bool) error {
+	return lock.Lock(f, exclusive, wait)
+}
+
+func (advisoryFileLocker) Unlock(f *os.File) error {
+	return lock.Unlock(f)
+}
@@ -0,0 +1,95 @@
+package car
+
+import (
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	mhreg "github.com/multiformats/go-multihash/core"
+)
+
+// ErrHashingWriterClosed is returned when writing to a closed HashingWriter.
+var ErrHashingWriterClosed = errors.New("write to closed hashing writer")
+
+// HashingWriter is an io.WriteCloser which hashes the bytes written through
+// it, such that the CID of a CAR, i.e. of the CAR file as a whole rather than
+// of any of the blocks it holds, is known once it has been written, without
+// reading it back. This allows CAR files to be content-addressed themselves,
+// such as when storing them in a block or object store keyed by their own CID.
+//
+// It wraps the destination of any writer of this package, e.g. WrapV1 or
+// NewSelectiveWriter, as long as that writer only writes the CAR
+// sequentially. In particular, the blockstore and storage writers, which
+// write at arbitrary offsets and finalize the CARv2 header last, cannot be
+// hashed this way.
+type HashingWriter struct {
+	w      io.Writer
+	code   uint64
+	h      hash.Hash
+	size   uint64
+	closed bool
+	mh     multihash.Multihash
+}
+
+// NewHashingWriter returns a HashingWriter writing to w, which hashes the bytes
+// written with the multihash function of the given code, e.g.
+// multihash.SHA2_256.
+func NewHashingWriter(w io.Writer, mhCode uint64) (*HashingWriter, error) {
+	h, err := mhreg.GetHasher(mhCode)
+	if err != nil {
+		return nil, err
+	}
+	return &HashingWriter{w: w, code: mhCode, h: h}, nil
+}
+
+// Write writes p to the underlying writer, hashing the bytes that were written.
+func (hw *HashingWriter) Write(p []byte) (int, error) {
+	if hw.closed {
+		return 0, ErrHashingWriterClosed
+	}
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.size += uint64(n)
+	return n, err
+}
+
+// Close computes the multihash of the bytes written, and closes the underlying
+// writer if it is an io.Closer. Subsequent calls are no-ops.
+func (hw *HashingWriter) Close() error {
+	if hw.closed {
+		return nil
+	}
+	hw.closed = true
+	mh, err := multihash.Encode(hw.h.Sum(nil), hw.code)
+	if err != nil {
+		return err
+	}
+	hw.mh = mh
+	if c, ok := hw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Size returns the number of bytes written so far.
+func (hw *HashingWriter) Size() uint64 {
+	return hw.size
+}
+
+// Multihash returns the multihash of the bytes written, or nil if the writer
+// is not closed yet.
+func (hw *HashingWriter) Multihash() multihash.Multihash {
+	return hw.mh
+}
+
+// Cid returns the CIDv1 of the bytes written, with the car multicodec, or
+// cid.Undef if the writer is not closed yet.
+func (hw *HashingWriter) Cid() cid.Cid {
+	if hw.mh == nil {
+		return cid.Undef
+	}
+	return cid.NewCidV1(uint64(multicodec.Car), hw.mh)
+}
@@ -0,0 +1,40 @@
+package car_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashingWriter(t *testing.T) {
+	f, err := os.Open("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	var buf bytes.Buffer
+	subject, err := carv2.NewHashingWriter(&buf, multihash.SHA2_256)
+	require.NoError(t, err)
+	require.NoError(t, carv2.WrapV1(f, subject))
+	require.Equal(t, cid.Undef, subject.Cid())
+	require.NoError(t, subject.Close())
+
+	digest := sha256.Sum256(buf.Bytes())
+	want, err := multihash.Encode(digest[:], multihash.SHA2_256)
+	require.NoError(t, err)
+	require.Equal(t, multihash.Multihash(want), subject.Multihash())
+	require.Equal(t, cid.NewCidV1(uint64(multicodec.Car), want), subject.Cid())
+	require.Equal(t, uint64(buf.Len()), subject.Size())
+
+	_, err = subject.Write([]byte("more"))
+	require.ErrorIs(t, err, carv2.ErrHashingWriterClosed)
+
+	_, err = carv2.NewHashingWriter(&buf, 0xbeef)
+	require.Error(t, err)
+}
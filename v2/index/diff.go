@@ -0,0 +1,203 @@
+package index
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"slices"
+	"sort"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// Difference is a record found in only one of the two indexes compared by
+// Diff.
+type Difference struct {
+	Multihash multihash.Multihash
+	Offset    uint64
+	// Added is set if the record is only present in the second index, and
+	// unset if it is only present in the first, i.e. it was removed.
+	Added bool
+}
+
+// Diff compares the records of two indexes, calling f with every record
+// present in one but not the other. Records are compared by multihash and
+// offset; an index holding the same record twice differs from one holding it
+// once.
+//
+// Both indexes are iterated side by side in digest order, that is ordered by
+// multihash code, digest length and digest. A MultihashIndexSorted is already
+// held in that order, such that comparing two of them, as is the case for the
+// indexes attached to CARv2 files by default, takes no memory beyond that of
+// the records sharing a multihash. The records of other indexes are collected
+// and sorted in memory first.
+//
+// f is called in digest order, and with the records of a given multihash in
+// ascending order of offset. If f returns an error, Diff stops and returns it.
+func Diff(a, b IterableIndex, f func(Difference) error) error {
+	ca, err := newDiffCursor(a)
+	if err != nil {
+		return err
+	}
+	cb, err := newDiffCursor(b)
+	if err != nil {
+		return err
+	}
+
+	ea, okA := ca.next()
+	eb, okB := cb.next()
+	var offsetsA, offsetsB []uint64
+	for okA || okB {
+		key := ea
+		if !okA || (okB && compareDiffEntries(eb, ea) < 0) {
+			key = eb
+		}
+		offsetsA, offsetsB = offsetsA[:0], offsetsB[:0]
+		for ; okA && compareDiffEntries(ea, key) == 0; ea, okA = ca.next() {
+			offsetsA = append(offsetsA, ea.offset)
+		}
+		for ; okB && compareDiffEntries(eb, key) == 0; eb, okB = cb.next() {
+			offsetsB = append(offsetsB, eb.offset)
+		}
+		if err := diffOffsets(key, offsetsA, offsetsB, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffOffsets calls f with the offsets of the records of key found in only one
+// of offsetsA and offsetsB, in ascending order.
+func diffOffsets(key diffEntry, offsetsA, offsetsB []uint64, f func(Difference) error) error {
+	slices.Sort(offsetsA)
+	slices.Sort(offsetsB)
+	var mh multihash.Multihash
+	report := func(offset uint64, added bool) error {
+		if mh == nil {
+			var err error
+			if mh, err = multihash.Encode(key.digest, key.code); err != nil {
+				return err
+			}
+		}
+		return f(Difference{Multihash: mh, Offset: offset, Added: added})
+	}
+	var i, j int
+	for i < len(offsetsA) || j < len(offsetsB) {
+		switch {
+		case j == len(offsetsB) || (i < len(offsetsA) && offsetsA[i] < offsetsB[j]):
+			if err := report(offsetsA[i], false); err != nil {
+				return err
+			}
+			i++
+		case i == len(offsetsA) || offsetsB[j] < offsetsA[i]:
+			if err := report(offsetsB[j], true); err != nil {
+				return err
+			}
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return nil
+}
+
+// diffEntry is a record of an index, with its multihash decoded.
+type diffEntry struct {
+	code   uint64
+	digest []byte
+	offset uint64
+}
+
+// compareDiffEntries orders entries by multihash code, digest length and
+// digest, ignoring their offsets.
+func compareDiffEntries(a, b diffEntry) int {
+	if c := cmp.Compare(a.code, b.code); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(len(a.digest), len(b.digest)); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.digest, b.digest)
+}
+
+// diffCursor iterates over the records of an index in digest order.
+type diffCursor interface {
+	next() (diffEntry, bool)
+}
+
+func newDiffCursor(idx IterableIndex) (diffCursor, error) {
+	if m, ok := idx.(*MultihashIndexSorted); ok {
+		c := &sortedDiffCursor{}
+		for _, code := range m.sortedMultihashCodes() {
+			mwci := (*m)[code]
+			widths := make([]uint32, 0, len(mwci.multiWidthIndex))
+			for width := range mwci.multiWidthIndex {
+				widths = append(widths, width)
+			}
+			sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+			for _, width := range widths {
+				c.buckets = append(c.buckets, sortedDiffBucket{code: code, swi: mwci.multiWidthIndex[width]})
+			}
+		}
+		return c, nil
+	}
+
+	var entries []diffEntry
+	if err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		dmh, err := multihash.Decode(mh)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, diffEntry{code: dmh.Code, digest: dmh.Digest, offset: offset})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, compareDiffEntries)
+	return &sliceDiffCursor{entries: entries}, nil
+}
+
+// sortedDiffCursor iterates over the records of a MultihashIndexSorted in
+// place, bucket by bucket.
+type sortedDiffCursor struct {
+	buckets []sortedDiffBucket
+	pos     int
+}
+
+type sortedDiffBucket struct {
+	code uint64
+	swi  singleWidthIndex
+}
+
+func (c *sortedDiffCursor) next() (diffEntry, bool) {
+	for len(c.buckets) > 0 {
+		b := c.buckets[0]
+		if end := (c.pos + 1) * int(b.swi.width); end <= len(b.swi.index) {
+			digestEnd := end - 8
+			c.pos++
+			return diffEntry{
+				code:   b.code,
+				digest: b.swi.index[end-int(b.swi.width) : digestEnd],
+				offset: binary.LittleEndian.Uint64(b.swi.index[digestEnd:end]),
+			}, true
+		}
+		c.buckets = c.buckets[1:]
+		c.pos = 0
+	}
+	return diffEntry{}, false
+}
+
+// sliceDiffCursor iterates over records collected in memory and sorted.
+type sliceDiffCursor struct {
+	entries []diffEntry
+}
+
+func (c *sliceDiffCursor) next() (diffEntry, bool) {
+	if len(c.entries) == 0 {
+		return diffEntry{}, false
+	}
+	e := c.entries[0]
+	c.entries = c.entries[1:]
+	return e, true
+}
@@ -0,0 +1,84 @@
+package index_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	records = append(records, generateIndexRecords(t, multihash.IDENTITY, rng)...)
+	require.Greater(t, len(records), 3)
+
+	load := func(codec multicodec.Code, records []index.Record) index.IterableIndex {
+		var idx index.Index
+		if codec == 0 {
+			idx = index.NewInsertionIndex()
+		} else {
+			var err error
+			idx, err = index.New(codec)
+			require.NoError(t, err)
+		}
+		require.NoError(t, idx.Load(records))
+		return idx.(index.IterableIndex)
+	}
+	diff := func(a, b index.IterableIndex) []index.Difference {
+		var diffs []index.Difference
+		require.NoError(t, index.Diff(a, b, func(d index.Difference) error {
+			diffs = append(diffs, d)
+			return nil
+		}))
+		return diffs
+	}
+
+	// Remove the first record, move the second, and add a second offset to
+	// the third.
+	changed := append([]index.Record{}, records[1:]...)
+	changed[0].Offset++
+	changed = append(changed, index.Record{Cid: records[2].Cid, Offset: records[2].Offset + 7})
+	want := []index.Difference{
+		{Multihash: records[0].Cid.Hash(), Offset: records[0].Offset},
+		{Multihash: records[1].Cid.Hash(), Offset: records[1].Offset},
+		{Multihash: records[1].Cid.Hash(), Offset: records[1].Offset + 1, Added: true},
+		{Multihash: records[2].Cid.Hash(), Offset: records[2].Offset + 7, Added: true},
+	}
+
+	for _, codecs := range [][2]multicodec.Code{
+		{multicodec.CarMultihashIndexSorted, multicodec.CarMultihashIndexSorted},
+		{multicodec.CarMultihashIndexSorted, 0},
+		{0, 0},
+	} {
+		t.Run(codecs[0].String()+"-"+codecs[1].String(), func(t *testing.T) {
+			require.Empty(t, diff(load(codecs[0], records), load(codecs[1], records)))
+
+			got := diff(load(codecs[0], records), load(codecs[1], changed))
+			require.ElementsMatch(t, want, got)
+
+			// Swapping the indexes swaps additions and removals.
+			swapped := diff(load(codecs[1], changed), load(codecs[0], records))
+			require.Len(t, swapped, len(want))
+			for i := range swapped {
+				swapped[i].Added = !swapped[i].Added
+			}
+			require.ElementsMatch(t, want, swapped)
+		})
+	}
+
+	// Records are reported in digest order.
+	got := diff(load(multicodec.CarMultihashIndexSorted, nil), load(multicodec.CarMultihashIndexSorted, records))
+	require.Len(t, got, len(records))
+	for i := 1; i < len(got); i++ {
+		prev, err := multihash.Decode(got[i-1].Multihash)
+		require.NoError(t, err)
+		cur, err := multihash.Decode(got[i].Multihash)
+		require.NoError(t, err)
+		require.LessOrEqual(t, prev.Code, cur.Code)
+	}
+}
@@ -2,5 +2,8 @@
 // CID to offset. This can then be used to implement random access over a CARv1.
 //
 // Index can be written or read using the following static functions: index.WriteTo and
-// index.ReadFrom.
+// index.ReadFrom. Detached index files can be written with a checksum, guarding against
+// truncation and corruption, using index.WriteToFile and read back using index.ReadFromFile.
+//
+// Third-party index implementations can be plugged in using index.Register.
 package index
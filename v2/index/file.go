@@ -0,0 +1,91 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrChecksumMismatch signals that an index file does not match its checksum,
+// typically because it was truncated or corrupted.
+var ErrChecksumMismatch = errors.New("index checksum mismatch")
+
+// checksumPrefix starts an index file written with a checksum. Its leading
+// zero byte can never start a plain index, since it would decode as the
+// identity codec, which allows ReadFromFile to tell both kinds of file apart.
+var checksumPrefix = []byte("\x00carindex-checksum\x01")
+
+// WriteToFile writes the given idx to a file at path, creating or truncating
+// it as necessary.
+//
+// Unless checksum is multicodec.Identity, the index is preceded by a prefix
+// marking it as checksummed and by a multihash of the encoded index, computed
+// with the given hash function, e.g. multicodec.Sha2_256. Such files can only
+// be read back by ReadFromFile, which verifies the checksum. Otherwise the
+// file holds the same bytes as written by WriteTo.
+func WriteToFile(idx Index, path string, checksum multicodec.Code) error {
+	var buf bytes.Buffer
+	if _, err := WriteTo(idx, &buf); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if checksum != multicodec.Identity {
+		mh, err := multihash.Sum(buf.Bytes(), uint64(checksum), -1)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(checksumPrefix); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(mh); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFromFile reads an index from the file at path, as written by either
+// WriteToFile or WriteTo. The codec of the index is sniffed from the file, and
+// if the file was written with a checksum, the checksum is verified before the
+// index is decoded, returning ErrChecksumMismatch if it does not match.
+func ReadFromFile(path string) (Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, checksumPrefix) {
+		data = data[len(checksumPrefix):]
+		n, mh, err := multihash.MHFromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+		}
+		data = data[n:]
+		dmh, err := multihash.Decode(mh)
+		if err != nil {
+			return nil, err
+		}
+		got, err := multihash.Sum(data, dmh.Code, dmh.Length)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(got, mh) {
+			return nil, ErrChecksumMismatch
+		}
+	}
+	return ReadFrom(bytes.NewReader(data))
+}
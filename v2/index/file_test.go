@@ -0,0 +1,50 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteToFileReadFromFile(t *testing.T) {
+	wantIdx, err := ReadFromFile("../testdata/sample-multihash-index-sorted.carindex")
+	require.NoError(t, err)
+	plain, err := os.ReadFile("../testdata/sample-multihash-index-sorted.carindex")
+	require.NoError(t, err)
+
+	for _, checksum := range []multicodec.Code{multicodec.Identity, multicodec.Sha2_256, multicodec.Blake3} {
+		t.Run(checksum.String(), func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "index.carindex")
+			require.NoError(t, WriteToFile(wantIdx, dest, checksum))
+
+			gotIdx, err := ReadFromFile(dest)
+			require.NoError(t, err)
+			require.Equal(t, wantIdx, gotIdx)
+
+			written, err := os.ReadFile(dest)
+			require.NoError(t, err)
+			if checksum == multicodec.Identity {
+				require.Equal(t, plain, written)
+				return
+			}
+			require.Greater(t, len(written), len(plain))
+
+			// Truncated and corrupted files are caught by the checksum.
+			require.NoError(t, os.WriteFile(dest, written[:len(written)-1], 0o644))
+			_, err = ReadFromFile(dest)
+			require.ErrorIs(t, err, ErrChecksumMismatch)
+
+			written[len(written)-1] ^= 0xff
+			require.NoError(t, os.WriteFile(dest, written, 0o644))
+			_, err = ReadFromFile(dest)
+			require.ErrorIs(t, err, ErrChecksumMismatch)
+
+			require.NoError(t, os.WriteFile(dest, written[:len(checksumPrefix)+3], 0o644))
+			_, err = ReadFromFile(dest)
+			require.ErrorIs(t, err, ErrChecksumMismatch)
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package index
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
+	"sync"
 
 	"github.com/ipfs/go-cid"
 	internalio "github.com/ipld/go-car/v2/internal/io"
@@ -23,6 +26,13 @@ type (
 		Offset uint64
 	}
 
+	// SizedRecord is a Record along with the length of the block data of its
+	// section, as loaded into a SizedIndex.
+	SizedRecord struct {
+		Record
+		Size uint64
+	}
+
 	// Index provides an interface for looking up byte offset of a given CID.
 	//
 	// Note that each indexing mechanism is free to match CIDs however it
@@ -89,6 +99,39 @@ type (
 		// The order of calls to the given function is deterministic, but entirely index-specific.
 		ForEach(func(multihash.Multihash, uint64) error) error
 	}
+
+	// Writable is an index to which records are added one at a time, and
+	// whose records are persisted incrementally, such that a long-running
+	// writer need not build its whole index in one go via Load, nor lose it if
+	// it stops before the CAR is finalized.
+	Writable interface {
+		// Add inserts a record into the index.
+		Add(Record) error
+
+		// Flush appends the records added since the last flush to w, in the
+		// append-only format read by ReadRecordLog, returning the number of
+		// bytes written. Records are only considered flushed once they were
+		// written successfully.
+		Flush(w io.Writer) (uint64, error)
+	}
+
+	// SizedIndex is an index which, in addition to the offset of each indexed
+	// section, records the length of the block data it holds. Consumers such as
+	// the blockstore detect this capability to answer size queries without
+	// reading the CAR payload.
+	SizedIndex interface {
+		Index
+
+		// GetAllSized is like GetAll, except that the given function is also
+		// called with the length in bytes of the block data of each matching
+		// section. Implementations must only match CIDs with an equal multihash.
+		GetAllSized(cid.Cid, func(offset, size uint64) bool) error
+
+		// LoadSized is like Load, also recording the length of the block data
+		// of each record. Since plain records do not hold it, Load may fail
+		// for indices which require it; see LoadSizedRecords.
+		LoadSized([]SizedRecord) error
+	}
 )
 
 // GetFirst is a wrapper over Index.GetAll, returning the offset for the first
@@ -102,16 +145,109 @@ func GetFirst(idx Index, key cid.Cid) (uint64, error) {
 	return firstOffset, err
 }
 
+// LoadSizedRecords loads the given records into idx, via LoadSized if it is a
+// SizedIndex, or via Load of the records without their sizes otherwise.
+func LoadSizedRecords(idx Index, records []SizedRecord) error {
+	if sized, ok := idx.(SizedIndex); ok {
+		return sized.LoadSized(records)
+	}
+	plain := make([]Record, len(records))
+	for i, r := range records {
+		plain[i] = r.Record
+	}
+	return idx.Load(plain)
+}
+
+// ForEachByOffset is like IterableIndex.ForEach, except that the given function
+// is called in ascending order of offset, i.e. in the order in which the
+// indexed sections appear in the CAR payload. Entries with equal offsets are
+// ordered by multihash. This allows consumers to stream the payload in
+// physical order with known multihashes.
+//
+// Since indices are not kept in offset order, the offset-sorted view is
+// derived by collecting all entries in memory before the first call.
+func ForEachByOffset(idx IterableIndex, f func(multihash.Multihash, uint64) error) error {
+	var records []Record
+	if err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		records = append(records, Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset})
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Offset != records[j].Offset {
+			return records[i].Offset < records[j].Offset
+		}
+		return bytes.Compare(records[i].Hash(), records[j].Hash()) < 0
+	})
+	for _, r := range records {
+		if err := f(r.Hash(), r.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[multicodec.Code]func() Index{
+		multicodec.CarIndexSorted:          newSorted,
+		multicodec.CarMultihashIndexSorted: func() Index { return NewMultihashSorted() },
+		CarMultihashIndexSortedWithSize:    func() Index { return NewMultihashSortedWithSize() },
+	}
+	// replaced holds the built-in codecs whose registration was replaced.
+	replaced = map[multicodec.Code]bool{}
+)
+
+// Register makes an index implementation available under the given codec, so
+// that it is constructed by New and, as a result, can be read via ReadFrom and
+// used wherever an index codec is accepted, e.g. via the UseIndexCodec option.
+// The given function must return a new, empty index whose Codec method returns
+// the given codec.
+//
+// Registering a codec that is already registered replaces the previous
+// registration, including that of the built-in implementations, which are then
+// no longer used to flatten an InsertionIndex either. Register is typically
+// called from an init function of the package providing the index.
+func Register(codec multicodec.Code, newIndex func() Index) {
+	if newIndex == nil {
+		panic("index: Register called with nil newIndex")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if isBuiltinCodec(codec) {
+		replaced[codec] = true
+	}
+	registry[codec] = newIndex
+}
+
+// Builtin reports whether the index of the given codec is implemented by this
+// package, i.e. whether the codec is one of CarIndexSorted,
+// CarMultihashIndexSorted and CarMultihashIndexSortedWithSize, and its
+// registration was not replaced via Register.
+func Builtin(codec multicodec.Code) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return isBuiltinCodec(codec) && !replaced[codec]
+}
+
+func isBuiltinCodec(codec multicodec.Code) bool {
+	switch codec {
+	case multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, CarMultihashIndexSortedWithSize:
+		return true
+	}
+	return false
+}
+
 // New constructs a new index corresponding to the given CAR index codec.
 func New(codec multicodec.Code) (Index, error) {
-	switch codec {
-	case multicodec.CarIndexSorted:
-		return newSorted(), nil
-	case multicodec.CarMultihashIndexSorted:
-		return NewMultihashSorted(), nil
-	default:
+	registryMu.RLock()
+	newIndex, ok := registry[codec]
+	registryMu.RUnlock()
+	if !ok {
 		return nil, fmt.Errorf("unknwon index codec: %v", codec)
 	}
+	return newIndex(), nil
 }
 
 // WriteTo writes the given idx into w.
@@ -2,6 +2,7 @@ package index
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
 )
@@ -46,6 +48,79 @@ func TestNew(t *testing.T) {
 	}
 }
 
+// customIndex is a third-party index implementation used to test Register.
+type customIndex struct {
+	*MultihashIndexSorted
+}
+
+const customIndexCodec = multicodec.Code(0x300099)
+
+func (customIndex) Codec() multicodec.Code {
+	return customIndexCodec
+}
+
+func TestRegister(t *testing.T) {
+	_, err := New(customIndexCodec)
+	require.Error(t, err)
+
+	Register(customIndexCodec, func() Index { return customIndex{NewMultihashSorted()} })
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, customIndexCodec)
+		registryMu.Unlock()
+	})
+
+	subject, err := New(customIndexCodec)
+	require.NoError(t, err)
+	require.Equal(t, customIndexCodec, subject.Codec())
+
+	blk := blocks.NewBlock([]byte("fish"))
+	require.NoError(t, subject.Load([]Record{{Cid: blk.Cid(), Offset: 42}}))
+
+	buf := bytes.Buffer{}
+	_, err = WriteTo(subject, &buf)
+	require.NoError(t, err)
+	got, err := ReadFrom(&buf)
+	require.NoError(t, err)
+	require.IsType(t, customIndex{}, got)
+	offset, err := GetFirst(got, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), offset)
+
+	require.Panics(t, func() { Register(customIndexCodec, nil) })
+}
+
+// overridingIndex replaces the built-in implementation of
+// CarMultihashIndexSorted in TestRegisterOverrideFlatten.
+type overridingIndex struct {
+	*MultihashIndexSorted
+}
+
+func TestRegisterOverrideFlatten(t *testing.T) {
+	ii := NewInsertionIndex()
+	blk := blocks.NewBlock([]byte("fish"))
+	ii.InsertNoReplace(blk.Cid(), 42)
+	require.True(t, Builtin(multicodec.CarMultihashIndexSorted))
+
+	Register(multicodec.CarMultihashIndexSorted, func() Index { return overridingIndex{NewMultihashSorted()} })
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry[multicodec.CarMultihashIndexSorted] = func() Index { return NewMultihashSorted() }
+		delete(replaced, multicodec.CarMultihashIndexSorted)
+		registryMu.Unlock()
+	})
+	require.False(t, Builtin(multicodec.CarMultihashIndexSorted))
+	require.True(t, Builtin(multicodec.CarIndexSorted))
+
+	// Flattening to an overridden codec uses the registered implementation.
+	subject, err := ii.Flatten(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	require.IsType(t, overridingIndex{}, subject)
+	offset, err := GetFirst(subject, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), offset)
+}
+
 func TestReadFrom(t *testing.T) {
 	idxf, err := os.Open("../testdata/sample-index.carindex")
 	require.NoError(t, err)
@@ -166,3 +241,50 @@ func TestMarshalledIndexStartsWithCodec(t *testing.T) {
 		})
 	}
 }
+
+func TestForEachByOffset(t *testing.T) {
+	idxf, err := os.Open("../testdata/sample-multihash-index-sorted.carindex")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, idxf.Close()) })
+	subject, err := ReadFrom(idxf)
+	require.NoError(t, err)
+	iterable, ok := subject.(IterableIndex)
+	require.True(t, ok)
+
+	crf, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, crf.Close()) })
+	cr, err := carv1.NewCarReader(crf)
+	require.NoError(t, err)
+	var wantMhs []multihash.Multihash
+	for {
+		block, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if block.Cid().Prefix().MhType != multihash.IDENTITY {
+			wantMhs = append(wantMhs, block.Cid().Hash())
+		}
+	}
+
+	var gotMhs []multihash.Multihash
+	var lastOffset uint64
+	err = ForEachByOffset(iterable, func(mh multihash.Multihash, offset uint64) error {
+		require.Greater(t, offset, lastOffset)
+		lastOffset = offset
+		gotMhs = append(gotMhs, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, wantMhs, gotMhs)
+
+	errStop := errors.New("stop")
+	var calls int
+	err = ForEachByOffset(iterable, func(multihash.Multihash, uint64) error {
+		calls++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, calls)
+}
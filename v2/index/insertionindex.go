@@ -3,13 +3,14 @@ package index
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 
 	"github.com/ipfs/go-cid"
+	internalio "github.com/ipld/go-car/v2/internal/io"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"github.com/petar/GoLLRB/llrb"
 	cbor "github.com/whyrusleeping/cbor/go"
 )
@@ -18,13 +19,16 @@ import (
 // and is not intended to be an index type that is attached to a CARv2.
 // See flatten() for conversion of this data to a known, existing index type.
 
-var (
-	errUnsupported      = errors.New("not supported")
-	insertionIndexCodec = multicodec.Code(0x300003)
-)
+var insertionIndexCodec = multicodec.Code(0x300003)
+
+var _ Writable = (*InsertionIndex)(nil)
 
 type InsertionIndex struct {
 	items llrb.LLRB
+	// pending holds the records added since the last Flush.
+	pending []Record
+	// flushErr fails all flushes once one wrote part of a record.
+	flushErr error
 }
 
 func NewInsertionIndex() *InsertionIndex {
@@ -34,14 +38,30 @@ func NewInsertionIndex() *InsertionIndex {
 type recordDigest struct {
 	digest []byte
 	Record
+	// size is the length of the block data of the section, if inserted with
+	// one.
+	size uint64
 }
 
+// Less orders records by digest first, such that all the records with a given
+// digest are found by ascending from a recordDigest holding only that digest,
+// which sorts before them. Records with equal digests are then ordered by
+// multihash and offset, such that each record can be deleted individually.
 func (r recordDigest) Less(than llrb.Item) bool {
 	other, ok := than.(recordDigest)
 	if !ok {
 		return false
 	}
-	return bytes.Compare(r.digest, other.digest) < 0
+	if c := bytes.Compare(r.digest, other.digest); c != 0 {
+		return c < 0
+	}
+	if !r.Cid.Defined() || !other.Cid.Defined() {
+		return !r.Cid.Defined() && other.Cid.Defined()
+	}
+	if c := bytes.Compare(r.Cid.Hash(), other.Cid.Hash()); c != 0 {
+		return c < 0
+	}
+	return r.Offset < other.Offset
 }
 
 func newRecordDigest(r Record) recordDigest {
@@ -50,7 +70,7 @@ func newRecordDigest(r Record) recordDigest {
 		panic(err)
 	}
 
-	return recordDigest{d.Digest, r}
+	return recordDigest{digest: d.Digest, Record: r}
 }
 
 func newRecordFromCid(c cid.Cid, at uint64) recordDigest {
@@ -59,11 +79,29 @@ func newRecordFromCid(c cid.Cid, at uint64) recordDigest {
 		panic(err)
 	}
 
-	return recordDigest{d.Digest, Record{Cid: c, Offset: at}}
+	return recordDigest{digest: d.Digest, Record: Record{Cid: c, Offset: at}}
 }
 
+// InsertNoReplace inserts a record of the given CID at the given offset,
+// keeping any other record of the same multihash. Inserting a record of the
+// same multihash at the same offset as an existing one replaces it.
 func (ii *InsertionIndex) InsertNoReplace(key cid.Cid, n uint64) {
-	ii.items.InsertNoReplace(newRecordFromCid(key, n))
+	ii.insert(newRecordFromCid(key, n))
+}
+
+// InsertSizedNoReplace is like InsertNoReplace, also recording the length of
+// the block data of the section, such that the index may be flattened to a
+// codec recording it, i.e. an index implementing SizedIndex.
+func (ii *InsertionIndex) InsertSizedNoReplace(key cid.Cid, n, size uint64) {
+	r := newRecordFromCid(key, n)
+	r.size = size
+	ii.insert(r)
+}
+
+// insert inserts r in the tree, replacing any identical record, since the tree
+// cannot reliably delete items among several equal ones.
+func (ii *InsertionIndex) insert(r recordDigest) {
+	ii.items.ReplaceOrInsert(r)
 }
 
 func (ii *InsertionIndex) Get(c cid.Cid) (uint64, error) {
@@ -80,15 +118,16 @@ func (ii *InsertionIndex) getRecord(c cid.Cid) (Record, error) {
 		return Record{}, err
 	}
 	entry := recordDigest{digest: d.Digest}
-	e := ii.items.Get(entry)
-	if e == nil {
+	var r *recordDigest
+	ii.items.AscendGreaterOrEqual(entry, func(i llrb.Item) bool {
+		if existing := i.(recordDigest); bytes.Equal(existing.digest, entry.digest) {
+			r = &existing
+		}
+		return false
+	})
+	if r == nil {
 		return Record{}, ErrNotFound
 	}
-	r, ok := e.(recordDigest)
-	if !ok {
-		return Record{}, errUnsupported
-	}
-
 	return r.Record, nil
 }
 
@@ -116,6 +155,61 @@ func (ii *InsertionIndex) GetAll(c cid.Cid, fn func(uint64) bool) error {
 	return nil
 }
 
+// Remove removes all the records of the given multihash, returning
+// ErrNotFound if there are none. Records of other multihashes with the same
+// digest, i.e. of another hash function, are kept.
+//
+// Subsequent calls to Marshal or Flatten serialize the index without the
+// removed records.
+func (ii *InsertionIndex) Remove(mh multihash.Multihash) error {
+	return ii.remove(mh, func(Record) bool { return true })
+}
+
+// RemoveOffset removes the records of the given multihash at the given
+// offset, returning ErrNotFound if there are none, such as to drop a single
+// copy of a block which appears multiple times in a CAR.
+func (ii *InsertionIndex) RemoveOffset(mh multihash.Multihash, offset uint64) error {
+	return ii.remove(mh, func(r Record) bool { return r.Offset == offset })
+}
+
+// remove removes the records of the given multihash matched by match.
+func (ii *InsertionIndex) remove(mh multihash.Multihash, match func(Record) bool) error {
+	d, err := multihash.Decode(mh)
+	if err != nil {
+		return err
+	}
+	entry := recordDigest{digest: d.Digest}
+
+	var matched []recordDigest
+	ii.items.AscendGreaterOrEqual(entry, func(i llrb.Item) bool {
+		existing := i.(recordDigest)
+		if !bytes.Equal(existing.digest, entry.digest) {
+			// We've already looked at all entries with matching digests.
+			return false
+		}
+		if bytes.Equal(existing.Record.Cid.Hash(), mh) && match(existing.Record) {
+			matched = append(matched, existing)
+		}
+		return true
+	})
+	if len(matched) == 0 {
+		return ErrNotFound
+	}
+	// Delete only once done iterating, as the tree must not be modified
+	// while iterating over it.
+	for _, r := range matched {
+		ii.items.Delete(r)
+	}
+	return nil
+}
+
+// marshalledRecord is the encoding of a Record by Marshal, since a cid.Cid
+// cannot be encoded as is.
+type marshalledRecord struct {
+	Cid    []byte
+	Offset uint64
+}
+
 func (ii *InsertionIndex) Marshal(w io.Writer) (uint64, error) {
 	l := uint64(0)
 	if err := binary.Write(w, binary.LittleEndian, int64(ii.items.Len())); err != nil {
@@ -124,11 +218,17 @@ func (ii *InsertionIndex) Marshal(w io.Writer) (uint64, error) {
 	l += 8
 
 	var err error
+	var buf bytes.Buffer
 	iter := func(i llrb.Item) bool {
-		if err = cbor.Encode(w, i.(recordDigest).Record); err != nil {
+		r := i.(recordDigest).Record
+		buf.Reset()
+		if err = cbor.Encode(&buf, marshalledRecord{Cid: r.Cid.Bytes(), Offset: r.Offset}); err != nil {
 			return false
 		}
-		return true
+		var n int64
+		n, err = buf.WriteTo(w)
+		l += uint64(n)
+		return err == nil
 	}
 	ii.items.AscendGreaterOrEqual(ii.items.Min(), iter)
 	return l, err
@@ -141,11 +241,15 @@ func (ii *InsertionIndex) Unmarshal(r io.Reader) error {
 	}
 	d := cbor.NewDecoder(r)
 	for i := int64(0); i < length; i++ {
-		var rec Record
+		var rec marshalledRecord
 		if err := d.Decode(&rec); err != nil {
 			return err
 		}
-		ii.items.InsertNoReplace(newRecordDigest(rec))
+		c, err := cid.Cast(rec.Cid)
+		if err != nil {
+			return err
+		}
+		ii.insert(newRecordFromCid(c, rec.Offset))
 	}
 	return nil
 }
@@ -161,15 +265,118 @@ func (ii *InsertionIndex) ForEach(f func(multihash.Multihash, uint64) error) err
 }
 
 func (ii *InsertionIndex) ForEachCid(f func(cid.Cid, uint64) error) error {
+	return ii.ForEachSizedRecord(func(r SizedRecord) error {
+		return f(r.Cid, r.Offset)
+	})
+}
+
+// ForEachSizedRecord calls f for every record of the index, in the same order
+// as ForEachCid, along with the size of its block data if it was inserted with
+// one, or zero otherwise.
+func (ii *InsertionIndex) ForEachSizedRecord(f func(SizedRecord) error) error {
 	var err error
 	ii.items.AscendGreaterOrEqual(ii.items.Min(), func(i llrb.Item) bool {
-		r := i.(recordDigest).Record
-		err = f(r.Cid, r.Offset)
+		r := i.(recordDigest)
+		err = f(SizedRecord{Record: r.Record, Size: r.size})
 		return err == nil
 	})
 	return err
 }
 
+// Add inserts a record into the index, and queues it to be written by the next
+// Flush.
+func (ii *InsertionIndex) Add(r Record) error {
+	rec := newRecordDigest(r)
+	if rec.digest == nil {
+		return fmt.Errorf("invalid entry: %v", r)
+	}
+	ii.insert(rec)
+	ii.pending = append(ii.pending, r)
+	return nil
+}
+
+// Flush appends the records added via Add since the last flush to w. Each
+// record is written as the uvarint length of its CID, the CID and the uvarint
+// offset, such that a file to which successive flushes are appended can be
+// read back with ReadRecordLog.
+//
+// Records which fail to be written are kept for the next flush, unless part of
+// a record was written, in which case appending more records would corrupt the
+// log; all later flushes then fail.
+func (ii *InsertionIndex) Flush(w io.Writer) (uint64, error) {
+	if ii.flushErr != nil {
+		return 0, ii.flushErr
+	}
+	var l uint64
+	var buf []byte
+	for len(ii.pending) > 0 {
+		r := ii.pending[0]
+		k := r.Cid.Bytes()
+		buf = binary.AppendUvarint(buf[:0], uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, r.Offset)
+		n, err := w.Write(buf)
+		l += uint64(n)
+		if err != nil {
+			if n > 0 {
+				ii.flushErr = fmt.Errorf("record log ends with a partially written record: %w", err)
+				return l, ii.flushErr
+			}
+			return l, err
+		}
+		ii.pending = ii.pending[1:]
+	}
+	ii.pending = nil
+	return l, nil
+}
+
+// maxRecordLogCidSize bounds the length of the CIDs read by ReadRecordLog, to
+// ~match the go-cid maximum.
+const maxRecordLogCidSize = 32 << 20
+
+// ReadRecordLog reads the records appended by successive calls to
+// InsertionIndex.Flush from r into a new InsertionIndex, which may then be
+// flattened into one of the index formats of CARv2.
+//
+// A log which ends with a partially written record, e.g. because its writer
+// crashed, yields io.ErrUnexpectedEOF along with the index of the records
+// read until then.
+func ReadRecordLog(r io.Reader) (*InsertionIndex, error) {
+	ii := NewInsertionIndex()
+	br := internalio.ToByteReader(r)
+	for {
+		l, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return ii, nil
+		}
+		if err != nil {
+			return ii, err
+		}
+		if l > maxRecordLogCidSize {
+			return ii, fmt.Errorf("malformed record log; cid length %d is larger than allowed maximum", l)
+		}
+		k := make([]byte, l)
+		if _, err := io.ReadFull(r, k); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return ii, err
+		}
+		c, err := cid.Cast(k)
+		if err != nil {
+			return ii, err
+		}
+		offset, err := binary.ReadUvarint(br)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return ii, err
+		}
+		ii.insert(newRecordFromCid(c, offset))
+	}
+}
+
 func (ii *InsertionIndex) Codec() multicodec.Code {
 	return insertionIndexCodec
 }
@@ -180,31 +387,178 @@ func (ii *InsertionIndex) Load(rs []Record) error {
 		if rec.digest == nil {
 			return fmt.Errorf("invalid entry: %v", r)
 		}
-		ii.items.InsertNoReplace(rec)
+		ii.insert(rec)
+	}
+	return nil
+}
+
+// LoadSized is like Load, also recording the size of the block data of each
+// record, as InsertSizedNoReplace does.
+func (ii *InsertionIndex) LoadSized(rs []SizedRecord) error {
+	for _, r := range rs {
+		rec := newRecordDigest(r.Record)
+		if rec.digest == nil {
+			return fmt.Errorf("invalid entry: %v", r.Record)
+		}
+		rec.size = r.Size
+		ii.insert(rec)
 	}
 	return nil
 }
 
 // flatten returns a formatted index in the given codec for more efficient subsequent loading.
+//
+// The sorted index codecs are built straight from the tree, without first
+// collecting its records, such that flattening a large index only allocates
+// the flattened index itself.
+//
+// Codecs whose registration was replaced via Register are flattened by loading
+// the records into an index constructed by New, as any other codec.
 func (ii *InsertionIndex) Flatten(codec multicodec.Code) (Index, error) {
+	switch {
+	case !Builtin(codec):
+	case codec == multicodec.CarIndexSorted:
+		buckets, err := ii.flattenSorted(false)
+		if err != nil {
+			return nil, err
+		}
+		si := buckets[0]
+		if si == nil {
+			si = make(multiWidthIndex)
+		}
+		return &si, nil
+	case codec == multicodec.CarMultihashIndexSorted:
+		buckets, err := ii.flattenSorted(true)
+		if err != nil {
+			return nil, err
+		}
+		si := make(MultihashIndexSorted, len(buckets))
+		for code, mwi := range buckets {
+			si.put(&multiWidthCodedIndex{multiWidthIndex: mwi, code: code})
+		}
+		return &si, nil
+	}
+
+	// Codecs implementing SizedIndex take the size of each record from those
+	// inserted with one, e.g. via InsertSizedNoReplace.
 	si, err := New(codec)
 	if err != nil {
 		return nil, err
 	}
-	rcrds := make([]Record, ii.items.Len())
+	rcrds := make([]SizedRecord, 0, ii.items.Len())
+	_ = ii.ForEachSizedRecord(func(r SizedRecord) error {
+		rcrds = append(rcrds, r)
+		return nil
+	})
 
-	idx := 0
-	iter := func(i llrb.Item) bool {
-		rcrds[idx] = i.(recordDigest).Record
-		idx++
+	if err := LoadSizedRecords(si, rcrds); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// flattenSorted returns the records of ii in the compact form of a
+// multiWidthIndex per multihash code, or of a single one under code 0 if
+// byCode is false.
+//
+// A first pass over the tree sizes each bucket, which is then allocated once
+// and filled by a second pass. Since the tree is ordered by digest, records
+// are appended to their bucket in sorted order.
+func (ii *InsertionIndex) flattenSorted(byCode bool) (map[uint64]multiWidthIndex, error) {
+	type bucketKey struct {
+		code  uint64
+		width uint32
+	}
+	keyOf := func(r recordDigest) (bucketKey, error) {
+		k := bucketKey{width: uint32(len(r.digest)) + 8}
+		if byCode {
+			code, err := multihashCode(r.Cid)
+			if err != nil {
+				return bucketKey{}, err
+			}
+			k.code = code
+		}
+		return k, nil
+	}
+
+	var err error
+	counts := make(map[bucketKey]uint64)
+	ii.items.AscendGreaterOrEqual(ii.items.Min(), func(i llrb.Item) bool {
+		var k bucketKey
+		if k, err = keyOf(i.(recordDigest)); err != nil {
+			return false
+		}
+		counts[k]++
 		return true
+	})
+	if err != nil {
+		return nil, err
 	}
-	ii.items.AscendGreaterOrEqual(ii.items.Min(), iter)
 
-	if err := si.Load(rcrds); err != nil {
+	buckets := make(map[bucketKey][]byte, len(counts))
+	for k, n := range counts {
+		buckets[k] = make([]byte, 0, uint64(k.width)*n)
+	}
+	ii.items.AscendGreaterOrEqual(ii.items.Min(), func(i llrb.Item) bool {
+		r := i.(recordDigest)
+		var k bucketKey
+		if k, err = keyOf(r); err != nil {
+			return false
+		}
+		b := append(buckets[k], r.digest...)
+		buckets[k] = binary.LittleEndian.AppendUint64(b, r.Offset)
+		return true
+	})
+	if err != nil {
 		return nil, err
 	}
-	return si, nil
+
+	flat := make(map[uint64]multiWidthIndex)
+	for k, b := range buckets {
+		mwi, ok := flat[k.code]
+		if !ok {
+			mwi = make(multiWidthIndex)
+			flat[k.code] = mwi
+		}
+		mwi[k.width] = singleWidthIndex{width: k.width, len: counts[k], index: b}
+	}
+	return flat, nil
+}
+
+// multihashCode returns the multihash code of c. Unlike c.Hash, it does not
+// copy the bytes of c, which would otherwise make up most of the allocations
+// of flattenSorted.
+func multihashCode(c cid.Cid) (uint64, error) {
+	if c.Version() == 0 {
+		return multihash.SHA2_256, nil
+	}
+	// Skip the CID version and codec preceding the multihash.
+	s := c.KeyString()
+	var code uint64
+	for i := 0; i < 3; i++ {
+		var n int
+		var err error
+		if code, n, err = uvarintString(s); err != nil {
+			return 0, err
+		}
+		s = s[n:]
+	}
+	return code, nil
+}
+
+func uvarintString(s string) (uint64, int, error) {
+	var x uint64
+	for i := 0; i < len(s) && i < varint.MaxLenUvarint63; i++ {
+		b := s[i]
+		x |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return x, i + 1, nil
+		}
+	}
+	if len(s) < varint.MaxLenUvarint63 {
+		return 0, 0, varint.ErrUnderflow
+	}
+	return 0, 0, varint.ErrOverflow
 }
 
 // note that hasExactCID is very similar to GetAll,
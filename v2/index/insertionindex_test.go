@@ -0,0 +1,270 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertionIndex_Flatten(t *testing.T) {
+	rng := rand.New(rand.NewSource(1415))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	records = append(records, generateIndexRecords(t, multihash.IDENTITY, rng)...)
+
+	for _, codec := range []multicodec.Code{multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			ii := index.NewInsertionIndex()
+			require.NoError(t, ii.Load(records))
+			flat, err := ii.Flatten(codec)
+			require.NoError(t, err)
+			require.Equal(t, codec, flat.Codec())
+			requireContainsAll(t, flat, records)
+
+			// The flattened index must be identical to one loaded from the
+			// records directly.
+			want, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, want.Load(records))
+			var wantBuf, gotBuf bytes.Buffer
+			_, err = index.WriteTo(want, &wantBuf)
+			require.NoError(t, err)
+			_, err = index.WriteTo(flat, &gotBuf)
+			require.NoError(t, err)
+			require.Equal(t, wantBuf.Bytes(), gotBuf.Bytes())
+		})
+	}
+
+	flat, err := index.NewInsertionIndex().Flatten(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	err = flat.GetAll(generateCidV1(t, multihash.SHA2_256, rng), func(uint64) bool { return true })
+	require.ErrorIs(t, err, index.ErrNotFound)
+}
+
+func TestInsertionIndex_Remove(t *testing.T) {
+	a, err := multihash.Sum([]byte("a"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	decoded, err := multihash.Decode(a)
+	require.NoError(t, err)
+	// b shares the digest of a, but not its hash function.
+	b, err := multihash.Encode(decoded.Digest, multihash.SHA3_256)
+	require.NoError(t, err)
+	c, err := multihash.Sum([]byte("c"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	aCid, bCid, cCid := cid.NewCidV1(cid.Raw, a), cid.NewCidV1(cid.Raw, b), cid.NewCidV1(cid.Raw, c)
+
+	offsets := func(idx index.IterableIndex, c cid.Cid) []uint64 {
+		var got []uint64
+		require.NoError(t, idx.ForEach(func(mh multihash.Multihash, o uint64) error {
+			if bytes.Equal(mh, c.Hash()) {
+				got = append(got, o)
+			}
+			return nil
+		}))
+		return got
+	}
+
+	ii := index.NewInsertionIndex()
+	require.NoError(t, ii.Load([]index.Record{
+		{Cid: aCid, Offset: 1},
+		{Cid: aCid, Offset: 2},
+		{Cid: bCid, Offset: 3},
+		{Cid: cCid, Offset: 4},
+	}))
+
+	require.NoError(t, ii.RemoveOffset(a, 2))
+	require.ErrorIs(t, ii.RemoveOffset(a, 2), index.ErrNotFound)
+	require.Equal(t, []uint64{1}, offsets(ii, aCid))
+
+	require.NoError(t, ii.Remove(a))
+	require.ErrorIs(t, ii.Remove(a), index.ErrNotFound)
+	has, err := ii.HasMultihash(a)
+	require.NoError(t, err)
+	require.False(t, has)
+	has, err = ii.HasMultihash(b)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// The removal is reflected when serializing the index.
+	var buf bytes.Buffer
+	_, err = ii.Marshal(&buf)
+	require.NoError(t, err)
+	unmarshalled := index.NewInsertionIndex()
+	require.NoError(t, unmarshalled.Unmarshal(&buf))
+	flat, err := ii.Flatten(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	for _, idx := range []index.IterableIndex{unmarshalled, flat.(index.IterableIndex)} {
+		require.Empty(t, offsets(idx, aCid))
+		require.Equal(t, []uint64{3}, offsets(idx, bCid))
+		require.Equal(t, []uint64{4}, offsets(idx, cCid))
+	}
+}
+
+func TestInsertionIndex_RemoveRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1416))
+	// Few distinct multihashes, sharing digests, such that records often
+	// share a digest or are identical.
+	var mhs []multihash.Multihash
+	for i := 0; i < 8; i++ {
+		sum, err := multihash.Sum([]byte{byte(i)}, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		decoded, err := multihash.Decode(sum)
+		require.NoError(t, err)
+		other, err := multihash.Encode(decoded.Digest, multihash.SHA3_256)
+		require.NoError(t, err)
+		mhs = append(mhs, sum, other)
+	}
+
+	ii := index.NewInsertionIndex()
+	want := make(map[string][]uint64)
+	for i := 0; i < 2000; i++ {
+		mh := mhs[rng.Intn(len(mhs))]
+		offset := uint64(rng.Intn(4))
+		switch rng.Intn(3) {
+		case 0:
+			// Inserting an identical record replaces it.
+			ii.InsertNoReplace(cid.NewCidV1(cid.Raw, mh), offset)
+			if !slices.Contains(want[string(mh)], offset) {
+				want[string(mh)] = append(want[string(mh)], offset)
+			}
+		case 1:
+			var kept []uint64
+			for _, o := range want[string(mh)] {
+				if o != offset {
+					kept = append(kept, o)
+				}
+			}
+			err := ii.RemoveOffset(mh, offset)
+			if len(kept) == len(want[string(mh)]) {
+				require.ErrorIs(t, err, index.ErrNotFound)
+			} else {
+				require.NoError(t, err)
+			}
+			want[string(mh)] = kept
+		case 2:
+			err := ii.Remove(mh)
+			if len(want[string(mh)]) == 0 {
+				require.ErrorIs(t, err, index.ErrNotFound)
+			} else {
+				require.NoError(t, err)
+			}
+			delete(want, string(mh))
+		}
+
+		got := make(map[string][]uint64)
+		require.NoError(t, ii.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			got[string(mh)] = append(got[string(mh)], offset)
+			return nil
+		}))
+		for k, v := range want {
+			if len(v) == 0 {
+				delete(want, k)
+			}
+		}
+		require.Equal(t, len(want), len(got))
+		for k, v := range want {
+			require.ElementsMatch(t, v, got[k])
+		}
+	}
+}
+
+func TestInsertionIndex_FlattenAllocations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1416))
+	ii := index.NewInsertionIndex()
+	const count = 20000
+	for i := 0; i < count; i++ {
+		ii.InsertNoReplace(generateCidV1(t, multihash.SHA2_256, rng), rng.Uint64())
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	_, err := ii.Flatten(multicodec.CarMultihashIndexSorted)
+	runtime.ReadMemStats(&after)
+	require.NoError(t, err)
+
+	// Each record takes a 32 byte digest and an 8 byte offset once flattened;
+	// flattening should allocate little beyond that.
+	compactSize := uint64(count * (32 + 8))
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, compactSize+compactSize/4)
+}
+
+func TestInsertionIndex_FlushAndReadRecordLog(t *testing.T) {
+	rng := rand.New(rand.NewSource(1416))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.IDENTITY, rng)...)
+
+	// Flush records in batches, appending to the same log.
+	var w index.Writable = index.NewInsertionIndex()
+	var log bytes.Buffer
+	var ends []int
+	const batchSize = 10
+	for i := 0; i < len(records); i += batchSize {
+		for _, r := range records[i:min(i+batchSize, len(records))] {
+			require.NoError(t, w.Add(r))
+		}
+		n, err := w.Flush(&log)
+		require.NoError(t, err)
+		require.NotZero(t, n)
+		ends = append(ends, log.Len())
+	}
+	// Nothing is left to flush.
+	n, err := w.Flush(&log)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	// Records are queryable as they are added.
+	requireContainsAll(t, w.(*index.InsertionIndex), records)
+
+	got, err := index.ReadRecordLog(bytes.NewReader(log.Bytes()))
+	require.NoError(t, err)
+	requireContainsAll(t, got, records)
+	flat, err := got.Flatten(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	requireContainsAll(t, flat, records)
+
+	// A partially written log yields the records of the complete batches.
+	got, err = index.ReadRecordLog(bytes.NewReader(log.Bytes()[:ends[0]+1]))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	requireContainsAll(t, got, records[:batchSize])
+
+	// Records which fail to be written are kept for the next flush.
+	ii := index.NewInsertionIndex()
+	require.NoError(t, ii.Add(records[0]))
+	_, err = ii.Flush(failingWriter{})
+	require.Error(t, err)
+	log.Reset()
+	_, err = ii.Flush(&log)
+	require.NoError(t, err)
+	got, err = index.ReadRecordLog(&log)
+	require.NoError(t, err)
+	requireContainsAll(t, got, records[:1])
+
+	// Once part of a record is written, flushes fail for good.
+	ii = index.NewInsertionIndex()
+	require.NoError(t, ii.Add(records[0]))
+	_, err = ii.Flush(failingWriter{partial: 3})
+	require.ErrorContains(t, err, "partially written")
+	log.Reset()
+	_, err = ii.Flush(&log)
+	require.ErrorContains(t, err, "partially written")
+	require.Zero(t, log.Len())
+}
+
+// failingWriter writes up to partial bytes, then fails.
+type failingWriter struct {
+	partial int
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return min(w.partial, len(p)), errors.New("write failed")
+}
@@ -0,0 +1,74 @@
+//go:build go1.23
+
+package index
+
+import (
+	"errors"
+	"iter"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// RecordIterator iterates over the records of an IterableIndex via
+// range-over-func:
+//
+//	ri := index.NewRecordIterator(idx)
+//	for r := range ri.Records() {
+//		...
+//	}
+//	if err := ri.Err(); err != nil {
+//		...
+//	}
+type RecordIterator struct {
+	idx IterableIndex
+	err error
+}
+
+// errStopIteration stops an iteration over IterableIndex.ForEach early.
+var errStopIteration = errors.New("stop iteration")
+
+// NewRecordIterator instantiates a new RecordIterator over the records of idx.
+func NewRecordIterator(idx IterableIndex) *RecordIterator {
+	return &RecordIterator{idx: idx}
+}
+
+// Records returns an iterator over the records of the index, in the order of
+// its ForEach method. Since indexes generally only store multihashes, the CID
+// of each record is a CIDv1 with the raw codec, unless the index stores whole
+// CIDs, as InsertionIndex does.
+//
+// Iteration ends after the last record, or at the first error of the index,
+// which is then returned by Err.
+func (ri *RecordIterator) Records() iter.Seq[Record] {
+	return func(yield func(Record) bool) {
+		var err error
+		if cids, ok := ri.idx.(interface {
+			ForEachCid(func(cid.Cid, uint64) error) error
+		}); ok {
+			err = cids.ForEachCid(func(c cid.Cid, offset uint64) error {
+				if !yield(Record{Cid: c, Offset: offset}) {
+					return errStopIteration
+				}
+				return nil
+			})
+		} else {
+			err = ri.idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+				if !yield(Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset}) {
+					return errStopIteration
+				}
+				return nil
+			})
+		}
+		if err == errStopIteration {
+			err = nil
+		}
+		ri.err = err
+	}
+}
+
+// Err returns the error which ended the last iteration over Records, or nil if
+// it reached the last record or was stopped early.
+func (ri *RecordIterator) Err() error {
+	return ri.err
+}
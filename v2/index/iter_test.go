@@ -0,0 +1,70 @@
+//go:build go1.23
+
+package index_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordIterator(t *testing.T) {
+	rng := rand.New(rand.NewSource(1417))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+
+	t.Run("Sorted", func(t *testing.T) {
+		idx, err := index.New(multicodec.CarMultihashIndexSorted)
+		require.NoError(t, err)
+		require.NoError(t, idx.Load(records))
+		ri := index.NewRecordIterator(idx.(index.IterableIndex))
+		var got []index.Record
+		for r := range ri.Records() {
+			got = append(got, r)
+		}
+		require.NoError(t, ri.Err())
+		require.Len(t, got, len(records))
+		requireContainsAll(t, idx, got)
+	})
+
+	t.Run("Insertion", func(t *testing.T) {
+		idx := index.NewInsertionIndex()
+		require.NoError(t, idx.Load(records))
+		ri := index.NewRecordIterator(idx)
+		var got []index.Record
+		for r := range ri.Records() {
+			got = append(got, r)
+		}
+		require.NoError(t, ri.Err())
+		// Whole CIDs are retained.
+		require.ElementsMatch(t, records, got)
+
+		got = got[:0]
+		for r := range ri.Records() {
+			got = append(got, r)
+			break
+		}
+		require.NoError(t, ri.Err())
+		require.Len(t, got, 1)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		ri := index.NewRecordIterator(failingIndex{})
+		for range ri.Records() {
+			t.Fatal("unexpected record")
+		}
+		require.EqualError(t, ri.Err(), "failed")
+	})
+}
+
+type failingIndex struct {
+	index.IterableIndex
+}
+
+func (failingIndex) ForEach(func(multihash.Multihash, uint64) error) error {
+	return errors.New("failed")
+}
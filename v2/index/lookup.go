@@ -0,0 +1,120 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+var errTruncatedIndex = errors.New("malformed index; unexpected end of data")
+
+// Lookup returns the offsets of mh in raw, the bytes of a
+// CarMultihashIndexSorted index as written by WriteTo, i.e. prefixed with its
+// codec. Unlike ReadFrom followed by GetAll, the index is binary searched in
+// place without being unmarshalled, such that lookups only allocate the
+// returned offsets. This suits minimal readers, e.g. compiled to wasm, which
+// hold an index in memory as is.
+//
+// The offsets are returned in the order in which they are stored. ErrNotFound
+// is returned if mh is not in the index. Only the buckets preceding the one
+// searched are checked to be well formed, so errors may go unnoticed in the
+// rest of raw.
+func Lookup(raw []byte, mh multihash.Multihash) ([]uint64, error) {
+	code, n, err := varint.FromUvarint(mh)
+	if err != nil {
+		return nil, err
+	}
+	length, l, err := varint.FromUvarint(mh[n:])
+	if err != nil {
+		return nil, err
+	}
+	digest := mh[n+l:]
+	if uint64(len(digest)) != length {
+		return nil, fmt.Errorf("malformed multihash; digest length %d does not match %d", len(digest), length)
+	}
+
+	codec, n, err := varint.FromUvarint(raw)
+	if err != nil {
+		return nil, err
+	}
+	if multicodec.Code(codec) != multicodec.CarMultihashIndexSorted {
+		return nil, fmt.Errorf("unsupported index codec %s; expected %s", multicodec.Code(codec), multicodec.CarMultihashIndexSorted)
+	}
+	raw = raw[n:]
+
+	codeCount, raw, err := lookupCount(raw)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < codeCount; i++ {
+		if len(raw) < 8 {
+			return nil, errTruncatedIndex
+		}
+		bucketCode := binary.LittleEndian.Uint64(raw)
+		var widthCount int
+		widthCount, raw, err = lookupCount(raw[8:])
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < widthCount; j++ {
+			if len(raw) < 12 {
+				return nil, errTruncatedIndex
+			}
+			width := binary.LittleEndian.Uint32(raw)
+			dataLen := binary.LittleEndian.Uint64(raw[4:])
+			raw = raw[12:]
+			if width < 8 {
+				return nil, errors.New("malformed index; width must be at least 8")
+			}
+			if dataLen > uint64(len(raw)) {
+				return nil, errTruncatedIndex
+			}
+			bucket := raw[:dataLen]
+			raw = raw[dataLen:]
+			if bucketCode == code && uint64(width) == length+8 {
+				return lookupBucket(bucket, int(width), digest)
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// lookupCount reads the little-endian int32 count of buckets at the start of
+// raw, returning it along with the remaining bytes.
+func lookupCount(raw []byte) (int, []byte, error) {
+	if len(raw) < 4 {
+		return 0, nil, errTruncatedIndex
+	}
+	count := int32(binary.LittleEndian.Uint32(raw))
+	if count < 0 {
+		return 0, nil, errors.New("index too big; bucket count is overflowing int32")
+	}
+	return int(count), raw[4:], nil
+}
+
+// lookupBucket binary searches the sorted records of the given width in
+// bucket, each a digest followed by its little-endian uint64 offset, for the
+// offsets of digest.
+func lookupBucket(bucket []byte, width int, digest []byte) ([]uint64, error) {
+	count := len(bucket) / width
+	recordDigest := func(i int) []byte {
+		return bucket[i*width : (i+1)*width-8]
+	}
+	i := sort.Search(count, func(i int) bool {
+		return bytes.Compare(recordDigest(i), digest) >= 0
+	})
+	var offsets []uint64
+	for ; i < count && bytes.Equal(recordDigest(i), digest); i++ {
+		offsets = append(offsets, binary.LittleEndian.Uint64(bucket[(i+1)*width-8:]))
+	}
+	if len(offsets) == 0 {
+		return nil, ErrNotFound
+	}
+	return offsets, nil
+}
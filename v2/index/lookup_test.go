@@ -0,0 +1,79 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	rng := rand.New(rand.NewSource(1415))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	records = append(records, generateIndexRecords(t, multihash.IDENTITY, rng)...)
+	// Add a second offset for one of the multihashes.
+	dup := records[0]
+	dup.Offset++
+	records = append(records, dup)
+
+	idx, err := index.New(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	require.NoError(t, idx.Load(records))
+	buf := new(bytes.Buffer)
+	_, err = index.WriteTo(idx, buf)
+	require.NoError(t, err)
+	raw := buf.Bytes()
+
+	for _, r := range records {
+		var want []uint64
+		require.NoError(t, idx.GetAll(r.Cid, func(o uint64) bool {
+			want = append(want, o)
+			return true
+		}))
+		got, err := index.Lookup(raw, r.Cid.Hash())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	got, err := index.Lookup(raw, records[0].Cid.Hash())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint64{records[0].Offset, dup.Offset}, got)
+
+	// Absent digests, of present and absent lengths and codes.
+	for _, mh := range []multihash.Multihash{
+		requireSum(t, []byte("absent"), multihash.SHA2_256),
+		requireSum(t, []byte("absent"), multihash.SHA3_256),
+		requireSum(t, []byte("absent"), multihash.IDENTITY),
+	} {
+		_, err := index.Lookup(raw, mh)
+		require.Equal(t, index.ErrNotFound, err)
+	}
+
+	// Malformed inputs.
+	_, err = index.Lookup(raw, multihash.Multihash{0x12})
+	require.Error(t, err)
+	// Buckets are only checked as far as they are scanned.
+	_, err = index.Lookup(raw[:len(raw)-1], requireSum(t, []byte("absent"), multihash.SHA3_256))
+	require.EqualError(t, err, "malformed index; unexpected end of data")
+	_, err = index.Lookup(raw[:3], records[0].Cid.Hash())
+	require.Error(t, err)
+
+	sorted, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	require.NoError(t, sorted.Load(records))
+	buf.Reset()
+	_, err = index.WriteTo(sorted, buf)
+	require.NoError(t, err)
+	_, err = index.Lookup(buf.Bytes(), records[0].Cid.Hash())
+	require.EqualError(t, err, "unsupported index codec car-index-sorted; expected car-multihash-index-sorted")
+}
+
+func requireSum(t *testing.T, data []byte, code uint64) multihash.Multihash {
+	mh, err := multihash.Sum(data, code, -1)
+	require.NoError(t, err)
+	return mh
+}
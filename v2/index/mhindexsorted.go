@@ -178,11 +178,21 @@ func (m *MultihashIndexSorted) GetAll(cid cid.Cid, f func(uint64) bool) error {
 	if err != nil {
 		return err
 	}
-	mwci, err := m.get(dmh)
-	if err != nil {
-		return err
+	return m.GetByDigest(dmh.Code, dmh.Digest, f)
+}
+
+// GetByDigest is like GetAll, but looks up the offsets of the multihash with
+// the given code and digest, such that callers holding the digest bytes need
+// not construct a multihash or CID for it.
+func (m *MultihashIndexSorted) GetByDigest(code uint64, digest []byte, f func(uint64) bool) error {
+	mwci, ok := (*m)[code]
+	if !ok {
+		return ErrNotFound
 	}
-	return mwci.GetAll(cid, f)
+	if s, ok := mwci.multiWidthIndex[uint32(len(digest)+8)]; ok {
+		return s.getAll(digest, f)
+	}
+	return ErrNotFound
 }
 
 // ForEach calls f for every multihash and its associated offset stored by this index.
@@ -201,13 +211,6 @@ func (m *MultihashIndexSorted) ForEach(f func(mh multihash.Multihash, offset uin
 	return nil
 }
 
-func (m *MultihashIndexSorted) get(dmh *multihash.DecodedMultihash) (*multiWidthCodedIndex, error) {
-	if codedIdx, ok := (*m)[dmh.Code]; ok {
-		return codedIdx, nil
-	}
-	return nil, ErrNotFound
-}
-
 func NewMultihashSorted() *MultihashIndexSorted {
 	index := make(MultihashIndexSorted)
 	return &index
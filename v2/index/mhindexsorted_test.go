@@ -77,6 +77,40 @@ func TestMultiWidthCodedIndex_StableIterate(t *testing.T) {
 	}
 }
 
+func TestMultihashIndexSorted_GetByDigest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1415))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewMultihashSorted()
+	require.NoError(t, subject.Load(records))
+
+	for _, r := range records {
+		dmh, err := multihash.Decode(r.Hash())
+		require.NoError(t, err)
+		var got []uint64
+		require.NoError(t, subject.GetByDigest(dmh.Code, dmh.Digest, func(o uint64) bool {
+			got = append(got, o)
+			return true
+		}))
+		require.Contains(t, got, r.Offset)
+
+		// The digest is only found along with the code it was indexed with.
+		err = subject.GetByDigest(multihash.SHA3_256, dmh.Digest, func(uint64) bool { return true })
+		require.ErrorIs(t, err, index.ErrNotFound)
+		err = subject.GetByDigest(dmh.Code, dmh.Digest[1:], func(uint64) bool { return true })
+		require.ErrorIs(t, err, index.ErrNotFound)
+	}
+
+	// Lookups by digest do not allocate.
+	d, err := multihash.Decode(records[0].Hash())
+	require.NoError(t, err)
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = subject.GetByDigest(d.Code, d.Digest, func(uint64) bool { return false })
+	})
+	require.Zero(t, allocs)
+}
+
 func generateIndexRecords(t *testing.T, hasherCode uint64, rng *rand.Rand) []index.Record {
 	var records []index.Record
 	recordCount := rng.Intn(99) + 1 // Up to 100 records
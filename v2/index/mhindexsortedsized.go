@@ -0,0 +1,215 @@
+package index
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// CarMultihashIndexSortedWithSize is the codec of MultihashIndexSortedWithSize.
+// It is not defined in a spec, and thus uses a code of the private range.
+const CarMultihashIndexSortedWithSize = multicodec.Code(0x300004)
+
+var (
+	_ Index         = (*MultihashIndexSortedWithSize)(nil)
+	_ IterableIndex = (*MultihashIndexSortedWithSize)(nil)
+	_ SizedIndex    = (*MultihashIndexSortedWithSize)(nil)
+)
+
+// sizedRecordTail is the length of the offset and size following the digest of
+// each record of a MultihashIndexSortedWithSize.
+const sizedRecordTail = 16
+
+var errSizesRequired = errors.New("records of a sized index must be loaded with their sizes via LoadSized")
+
+// MultihashIndexSortedWithSize is like MultihashIndexSorted, except that each
+// record holds the length of the block data of its section in addition to its
+// offset, such that the size of a block is known without reading the CAR
+// payload; see SizedIndex.
+//
+// Its serial form is that of MultihashIndexSorted, with the size of each record
+// encoded as a little-endian uint64 right after its offset.
+//
+// Records must be loaded via LoadSized, as done for the records generated from
+// a CAR payload, such as by car.LoadIndex, and by the writers which index
+// blocks as they are put, which may thus use this codec; only along with
+// neither an index checkpoint nor an index spill though, whose records do not
+// hold sizes.
+type MultihashIndexSortedWithSize MultihashIndexSorted
+
+// NewMultihashSortedWithSize returns a new, empty MultihashIndexSortedWithSize.
+func NewMultihashSortedWithSize() *MultihashIndexSortedWithSize {
+	index := make(MultihashIndexSortedWithSize)
+	return &index
+}
+
+func (m *MultihashIndexSortedWithSize) Codec() multicodec.Code {
+	return CarMultihashIndexSortedWithSize
+}
+
+func (m *MultihashIndexSortedWithSize) Marshal(w io.Writer) (uint64, error) {
+	return (*MultihashIndexSorted)(m).Marshal(w)
+}
+
+func (m *MultihashIndexSortedWithSize) Unmarshal(r io.Reader) error {
+	if err := (*MultihashIndexSorted)(m).Unmarshal(r); err != nil {
+		return err
+	}
+	for _, mwci := range *m {
+		for width := range mwci.multiWidthIndex {
+			if width < sizedRecordTail {
+				return errors.New("malformed index; width must be at least 16")
+			}
+		}
+	}
+	return nil
+}
+
+// Load fails unless records is empty, since plain records do not hold the
+// sizes this index records; use LoadSized instead.
+func (m *MultihashIndexSortedWithSize) Load(records []Record) error {
+	if len(records) != 0 {
+		return errSizesRequired
+	}
+	return nil
+}
+
+// LoadSized inserts a number of records, along with the length of the block
+// data of each, into the index.
+func (m *MultihashIndexSortedWithSize) LoadSized(records []SizedRecord) error {
+	type sizedRecord struct {
+		digest       []byte
+		offset, size uint64
+	}
+	type bucketKey struct {
+		code  uint64
+		width uint32
+	}
+	buckets := make(map[bucketKey][]sizedRecord)
+	for _, record := range records {
+		dmh, err := multihash.Decode(record.Hash())
+		if err != nil {
+			return err
+		}
+		key := bucketKey{dmh.Code, uint32(len(dmh.Digest) + sizedRecordTail)}
+		buckets[key] = append(buckets[key], sizedRecord{dmh.Digest, record.Offset, record.Size})
+	}
+
+	for key, rcrds := range buckets {
+		slices.SortFunc(rcrds, func(a, b sizedRecord) int {
+			if c := bytes.Compare(a.digest, b.digest); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.offset, b.offset)
+		})
+		compact := make([]byte, int(key.width)*len(rcrds))
+		for i, r := range rcrds {
+			buf := compact[i*int(key.width) : (i+1)*int(key.width)]
+			n := copy(buf, r.digest)
+			binary.LittleEndian.PutUint64(buf[n:], r.offset)
+			binary.LittleEndian.PutUint64(buf[n+8:], r.size)
+		}
+		mwci, ok := (*m)[key.code]
+		if !ok {
+			mwci = newMultiWidthCodedIndex()
+			mwci.code = key.code
+			(*m)[key.code] = mwci
+		}
+		mwci.multiWidthIndex[key.width] = singleWidthIndex{
+			width: key.width,
+			len:   uint64(len(rcrds)),
+			index: compact,
+		}
+	}
+	return nil
+}
+
+func (m *MultihashIndexSortedWithSize) GetAll(c cid.Cid, f func(uint64) bool) error {
+	return m.GetAllSized(c, func(offset, _ uint64) bool {
+		return f(offset)
+	})
+}
+
+// GetAllSized calls f with the offset and block data length of each section
+// holding the multihash of the given CID.
+func (m *MultihashIndexSortedWithSize) GetAllSized(c cid.Cid, f func(offset, size uint64) bool) error {
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	mwci, ok := (*m)[dmh.Code]
+	if !ok {
+		return ErrNotFound
+	}
+	s, ok := mwci.multiWidthIndex[uint32(len(dmh.Digest)+sizedRecordTail)]
+	if !ok {
+		return ErrNotFound
+	}
+	width := int(s.width)
+	at := func(i int) []byte { return s.index[i*width : (i+1)*width] }
+	i := sort.Search(int(s.len), func(i int) bool {
+		return bytes.Compare(dmh.Digest, at(i)[:width-sizedRecordTail]) <= 0
+	})
+	var any bool
+	for ; i < int(s.len); i++ {
+		rcrd := at(i)
+		if !bytes.Equal(dmh.Digest, rcrd[:width-sizedRecordTail]) {
+			break
+		}
+		any = true
+		offset := binary.LittleEndian.Uint64(rcrd[width-sizedRecordTail:])
+		size := binary.LittleEndian.Uint64(rcrd[width-8:])
+		if !f(offset, size) {
+			break
+		}
+	}
+	if !any {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ForEach calls f for every multihash and its associated offset stored by this
+// index, in the same order as MultihashIndexSorted.ForEach.
+func (m *MultihashIndexSortedWithSize) ForEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	return m.ForEachSized(func(mh multihash.Multihash, offset, _ uint64) error {
+		return f(mh, offset)
+	})
+}
+
+// ForEachSized is like ForEach, except that f is also called with the block
+// data length of each record.
+func (m *MultihashIndexSortedWithSize) ForEachSized(f func(mh multihash.Multihash, offset, size uint64) error) error {
+	for _, code := range (*MultihashIndexSorted)(m).sortedMultihashCodes() {
+		mwci := (*m)[code]
+		widths := make([]uint32, 0, len(mwci.multiWidthIndex))
+		for width := range mwci.multiWidthIndex {
+			widths = append(widths, width)
+		}
+		slices.Sort(widths)
+		for _, width := range widths {
+			s := mwci.multiWidthIndex[width]
+			for rcrd := s.index; len(rcrd) >= int(width); rcrd = rcrd[width:] {
+				digestEnd := int(width) - sizedRecordTail
+				mh, err := multihash.Encode(rcrd[:digestEnd], code)
+				if err != nil {
+					return err
+				}
+				offset := binary.LittleEndian.Uint64(rcrd[digestEnd:])
+				size := binary.LittleEndian.Uint64(rcrd[digestEnd+8:])
+				if err := f(mh, offset, size); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultihashIndexSortedWithSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	records = append(records, generateIndexRecords(t, multihash.IDENTITY, rng)...)
+	sized := make([]index.SizedRecord, len(records))
+	sizes := make(map[string]uint64)
+	for i, r := range records {
+		sized[i] = index.SizedRecord{Record: r, Size: rng.Uint64()}
+		sizes[string(r.Hash())] = sized[i].Size
+	}
+
+	subject, err := index.New(index.CarMultihashIndexSortedWithSize)
+	require.NoError(t, err)
+	require.Equal(t, index.CarMultihashIndexSortedWithSize, subject.Codec())
+	// Plain records do not hold the sizes the index records.
+	require.Error(t, subject.Load(records))
+	require.NoError(t, index.LoadSizedRecords(subject, sized))
+
+	// Round-trip the index through its serial form.
+	var buf bytes.Buffer
+	_, err = index.WriteTo(subject, &buf)
+	require.NoError(t, err)
+	read, err := index.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, subject, read)
+
+	for _, idx := range []index.Index{subject, read} {
+		requireContainsAll(t, idx, records)
+		sizedIdx, ok := idx.(index.SizedIndex)
+		require.True(t, ok)
+		for _, r := range sized {
+			var found bool
+			err := sizedIdx.GetAllSized(r.Cid, func(offset, size uint64) bool {
+				found = offset == r.Offset && size == r.Size
+				return !found
+			})
+			require.NoError(t, err)
+			require.True(t, found)
+		}
+	}
+
+	// Iteration is in the order of MultihashIndexSorted.
+	mhSorted := index.NewMultihashSorted()
+	require.NoError(t, mhSorted.Load(records))
+	var want, got []index.SizedRecord
+	require.NoError(t, mhSorted.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		want = append(want, index.SizedRecord{Record: index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset}, Size: sizes[string(mh)]})
+		return nil
+	}))
+	require.NoError(t, read.(*index.MultihashIndexSortedWithSize).ForEachSized(func(mh multihash.Multihash, offset, size uint64) error {
+		got = append(got, index.SizedRecord{Record: index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset}, Size: size})
+		return nil
+	}))
+	require.Equal(t, want, got)
+
+	err = subject.(index.SizedIndex).GetAllSized(generateCidV1(t, multihash.SHA2_256, rng), func(uint64, uint64) bool { return true })
+	require.ErrorIs(t, err, index.ErrNotFound)
+}
+
+func TestMultihashIndexSortedWithSize_Flatten(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	sized := make([]index.SizedRecord, len(records))
+	ii := index.NewInsertionIndex()
+	for i, r := range records {
+		sized[i] = index.SizedRecord{Record: r, Size: rng.Uint64()}
+		ii.InsertSizedNoReplace(r.Cid, r.Offset, sized[i].Size)
+	}
+
+	// Flattening keeps the sizes records were inserted with.
+	subject, err := ii.Flatten(index.CarMultihashIndexSortedWithSize)
+	require.NoError(t, err)
+	want := index.NewMultihashSortedWithSize()
+	require.NoError(t, want.LoadSized(sized))
+	require.Equal(t, want, subject)
+}
@@ -0,0 +1,385 @@
+package car
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// IndexCarBucketSize is the maximum number of index records held by each
+// bucket block written by WriteIndexCar.
+const IndexCarBucketSize = 4096
+
+const indexCarVersion = 1
+
+var indexCarPrefix = cid.Prefix{
+	Version:  1,
+	Codec:    uint64(multicodec.DagCbor),
+	MhType:   multihash.SHA2_256,
+	MhLength: -1,
+}
+
+// ErrInvalidIndexCar is returned by ReadIndexCar when the CAR read does not
+// encode a well-formed index.
+var ErrInvalidIndexCar = errors.New("invalid index car")
+
+// WriteIndexCar encodes the given index as IPLD blocks and writes them to w as
+// a CARv1, a "CAR of index", whose single root is the root of the index. This
+// allows an index to be transmitted and verified independently of the raw
+// index formats, since every block, and therefore every record, is addressed
+// by its hash.
+//
+// The index is encoded as DAG-CBOR: a root block of the form
+//
+//	{"version": 1, "count": <records>, "buckets": [{"first": <multihash>, "link": <bucket>}, ...]}
+//
+// linking to bucket blocks, each a list of up to IndexCarBucketSize
+// [<multihash>, <offset>] records, sorted by multihash across all buckets.
+// The CID of the root is returned.
+//
+// This encoding is experimental and may change in future releases.
+func WriteIndexCar(idx index.IterableIndex, w io.Writer) (cid.Cid, error) {
+	var records []index.Record
+	if err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		records = append(records, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset})
+		return nil
+	}); err != nil {
+		return cid.Undef, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if c := bytes.Compare(records[i].Hash(), records[j].Hash()); c != 0 {
+			return c < 0
+		}
+		return records[i].Offset < records[j].Offset
+	})
+
+	type encodedBlock struct {
+		cid  cid.Cid
+		data []byte
+	}
+	var blks []encodedBlock
+	encode := func(n datamodel.Node) (cid.Cid, error) {
+		var buf bytes.Buffer
+		if err := dagcbor.Encode(n, &buf); err != nil {
+			return cid.Undef, err
+		}
+		c, err := indexCarPrefix.Sum(buf.Bytes())
+		if err != nil {
+			return cid.Undef, err
+		}
+		blks = append(blks, encodedBlock{c, buf.Bytes()})
+		return c, nil
+	}
+
+	type bucketRef struct {
+		first multihash.Multihash
+		link  cid.Cid
+	}
+	var buckets []bucketRef
+	for start := 0; start < len(records); start += IndexCarBucketSize {
+		end := start + IndexCarBucketSize
+		if end > len(records) {
+			end = len(records)
+		}
+		bucket := records[start:end]
+		n, err := buildList(len(bucket), func(la datamodel.ListAssembler) error {
+			for _, r := range bucket {
+				ra, err := la.AssembleValue().BeginList(2)
+				if err != nil {
+					return err
+				}
+				if err := ra.AssembleValue().AssignBytes(r.Hash()); err != nil {
+					return err
+				}
+				if err := ra.AssembleValue().AssignInt(int64(r.Offset)); err != nil {
+					return err
+				}
+				if err := ra.Finish(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return cid.Undef, err
+		}
+		c, err := encode(n)
+		if err != nil {
+			return cid.Undef, err
+		}
+		buckets = append(buckets, bucketRef{bucket[0].Hash(), c})
+	}
+
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(3)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := ma.AssembleKey().AssignString("version"); err != nil {
+		return cid.Undef, err
+	}
+	if err := ma.AssembleValue().AssignInt(indexCarVersion); err != nil {
+		return cid.Undef, err
+	}
+	if err := ma.AssembleKey().AssignString("count"); err != nil {
+		return cid.Undef, err
+	}
+	if err := ma.AssembleValue().AssignInt(int64(len(records))); err != nil {
+		return cid.Undef, err
+	}
+	if err := ma.AssembleKey().AssignString("buckets"); err != nil {
+		return cid.Undef, err
+	}
+	bl, err := ma.AssembleValue().BeginList(int64(len(buckets)))
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, b := range buckets {
+		ba, err := bl.AssembleValue().BeginMap(2)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := ba.AssembleKey().AssignString("first"); err != nil {
+			return cid.Undef, err
+		}
+		if err := ba.AssembleValue().AssignBytes(b.first); err != nil {
+			return cid.Undef, err
+		}
+		if err := ba.AssembleKey().AssignString("link"); err != nil {
+			return cid.Undef, err
+		}
+		if err := ba.AssembleValue().AssignLink(cidlink.Link{Cid: b.link}); err != nil {
+			return cid.Undef, err
+		}
+		if err := ba.Finish(); err != nil {
+			return cid.Undef, err
+		}
+	}
+	if err := bl.Finish(); err != nil {
+		return cid.Undef, err
+	}
+	if err := ma.Finish(); err != nil {
+		return cid.Undef, err
+	}
+	root, err := encode(nb.Build())
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// Write the root first so that readers can validate the structure as they
+	// stream the buckets.
+	if err := carv1.WriteHeader(&carv1.CarHeader{Roots: []cid.Cid{root}, Version: 1}, w); err != nil {
+		return cid.Undef, err
+	}
+	rootBlk := blks[len(blks)-1]
+	if err := util.LdWrite(w, rootBlk.cid.Bytes(), rootBlk.data); err != nil {
+		return cid.Undef, err
+	}
+	for _, b := range blks[:len(blks)-1] {
+		if err := util.LdWrite(w, b.cid.Bytes(), b.data); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return root, nil
+}
+
+func buildList(size int, fn func(datamodel.ListAssembler) error) (datamodel.Node, error) {
+	nb := basicnode.Prototype.List.NewBuilder()
+	la, err := nb.BeginList(int64(size))
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(la); err != nil {
+		return nil, err
+	}
+	if err := la.Finish(); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+// ReadIndexCar reads a CAR of index written by WriteIndexCar from r, verifying
+// the hash of every block against its CID and the structure of the index,
+// and returns the index root along with the decoded index. The index is
+// decoded using the codec set via the UseIndexCodec option, which must be an
+// index codec accepting arbitrary multihashes such as the default
+// multicodec.CarMultihashIndexSorted. Since block sizes are not part of the
+// encoding, codecs of an index.SizedIndex are rejected.
+//
+// This encoding is experimental and may change in future releases.
+func ReadIndexCar(r io.Reader, opts ...Option) (cid.Cid, index.Index, error) {
+	o := ApplyOptions(opts...)
+	// Blocks must always be verified, regardless of the TrustedCAR option.
+	br, err := NewBlockReader(r, append(opts, WithTrustedCAR(false))...)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if len(br.Roots) != 1 {
+		return cid.Undef, nil, fmt.Errorf("%w: expected exactly one root; got %d", ErrInvalidIndexCar, len(br.Roots))
+	}
+	root := br.Roots[0]
+
+	blks := make(map[cid.Cid][]byte)
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cid.Undef, nil, err
+		}
+		blks[blk.Cid()] = blk.RawData()
+	}
+
+	decode := func(c cid.Cid) (datamodel.Node, error) {
+		data, ok := blks[c]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing block %s", ErrInvalidIndexCar, c)
+		}
+		if c.Prefix().Codec != uint64(multicodec.DagCbor) {
+			return nil, fmt.Errorf("%w: unexpected codec for block %s", ErrInvalidIndexCar, c)
+		}
+		nb := basicnode.Prototype.Any.NewBuilder()
+		if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidIndexCar, err)
+		}
+		return nb.Build(), nil
+	}
+	invalid := func(err error) (cid.Cid, index.Index, error) {
+		return cid.Undef, nil, fmt.Errorf("%w: %v", ErrInvalidIndexCar, err)
+	}
+
+	rn, err := decode(root)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	version, err := lookupInt(rn, "version")
+	if err != nil {
+		return invalid(err)
+	}
+	if version != indexCarVersion {
+		return cid.Undef, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidIndexCar, version)
+	}
+	count, err := lookupInt(rn, "count")
+	if err != nil {
+		return invalid(err)
+	}
+	bn, err := rn.LookupByString("buckets")
+	if err != nil {
+		return invalid(err)
+	}
+
+	var records []index.Record
+	var last []byte
+	bi := bn.ListIterator()
+	if bi == nil {
+		return invalid(fmt.Errorf("buckets is not a list"))
+	}
+	for !bi.Done() {
+		_, ref, err := bi.Next()
+		if err != nil {
+			return invalid(err)
+		}
+		first, err := lookupBytes(ref, "first")
+		if err != nil {
+			return invalid(err)
+		}
+		ln, err := ref.LookupByString("link")
+		if err != nil {
+			return invalid(err)
+		}
+		lnk, err := ln.AsLink()
+		if err != nil {
+			return invalid(err)
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return invalid(fmt.Errorf("unsupported link %s", lnk))
+		}
+		bucket, err := decode(cl.Cid)
+		if err != nil {
+			return cid.Undef, nil, err
+		}
+		ri := bucket.ListIterator()
+		if ri == nil {
+			return invalid(fmt.Errorf("bucket %s is not a list", cl.Cid))
+		}
+		for i := 0; !ri.Done(); i++ {
+			_, rec, err := ri.Next()
+			if err != nil {
+				return invalid(err)
+			}
+			mhn, err := rec.LookupByIndex(0)
+			if err != nil {
+				return invalid(err)
+			}
+			mh, err := mhn.AsBytes()
+			if err != nil {
+				return invalid(err)
+			}
+			if _, err := multihash.Decode(mh); err != nil {
+				return invalid(err)
+			}
+			on, err := rec.LookupByIndex(1)
+			if err != nil {
+				return invalid(err)
+			}
+			offset, err := on.AsInt()
+			if err != nil || offset < 0 {
+				return invalid(fmt.Errorf("invalid offset in bucket %s", cl.Cid))
+			}
+			if i == 0 && !bytes.Equal(mh, first) {
+				return invalid(fmt.Errorf("first multihash of bucket %s does not match its reference", cl.Cid))
+			}
+			if bytes.Compare(last, mh) > 0 {
+				return invalid(fmt.Errorf("records are not sorted"))
+			}
+			last = mh
+			records = append(records, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: uint64(offset)})
+		}
+	}
+	if int64(len(records)) != count {
+		return cid.Undef, nil, fmt.Errorf("%w: expected %d records; got %d", ErrInvalidIndexCar, count, len(records))
+	}
+
+	idx, err := index.New(o.IndexCodec)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if _, ok := idx.(index.SizedIndex); ok {
+		return cid.Undef, nil, fmt.Errorf("cannot read a CAR of index into codec %v, since it does not record block sizes", o.IndexCodec)
+	}
+	if err := idx.Load(records); err != nil {
+		return cid.Undef, nil, err
+	}
+	return root, idx, nil
+}
+
+func lookupInt(n datamodel.Node, key string) (int64, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.AsInt()
+}
+
+func lookupBytes(n datamodel.Node, key string) ([]byte, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.AsBytes()
+}
@@ -0,0 +1,105 @@
+package car_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexCarRoundTrip(t *testing.T) {
+	var records []index.Record
+	for i := 0; i < carv2.IndexCarBucketSize*2+10; i++ {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("record %d", i)), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		records = append(records, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: uint64(i * 100)})
+	}
+	idx := index.NewMultihashSorted()
+	require.NoError(t, idx.Load(records))
+
+	var buf bytes.Buffer
+	root, err := carv2.WriteIndexCar(idx, &buf)
+	require.NoError(t, err)
+	encoded := buf.Bytes()
+
+	gotRoot, got, err := carv2.ReadIndexCar(bytes.NewReader(encoded))
+	require.NoError(t, err)
+	require.Equal(t, root, gotRoot)
+
+	type entry struct {
+		mh     string
+		offset uint64
+	}
+	collect := func(idx index.Index) []entry {
+		var entries []entry
+		require.NoError(t, idx.(index.IterableIndex).ForEach(func(mh multihash.Multihash, offset uint64) error {
+			entries = append(entries, entry{string(mh), offset})
+			return nil
+		}))
+		return entries
+	}
+	require.Equal(t, collect(idx), collect(got))
+
+	// Encoding is deterministic.
+	buf.Reset()
+	root2, err := carv2.WriteIndexCar(got.(index.IterableIndex), &buf)
+	require.NoError(t, err)
+	require.Equal(t, root, root2)
+	require.Equal(t, encoded, buf.Bytes())
+
+	// Tampering with any block is detected.
+	tampered := bytes.Clone(encoded)
+	tampered[len(tampered)-1] ^= 0xff
+	_, _, err = carv2.ReadIndexCar(bytes.NewReader(tampered))
+	require.ErrorContains(t, err, "mismatch in content integrity")
+
+	// Missing blocks are detected.
+	truncated := encoded[:len(encoded)/2]
+	_, _, err = carv2.ReadIndexCar(bytes.NewReader(truncated))
+	require.Error(t, err)
+}
+
+func TestReadIndexCarRejectsMalformedIndex(t *testing.T) {
+	mh, err := multihash.Sum([]byte("record"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	idx := index.NewMultihashSorted()
+	require.NoError(t, idx.Load([]index.Record{{Cid: cid.NewCidV1(cid.Raw, mh), Offset: 1}}))
+	var buf bytes.Buffer
+	root, err := carv2.WriteIndexCar(idx, &buf)
+	require.NoError(t, err)
+	br, err := carv2.NewBlockReader(&buf)
+	require.NoError(t, err)
+	rootBlk, err := br.Next()
+	require.NoError(t, err)
+	require.Equal(t, root, rootBlk.Cid())
+
+	notIndex := blocks.NewBlock([]byte("not an index"))
+	tests := []struct {
+		name  string
+		roots []cid.Cid
+		blks  []blocks.Block
+	}{
+		{"MissingBucket", []cid.Cid{root}, []blocks.Block{rootBlk}},
+		{"NotDagCbor", []cid.Cid{notIndex.Cid()}, []blocks.Block{notIndex}},
+		{"MultipleRoots", []cid.Cid{root, root}, []blocks.Block{rootBlk}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var car bytes.Buffer
+			require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: tt.roots, Version: 1}, &car))
+			for _, blk := range tt.blks {
+				require.NoError(t, util.LdWrite(&car, blk.Cid().Bytes(), blk.RawData()))
+			}
+			_, _, err := carv2.ReadIndexCar(&car)
+			require.ErrorIs(t, err, carv2.ErrInvalidIndexCar)
+		})
+	}
+}
@@ -38,12 +38,22 @@ func GenerateIndex(v1r io.Reader, opts ...Option) (index.Index, error) {
 // CIDs will be included in the index. By default this option is off, and
 // identity CIDs will not be included in the index.
 //
+// Progress may be reported via the WithIndexProgress option, and generation
+// aborted via the WithIndexContext option. Reads from an r that implements
+// io.ReaderAt may be batched via the WithReadAhead option.
+//
 // Note, the index is re-generated every time even if r is in CARv2 format and already has an index.
 // To read existing index when available see ReadOrGenerateIndex.
 func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 	// Parse Options.
 	o := ApplyOptions(opts...)
 
+	if o.ReadAheadSize > 0 {
+		var err error
+		if r, err = withReadAhead(r, o.ReadAheadSize); err != nil {
+			return err
+		}
+	}
 	reader := internalio.ToByteReadSeeker(r)
 	pragma, err := carv1.ReadHeader(r, o.MaxAllowedHeaderSize)
 	if err != nil {
@@ -111,8 +121,14 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 	// CARv2 header.
 	sectionOffset -= dataOffset
 
-	records := make([]index.Record, 0)
+	records := make([]index.SizedRecord, 0)
 	for {
+		if o.IndexContext != nil {
+			if err := o.IndexContext.Err(); err != nil {
+				return err
+			}
+		}
+
 		// Read the section's length.
 		sectionLen, err := varint.ReadUvarint(reader)
 		if err != nil {
@@ -141,7 +157,7 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 			if uint64(cidLen) > o.MaxIndexCidSize {
 				return &ErrCidTooLarge{MaxSize: o.MaxIndexCidSize, CurrentSize: uint64(cidLen)}
 			}
-			records = append(records, index.Record{Cid: c, Offset: uint64(sectionOffset)})
+			records = append(records, index.SizedRecord{Record: index.Record{Cid: c, Offset: uint64(sectionOffset)}, Size: sectionLen - uint64(cidLen)})
 		}
 
 		// Seek to the next section by skipping the block.
@@ -150,6 +166,9 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 		if sectionOffset, err = reader.Seek(remainingSectionLen, io.SeekCurrent); err != nil {
 			return err
 		}
+		if o.IndexProgress != nil {
+			o.IndexProgress(uint64(sectionOffset), uint64(len(records)))
+		}
 		// Subtract the data offset which will be non-zero when reader represents a CARv2.
 		sectionOffset -= dataOffset
 
@@ -160,13 +179,43 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 		}
 	}
 
-	if err := idx.Load(records); err != nil {
+	if o.IndexProgress != nil {
+		o.IndexProgress(uint64(sectionOffset+dataOffset), uint64(len(records)))
+	}
+
+	if err := index.LoadSizedRecords(idx, records); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// withReadAhead wraps r such that it is read in windows of the given size, if r
+// implements io.ReaderAt and io.Seeker. The returned reader is positioned at
+// the current position of r, relative to the same start.
+func withReadAhead(r io.Reader, size int) (io.Reader, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		return r, nil
+	}
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return r, nil
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := internalio.NewOffsetReadSeeker(internalio.NewReadAheadReaderAt(ra, size), 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
 // GenerateIndexFromFile walks a CAR file at the give path and generates an index of cid->byte offset.
 // The index can be stored using index.WriteTo. Both CARv1 and CARv2 formats are accepted.
 //
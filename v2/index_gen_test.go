@@ -1,6 +1,8 @@
 package car_test
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"os"
 	"testing"
@@ -140,6 +142,49 @@ func TestGenerateIndex(t *testing.T) {
 	}
 }
 
+func TestGenerateIndexProgressAndCancellation(t *testing.T) {
+	for _, path := range []string{"testdata/sample-v1.car", "testdata/sample-wrapped-v2.car"} {
+		t.Run(path, func(t *testing.T) {
+			stat, err := os.Stat(path)
+			require.NoError(t, err)
+
+			var calls int
+			var lastBytes, lastRecords uint64
+			idx, err := carv2.GenerateIndexFromFile(path, carv2.WithIndexProgress(func(bytesScanned, records uint64) {
+				require.GreaterOrEqual(t, bytesScanned, lastBytes)
+				require.GreaterOrEqual(t, records, lastRecords)
+				calls++
+				lastBytes, lastRecords = bytesScanned, records
+			}))
+			require.NoError(t, err)
+			require.Greater(t, calls, 1)
+			require.LessOrEqual(t, lastBytes, uint64(stat.Size()))
+
+			var indexed uint64
+			require.NoError(t, idx.(index.IterableIndex).ForEach(func(multihash.Multihash, uint64) error {
+				indexed++
+				return nil
+			}))
+			require.Equal(t, indexed, lastRecords)
+		})
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int
+		_, err := carv2.GenerateIndexFromFile("testdata/sample-v1.car",
+			carv2.WithIndexContext(ctx),
+			carv2.WithIndexProgress(func(uint64, uint64) {
+				calls++
+				if calls == 3 {
+					cancel()
+				}
+			}))
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 3, calls)
+	})
+}
+
 func TestMultihashIndexSortedConsistencyWithIndexSorted(t *testing.T) {
 	path := "testdata/sample-v1.car"
 
@@ -272,3 +317,43 @@ func generateMultihashSortedIndex(t *testing.T, path string) *index.MultihashInd
 
 	return idx
 }
+
+type countingReaderAt struct {
+	ra    io.ReaderAt
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.ra.ReadAt(p, off)
+}
+
+func TestGenerateIndexReadAhead(t *testing.T) {
+	for _, path := range []string{"testdata/sample-v1.car", "testdata/sample-wrapped-v2.car"} {
+		t.Run(path, func(t *testing.T) {
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			generate := func(opts ...carv2.Option) ([]byte, int) {
+				counting := &countingReaderAt{ra: f}
+				rs, err := internalio.NewOffsetReadSeeker(counting, 0)
+				require.NoError(t, err)
+				idx, err := carv2.GenerateIndex(rs, opts...)
+				require.NoError(t, err)
+				var buf bytes.Buffer
+				_, err = index.WriteTo(idx, &buf)
+				require.NoError(t, err)
+				return buf.Bytes(), counting.reads
+			}
+			want, unbatched := generate()
+			got, batched := generate(carv2.WithReadAhead(64 << 10))
+			require.Equal(t, want, got)
+			require.Less(t, batched*100, unbatched)
+
+			// Windows smaller than some reads are bypassed by those reads.
+			got, _ = generate(carv2.WithReadAhead(3))
+			require.Equal(t, want, got)
+		})
+	}
+}
@@ -96,3 +96,56 @@ func LdRead(r io.Reader, zeroLenAsEOF bool, maxReadBytes uint64) ([]byte, error)
 
 	return buf, nil
 }
+
+// ValidSection reads the section at the given offset of a CARv1 payload of the
+// given size, returning its CID, its total length and the length of its block
+// data, or false if it is not a complete section whose data matches its CID.
+func ValidSection(src io.ReaderAt, offset, size int64, maxSectionSize, maxCidSize uint64) (cid.Cid, int64, int64, bool) {
+	rs, err := internalio.NewOffsetReadSeeker(src, offset)
+	if err != nil {
+		return cid.Undef, 0, 0, false
+	}
+	length, err := varint.ReadUvarint(rs)
+	if err != nil || length == 0 || length > maxSectionSize {
+		return cid.Undef, 0, 0, false
+	}
+	sectionLen := int64(varint.UvarintSize(length)) + int64(length)
+	if sectionLen > size-offset {
+		return cid.Undef, 0, 0, false
+	}
+	cidLen, c, err := cid.CidFromReader(rs)
+	if err != nil || uint64(cidLen) > length || uint64(cidLen) > maxCidSize {
+		return cid.Undef, 0, 0, false
+	}
+	data := make([]byte, int(length)-cidLen)
+	if _, err := io.ReadFull(rs, data); err != nil {
+		return cid.Undef, 0, 0, false
+	}
+	hashed, err := c.Prefix().Sum(data)
+	if err != nil || !hashed.Equals(c) {
+		return cid.Undef, 0, 0, false
+	}
+	return c, sectionLen, int64(len(data)), true
+}
+
+// NextValidSection returns the offset of the first section at or after the
+// given offset of a CARv1 payload that is valid as per ValidSection, in order
+// to resynchronize on the section boundaries after damage. If there is none,
+// the offset at which src ends, or size if it is reached first, is returned.
+func NextValidSection(src io.ReaderAt, offset, size int64, maxSectionSize, maxCidSize uint64) int64 {
+	var b [1]byte
+	for ; offset < size; offset++ {
+		if _, err := src.ReadAt(b[:], offset); err != nil {
+			break
+		}
+		// The length prefix of a section can't be zero, so skip over padding
+		// and any other zero bytes without decoding anything.
+		if b[0] == 0 {
+			continue
+		}
+		if _, _, _, ok := ValidSection(src, offset, size, maxSectionSize, maxCidSize); ok {
+			break
+		}
+	}
+	return offset
+}
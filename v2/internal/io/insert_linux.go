@@ -0,0 +1,43 @@
+//go:build linux
+
+package io
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// PrependHole shifts the contents of f forward by inserting a range of zeros
+// at its start, without rewriting them, via fallocate with
+// FALLOC_FL_INSERT_RANGE. The range is at least min bytes long, rounded up to
+// a multiple of the block size of the filesystem as fallocate requires, and
+// its length is returned. errors.ErrUnsupported is returned if the
+// filesystem does not support inserting ranges.
+func PrependHole(f *os.File, min int64) (int64, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return 0, &os.PathError{Op: "fstat", Path: f.Name(), Err: err}
+	}
+	bs := int64(st.Blksize)
+	if bs <= 0 {
+		return 0, errors.ErrUnsupported
+	}
+	n := (min + bs - 1) / bs * bs
+	for {
+		err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_INSERT_RANGE, 0, n)
+		switch {
+		case err == nil:
+			return n, nil
+		case errors.Is(err, unix.EINTR):
+			continue
+		case errors.Is(err, unix.EOPNOTSUPP), errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EINVAL):
+			// EINVAL is returned by filesystems whose allocation unit is not
+			// the block size, or if the file is too small for the range.
+			return 0, errors.ErrUnsupported
+		default:
+			return 0, &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+		}
+	}
+}
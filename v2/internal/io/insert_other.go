@@ -0,0 +1,14 @@
+//go:build !linux
+
+package io
+
+import (
+	"errors"
+	"os"
+)
+
+// PrependHole always returns errors.ErrUnsupported on platforms other than
+// Linux, which lack a way of inserting a range at the start of a file.
+func PrependHole(f *os.File, min int64) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,56 @@
+package io
+
+import (
+	"io"
+	"sync"
+)
+
+var _ io.ReaderAt = (*readAheadReaderAt)(nil)
+
+// readAheadReaderAt serves reads from a window of bytes read ahead from an
+// underlying io.ReaderAt, such that the many small reads of a sequential scan
+// are batched into fewer, larger reads. This matters most when reads from the
+// underlying io.ReaderAt are expensive, e.g. when served over the network.
+//
+// Reads at least as large as the window bypass it.
+type readAheadReaderAt struct {
+	ra  io.ReaderAt
+	mu  sync.Mutex
+	buf []byte
+	off int64
+	eof bool // whether buf ends at the end of ra
+}
+
+// NewReadAheadReaderAt returns an io.ReaderAt which reads from ra in windows of
+// the given size. The contents of ra must not change while it is in use.
+func NewReadAheadReaderAt(ra io.ReaderAt, window int) io.ReaderAt {
+	if window <= 0 {
+		return ra
+	}
+	return &readAheadReaderAt{ra: ra, buf: make([]byte, 0, window)}
+}
+
+func (r *readAheadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) >= cap(r.buf) || off < 0 {
+		return r.ra.ReadAt(p, off)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	end := r.off + int64(len(r.buf))
+	if off < r.off || off > end || (off+int64(len(p)) > end && !r.eof) {
+		n, err := r.ra.ReadAt(r.buf[:cap(r.buf)], off)
+		if err != nil && err != io.EOF {
+			r.buf = r.buf[:0]
+			return 0, err
+		}
+		r.buf = r.buf[:n]
+		r.off = off
+		r.eof = n < cap(r.buf)
+	}
+	n := copy(p, r.buf[off-r.off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,48 @@
+// Package links decodes the links contained within blocks, for the blocks of
+// codecs with a decoder registered with go-ipld-prime.
+package links
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	ipldcodec "github.com/ipld/go-ipld-prime/codec"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipldmulticodec "github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/multiformats/go-multicodec"
+)
+
+// Decoder returns the decoder for the block with the given CID, or nil if the
+// block cannot contain links or no decoder is registered for its codec.
+func Decoder(c cid.Cid) ipldcodec.Decoder {
+	if multicodec.Code(c.Prefix().Codec) == multicodec.Raw {
+		return nil
+	}
+	decoder, _ := ipldmulticodec.LookupDecoder(c.Prefix().Codec)
+	return decoder
+}
+
+// Decode decodes the given block data using decoder, and returns the links
+// contained within it.
+func Decode(decoder ipldcodec.Decoder, data []byte) ([]cid.Cid, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decoder(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	links, err := traversal.SelectLinks(nb.Build())
+	if err != nil {
+		return nil, err
+	}
+	cids := make([]cid.Cid, 0, len(links))
+	for _, l := range links {
+		cl, ok := l.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type: %T", l)
+		}
+		cids = append(cids, cl.Cid)
+	}
+	return cids, nil
+}
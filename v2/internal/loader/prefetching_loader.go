@@ -0,0 +1,214 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/internal/links"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// prefetchWindowPerWorker is the number of blocks each prefetch worker may
+// hold loaded ahead of the reads that consume them.
+const prefetchWindowPerWorker = 8
+
+var errNotPrefetched = errors.New("block not prefetched")
+
+type prefetched struct {
+	done    chan struct{}
+	started bool
+	data    []byte
+	err     error
+}
+
+// Prefetcher loads blocks from a link system in parallel, ahead of the serial
+// reads of a traversal, so that the latency of slow loads is hidden. Blocks
+// are only ever handed to the traversal when it reads them, so the order in
+// which blocks are read, and hence written, is unchanged.
+//
+// A nil *Prefetcher is valid and prefetches nothing.
+type Prefetcher struct {
+	ls     ipld.LinkSystem
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan cid.Cid
+	wg     sync.WaitGroup
+	window int
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[cid.Cid]*prefetched
+	order   []cid.Cid
+	seen    map[cid.Cid]struct{}
+}
+
+// PrefetchingLinkSystem wraps an IPLD LinkSystem such that blocks queued via
+// Prefetcher.Prefetch are loaded by the given number of workers in the
+// background, and handed out when read from the returned LinkSystem. If
+// followLinks is set, the children of each block read are queued, as a
+// traversal is likely to read them next; links are only decoded for blocks of
+// codecs with a registered decoder.
+//
+// If workers is not positive, ls is returned unchanged along with a nil
+// Prefetcher. Otherwise, Close must be called once done reading to stop the
+// workers.
+func PrefetchingLinkSystem(ctx context.Context, ls ipld.LinkSystem, workers int, followLinks bool) (ipld.LinkSystem, *Prefetcher) {
+	if workers <= 0 {
+		return ls, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Prefetcher{
+		ls:      ls,
+		ctx:     ctx,
+		cancel:  cancel,
+		window:  workers * prefetchWindowPerWorker,
+		pending: make(map[cid.Cid]*prefetched),
+		seen:    make(map[cid.Cid]struct{}),
+	}
+	p.queue = make(chan cid.Cid, p.window)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	pls := ls
+	pls.StorageReadOpener = func(lc linking.LinkContext, l ipld.Link) (io.Reader, error) {
+		_, c, err := cid.CidFromBytes([]byte(l.Binary()))
+		if err != nil {
+			return nil, err
+		}
+		data, err := p.take(c)
+		if err != nil {
+			// The block was not prefetched, or failed to be; load it now such
+			// that any error is the one of the read itself.
+			if data, err = p.load(lc, l); err != nil {
+				return nil, err
+			}
+		}
+		if followLinks {
+			if decoder := links.Decoder(c); decoder != nil {
+				// Links are only a hint; a block that cannot be decoded is
+				// left to fail in the traversal.
+				if children, err := links.Decode(decoder, data); err == nil {
+					for _, child := range children {
+						p.Prefetch(child)
+					}
+				}
+			}
+		}
+		return bytes.NewReader(data), nil
+	}
+	return pls, p
+}
+
+// Prefetch queues the block with the given CID to be loaded in the
+// background, unless it has been queued or read before. The oldest prefetched
+// blocks that have not been read yet are dropped to make room if needed.
+func (p *Prefetcher) Prefetch(c cid.Cid) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if _, ok := p.seen[c]; ok {
+		return
+	}
+	for len(p.pending) >= p.window && len(p.order) > 0 {
+		delete(p.pending, p.order[0])
+		p.order = p.order[1:]
+	}
+	select {
+	case p.queue <- c:
+		p.seen[c] = struct{}{}
+		p.pending[c] = &prefetched{done: make(chan struct{})}
+		p.order = append(p.order, c)
+	default:
+		// The workers are saturated; the block will be loaded when read.
+	}
+}
+
+// Close stops the workers, discarding any blocks loaded but not read.
+func (p *Prefetcher) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.pending = nil
+	p.order = nil
+	close(p.queue)
+	p.mu.Unlock()
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *Prefetcher) work() {
+	defer p.wg.Done()
+	for c := range p.queue {
+		p.mu.Lock()
+		f, ok := p.pending[c]
+		if ok {
+			f.started = true
+		}
+		p.mu.Unlock()
+		if !ok || p.ctx.Err() != nil {
+			// Dropped before being loaded, or closed.
+			continue
+		}
+		f.data, f.err = p.load(linking.LinkContext{Ctx: p.ctx}, cidlink.Link{Cid: c})
+		close(f.done)
+	}
+}
+
+// take returns the data of the block with the given CID if it was queued,
+// waiting for it to be loaded.
+func (p *Prefetcher) take(c cid.Cid) ([]byte, error) {
+	p.mu.Lock()
+	// Mark the block as seen such that reading it is not followed by a
+	// pointless prefetch.
+	if !p.closed {
+		p.seen[c] = struct{}{}
+	}
+	f, ok := p.pending[c]
+	started := ok && f.started
+	if ok {
+		delete(p.pending, c)
+		for i, o := range p.order {
+			if o == c {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				break
+			}
+		}
+	}
+	p.mu.Unlock()
+	if !started {
+		return nil, errNotPrefetched
+	}
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+}
+
+func (p *Prefetcher) load(lc linking.LinkContext, l ipld.Link) ([]byte, error) {
+	r, err := p.ls.StorageReadOpener(lc, l)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -13,10 +13,11 @@ import (
 )
 
 type writerOutput struct {
-	w     io.Writer
-	size  uint64
-	code  multicodec.Code
-	rcrds map[cid.Cid]index.Record
+	w       io.Writer
+	size    uint64
+	code    multicodec.Code
+	rcrds   map[cid.Cid]index.SizedRecord
+	onBlock func() error
 }
 
 func (w *writerOutput) Size() uint64 {
@@ -28,11 +29,11 @@ func (w *writerOutput) Index() (index.Index, error) {
 	if err != nil {
 		return nil, err
 	}
-	rcrds := make([]index.Record, 0, len(w.rcrds))
+	rcrds := make([]index.SizedRecord, 0, len(w.rcrds))
 	for _, r := range w.rcrds {
 		rcrds = append(rcrds, r)
 	}
-	if err := idx.Load(rcrds); err != nil {
+	if err := index.LoadSizedRecords(idx, rcrds); err != nil {
 		return nil, err
 	}
 
@@ -71,12 +72,20 @@ func (w *writingReader) Read(p []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
-		w.wo.rcrds[c] = index.Record{
-			Cid:    c,
-			Offset: w.wo.size,
+		w.wo.rcrds[c] = index.SizedRecord{
+			Record: index.Record{
+				Cid:    c,
+				Offset: w.wo.size,
+			},
+			Size: uint64(w.len),
 		}
 		w.wo.size += uint64(w.len) + uint64(len(size)+len(w.cid))
 
+		if w.wo.onBlock != nil {
+			if err := w.wo.onBlock(); err != nil {
+				return 0, err
+			}
+		}
 		w.wo = nil
 	}
 
@@ -91,12 +100,14 @@ func (w *writingReader) Read(p []byte) (int, error) {
 //	included in the `.Size()` of the IndexTracker.
 //
 // An indexCodec of `index.CarIndexNoIndex` can be used to not track these offsets.
-func TeeingLinkSystem(ls ipld.LinkSystem, w io.Writer, initialOffset uint64, indexCodec multicodec.Code) (ipld.LinkSystem, IndexTracker) {
+// If non-nil, onBlock is called each time a complete CAR block has been written.
+func TeeingLinkSystem(ls ipld.LinkSystem, w io.Writer, initialOffset uint64, indexCodec multicodec.Code, onBlock func() error) (ipld.LinkSystem, IndexTracker) {
 	wo := writerOutput{
-		w:     w,
-		size:  initialOffset,
-		code:  indexCodec,
-		rcrds: make(map[cid.Cid]index.Record),
+		w:       w,
+		size:    initialOffset,
+		code:    indexCodec,
+		rcrds:   make(map[cid.Cid]index.SizedRecord),
+		onBlock: onBlock,
 	}
 
 	tls := ls
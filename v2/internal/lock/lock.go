@@ -0,0 +1,9 @@
+// Package lock implements advisory locks on files, used to keep processes from
+// writing to a CAR file while others read or write it.
+package lock
+
+import "errors"
+
+// ErrLocked is returned by Lock when a conflicting lock is held on the file
+// and waiting for it was not requested.
+var ErrLocked = errors.New("file is locked by another process")
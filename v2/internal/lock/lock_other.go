@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package lock
+
+import "os"
+
+// Lock is a no-op on platforms without file locking.
+func Lock(f *os.File, exclusive, wait bool) error {
+	return nil
+}
+
+// Unlock is a no-op on platforms without file locking.
+func Unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Locks on Windows are mandatory for the range of bytes they cover, so the
+// lock is taken on a single byte at the largest offset, past any data, which
+// makes it advisory.
+const (
+	lockOffset = ^uint32(0)
+	lockLength = 1
+)
+
+// Lock acquires a lock on f using LockFileEx, exclusive or shared. Unless
+// wait is set, ErrLocked is returned if a conflicting lock is held.
+func Lock(f *os.File, exclusive, wait bool) error {
+	var flags uint32
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	ol := windows.Overlapped{Offset: lockOffset, OffsetHigh: lockOffset}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, lockLength, 0, &ol)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, windows.ERROR_LOCK_VIOLATION):
+		return ErrLocked
+	default:
+		return &os.PathError{Op: "LockFileEx", Path: f.Name(), Err: err}
+	}
+}
+
+// Unlock releases the lock acquired on f by Lock.
+func Unlock(f *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffset, OffsetHigh: lockOffset}
+	if err := windows.UnlockFileEx(windows.Handle(f.Fd()), 0, lockLength, 0, &ol); err != nil {
+		return &os.PathError{Op: "UnlockFileEx", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-varint"
+)
+
+// Checkpoint appends the records added to an index to a sidecar file, such
+// that the index can be restored via LoadCheckpoint when resuming from the
+// CAR, instead of being regenerated by scanning the CAR.
+type Checkpoint struct {
+	f         *os.File
+	everyPuts uint64
+	interval  time.Duration
+	puts      uint64
+	last      time.Time
+}
+
+// LoadCheckpoint restores the index persisted to the checkpoint file at path
+// for the CARv1 payload read from backing, returning it along with the offset
+// past the last section it covers, from which the payload remains to be
+// scanned. Records past the last one whose section holds its block are
+// dropped, as are the bytes of a partially written record at the end of the
+// file, in which case the file is to be rewritten rather than appended to, as
+// reported by rewrite. If the file is missing, cannot be read, or no record
+// matches the payload, an empty index and a zero offset are returned.
+func LoadCheckpoint(path string, backing io.ReaderAt) (idx *index.InsertionIndex, scanFrom uint64, rewrite bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return index.NewInsertionIndex(), 0, true, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer f.Close()
+	idx, err = index.ReadRecordLog(bufio.NewReader(f))
+	torn := errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !torn {
+		return index.NewInsertionIndex(), 0, true, nil
+	}
+
+	var records []index.Record
+	if err := idx.ForEachCid(func(c cid.Cid, offset uint64) error {
+		records = append(records, index.Record{Cid: c, Offset: offset})
+		return nil
+	}); err != nil {
+		return nil, 0, false, err
+	}
+	if len(records) == 0 {
+		return idx, 0, torn, nil
+	}
+	slices.SortFunc(records, func(a, b index.Record) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	// The section of the last record kept must hold the block it names,
+	// which guards against a checkpoint of another CAR, or one which got
+	// ahead of the CAR on disk.
+	for i := len(records) - 1; i >= 0; i-- {
+		end, ok, err := sectionEnd(backing, records[i])
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if !ok {
+			continue
+		}
+		if i == len(records)-1 && !torn {
+			return idx, end, false, nil
+		}
+		idx = index.NewInsertionIndex()
+		if err := idx.Load(records[:i+1]); err != nil {
+			return nil, 0, false, err
+		}
+		return idx, end, true, nil
+	}
+	return index.NewInsertionIndex(), 0, true, nil
+}
+
+// sectionEnd returns the offset past the section of backing at the offset of
+// r, if it was entirely written and holds the block of r.
+func sectionEnd(backing io.ReaderAt, r index.Record) (uint64, bool, error) {
+	rs, err := internalio.NewOffsetReadSeeker(backing, int64(r.Offset))
+	if err != nil {
+		return 0, false, err
+	}
+	length, err := varint.ReadUvarint(rs)
+	if err != nil {
+		return 0, false, nil
+	}
+	if _, c, err := cid.CidFromReader(rs); err != nil || !c.Equals(r.Cid) {
+		return 0, false, nil
+	}
+	end := r.Offset + uint64(varint.UvarintSize(length)) + length
+	var b [1]byte
+	if _, err := backing.ReadAt(b[:], int64(end)-1); err != nil {
+		return 0, false, nil
+	}
+	return end, true, nil
+}
+
+// OpenCheckpoint opens the checkpoint file at path, to which the records of
+// idx at or past offset from are written straight away, along with any record
+// subsequently added to idx. The file is truncated first if rewrite is set,
+// in which case all the records of idx are written.
+func OpenCheckpoint(path string, idx *index.InsertionIndex, from uint64, rewrite bool, everyPuts uint64, interval time.Duration) (*Checkpoint, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if rewrite {
+		flag |= os.O_TRUNC
+		from = 0
+	}
+	f, err := os.OpenFile(path, flag, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("could not open index checkpoint: %w", err)
+	}
+	pending := index.NewInsertionIndex()
+	if err := idx.ForEachCid(func(c cid.Cid, offset uint64) error {
+		if offset >= from {
+			return pending.Add(index.Record{Cid: c, Offset: offset})
+		}
+		return nil
+	}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := pending.Flush(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not write index checkpoint: %w", err)
+	}
+	return &Checkpoint{f: f, everyPuts: everyPuts, interval: interval, last: time.Now()}, nil
+}
+
+// Put records that puts blocks were written and added to idx, writing the
+// records added since the last checkpoint if one is due.
+func (c *Checkpoint) Put(idx index.Writable, puts uint64) error {
+	c.puts += puts
+	due := c.everyPuts == 0 && c.interval == 0 ||
+		c.everyPuts > 0 && c.puts >= c.everyPuts ||
+		c.interval > 0 && time.Since(c.last) >= c.interval
+	if !due {
+		return nil
+	}
+	if _, err := idx.Flush(c.f); err != nil {
+		return fmt.Errorf("could not write index checkpoint: %w", err)
+	}
+	c.puts = 0
+	c.last = time.Now()
+	return nil
+}
+
+// Close closes the checkpoint file, removing it if remove is set.
+func (c *Checkpoint) Close(remove bool) error {
+	err := c.f.Close()
+	if remove {
+		if rerr := os.Remove(c.f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
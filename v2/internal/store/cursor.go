@@ -0,0 +1,60 @@
+package store
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Cursor remembers the offset of the section immediately following the last
+// section found in a CARv1 payload, so that a lookup of the CID stored in that
+// next section can be served without consulting the index. This benefits
+// consumers that read blocks in the order in which they appear in the CAR.
+//
+// Cursor is safe for concurrent use, though concurrent readers will naturally
+// reduce its hit rate.
+type Cursor struct {
+	next atomic.Int64
+}
+
+// NewCursor instantiates a new Cursor positioned at no particular section.
+func NewCursor() *Cursor {
+	c := &Cursor{}
+	c.next.Store(-1)
+	return c
+}
+
+// Find checks whether the section at the cursor position matches the given
+// key, in which case the data, data offset and data length are returned
+// similarly to FindCid, and the cursor is advanced to the following section.
+// Any failure to read the section at cursor position, including reaching the
+// end of the payload, is reported as a miss rather than an error so that the
+// caller can fall back on the index.
+func (c *Cursor) Find(
+	reader io.ReaderAt,
+	key cid.Cid,
+	useWholeCids bool,
+	zeroLenAsEOF bool,
+	maxReadBytes uint64,
+	readBytes bool,
+) ([]byte, int64, int, bool) {
+	next := c.next.Load()
+	if next < 0 {
+		return nil, -1, -1, false
+	}
+	readCid, data, dataOffset, dataLen, err := readSection(reader, next, zeroLenAsEOF, maxReadBytes, readBytes)
+	if err != nil || !matchesCid(readCid, key, useWholeCids) {
+		return nil, -1, -1, false
+	}
+	c.next.CompareAndSwap(next, dataOffset+int64(dataLen))
+	return data, dataOffset, dataLen, true
+}
+
+// Advance positions the cursor right after a section whose data was found at
+// the given data offset with the given length.
+func (c *Cursor) Advance(dataOffset int64, dataLen int) {
+	if dataOffset >= 0 && dataLen >= 0 {
+		c.next.Store(dataOffset + int64(dataLen))
+	}
+}
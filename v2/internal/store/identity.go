@@ -2,6 +2,7 @@ package store
 
 import (
 	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
 	"github.com/multiformats/go-multihash"
 )
 
@@ -15,3 +16,24 @@ func IsIdentity(key cid.Cid) (digest []byte, ok bool, err error) {
 	digest = dmh.Digest
 	return digest, ok, nil
 }
+
+// IdentityPolicy returns the identity CID policy resulting from the
+// StoreIdentityCIDs option.
+func IdentityPolicy(storeIdentityCIDs bool) carv2.IdentityCIDPolicy {
+	if storeIdentityCIDs {
+		return carv2.IdentityCIDsStored
+	}
+	return carv2.IdentityCIDsInlined
+}
+
+// InlineIdentity returns the digest of key, and true, if key is an IDENTITY
+// CID that is answered from the CID itself under the policy resulting from the
+// StoreIdentityCIDs option, rather than looked up in the CAR. It is used to
+// apply the same policy to Has, Get, GetSize and Put across the CAR
+// interfaces.
+func InlineIdentity(key cid.Cid, storeIdentityCIDs bool) (digest []byte, ok bool, err error) {
+	if IdentityPolicy(storeIdentityCIDs) != carv2.IdentityCIDsInlined {
+		return nil, false, nil
+	}
+	return IsIdentity(key)
+}
@@ -2,7 +2,9 @@ package store
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"os"
 
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
@@ -18,7 +20,9 @@ import (
 // of the block.
 func FindCid(
 	reader io.ReaderAt,
-	idx index.Index,
+	idx interface {
+		GetAll(cid.Cid, func(uint64) bool) error
+	},
 	key cid.Cid,
 	useWholeCids bool,
 	zeroLenAsEOF bool,
@@ -30,50 +34,37 @@ func FindCid(
 	var fnOffset int64
 	var fnLen int = -1
 	var fnErr error
-	err := idx.GetAll(key, func(offset uint64) bool {
-		reader, err := internalio.NewOffsetReadSeeker(reader, int64(offset))
+	// The offsets of a corrupt index may point past the end of a reader of
+	// known size, such as the section reader of a CARv2 data payload.
+	sizer, sized := reader.(interface{ Size() int64 })
+	fn := func(offset uint64) bool {
+		if sized && offset >= uint64(sizer.Size()) {
+			fnErr = fmt.Errorf("index offset %d is beyond the end of the data payload at %d", offset, sizer.Size())
+			return false
+		}
+		readCid, data, dataOffset, dataLen, err := readSection(reader, int64(offset), zeroLenAsEOF, maxReadBytes, readBytes)
 		if err != nil {
 			fnErr = err
 			return false
 		}
-		var readCid cid.Cid
-		if readBytes {
-			readCid, fnData, err = util.ReadNode(reader, zeroLenAsEOF, maxReadBytes)
-			if err != nil {
-				fnErr = err
-				return false
-			}
-			fnLen = len(fnData)
-		} else {
-			sectionLen, err := varint.ReadUvarint(reader)
-			if err != nil {
-				fnErr = err
-				return false
-			}
-			var cidLen int
-			cidLen, readCid, err = cid.CidFromReader(reader)
-			if err != nil {
-				fnErr = err
-				return false
-			}
-			fnLen = int(sectionLen) - cidLen
-			fnOffset = int64(offset) + reader.(interface{ Position() int64 }).Position()
+		if !matchesCid(readCid, key, useWholeCids) {
+			// weird, bad index, continue looking
+			return true
 		}
-		if useWholeCids {
-			if !readCid.Equals(key) {
-				fnLen = -1
-				return true // continue looking
-			}
-			return false
-		} else {
-			if !bytes.Equal(readCid.Hash(), key.Hash()) {
-				// weird, bad index, continue looking
-				fnLen = -1
-				return true
-			}
-			return false
+		fnData, fnOffset, fnLen = data, dataOffset, dataLen
+		return false
+	}
+	var err error
+	if di, ok := idx.(digestIndex); ok {
+		// Look up the digest in place rather than decoding the multihash.
+		var code uint64
+		var digest []byte
+		if code, digest, err = splitMultihash(key.Hash()); err == nil {
+			err = di.GetByDigest(code, digest, fn)
 		}
-	})
+	} else {
+		err = idx.GetAll(key, fn)
+	}
 	if err != nil {
 		return nil, -1, -1, err
 	}
@@ -86,14 +77,88 @@ func FindCid(
 	return fnData, fnOffset, fnLen, nil
 }
 
+// digestIndex is implemented by indexes which can be queried by multihash code
+// and digest, such as index.MultihashIndexSorted.
+type digestIndex interface {
+	GetByDigest(code uint64, digest []byte, fn func(uint64) bool) error
+}
+
+// splitMultihash returns the code and digest of mh without copying the digest.
+func splitMultihash(mh []byte) (uint64, []byte, error) {
+	code, n, err := varint.FromUvarint(mh)
+	if err != nil {
+		return 0, nil, err
+	}
+	length, l, err := varint.FromUvarint(mh[n:])
+	if err != nil {
+		return 0, nil, err
+	}
+	digest := mh[n+l:]
+	if uint64(len(digest)) != length {
+		return 0, nil, fmt.Errorf("malformed multihash; digest length %d does not match %d", len(digest), length)
+	}
+	return code, digest, nil
+}
+
+// readSection reads the section starting at the given offset, returning its
+// CID along with the offset and length of its data. The data bytes are only
+// read when readBytes is set.
+func readSection(
+	reader io.ReaderAt,
+	offset int64,
+	zeroLenAsEOF bool,
+	maxReadBytes uint64,
+	readBytes bool,
+) (cid.Cid, []byte, int64, int, error) {
+	rs, err := internalio.NewOffsetReadSeeker(reader, offset)
+	if err != nil {
+		return cid.Undef, nil, -1, -1, err
+	}
+	if readBytes {
+		readCid, data, err := util.ReadNode(rs, zeroLenAsEOF, maxReadBytes)
+		if err != nil {
+			return cid.Undef, nil, -1, -1, err
+		}
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return cid.Undef, nil, -1, -1, err
+		}
+		return readCid, data, offset + pos - int64(len(data)), len(data), nil
+	}
+	sectionLen, err := util.LdReadSize(rs, zeroLenAsEOF, maxReadBytes)
+	if err != nil {
+		return cid.Undef, nil, -1, -1, err
+	}
+	cidLen, readCid, err := cid.CidFromReader(rs)
+	if err != nil {
+		return cid.Undef, nil, -1, -1, err
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return cid.Undef, nil, -1, -1, err
+	}
+	return readCid, nil, offset + pos, int(sectionLen) - cidLen, nil
+}
+
+func matchesCid(readCid, key cid.Cid, useWholeCids bool) bool {
+	if useWholeCids {
+		return readCid.Equals(key)
+	}
+	return bytes.Equal(readCid.Hash(), key.Hash())
+}
+
 // Finalize will write the index to the writer at the offset specified in the header. It should only
-// be used for a CARv2 and when the CAR interface is being closed.
+// be used for a CARv2 and when the CAR interface is being closed. If indexCodec is
+// index.CarIndexNone, only the header is written, with a zero index offset.
 func Finalize(writer io.WriterAt, header carv2.Header, idx *index.InsertionIndex, dataSize uint64, storeIdentityCIDs bool, indexCodec multicodec.Code) error {
-	// TODO check if add index option is set and don't write the index then set index offset to zero.
 	header = header.WithDataSize(dataSize)
 	header.Characteristics.SetFullyIndexed(storeIdentityCIDs)
 
-	// TODO if index not needed don't bother flattening it.
+	if indexCodec == index.CarIndexNone {
+		header.IndexOffset = 0
+		_, err := header.WriteTo(internalio.NewOffsetWriter(writer, carv2.PragmaSize))
+		return err
+	}
 	fi, err := idx.Flatten(indexCodec)
 	if err != nil {
 		return err
@@ -106,3 +171,38 @@ func Finalize(writer io.WriterAt, header carv2.Header, idx *index.InsertionIndex
 	}
 	return nil
 }
+
+// WriteDetachedIndex flattens the index using the given codec and writes it to
+// a file at path, replacing any existing file. It is used to persist the index
+// of a CARv1 written via the CAR interfaces, which is otherwise discarded. If
+// sync is set, the file is flushed to stable storage before returning.
+func WriteDetachedIndex(path string, idx *index.InsertionIndex, indexCodec multicodec.Code, sync bool) (err error) {
+	fi, err := idx.Flatten(indexCodec)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create detached index file: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if _, err = index.WriteTo(fi, f); err != nil || !sync {
+		return err
+	}
+	return Sync(f)
+}
+
+// IsSizedIndexCodec reports whether indices of the given codec record the size
+// of the blocks they index, i.e. implement index.SizedIndex.
+func IsSizedIndexCodec(codec multicodec.Code) bool {
+	idx, err := index.New(codec)
+	if err != nil {
+		return false
+	}
+	_, ok := idx.(index.SizedIndex)
+	return ok
+}
@@ -3,7 +3,6 @@ package store
 import (
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
-	"github.com/ipld/go-car/v2/index"
 )
 
 // ShouldPut returns true if the block should be put into the CAR according to the options provided
@@ -11,7 +10,7 @@ import (
 // is an identity block and StoreIdentityCIDs is false, or because it already exists and
 // BlockstoreAllowDuplicatePuts is false.
 func ShouldPut(
-	idx *index.InsertionIndex,
+	idx LookupIndex,
 	c cid.Cid,
 	maxIndexCidSize uint64,
 	storeIdentityCIDs bool,
@@ -20,13 +19,11 @@ func ShouldPut(
 ) (bool, error) {
 
 	// If StoreIdentityCIDs option is disabled then treat IDENTITY CIDs like IdStore.
-	if !storeIdentityCIDs {
-		// Check for IDENTITY CID. If IDENTITY, ignore and move to the next block.
-		if _, ok, err := IsIdentity(c); err != nil {
-			return false, err
-		} else if ok {
-			return false, nil
-		}
+	// Check for IDENTITY CID. If IDENTITY, ignore and move to the next block.
+	if _, ok, err := InlineIdentity(c, storeIdentityCIDs); err != nil {
+		return false, err
+	} else if ok {
+		return false, nil
 	}
 
 	// Check if its size is too big.
@@ -61,7 +58,7 @@ func ShouldPut(
 // rules associated with the options. Similar to ShouldPut, but for the simpler
 // Has() case.
 func Has(
-	idx *index.InsertionIndex,
+	idx LookupIndex,
 	c cid.Cid,
 	maxIndexCidSize uint64,
 	storeIdentityCIDs bool,
@@ -70,12 +67,17 @@ func Has(
 ) (bool, error) {
 
 	// If StoreIdentityCIDs option is disabled then treat IDENTITY CIDs like IdStore.
-	if !storeIdentityCIDs {
-		if _, ok, err := IsIdentity(c); err != nil {
-			return false, err
-		} else if ok {
-			return true, nil
-		}
+	if _, ok, err := InlineIdentity(c, storeIdentityCIDs); err != nil {
+		return false, err
+	} else if ok {
+		return true, nil
+	}
+
+	// A CID too large to be indexed cannot have been put, which is reported as
+	// such rather than as a missing block, as ShouldPut does.
+	cSize := uint64(len(c.Bytes()))
+	if cSize > maxIndexCidSize {
+		return false, &carv2.ErrCidTooLarge{MaxSize: maxIndexCidSize, CurrentSize: cSize}
 	}
 
 	if blockstoreUseWholeCIDs {
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// LazyIndex is an index over a CARv1 payload which is populated incrementally
+// as lookups miss, by scanning forward from the last indexed section until the
+// looked up CID is found or the end of the payload is reached. This amortizes
+// the cost of indexing for workloads that only touch a few blocks.
+//
+// LazyIndex is safe for concurrent use; lookups are serialized.
+type LazyIndex struct {
+	mu  sync.Mutex
+	idx *index.InsertionIndex
+	// next is the offset of the first section not yet indexed, or -1 once the
+	// whole payload has been indexed.
+	next int64
+	// scan reads the sections being indexed, in windows of the read-ahead size
+	// if one is set.
+	scan io.ReaderAt
+}
+
+// NewLazyIndex instantiates a new LazyIndex over the given CARv1 payload,
+// positioned at the first section following its header. If readAhead is
+// positive, the payload is scanned in windows of that many bytes.
+func NewLazyIndex(reader io.ReaderAt, maxHeaderSize uint64, readAhead int) (*LazyIndex, error) {
+	rs, err := internalio.NewOffsetReadSeeker(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := carv1.ReadHeader(rs, maxHeaderSize); err != nil {
+		return nil, fmt.Errorf("error reading car header: %w", err)
+	}
+	next, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &LazyIndex{
+		idx:  index.NewInsertionIndex(),
+		next: next,
+		scan: internalio.NewReadAheadReaderAt(reader, readAhead),
+	}, nil
+}
+
+// Index returns the records indexed so far. It is updated by subsequent
+// lookups, and thus must not be used concurrently with them; see Snapshot.
+func (l *LazyIndex) Index() index.Index {
+	return l.idx
+}
+
+// Snapshot returns a copy of the records indexed so far, which may be used
+// concurrently with subsequent lookups.
+func (l *LazyIndex) Snapshot() index.Index {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snapshot := index.NewInsertionIndex()
+	_ = l.idx.ForEachSizedRecord(func(r index.SizedRecord) error {
+		snapshot.InsertSizedNoReplace(r.Cid, r.Offset, r.Size)
+		return nil
+	})
+	return snapshot
+}
+
+// FindCid behaves like FindCid, indexing further sections of the payload if
+// the key is not found among the ones indexed so far.
+func (l *LazyIndex) FindCid(
+	reader io.ReaderAt,
+	key cid.Cid,
+	opts carv2.Options,
+	readBytes bool,
+) ([]byte, int64, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, offset, size, err := FindCid(reader, l.idx, key, opts.BlockstoreUseWholeCIDs, opts.ZeroLengthSectionAsEOF, opts.MaxAllowedSectionSize, readBytes)
+	if err != index.ErrNotFound {
+		return data, offset, size, err
+	}
+	for l.next >= 0 {
+		sectionOffset := l.next
+		found, err := l.indexNext(key, opts)
+		if err != nil {
+			return nil, -1, -1, err
+		}
+		if found {
+			_, data, offset, size, err := readSection(reader, sectionOffset, opts.ZeroLengthSectionAsEOF, opts.MaxAllowedSectionSize, readBytes)
+			if err != nil {
+				return nil, -1, -1, err
+			}
+			return data, offset, size, nil
+		}
+	}
+	return nil, -1, -1, index.ErrNotFound
+}
+
+// indexNext indexes the section at l.next and advances past it, returning
+// whether the section holds the given key.
+func (l *LazyIndex) indexNext(key cid.Cid, opts carv2.Options) (bool, error) {
+	rs, err := internalio.NewOffsetReadSeeker(l.scan, l.next)
+	if err != nil {
+		return false, err
+	}
+	sectionLen, err := varint.ReadUvarint(rs)
+	if err == io.EOF {
+		l.next = -1
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	// Null padding; by default it's an error.
+	if sectionLen == 0 {
+		if opts.ZeroLengthSectionAsEOF {
+			l.next = -1
+			return false, nil
+		}
+		return false, fmt.Errorf("carv1 null padding not allowed by default; see ZeroLengthSectionAsEOF")
+	}
+	cidLen, c, err := cid.CidFromReader(rs)
+	if err != nil {
+		return false, err
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	sectionOffset := l.next
+	found := false
+	if opts.StoreIdentityCIDs || c.Prefix().MhType != multihash.IDENTITY {
+		if uint64(cidLen) > opts.MaxIndexCidSize {
+			return false, &carv2.ErrCidTooLarge{MaxSize: opts.MaxIndexCidSize, CurrentSize: uint64(cidLen)}
+		}
+		l.idx.InsertSizedNoReplace(c, uint64(sectionOffset), sectionLen-uint64(cidLen))
+		found = matchesCid(c, key, opts.BlockstoreUseWholeCIDs)
+	}
+	l.next = l.next + pos + int64(sectionLen) - int64(cidLen)
+	return found, nil
+}
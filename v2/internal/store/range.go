@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+	"io"
+)
+
+// ClipRange checks the range of length bytes from offset into a block of the
+// given size, returning its length clipped to the end of the block.
+func ClipRange(size int, offset, length int64) (int64, error) {
+	if offset < 0 || length < 0 {
+		return 0, fmt.Errorf("invalid range of %d bytes at offset %d", length, offset)
+	}
+	if offset > int64(size) {
+		return 0, fmt.Errorf("range offset %d is beyond the block size of %d", offset, size)
+	}
+	return min(length, int64(size)-offset), nil
+}
+
+// ReadRange reads the range of length bytes from offset into the data of a
+// block of the given size, found at dataOffset of r, clipped to the end of the
+// block. Only the bytes of the range are read.
+func ReadRange(r io.ReaderAt, dataOffset int64, size int, offset, length int64) ([]byte, error) {
+	length, err := ClipRange(size, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, dataOffset+offset)
+	if n == len(buf) {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		// The CAR is truncated within the block.
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
@@ -58,6 +58,7 @@ func Resume(
 	v1 bool,
 	maxAllowedHeaderSize uint64,
 	zeroLengthSectionAsEOF bool,
+	scanFrom uint64,
 ) error {
 
 	var headerInFile carv2.Header
@@ -155,11 +156,21 @@ func Resume(
 	if err != nil {
 		return err
 	}
+	// The sections before scanFrom are already in idx, e.g. restored from a
+	// checkpoint; only the remaining ones are indexed.
+	offset = max(offset, scanFrom)
 	sectionOffset := int64(0)
 	if sectionOffset, err = v1r.Seek(int64(offset), io.SeekStart); err != nil {
 		return err
 	}
 
+	// A CARv1 has no header recording the size of its payload, such that the
+	// last section may have been partially written, e.g. due to a crash while
+	// putting its block. Such a section is removed, and written over.
+	torn := func(err error) bool {
+		return v1 && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF))
+	}
+	var partial bool
 	for {
 		// Grab the length of the section.
 		// Note that ReadUvarint wants a ByteReader.
@@ -168,6 +179,10 @@ func Resume(
 			if err == io.EOF {
 				break
 			}
+			if torn(err) {
+				partial = true
+				break
+			}
 			return err
 		}
 
@@ -183,9 +198,24 @@ func Resume(
 		// Grab the CID.
 		n, c, err := cid.CidFromReader(v1r)
 		if err != nil {
+			if torn(err) {
+				partial = true
+				break
+			}
 			return err
 		}
-		idx.InsertNoReplace(c, uint64(sectionOffset))
+		if v1 {
+			var b [1]byte
+			end := sectionOffset + int64(varint.UvarintSize(length)) + int64(length)
+			if _, err := v1r.ReadAt(b[:], end-1); err != nil {
+				if torn(err) {
+					partial = true
+					break
+				}
+				return err
+			}
+		}
+		idx.InsertSizedNoReplace(c, uint64(sectionOffset), length-uint64(n))
 
 		// Seek to the next section by skipping the block.
 		// The section length includes the CID, so subtract it.
@@ -193,6 +223,15 @@ func Resume(
 			return err
 		}
 	}
+	if partial {
+		t, ok := rw.(interface{ Truncate(size int64) error })
+		if !ok {
+			return fmt.Errorf("cannot resume from a CARv1 with a partially written section at offset %d without the ability to truncate (e.g. an io.File)", sectionOffset)
+		}
+		if err := t.Truncate(sectionOffset); err != nil {
+			return err
+		}
+	}
 	// Seek to the end of last skipped block where the writer should resume writing.
 	_, err = dataWriter.Seek(sectionOffset, io.SeekStart)
 	return err
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/ipld/go-car/v2/internal/links"
+)
+
+// shiftBufferSize is the size of the chunks in which ShiftSections moves data.
+const shiftBufferSize = 1 << 20
+
+// DetectRoots reads the CARv1 data payload from r and returns the CIDs of the
+// blocks that are not linked to by any other block, in the order in which they
+// appear. Links are only followed for blocks of codecs with a registered
+// decoder.
+func DetectRoots(r io.Reader, opts carv2.Options) ([]cid.Cid, error) {
+	if _, err := carv1.ReadHeader(r, opts.MaxAllowedHeaderSize); err != nil {
+		return nil, err
+	}
+	var present []cid.Cid
+	seen := make(map[string]struct{})
+	linked := make(map[string]struct{})
+	for {
+		c, data, err := util.ReadNode(r, opts.ZeroLengthSectionAsEOF, opts.MaxAllowedSectionSize)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if _, ok := seen[string(c.Hash())]; !ok {
+			seen[string(c.Hash())] = struct{}{}
+			present = append(present, c)
+		}
+		if decoder := links.Decoder(c); decoder != nil {
+			ls, err := links.Decode(decoder, data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode block %s: %w", c, err)
+			}
+			for _, l := range ls {
+				linked[string(l.Hash())] = struct{}{}
+			}
+		}
+	}
+
+	roots := present[:0]
+	for _, c := range present {
+		if _, ok := linked[string(c.Hash())]; !ok {
+			roots = append(roots, c)
+		}
+	}
+	return roots, nil
+}
+
+// ShiftSections moves the bytes of rw between offsets start and end by delta
+// bytes, which may be negative, such that they are not overwritten before
+// being moved.
+func ShiftSections(rw ReaderWriterAt, start, end, delta int64) error {
+	if delta == 0 || start == end {
+		return nil
+	}
+	if start+delta < 0 {
+		return errors.New("cannot shift sections before the start of the file")
+	}
+	buf := make([]byte, min(shiftBufferSize, end-start))
+	move := func(off int64) error {
+		n := min(int64(len(buf)), end-off)
+		if m, err := rw.ReadAt(buf[:n], off); int64(m) != n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		_, err := rw.WriteAt(buf[:n], off+delta)
+		return err
+	}
+	if delta > 0 {
+		// Move the chunks from last to first when shifting forward.
+		for off := end - (end-start-1)%int64(len(buf)) - 1; off >= start; off -= int64(len(buf)) {
+			if err := move(off); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for off := start; off < end; off += int64(len(buf)) {
+		if err := move(off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ LookupIndex = (*Snapshot)(nil)
+
+// Snapshot is an immutable view of the records of an index, which is safe for
+// concurrent lookups without locking. A Snapshot is never modified; instead,
+// With returns a new Snapshot including additional records, allowing writers
+// to publish a new Snapshot after each batch of writes, e.g. via an
+// atomic.Pointer, while readers keep using the one they loaded.
+//
+// Records are kept in sorted runs whose sizes at least double from the newest
+// run to the oldest one, such that a Snapshot holds a logarithmic number of
+// runs and each record is copied a logarithmic number of times as records are
+// added.
+type Snapshot struct {
+	runs [][]snapshotRecord
+}
+
+type snapshotRecord struct {
+	mh     []byte
+	cid    cid.Cid
+	offset uint64
+}
+
+func (r snapshotRecord) less(other snapshotRecord) bool {
+	if c := bytes.Compare(r.mh, other.mh); c != 0 {
+		return c < 0
+	}
+	return r.offset < other.offset
+}
+
+// With returns a new Snapshot holding the records of s along with the given
+// records. s may be nil, in which case the Snapshot only holds the given
+// records.
+func (s *Snapshot) With(records []index.Record) *Snapshot {
+	var runs [][]snapshotRecord
+	if s != nil {
+		runs = append(runs, s.runs...)
+	}
+	if len(records) == 0 {
+		return &Snapshot{runs: runs}
+	}
+
+	run := make([]snapshotRecord, len(records))
+	for i, r := range records {
+		run[i] = snapshotRecord{mh: r.Cid.Hash(), cid: r.Cid, offset: r.Offset}
+	}
+	sort.Slice(run, func(i, j int) bool { return run[i].less(run[j]) })
+	runs = append(runs, run)
+
+	for len(runs) > 1 && len(runs[len(runs)-2]) <= 2*len(runs[len(runs)-1]) {
+		merged := mergeRuns(runs[len(runs)-2], runs[len(runs)-1])
+		runs = append(runs[:len(runs)-2], merged)
+	}
+	return &Snapshot{runs: runs}
+}
+
+func mergeRuns(a, b []snapshotRecord) []snapshotRecord {
+	merged := make([]snapshotRecord, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].less(a[0]) {
+			merged = append(merged, b[0])
+			b = b[1:]
+		} else {
+			merged = append(merged, a[0])
+			a = a[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// forEachMatch calls f with the records whose multihash equals mh, run by
+// run, until f returns false.
+func (s *Snapshot) forEachMatch(mh []byte, f func(snapshotRecord) bool) {
+	for _, run := range s.runs {
+		i := sort.Search(len(run), func(i int) bool { return bytes.Compare(run[i].mh, mh) >= 0 })
+		for ; i < len(run) && bytes.Equal(run[i].mh, mh); i++ {
+			if !f(run[i]) {
+				return
+			}
+		}
+	}
+}
+
+// GetAll calls fn with the offset of each record whose multihash matches that
+// of the given CID, until fn returns false, or returns index.ErrNotFound if
+// there is no such record.
+func (s *Snapshot) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	found := false
+	s.forEachMatch(c.Hash(), func(r snapshotRecord) bool {
+		found = true
+		return fn(r.offset)
+	})
+	if !found {
+		return index.ErrNotFound
+	}
+	return nil
+}
+
+// Get returns the offset of the first record whose multihash matches that of
+// the given CID, or index.ErrNotFound if there is no such record.
+func (s *Snapshot) Get(c cid.Cid) (uint64, error) {
+	var offset uint64
+	err := s.GetAll(c, func(o uint64) bool {
+		offset = o
+		return false
+	})
+	return offset, err
+}
+
+// HasExactCID reports whether the Snapshot holds a record for the given CID.
+func (s *Snapshot) HasExactCID(c cid.Cid) (bool, error) {
+	found := false
+	s.forEachMatch(c.Hash(), func(r snapshotRecord) bool {
+		found = r.cid.Equals(c)
+		return !found
+	})
+	return found, nil
+}
+
+// HasMultihash reports whether the Snapshot holds a record for the given
+// multihash.
+func (s *Snapshot) HasMultihash(mh multihash.Multihash) (bool, error) {
+	found := false
+	s.forEachMatch(mh, func(snapshotRecord) bool {
+		found = true
+		return false
+	})
+	return found, nil
+}
@@ -0,0 +1,563 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// spillFenceEvery is the number of records of a run between two fences, i.e.
+// records whose digest and position are held in memory to look up the run.
+const spillFenceEvery = 64
+
+// LookupIndex is implemented by the indexes of the blocks written to a CAR,
+// i.e. an index.InsertionIndex, or a SpillIndex along with its records yet to
+// be spilled.
+type LookupIndex interface {
+	Get(cid.Cid) (uint64, error)
+	GetAll(cid.Cid, func(uint64) bool) error
+	HasExactCID(cid.Cid) (bool, error)
+	HasMultihash(multihash.Multihash) (bool, error)
+}
+
+// SpillIndex holds the records of an index.InsertionIndex which were spilled
+// to disk once it grew to a given number of records, such that the index of a
+// CAR with many blocks need not be held in memory while the CAR is written.
+//
+// Each spill writes the records to a run file in a temporary directory, in
+// the order of the InsertionIndex and in the format read by
+// index.ReadRecordLog. The runs are merged into the index of the CAR when it
+// is finalized.
+type SpillIndex struct {
+	dir     string
+	max     uint64
+	pending uint64
+	runs    []*spillRun
+}
+
+// spillRun is a run file, along with the fences to look it up by digest.
+type spillRun struct {
+	f      *os.File
+	size   int64
+	fences []spillFence
+}
+
+type spillFence struct {
+	digest []byte
+	pos    int64
+}
+
+// NewSpillIndex creates a SpillIndex writing its runs to a new temporary
+// directory under dir, or under the default directory for temporary files if
+// dir is empty, which spills records once maxRecords of them are pending.
+// The directory is removed by Close.
+func NewSpillIndex(dir string, maxRecords uint64) (*SpillIndex, error) {
+	if maxRecords == 0 {
+		return nil, errors.New("index spill must allow at least one record in memory")
+	}
+	d, err := os.MkdirTemp(dir, "car-index-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create index spill directory: %w", err)
+	}
+	return &SpillIndex{dir: d, max: maxRecords}, nil
+}
+
+// Put records that a record was inserted into mem, which is spilled if it now
+// holds the maximum number of records. The index to insert subsequent records
+// into is returned, i.e. mem or a new, empty one if mem was spilled.
+func (s *SpillIndex) Put(mem *index.InsertionIndex) (*index.InsertionIndex, error) {
+	s.pending++
+	if s.pending < s.max {
+		return mem, nil
+	}
+	if err := s.spill(mem); err != nil {
+		return nil, err
+	}
+	return index.NewInsertionIndex(), nil
+}
+
+// spill writes the records of mem to a new run.
+func (s *SpillIndex) spill(mem *index.InsertionIndex) error {
+	f, err := os.Create(filepath.Join(s.dir, "run-"+strconv.Itoa(len(s.runs))))
+	if err != nil {
+		return fmt.Errorf("could not create index spill run: %w", err)
+	}
+	run := &spillRun{f: f}
+	s.runs = append(s.runs, run)
+
+	w := bufio.NewWriter(f)
+	var buf []byte
+	var n uint64
+	if err := mem.ForEachCid(func(c cid.Cid, offset uint64) error {
+		if n%spillFenceEvery == 0 {
+			_, digest, err := splitMultihash(c.Hash())
+			if err != nil {
+				return err
+			}
+			run.fences = append(run.fences, spillFence{digest: digest, pos: run.size})
+		}
+		n++
+		k := c.Bytes()
+		buf = binary.AppendUvarint(buf[:0], uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, offset)
+		written, err := w.Write(buf)
+		run.size += int64(written)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not write index spill run: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not write index spill run: %w", err)
+	}
+	s.pending = 0
+	return nil
+}
+
+// forDigest calls fn with the records of the run whose digest is the given
+// one, in order, until fn returns false.
+func (r *spillRun) forDigest(digest []byte, fn func(cid.Cid, uint64) bool) error {
+	// Records with the digest may precede the first fence holding it.
+	i := sort.Search(len(r.fences), func(i int) bool {
+		return bytes.Compare(r.fences[i].digest, digest) >= 0
+	})
+	var start int64
+	if i > 0 {
+		start = r.fences[i-1].pos
+	}
+	it := newSpillIterator(r, start)
+	for {
+		c, offset, err := it.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, d, err := splitMultihash(c.Hash())
+		if err != nil {
+			return err
+		}
+		switch cmp := bytes.Compare(d, digest); {
+		case cmp > 0:
+			return nil
+		case cmp == 0:
+			if !fn(c, offset) {
+				return nil
+			}
+		}
+	}
+}
+
+// spillIterator reads the records of a run in order.
+type spillIterator struct {
+	r *bufio.Reader
+}
+
+func newSpillIterator(run *spillRun, start int64) *spillIterator {
+	return &spillIterator{r: bufio.NewReader(io.NewSectionReader(run.f, start, run.size-start))}
+}
+
+func (it *spillIterator) next() (cid.Cid, uint64, error) {
+	l, err := binary.ReadUvarint(it.r)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	k := make([]byte, l)
+	if _, err := io.ReadFull(it.r, k); err != nil {
+		return cid.Undef, 0, io.ErrUnexpectedEOF
+	}
+	c, err := cid.Cast(k)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	offset, err := binary.ReadUvarint(it.r)
+	if err != nil {
+		return cid.Undef, 0, io.ErrUnexpectedEOF
+	}
+	return c, offset, nil
+}
+
+// With returns a LookupIndex over the records of mem, which are yet to be
+// spilled, along with those spilled so far.
+func (s *SpillIndex) With(mem *index.InsertionIndex) LookupIndex {
+	return &spillLookup{s: s, mem: mem}
+}
+
+type spillLookup struct {
+	s   *SpillIndex
+	mem *index.InsertionIndex
+}
+
+// forDigest calls fn with the spilled records of the digest of mh, run by
+// run, until fn returns false.
+func (l *spillLookup) forDigest(mh multihash.Multihash, fn func(cid.Cid, uint64) bool) error {
+	_, digest, err := splitMultihash(mh)
+	if err != nil {
+		return err
+	}
+	done := false
+	for _, run := range l.s.runs {
+		if err := run.forDigest(digest, func(c cid.Cid, offset uint64) bool {
+			done = !fn(c, offset)
+			return !done
+		}); err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *spillLookup) Get(c cid.Cid) (uint64, error) {
+	if offset, err := l.mem.Get(c); !errors.Is(err, index.ErrNotFound) {
+		return offset, err
+	}
+	var offset uint64
+	found := false
+	if err := l.forDigest(c.Hash(), func(_ cid.Cid, o uint64) bool {
+		offset, found = o, true
+		return false
+	}); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, index.ErrNotFound
+	}
+	return offset, nil
+}
+
+func (l *spillLookup) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	found, done := false, false
+	err := l.mem.GetAll(c, func(offset uint64) bool {
+		found = true
+		done = !fn(offset)
+		return !done
+	})
+	if err != nil && !errors.Is(err, index.ErrNotFound) || done {
+		return err
+	}
+	if err := l.forDigest(c.Hash(), func(_ cid.Cid, offset uint64) bool {
+		found = true
+		return fn(offset)
+	}); err != nil {
+		return err
+	}
+	if !found {
+		return index.ErrNotFound
+	}
+	return nil
+}
+
+func (l *spillLookup) HasExactCID(c cid.Cid) (bool, error) {
+	if has, err := l.mem.HasExactCID(c); err != nil || has {
+		return has, err
+	}
+	found := false
+	err := l.forDigest(c.Hash(), func(existing cid.Cid, _ uint64) bool {
+		found = existing.Equals(c)
+		return !found
+	})
+	return found, err
+}
+
+func (l *spillLookup) HasMultihash(mh multihash.Multihash) (bool, error) {
+	if has, err := l.mem.HasMultihash(mh); err != nil || has {
+		return has, err
+	}
+	found := false
+	err := l.forDigest(mh, func(existing cid.Cid, _ uint64) bool {
+		found = bytes.Equal(existing.Hash(), mh)
+		return !found
+	})
+	return found, err
+}
+
+// Finalize is like the package-level Finalize, for the index made of the
+// records of mem along with those spilled so far. The built-in sorted index
+// codecs are written by merging the runs, one record at a time, whereas other
+// codecs require the whole index to be built in memory first.
+func (s *SpillIndex) Finalize(writer io.WriterAt, header carv2.Header, mem *index.InsertionIndex, dataSize uint64, storeIdentityCIDs bool, indexCodec multicodec.Code) error {
+	if indexCodec == index.CarIndexNone {
+		return Finalize(writer, header, mem, dataSize, storeIdentityCIDs, indexCodec)
+	}
+	// Spill mem regardless of the records pending, since it may also hold
+	// records it was resumed with.
+	if err := s.spill(mem); err != nil {
+		return err
+	}
+	if !index.Builtin(indexCodec) || indexCodec != multicodec.CarIndexSorted && indexCodec != multicodec.CarMultihashIndexSorted {
+		all, err := s.mergeAll()
+		if err != nil {
+			return err
+		}
+		return Finalize(writer, header, all, dataSize, storeIdentityCIDs, indexCodec)
+	}
+
+	header = header.WithDataSize(dataSize)
+	header.Characteristics.SetFullyIndexed(storeIdentityCIDs)
+	if err := s.writeSorted(writer, int64(header.IndexOffset), indexCodec == multicodec.CarMultihashIndexSorted); err != nil {
+		return err
+	}
+	if _, err := header.WriteTo(internalio.NewOffsetWriter(writer, carv2.PragmaSize)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Merged returns an index of the records of the runs along with those of mem,
+// which is spilled first. Unlike Finalize, the whole index is thus held in
+// memory.
+func (s *SpillIndex) Merged(mem *index.InsertionIndex) (*index.InsertionIndex, error) {
+	if err := s.spill(mem); err != nil {
+		return nil, err
+	}
+	return s.mergeAll()
+}
+
+// mergeAll returns an index of the records of the runs.
+func (s *SpillIndex) mergeAll() (*index.InsertionIndex, error) {
+	all := index.NewInsertionIndex()
+	if err := s.merge(func(c cid.Cid, offset uint64) error {
+		all.InsertNoReplace(c, offset)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// spillBucket identifies a bucket of a sorted index, i.e. the records of a
+// multihash code, if bucketed by code, and of a given width.
+type spillBucket struct {
+	code  uint64
+	width uint32
+}
+
+// bucketOf returns the bucket of the record of c, along with its digest.
+func bucketOf(c cid.Cid, byCode bool) (spillBucket, []byte, error) {
+	code, digest, err := splitMultihash(c.Hash())
+	if err != nil {
+		return spillBucket{}, nil, err
+	}
+	b := spillBucket{width: uint32(len(digest)) + 8}
+	if byCode {
+		b.code = code
+	}
+	return b, digest, nil
+}
+
+// writeSorted writes the runs merged as a sorted index at offset off of w,
+// i.e. as an index.MultihashIndexSorted if byCode is set, or else as the
+// index of multicodec.CarIndexSorted, as flattening an InsertionIndex would.
+//
+// A first pass over the runs sizes the buckets, whose position in the index is
+// then known, such that the merged records are written to their bucket as
+// they come.
+func (s *SpillIndex) writeSorted(w io.WriterAt, off int64, byCode bool) error {
+	counts := make(map[spillBucket]uint64)
+	for _, run := range s.runs {
+		it := newSpillIterator(run, 0)
+		for {
+			c, _, err := it.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			b, _, err := bucketOf(c, byCode)
+			if err != nil {
+				return err
+			}
+			counts[b]++
+		}
+	}
+	buckets := make([]spillBucket, 0, len(counts))
+	for b := range counts {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].code != buckets[j].code {
+			return buckets[i].code < buckets[j].code
+		}
+		return buckets[i].width < buckets[j].width
+	})
+
+	// Lay out the index, writing everything but the records of the buckets.
+	codec := multicodec.CarIndexSorted
+	if byCode {
+		codec = multicodec.CarMultihashIndexSorted
+	}
+	hdr := varint.ToUvarint(uint64(codec))
+	if byCode {
+		codes := 0
+		for i, b := range buckets {
+			if i == 0 || b.code != buckets[i-1].code {
+				codes++
+			}
+		}
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(codes))
+	} else {
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(buckets)))
+	}
+	writers := make(map[spillBucket]*bufio.Writer, len(buckets))
+	pos := off
+	for i, b := range buckets {
+		if byCode && (i == 0 || b.code != buckets[i-1].code) {
+			widths := 0
+			for _, other := range buckets[i:] {
+				if other.code != b.code {
+					break
+				}
+				widths++
+			}
+			hdr = binary.LittleEndian.AppendUint64(hdr, b.code)
+			hdr = binary.LittleEndian.AppendUint32(hdr, uint32(widths))
+		}
+		size := int64(counts[b]) * int64(b.width)
+		hdr = binary.LittleEndian.AppendUint32(hdr, b.width)
+		hdr = binary.LittleEndian.AppendUint64(hdr, uint64(size))
+		if _, err := w.WriteAt(hdr, pos); err != nil {
+			return err
+		}
+		pos += int64(len(hdr))
+		hdr = hdr[:0]
+		writers[b] = bufio.NewWriter(internalio.NewOffsetWriter(w, pos))
+		pos += size
+	}
+	if len(hdr) > 0 {
+		// There are no buckets, i.e. the index is empty.
+		if _, err := w.WriteAt(hdr, pos); err != nil {
+			return err
+		}
+	}
+
+	var rec []byte
+	if err := s.merge(func(c cid.Cid, offset uint64) error {
+		b, digest, err := bucketOf(c, byCode)
+		if err != nil {
+			return err
+		}
+		rec = append(rec[:0], digest...)
+		rec = binary.LittleEndian.AppendUint64(rec, offset)
+		_, err = writers[b].Write(rec)
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, bw := range writers {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge calls fn with the records of all runs, in the order of an
+// InsertionIndex holding them all.
+func (s *SpillIndex) merge(fn func(cid.Cid, uint64) error) error {
+	h := make(spillHeap, 0, len(s.runs))
+	for _, run := range s.runs {
+		head := &spillHead{it: newSpillIterator(run, 0)}
+		if ok, err := head.advance(); err != nil {
+			return err
+		} else if ok {
+			h = append(h, head)
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		head := h[0]
+		if err := fn(head.c, head.offset); err != nil {
+			return err
+		}
+		if ok, err := head.advance(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// spillHead is the next record of a run being merged.
+type spillHead struct {
+	it     *spillIterator
+	c      cid.Cid
+	mh     []byte
+	digest []byte
+	offset uint64
+}
+
+func (h *spillHead) advance() (bool, error) {
+	c, offset, err := h.it.next()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	mh := c.Hash()
+	_, digest, err := splitMultihash(mh)
+	if err != nil {
+		return false, err
+	}
+	h.c, h.mh, h.digest, h.offset = c, mh, digest, offset
+	return true, nil
+}
+
+// spillHeap orders the heads of runs as an InsertionIndex orders records: by
+// digest, multihash and offset.
+type spillHeap []*spillHead
+
+func (h spillHeap) Len() int { return len(h) }
+func (h spillHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].digest, h[j].digest); c != 0 {
+		return c < 0
+	}
+	if c := bytes.Compare(h[i].mh, h[j].mh); c != 0 {
+		return c < 0
+	}
+	return h[i].offset < h[j].offset
+}
+func (h spillHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *spillHeap) Push(x any)   { *h = append(*h, x.(*spillHead)) }
+func (h *spillHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Close closes the runs and removes the directory holding them.
+func (s *SpillIndex) Close() error {
+	var err error
+	for _, run := range s.runs {
+		if cerr := run.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.runs = nil
+	if rerr := os.RemoveAll(s.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
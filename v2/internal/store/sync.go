@@ -0,0 +1,42 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Sync flushes the writes made to w to stable storage if it has a Sync method,
+// as os.File does. The directory holding an os.File is synced as well, such
+// that a newly created file is durable under its name.
+func Sync(w any) error {
+	s, ok := w.(interface{ Sync() error })
+	if !ok {
+		return nil
+	}
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok {
+		return SyncDir(filepath.Dir(f.Name()))
+	}
+	return nil
+}
+
+// SyncDir flushes the entries of the given directory to stable storage.
+func SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories cannot be synced on Windows, where the entry of a file is
+		// flushed along with the file itself.
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,73 @@
+// Package ioutil provides the io adapters used by this module to read and
+// write CAR sections at offsets of an underlying file, for embedders
+// implementing their own CAR pipelines, e.g. reading the data payload of a
+// CARv2 or writing a CARv1 after room left for a CARv2 header.
+//
+// Unlike the internal packages it exposes, this package follows the semantic
+// versioning of the module: its API only changes in a backwards-compatible
+// manner within v2.
+package ioutil
+
+import (
+	"io"
+
+	internalio "github.com/ipld/go-car/v2/internal/io"
+)
+
+// ReadSeekerAt is the union of the reader interfaces a CAR section reader
+// provides.
+type ReadSeekerAt interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.ByteReader
+}
+
+// OffsetWriteSeeker is an io.WriteSeeker over an io.WriterAt, starting at a
+// given offset of it.
+type OffsetWriteSeeker interface {
+	io.WriteSeeker
+	// Position returns the current position relative to the initial offset,
+	// i.e. the number of bytes written if Seek was never called.
+	Position() int64
+}
+
+// NewOffsetReadSeeker returns a ReadSeekerAt reading from r starting at offset
+// off, such that its offset zero is off in r. Unlike an io.SectionReader, it
+// does not need the number of readable bytes: reads stop with io.EOF when r
+// does. For the same reason, seeking relative to io.SeekEnd is unsupported and
+// panics.
+//
+// Nesting offset read seekers adds up their offsets, rather than stacking them.
+func NewOffsetReadSeeker(r io.ReaderAt, off int64) (ReadSeekerAt, error) {
+	return internalio.NewOffsetReadSeeker(r, off)
+}
+
+// NewOffsetWriter returns an OffsetWriteSeeker writing to w starting at offset
+// off, such that its offset zero is off in w. Seeking relative to io.SeekEnd is
+// unsupported and panics.
+func NewOffsetWriter(w io.WriterAt, off int64) OffsetWriteSeeker {
+	return internalio.NewOffsetWriter(w, off)
+}
+
+// ToReadSeeker returns ra as an io.ReadSeeker, reading from its offset zero.
+// It is returned as is if it is one already. Otherwise, the returned reader is
+// safe for concurrent use, and seeking relative to io.SeekEnd fails.
+func ToReadSeeker(ra io.ReaderAt) io.ReadSeeker {
+	return internalio.ToReadSeeker(ra)
+}
+
+// ToReaderAt returns rs as an io.ReaderAt. It is returned as is if it is one
+// already. Otherwise, each ReadAt seeks rs to the offset read, under a lock,
+// such that the returned reader is safe for concurrent use as long as rs is
+// not used otherwise.
+func ToReaderAt(rs io.ReadSeeker) io.ReaderAt {
+	return internalio.ToReaderAt(rs)
+}
+
+// ToByteReader returns r as an io.ByteReader, which reads one byte at a time
+// from r if it is not one already. It does not buffer, such that r may still
+// be read from directly after ReadByte.
+func ToByteReader(r io.Reader) io.ByteReader {
+	return internalio.ToByteReader(r)
+}
@@ -0,0 +1,89 @@
+package ioutil_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipld/go-car/v2/ioutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetReadSeeker(t *testing.T) {
+	data := []byte("0123456789")
+	rs, err := ioutil.NewOffsetReadSeeker(bytes.NewReader(data), 3)
+	require.NoError(t, err)
+
+	got, err := io.ReadAll(rs)
+	require.NoError(t, err)
+	require.Equal(t, data[3:], got)
+
+	pos, err := rs.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), pos)
+	b, err := rs.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('5'), b)
+
+	buf := make([]byte, 2)
+	_, err = rs.ReadAt(buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("45"), buf)
+
+	// Nested offset read seekers add up their offsets.
+	nested, err := ioutil.NewOffsetReadSeeker(rs.(io.ReaderAt), 4)
+	require.NoError(t, err)
+	got, err = io.ReadAll(nested)
+	require.NoError(t, err)
+	require.Equal(t, data[7:], got)
+
+	require.Panics(t, func() { _, _ = rs.Seek(0, io.SeekEnd) })
+}
+
+func TestOffsetWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	w := ioutil.NewOffsetWriter(f, 4)
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), w.Position())
+	_, err = w.Seek(1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("Z"))
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []byte("\x00\x00\x00\x00aZc"), got)
+}
+
+func TestConverters(t *testing.T) {
+	data := []byte("0123456789")
+
+	// A reader which is neither a seeker nor a reader at.
+	ra := ioutil.ToReaderAt(ioutil.ToReadSeeker(readerAtOnly{bytes.NewReader(data)}))
+	buf := make([]byte, 3)
+	_, err := ra.ReadAt(buf, 5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("567"), buf)
+
+	r := bytes.NewReader(data)
+	require.Same(t, r, ioutil.ToByteReader(r))
+	br := ioutil.ToByteReader(io.MultiReader(bytes.NewReader(data)))
+	b, err := br.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('0'), b)
+}
+
+// readerAtOnly hides all but the io.ReaderAt methods of its reader.
+type readerAtOnly struct {
+	r io.ReaderAt
+}
+
+func (r readerAtOnly) ReadAt(p []byte, off int64) (int, error) {
+	return r.r.ReadAt(p, off)
+}
@@ -0,0 +1,111 @@
+//go:build go1.23
+
+package car
+
+import (
+	"io"
+	"iter"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// Blocks returns an iterator over the CID and data of the remaining blocks of
+// the CAR payload, as read by Next, for use with range-over-func:
+//
+//	for c, data := range br.Blocks() {
+//		...
+//	}
+//	if err := br.Err(); err != nil {
+//		...
+//	}
+//
+// Iteration ends at the end of the payload, or at the first error other than
+// io.EOF, which is then returned by Err.
+func (br *BlockReader) Blocks() iter.Seq2[cid.Cid, []byte] {
+	return func(yield func(cid.Cid, []byte) bool) {
+		br.iterErr = nil
+		for {
+			blk, err := br.Next()
+			if err != nil {
+				if err != io.EOF {
+					br.iterErr = err
+				}
+				return
+			}
+			if !yield(blk.Cid(), blk.RawData()) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error which ended the last iteration over Blocks, or nil if
+// it reached the end of the payload or was stopped early.
+func (br *BlockReader) Err() error {
+	return br.iterErr
+}
+
+// BlockSource is the interface of a reader of blocks, one at a time until it
+// returns io.EOF, as implemented by BlockReader and by the CarReader of go-car
+// v0.
+type BlockSource interface {
+	Next() (blocks.Block, error)
+}
+
+// BlocksOf adapts a BlockSource to an iterator over the CID and data of its
+// remaining blocks, like BlockReader.Blocks, such that code written against
+// either API can consume the other while migrating. Iteration ends when Next
+// returns an error; the returned function reports it, unless it was io.EOF.
+func BlocksOf(src BlockSource) (iter.Seq2[cid.Cid, []byte], func() error) {
+	var iterErr error
+	seq := func(yield func(cid.Cid, []byte) bool) {
+		iterErr = nil
+		for {
+			blk, err := src.Next()
+			if err != nil {
+				if err != io.EOF {
+					iterErr = err
+				}
+				return
+			}
+			if !yield(blk.Cid(), blk.RawData()) {
+				return
+			}
+		}
+	}
+	return seq, func() error { return iterErr }
+}
+
+var _ BlockSource = (*SeqBlockSource)(nil)
+
+// SeqBlockSource adapts an iterator over the CID and data of blocks, such as
+// BlockReader.Blocks, to a BlockSource. The data is not verified against the
+// CID, and errors which end the iteration, if any, are reported by its origin,
+// e.g. BlockReader.Err, rather than by Next.
+type SeqBlockSource struct {
+	next func() (cid.Cid, []byte, bool)
+	stop func()
+}
+
+// NewSeqBlockSource returns a SeqBlockSource reading the blocks of seq. It
+// must be closed if not read until io.EOF.
+func NewSeqBlockSource(seq iter.Seq2[cid.Cid, []byte]) *SeqBlockSource {
+	next, stop := iter.Pull2(seq)
+	return &SeqBlockSource{next: next, stop: stop}
+}
+
+// Next returns the next block of the iterator, or io.EOF once it is done.
+func (s *SeqBlockSource) Next() (blocks.Block, error) {
+	c, data, ok := s.next()
+	if !ok {
+		return nil, io.EOF
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// Close stops the iteration, after which Next returns io.EOF.
+func (s *SeqBlockSource) Close() error {
+	s.stop()
+	return nil
+}
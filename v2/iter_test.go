@@ -0,0 +1,117 @@
+//go:build go1.23
+
+package car_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockReaderBlocks(t *testing.T) {
+	for _, path := range []string{"testdata/sample-v1.car", "testdata/sample-wrapped-v2.car"} {
+		t.Run(path, func(t *testing.T) {
+			br, err := carv2.NewBlockReader(requireReaderFromPath(t, path))
+			require.NoError(t, err)
+			var want []cid.Cid
+			for {
+				blk, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				want = append(want, blk.Cid())
+			}
+
+			br, err = carv2.NewBlockReader(requireReaderFromPath(t, path))
+			require.NoError(t, err)
+			var got []cid.Cid
+			for c, data := range br.Blocks() {
+				hashed, err := c.Prefix().Sum(data)
+				require.NoError(t, err)
+				require.Equal(t, c, hashed)
+				got = append(got, c)
+			}
+			require.NoError(t, br.Err())
+			require.Equal(t, want, got)
+
+			// Iteration may be stopped early and resumed.
+			br, err = carv2.NewBlockReader(requireReaderFromPath(t, path))
+			require.NoError(t, err)
+			got = got[:0]
+			for c := range br.Blocks() {
+				got = append(got, c)
+				break
+			}
+			for c := range br.Blocks() {
+				got = append(got, c)
+			}
+			require.NoError(t, br.Err())
+			require.Equal(t, want, got)
+		})
+	}
+
+	//                               header                             cid                                                                          data
+	corrupt, _ := hex.DecodeString("11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681caffffffffffffffffffff")
+	br, err := carv2.NewBlockReader(bytes.NewReader(corrupt))
+	require.NoError(t, err)
+	for range br.Blocks() {
+		t.Fatal("unexpected block")
+	}
+	require.ErrorContains(t, br.Err(), "mismatch in content integrity")
+}
+
+func TestBlockSourceAdapters(t *testing.T) {
+	br, err := carv2.NewBlockReader(requireReaderFromPath(t, "testdata/sample-v1.car"))
+	require.NoError(t, err)
+	var want []cid.Cid
+	for c := range br.Blocks() {
+		want = append(want, c)
+	}
+	require.NoError(t, br.Err())
+
+	// From blocks to an iterator, and back.
+	br, err = carv2.NewBlockReader(requireReaderFromPath(t, "testdata/sample-v1.car"))
+	require.NoError(t, err)
+	seq, seqErr := carv2.BlocksOf(br)
+	src := carv2.NewSeqBlockSource(seq)
+	var got []cid.Cid
+	for {
+		blk, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		hashed, err := blk.Cid().Prefix().Sum(blk.RawData())
+		require.NoError(t, err)
+		require.Equal(t, blk.Cid(), hashed)
+		got = append(got, blk.Cid())
+	}
+	require.NoError(t, seqErr())
+	require.Equal(t, want, got)
+
+	// Closing a source stops it early.
+	br, err = carv2.NewBlockReader(requireReaderFromPath(t, "testdata/sample-v1.car"))
+	require.NoError(t, err)
+	src = carv2.NewSeqBlockSource(br.Blocks())
+	_, err = src.Next()
+	require.NoError(t, err)
+	require.NoError(t, src.Close())
+	_, err = src.Next()
+	require.Equal(t, io.EOF, err)
+
+	// Errors other than io.EOF end the iteration and are reported.
+	corrupt, _ := hex.DecodeString("11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681caffffffffffffffffffff")
+	br, err = carv2.NewBlockReader(bytes.NewReader(corrupt))
+	require.NoError(t, err)
+	seq, seqErr = carv2.BlocksOf(br)
+	for range seq {
+		t.Fatal("unexpected block")
+	}
+	require.ErrorContains(t, seqErr(), "mismatch in content integrity")
+}
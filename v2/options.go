@@ -1,9 +1,14 @@
 package car
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"math"
+	"time"
 
 	"github.com/ipld/go-car/v2/index"
+	ipld "github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/traversal"
 	"github.com/multiformats/go-multicodec"
 
@@ -20,7 +25,7 @@ const DefaultMaxIndexCidSize = 2 << 10 // 2 KiB
 // Currently set to 32 MiB.
 const DefaultMaxAllowedHeaderSize = carv1.DefaultMaxAllowedHeaderSize
 
-// DefaultMaxAllowedHeaderSize specifies the default maximum size that a CARv1
+// DefaultMaxAllowedSectionSize specifies the default maximum size that a CARv1
 // decode (including within a CARv2 container) will allow a section to be
 // without erroring. This is to prevent OOM errors where a section prefix
 // includes a too-large size specifier.
@@ -29,6 +34,32 @@ const DefaultMaxAllowedHeaderSize = carv1.DefaultMaxAllowedHeaderSize
 // Currently set to 8 MiB.
 const DefaultMaxAllowedSectionSize = carv1.DefaultMaxAllowedSectionSize
 
+// ConservativeMaxAllowedHeaderSize is the maximum header size set by
+// ConservativeOptions. At 1 MiB, it fits the tens of thousands of roots of even
+// the largest CARs seen in practice.
+const ConservativeMaxAllowedHeaderSize = 1 << 20 // 1 MiB
+
+// ConservativeMaxAllowedSectionSize is the maximum section size set by
+// ConservativeOptions. It fits a block of 2 MiB, the largest that can be
+// exchanged over Bitswap and thus the largest most producers emit, along with a
+// CID of up to DefaultMaxIndexCidSize.
+const ConservativeMaxAllowedSectionSize = 2<<20 + DefaultMaxIndexCidSize
+
+// PermissiveMaxAllowedHeaderSize is the maximum header size set by
+// PermissiveOptions.
+const PermissiveMaxAllowedHeaderSize = 128 << 20 // 128 MiB
+
+// PermissiveMaxAllowedSectionSize is the maximum section size set by
+// PermissiveOptions. It fits the unusually large blocks of DAGs built with
+// non-standard chunkers, while still bounding the memory a single section can
+// make a reader allocate.
+const PermissiveMaxAllowedSectionSize = 128 << 20 // 128 MiB
+
+// DefaultStreamingVerificationBufferSize is the size of the buffer through
+// which block data is streamed into hash functions when verifying blocks
+// without reading them into memory, unless set via WithStreamingVerification.
+const DefaultStreamingVerificationBufferSize = 32 << 10 // 32 KiB
+
 // Option describes an option which affects behavior when interacting with CAR files.
 type Option func(*Options)
 
@@ -54,13 +85,47 @@ type Options struct {
 	ZeroLengthSectionAsEOF bool
 	MaxIndexCidSize        uint64
 	StoreIdentityCIDs      bool
+	AllowIndexOnly         bool
+	InsertRange            bool
 
-	BlockstoreAllowDuplicatePuts bool
-	BlockstoreUseWholeCIDs       bool
-	MaxTraversalLinks            uint64
-	WriteAsCarV1                 bool
-	TraversalPrototypeChooser    traversal.LinkTargetNodePrototypeChooser
-	TrustedCAR                   bool
+	BlockstoreAllowDuplicatePuts    bool
+	BlockstoreUseWholeCIDs          bool
+	BlockstoreSequentialCursor      bool
+	BlockstoreLazyIndex             bool
+	BlockstoreSnapshotIndex         bool
+	BlockstoreAutoDetectRoots       bool
+	MaxTraversalLinks               uint64
+	WriteAsCarV1                    bool
+	NormalizeRoots                  bool
+	DetachedIndexPath               string
+	IndexCheckpointPath             string
+	IndexCheckpointEveryPuts        uint64
+	IndexCheckpointInterval         time.Duration
+	IndexSpillDir                   string
+	IndexSpillRecords               uint64
+	SyncOnFinalize                  bool
+	FileLocker                      FileLocker
+	WaitForFileLock                 bool
+	TraversalPrototypeChooser       traversal.LinkTargetNodePrototypeChooser
+	TraversalADLs                   map[multicodec.Code]ipld.NodeReifier
+	TrustedCAR                      bool
+	FlushEveryBytes                 uint64
+	FlushEveryBlocks                uint64
+	FlushInterval                   time.Duration
+	TargetPayloadSize               uint64
+	PayloadPadByte                  byte
+	InspectLinks                    bool
+	SkipDuplicateBlocks             bool
+	StreamingVerificationBufferSize int
+	IndexProgress                   func(bytesScanned, records uint64)
+	IndexContext                    context.Context
+	ReadAheadSize                   int
+	TraversalPrefetchWorkers        int
+	SelectiveSizeCache              SizeCache
+	VersionHandlers                 map[uint64]VersionHandler
+	SkipCorruptSections             bool
+	OnCorruptSection                func(offset, length uint64, err error)
+	BackingSize                     int64
 
 	MaxAllowedHeaderSize  uint64
 	MaxAllowedSectionSize uint64
@@ -126,14 +191,47 @@ func WithoutIndex() Option {
 	}
 }
 
+// UseInsertRange makes WrapV1FileInPlace wrap a CARv1 by inserting a range
+// for the CARv2 header at the start of the file, where the filesystem supports
+// it, rather than by copying the CARv1 to a new file.
+//
+// This is not crash-safe: the file starts with zeros, and is neither a valid
+// CARv1 nor a valid CARv2, from the moment the range is inserted until the
+// pragma and header are written to it. An interruption beforehand leaves the
+// index written after the CARv1.
+func UseInsertRange(enable bool) Option {
+	return func(o *Options) {
+		o.InsertRange = enable
+	}
+}
+
+// NormalizeRoots is a write option which makes CAR writers remove duplicate
+// roots and sort the remaining ones in canonical order, i.e. by the bytes of
+// their binary representation, before writing them in the CAR header. The
+// normalized roots are reflected in the roots reported by the writer, e.g. via
+// Roots methods; see SortAndDedupeRoots to determine whether normalization
+// changes a given list of roots.
+//
+// When resuming a previously written CAR, the roots are normalized before
+// being compared against the roots in the existing CAR header.
+//
+// This option is honored by the blockstore and storage writers, and by
+// ReplaceRootsInFile. Selective writers always write a single root and are
+// therefore unaffected.
+func NormalizeRoots(enable bool) Option {
+	return func(o *Options) {
+		o.NormalizeRoots = enable
+	}
+}
+
 // StoreIdentityCIDs sets whether to persist sections that are referenced by
 // CIDs with multihash.IDENTITY digest.
 // When writing CAR files with this option, Characteristics.IsFullyIndexed will
 // be set.
 //
-// By default, the blockstore interface will always return true for Has() called
-// with identity CIDs, but when this option is turned on, it will defer to the
-// index.
+// By default, the CAR interfaces (blockstore or storage) answer Has, Get and
+// GetSize for identity CIDs from the CIDs themselves, but when this option is
+// turned on, they defer to the index. See IdentityCIDPolicy.
 //
 // When creating an index (or loading a CARv1 as a blockstore), when this option
 // is on, identity CIDs will be included in the index.
@@ -145,6 +243,46 @@ func StoreIdentityCIDs(b bool) Option {
 	}
 }
 
+// IdentityCIDPolicy describes how the CAR interfaces (blockstore or storage)
+// treat blocks with identity CIDs, as set via the StoreIdentityCIDs option.
+type IdentityCIDPolicy int
+
+const (
+	// IdentityCIDsInlined is the default policy, which mirrors an IdStore:
+	// blocks with identity CIDs are not written to the CAR, and identity CIDs
+	// are always reported as present, with their digest as their data.
+	IdentityCIDsInlined IdentityCIDPolicy = iota
+	// IdentityCIDsStored is the policy when StoreIdentityCIDs is enabled:
+	// blocks with identity CIDs are written and indexed like any other, and
+	// identity CIDs are only reported as present if found in the CAR.
+	IdentityCIDsStored
+)
+
+func (p IdentityCIDPolicy) String() string {
+	switch p {
+	case IdentityCIDsInlined:
+		return "inlined"
+	case IdentityCIDsStored:
+		return "stored"
+	default:
+		return fmt.Sprintf("IdentityCIDPolicy(%d)", int(p))
+	}
+}
+
+// AllowIndexOnly sets whether a CARv2 holding only an index, i.e. with a zero
+// data size and an index offset, as written by WriteIndexOnly, can be read.
+// Such CARv2s are shipped by some systems alongside a data payload stored
+// elsewhere. The index of an index-only CARv2 is read via Reader.IndexReader,
+// whereas reading its data payload or roots returns ErrIndexOnly.
+//
+// This option is disabled by default, in which case a zero data size is
+// rejected as invalid.
+func AllowIndexOnly(allow bool) Option {
+	return func(o *Options) {
+		o.AllowIndexOnly = allow
+	}
+}
+
 // MaxIndexCidSize specifies the maximum allowed size for indexed CIDs in bytes.
 // Indexing a CID with larger than the allowed size results in ErrCidTooLarge error.
 func MaxIndexCidSize(s uint64) Option {
@@ -169,6 +307,164 @@ func WithTrustedCAR(t bool) Option {
 	}
 }
 
+// InspectLinks sets whether Reader.Inspect should decode blocks of codecs
+// with a registered go-ipld-prime decoder to gather link statistics, reported
+// via Stats.LinkCount and Stats.ExternalLinkCount. Blocks whose codec has no
+// registered decoder are not decoded and do not contribute to link counts.
+//
+// Enabling this option requires every decodable block to be read into memory
+// and keeps track of every block and link target seen during the scan.
+//
+// This option is disabled by default.
+func InspectLinks(enable bool) Option {
+	return func(o *Options) {
+		o.InspectLinks = enable
+	}
+}
+
+// WithStreamingVerification enables verifying blocks against their CID while
+// holding no more than bufferSize bytes of their data in memory at once, by
+// streaming the data through the hash function and discarding it. This is
+// how Reader.Inspect verifies blocks, and how BlockReader.VerifyNext, and
+// therefore InspectReader, verify blocks regardless of this option; setting it
+// sets the size of their buffer, which is DefaultStreamingVerificationBufferSize
+// otherwise.
+//
+// Since blocks verified this way are never held in memory, they are no longer
+// subject to MaxAllowedSectionSize once this option is set, allowing CARs with
+// blocks of many gigabytes to be verified. Blocks which must still be read
+// into memory, such as ones decoded due to the InspectLinks option, remain
+// subject to it.
+func WithStreamingVerification(bufferSize int) Option {
+	return func(o *Options) {
+		o.StreamingVerificationBufferSize = bufferSize
+	}
+}
+
+// SkipDuplicateBlocks sets whether BlockReader.Next and BlockReader.SkipNext
+// should silently skip sections whose multihash was already returned since the
+// BlockReader was instantiated or last reset, such that each block is yielded
+// once even when reading a CAR written with duplicates allowed.
+//
+// Enabling this option keeps track of the multihash of every block read.
+//
+// This option is disabled by default.
+func SkipDuplicateBlocks(enable bool) Option {
+	return func(o *Options) {
+		o.SkipDuplicateBlocks = enable
+	}
+}
+
+// WithIndexProgress sets a callback which is invoked by LoadIndex, and
+// therefore GenerateIndex and friends, as the CAR payload is scanned. It is
+// called after each section with the number of bytes scanned so far, counted
+// from the beginning of the given reader, and the number of index records
+// emitted so far. It is called once more upon reaching the end of the payload.
+//
+// The callback is invoked synchronously in the scanning loop and should return
+// promptly; callers wishing to report at a lower frequency should throttle
+// within it.
+func WithIndexProgress(fn func(bytesScanned, records uint64)) Option {
+	return func(o *Options) {
+		o.IndexProgress = fn
+	}
+}
+
+// WithIndexContext sets a context which is checked by LoadIndex, and therefore
+// GenerateIndex and friends, between sections as the CAR payload is scanned.
+// Once the context is done, index generation is aborted and the error of the
+// context is returned.
+func WithIndexContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.IndexContext = ctx
+	}
+}
+
+// WithReadAhead makes the scans of a CAR payload that are backed by an
+// io.ReaderAt read it in windows of the given number of bytes, rather than
+// reading each section piecemeal. This applies to LoadIndex, and therefore
+// GenerateIndex and friends, when given a reader that implements io.ReaderAt
+// and io.Seeker, as well as to the index generated by the read-only blockstore
+// and storage, including the lazy index set via UseLazyIndex.
+//
+// Batching reads this way drastically speeds up generating the index of a CAR
+// whose reads are expensive, such as one read remotely via HTTP range
+// requests, at the cost of holding a window in memory. The contents of the
+// CAR must not change while it is being scanned.
+func WithReadAhead(size int) Option {
+	return func(o *Options) {
+		o.ReadAheadSize = size
+	}
+}
+
+// SkipCorruptSections makes the read-only blockstore skip over the damaged
+// sections of the CARv1 data payload when enumerating its keys via AllKeys or
+// AllKeysChan, rather than stopping at the first of them. This is intended for
+// forensic reads of damaged CARs.
+//
+// A section is damaged if its length prefix or CID cannot be decoded, or if
+// it extends past the end of the payload. Reading then resumes at the next
+// offset holding a complete section whose data matches its CID, or else stops
+// at the end of the payload. Each skip is reported to onSkip, if not nil, with
+// the offset of the damaged section in the data payload, the number of bytes
+// skipped over, and the error encountered when reading the section.
+//
+// The option also makes BlockReader.Next skip over the sections whose CID
+// cannot be decoded, or whose data does not match their CID unless
+// WithTrustedCAR is set, for best-effort reads of partially corrupt CARs.
+// Since a stream cannot be searched for the next valid section, a damaged
+// length prefix still ends the read with an error.
+func SkipCorruptSections(onSkip func(offset, length uint64, err error)) Option {
+	return func(o *Options) {
+		o.SkipCorruptSections = true
+		o.OnCorruptSection = onSkip
+	}
+}
+
+// WithSize sets the size in bytes of the CAR read by the read-only blockstore,
+// such that it is only read within those bounds, and never probed for its end.
+// This allows backing the blockstore with an io.ReaderAt which fails on reads
+// past its end, or which cannot seek, such as one reading a remote CAR via HTTP
+// range requests. Index offsets past the end of the data payload are then
+// reported as such, rather than read.
+//
+// Backings which report their size via a Size() int64 method, such as
+// io.SectionReader, need not set this option.
+func WithSize(n int64) Option {
+	return func(o *Options) {
+		o.BackingSize = n
+	}
+}
+
+// VersionHandler reads a CAR of a version other than 1 or 2, such as an
+// experimental on-disk format, on behalf of the readers; see
+// WithVersionHandler.
+//
+// The handler is given the CAR positioned right after its version header, i.e.
+// the CARv1 header which holds the version, and returns a reader of the CARv1
+// data payload held by the CAR, starting with its header. When called by
+// NewReader, r is also an io.ReaderAt, and so must be the returned reader.
+type VersionHandler func(r io.Reader) (io.Reader, error)
+
+// WithVersionHandler sets the handler with which NewBlockReader and NewReader
+// read CARs of version v, which they otherwise reject. This allows future CAR
+// versions to be read via plug-ins, as long as they hold a CARv1 data payload.
+// Handlers for versions 1 and 2 are ignored, since those are always read
+// natively.
+//
+// Offsets of blocks read from such a CAR are relative to the start of the data
+// payload returned by the handler, and the CAR is considered to have no index.
+func WithVersionHandler(v uint64, h VersionHandler) Option {
+	return func(o *Options) {
+		handlers := make(map[uint64]VersionHandler, len(o.VersionHandlers)+1)
+		for version, handler := range o.VersionHandlers {
+			handlers[version] = handler
+		}
+		handlers[v] = h
+		o.VersionHandlers = handlers
+	}
+}
+
 // MaxAllowedHeaderSize overrides the default maximum size (of 32 MiB) that a
 // CARv1 decode (including within a CARv2 container) will allow a header to be
 // without erroring.
@@ -179,7 +475,7 @@ func MaxAllowedHeaderSize(max uint64) Option {
 }
 
 // MaxAllowedSectionSize overrides the default maximum size (of 8 MiB) that a
-// CARv1 decode (including within a CARv2 container) will allow a header to be
+// CARv1 decode (including within a CARv2 container) will allow a section to be
 // without erroring.
 // Typically IPLD blocks should be under 2 MiB (ideally under 1 MiB), so unless
 // atypical data is expected, this should not be a large value.
@@ -189,6 +485,48 @@ func MaxAllowedSectionSize(max uint64) Option {
 	}
 }
 
+// ConservativeOptions returns an Option bundling the settings suited to reading
+// CARs from untrusted sources, such as uploads to a public service, where the
+// memory a malicious CAR can make a reader allocate should be bounded as
+// tightly as valid data allows:
+//
+//   - headers are limited to ConservativeMaxAllowedHeaderSize, and sections to
+//     ConservativeMaxAllowedSectionSize;
+//   - CIDs in indexes are limited to DefaultMaxIndexCidSize;
+//   - blocks are verified against their CIDs as they are read, i.e. the CAR is
+//     not trusted.
+//
+// Options given after it override its settings, e.g. to allow larger blocks.
+func ConservativeOptions() Option {
+	return func(o *Options) {
+		o.MaxAllowedHeaderSize = ConservativeMaxAllowedHeaderSize
+		o.MaxAllowedSectionSize = ConservativeMaxAllowedSectionSize
+		o.MaxIndexCidSize = DefaultMaxIndexCidSize
+		o.TrustedCAR = false
+	}
+}
+
+// PermissiveOptions returns an Option bundling the settings suited to reading
+// CARs from trusted sources, such as ones written locally, favouring
+// throughput and leniency over the checks guarding against malicious data:
+//
+//   - headers are limited to PermissiveMaxAllowedHeaderSize, and sections to
+//     PermissiveMaxAllowedSectionSize;
+//   - a zero-length section is treated as the end of the data payload, as
+//     with ZeroLengthSectionAsEOF, such that null-padded CARs can be read;
+//   - blocks are not verified against their CIDs as they are read, i.e. the
+//     CAR is trusted.
+//
+// Options given after it override its settings.
+func PermissiveOptions() Option {
+	return func(o *Options) {
+		o.MaxAllowedHeaderSize = PermissiveMaxAllowedHeaderSize
+		o.MaxAllowedSectionSize = PermissiveMaxAllowedSectionSize
+		o.ZeroLengthSectionAsEOF = true
+		o.TrustedCAR = true
+	}
+}
+
 // --------------------------------------------------- storage interface options
 
 // UseWholeCIDs is a read option which makes a CAR storage interface (blockstore
@@ -215,9 +553,84 @@ func UseWholeCIDs(enable bool) Option {
 	}
 }
 
+// UseSequentialCursor is a read option which makes a CAR blockstore remember
+// the position of the section following the last block it looked up. Has, Get
+// and GetSize calls for the CID stored in that section are then served
+// directly from it, without consulting the index. This speeds up consumers
+// that read blocks in the order in which they appear in the CAR, e.g. when
+// replaying a CAR sequentially; lookups in any other order fall back on the
+// index as usual.
+//
+// Note that this option only affects the blockstore interface, and is ignored
+// by the root go-car/v2 package.
+func UseSequentialCursor(enable bool) Option {
+	return func(o *Options) {
+		o.BlockstoreSequentialCursor = enable
+	}
+}
+
+// UseLazyIndex is a read option which makes a read-only CAR blockstore, or a
+// storage opened with storage.OpenReadable, over a CAR that carries no index, i.e. a CARv1 or an indexless CARv2, index its
+// payload incrementally instead of generating a full index up front. A lookup
+// for a CID not indexed so far scans forward from the last indexed section
+// until the CID is found or the end of the payload is reached. This amortizes
+// the cost of indexing for workloads that only touch a few blocks; lookups are
+// serialized while the payload is being indexed.
+//
+// Note that this option only affects the blockstore interface and
+// storage.OpenReadable, and is ignored by the root go-car/v2 package.
+func UseLazyIndex(enable bool) Option {
+	return func(o *Options) {
+		o.BlockstoreLazyIndex = enable
+	}
+}
+
+// UseSnapshotIndex is a write option which makes a read-write CAR blockstore
+// serve Has, Get and GetSize without taking its lock. Reads are instead served
+// from an immutable snapshot of the index, which is replaced atomically after
+// each Put or PutMany call, such that reads never wait for writes in progress
+// and see all blocks from the calls that have completed. This benefits
+// workloads that read heavily from a blockstore while it is being written.
+//
+// The storage backing the blockstore must support concurrent reads and
+// writes, as os.File does. The UseSequentialCursor option is ignored by the
+// reads served from the snapshot.
+//
+// Note that this option only affects the blockstore interface, and is ignored
+// by the root go-car/v2 package.
+func UseSnapshotIndex(enable bool) Option {
+	return func(o *Options) {
+		o.BlockstoreSnapshotIndex = enable
+	}
+}
+
+// AutoDetectRoots is a write option which makes a read-write CAR blockstore
+// replace the roots of the CAR upon finalization with the blocks it contains
+// that are not linked to by any other block, in the order in which they were
+// written. This saves writing a CAR in two passes when its roots are not known
+// before its blocks are written.
+//
+// Only the links of blocks whose codec has a decoder registered with
+// go-ipld-prime are followed, and blocks that fail to decode cause
+// finalization to fail. If the detected roots serialize to a different size
+// than the roots given at creation, the data sections are moved to make room
+// for the new CARv1 header, which rewrites the entire data payload. A shorter
+// header additionally requires the backing of the blockstore to implement
+// Truncate(int64) error, as files do, for finalization to succeed. Since the
+// sections may be moved, this option cannot be combined with UseSnapshotIndex.
+//
+// Note that this option only affects the blockstore interface, and is ignored
+// by the root go-car/v2 package.
+func AutoDetectRoots(enable bool) Option {
+	return func(o *Options) {
+		o.BlockstoreAutoDetectRoots = enable
+	}
+}
+
 // WriteAsCarV1 is a write option which makes a CAR interface (blockstore or
 // storage) write the output as a CARv1 only, with no CARv2 header or index.
-// Indexing is used internally during write but is discarded upon finalization.
+// Indexing is used internally during write but is discarded upon finalization,
+// unless WithDetachedIndexPath is set.
 //
 // Note that this option only affects the storage interfaces (blockstore
 // or storage), and is ignored by the root go-car/v2 package.
@@ -227,6 +640,108 @@ func WriteAsCarV1(asCarV1 bool) Option {
 	}
 }
 
+// WithDetachedIndexPath is a write option which makes a CAR interface
+// (blockstore or storage) persist the index built during writing to a separate
+// file at the given path upon finalization. The index is encoded using the
+// codec set via UseIndexCodec, and can be read back using index.ReadFrom.
+//
+// This option is only effective when combined with WriteAsCarV1, since a
+// CARv2 output already carries its index. It allows the index of a CARv1 to be
+// kept alongside it instead of being regenerated later.
+func WithDetachedIndexPath(path string) Option {
+	return func(o *Options) {
+		o.DetachedIndexPath = path
+	}
+}
+
+// WithIndexCheckpoint is a write option which makes the read/write blockstore
+// persist the records of its in-memory index to a sidecar file at the given
+// path while blocks are put, once at least everyPuts blocks were written or
+// interval elapsed since the last checkpoint, whichever comes first. If both
+// are zero, a checkpoint is made after every Put and PutMany.
+//
+// When the blockstore resumes from an existing CAR, the records of the sidecar
+// file are restored, such that only the blocks written after the last
+// checkpoint are re-indexed by scanning the CAR, rather than all of them. A
+// sidecar file which does not match the CAR is ignored, falling back to a full
+// scan. The file is appended to with the records of each checkpoint, in the
+// format read by index.ReadRecordLog, and removed once the blockstore is
+// finalized.
+//
+// Since checkpoints do not record the size of blocks, this option cannot be
+// used along with an index codec recording them, such as
+// index.CarMultihashIndexSortedWithSize.
+func WithIndexCheckpoint(path string, everyPuts uint64, interval time.Duration) Option {
+	return func(o *Options) {
+		o.IndexCheckpointPath = path
+		o.IndexCheckpointEveryPuts = everyPuts
+		o.IndexCheckpointInterval = interval
+	}
+}
+
+// WithIndexSpill is a write option which makes a writable storage CAR hold at
+// most maxRecords records of the index it builds in memory, spilling them to
+// sorted run files in a new temporary directory under dir once it holds that
+// many, so that writing a CAR of many millions of blocks does not run out of
+// memory. If dir is empty, the default directory for temporary files is used.
+//
+// Looking up blocks, including to deduplicate puts, then reads the runs on
+// disk, which only hold a sparse summary in memory. Upon finalization, the
+// runs are merged into the index of the CAR, which is written a record at a
+// time for the sorted index codecs; other codecs require the whole index to be
+// built in memory at that point. The temporary directory is removed once the
+// CAR is finalized, or discarded.
+//
+// Since spilled records do not hold the size of blocks, this option cannot be
+// used along with an index codec recording them, such as
+// index.CarMultihashIndexSortedWithSize.
+func WithIndexSpill(dir string, maxRecords uint64) Option {
+	return func(o *Options) {
+		o.IndexSpillDir = dir
+		o.IndexSpillRecords = maxRecords
+	}
+}
+
+// WithSyncOnFinalize is a write option which makes a CAR interface (blockstore
+// or storage) flush the CAR to stable storage upon finalization, along with the
+// directory entry of the file, before Finalize returns. By default, Finalize
+// returns once the CAR is handed to the operating system, and a crash or power
+// loss shortly after may lose it; pipelines that delete the source of a CAR
+// once it is finalized should enable this option.
+//
+// Only outputs with a Sync method, such as os.File, can be synced; others are
+// left as they are. A detached index set via WithDetachedIndexPath is synced
+// too.
+func WithSyncOnFinalize(sync bool) Option {
+	return func(o *Options) {
+		o.SyncOnFinalize = sync
+	}
+}
+
+// WithFileLocker sets the FileLocker used by the blockstore to lock the CAR
+// files it opens by path: exclusively by OpenReadWrite, so that no other
+// process writes to or reads from the CAR while it is written, and shared by
+// OpenReadOnly and OpenReadOnlyShared, so that the CAR is not written to while
+// read. Locking is opt-in: by default, or with a nil locker, files are not
+// locked, such as to keep opening a CAR for reading while it is written, e.g.
+// within the same process. Pass AdvisoryFileLocker to use advisory locks.
+//
+// Files passed in opened, such as to OpenReadWriteFile, are not locked.
+func WithFileLocker(l FileLocker) Option {
+	return func(o *Options) {
+		o.FileLocker = l
+	}
+}
+
+// WaitForFileLock sets whether opening a CAR file locked by another process in
+// a conflicting way waits for the lock to be released. By default, it fails
+// straight away with ErrFileLocked.
+func WaitForFileLock(wait bool) Option {
+	return func(o *Options) {
+		o.WaitForFileLock = wait
+	}
+}
+
 // AllowDuplicatePuts is a write option which makes a CAR interface (blockstore
 // or storage) not deduplicate blocks in Put and PutMany. The default is to
 // deduplicate, which matches the current semantics of go-ipfs-blockstore v1.
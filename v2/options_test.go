@@ -1,11 +1,16 @@
 package car_test
 
 import (
+	"bytes"
 	"math"
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
@@ -48,3 +53,47 @@ func TestApplyOptions_AppliesOptions(t *testing.T) {
 			blockstore.UseWholeCIDs(true),
 		))
 }
+
+func TestConservativeAndPermissiveOptions(t *testing.T) {
+	conservative := carv2.ApplyOptions(carv2.PermissiveOptions(), carv2.ConservativeOptions())
+	require.Equal(t, uint64(carv2.ConservativeMaxAllowedHeaderSize), conservative.MaxAllowedHeaderSize)
+	require.Equal(t, uint64(carv2.ConservativeMaxAllowedSectionSize), conservative.MaxAllowedSectionSize)
+	require.False(t, conservative.TrustedCAR)
+
+	permissive := carv2.ApplyOptions(carv2.PermissiveOptions())
+	require.Equal(t, uint64(carv2.PermissiveMaxAllowedHeaderSize), permissive.MaxAllowedHeaderSize)
+	require.Equal(t, uint64(carv2.PermissiveMaxAllowedSectionSize), permissive.MaxAllowedSectionSize)
+	require.True(t, permissive.ZeroLengthSectionAsEOF)
+	require.True(t, permissive.TrustedCAR)
+
+	// Options given after a preset override it.
+	overridden := carv2.ApplyOptions(carv2.ConservativeOptions(), carv2.MaxAllowedSectionSize(4<<20))
+	require.Equal(t, uint64(4<<20), overridden.MaxAllowedSectionSize)
+	require.Equal(t, uint64(carv2.ConservativeMaxAllowedHeaderSize), overridden.MaxAllowedHeaderSize)
+
+	// A 3 MiB block is within the defaults, but not the conservative limits.
+	blk := blocks.NewBlock(make([]byte, 3<<20))
+	var buf bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: []cid.Cid{blk.Cid()}, Version: 1}, &buf))
+	require.NoError(t, util.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData()))
+	for _, tc := range []struct {
+		name    string
+		opts    []carv2.Option
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"conservative", []carv2.Option{carv2.ConservativeOptions()}, true},
+		{"permissive", []carv2.Option{carv2.PermissiveOptions()}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			br, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()), tc.opts...)
+			require.NoError(t, err)
+			_, err = br.Next()
+			if tc.wantErr {
+				require.ErrorIs(t, err, util.ErrSectionTooLarge)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -1,16 +1,20 @@
 package car
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/ipld/go-car/v2/internal/links"
+	ipldcodec "github.com/ipld/go-ipld-prime/codec"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
@@ -22,9 +26,11 @@ type Reader struct {
 	Header  Header
 	Version uint64
 	r       io.ReaderAt
-	roots   []cid.Cid
-	opts    Options
-	closer  io.Closer
+	// The data payload, if read via a VersionHandler.
+	data   io.ReaderAt
+	roots  []cid.Cid
+	opts   Options
+	closer io.Closer
 }
 
 // OpenReader is a wrapper for NewReader which opens the file at path.
@@ -47,8 +53,9 @@ func OpenReader(path string, opts ...Option) (*Reader, error) {
 // Upon instantiation, the reader inspects the payload and provides appropriate read operations
 // for both CARv1 and CARv2.
 //
-// Note that any other version other than 1 or 2 will result in an error. The caller may use
-// Reader.Version to get the actual version r represents. In the case where r represents a CARv1
+// Note that any other version other than 1 or 2 will result in an error, unless a handler is set
+// for it via WithVersionHandler. The caller may use Reader.Version to get the actual version r
+// represents. In the case where r represents a CARv1, or a version read via a handler,
 // Reader.Header will not be populated and is left as zero-valued.
 func NewReader(r io.ReaderAt, opts ...Option) (*Reader, error) {
 	cr := &Reader{
@@ -66,7 +73,23 @@ func NewReader(r io.ReaderAt, opts ...Option) (*Reader, error) {
 	}
 
 	if cr.Version != 1 && cr.Version != 2 {
-		return nil, fmt.Errorf("invalid car version: %d", cr.Version)
+		h, ok := cr.opts.VersionHandlers[cr.Version]
+		if !ok {
+			return nil, fmt.Errorf("invalid car version: %d", cr.Version)
+		}
+		hdrLen, err := or.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := h(io.NewSectionReader(r, hdrLen, math.MaxInt64-hdrLen))
+		if err != nil {
+			return nil, err
+		}
+		data, ok := payload.(io.ReaderAt)
+		if !ok {
+			return nil, fmt.Errorf("handler of car version %d must return an io.ReaderAt", cr.Version)
+		}
+		cr.data = data
 	}
 
 	if cr.Version == 2 {
@@ -98,7 +121,7 @@ func (r *Reader) Roots() ([]cid.Cid, error) {
 
 func (r *Reader) readV2Header() (err error) {
 	headerSection := io.NewSectionReader(r.r, PragmaSize, HeaderSize)
-	_, err = r.Header.ReadFrom(headerSection)
+	_, err = r.Header.readFrom(headerSection, r.opts.AllowIndexOnly)
 	return
 }
 
@@ -112,10 +135,17 @@ type SectionReader interface {
 }
 
 // DataReader provides a reader containing the data payload in CARv1 format.
+// ErrIndexOnly is returned if the CARv2 only holds an index.
 func (r *Reader) DataReader() (SectionReader, error) {
+	if r.Header.IsIndexOnly() {
+		return nil, ErrIndexOnly
+	}
 	if r.Version == 2 {
 		return io.NewSectionReader(r.r, int64(r.Header.DataOffset), int64(r.Header.DataSize)), nil
 	}
+	if r.data != nil {
+		return internalio.NewOffsetReadSeeker(r.data, 0)
+	}
 	return internalio.NewOffsetReadSeeker(r.r, 0)
 }
 
@@ -123,7 +153,7 @@ func (r *Reader) DataReader() (SectionReader, error) {
 // present. Otherwise, returns nil.
 // Note, this function will always return nil if the backing payload represents a CARv1.
 func (r *Reader) IndexReader() (io.Reader, error) {
-	if r.Version == 1 || !r.Header.HasIndex() {
+	if r.Version != 2 || !r.Header.HasIndex() {
 		return nil, nil
 	}
 	return internalio.NewOffsetReadSeeker(r.r, int64(r.Header.IndexOffset))
@@ -145,6 +175,13 @@ type Stats struct {
 	MaxBlockLength uint64
 	MinBlockLength uint64
 	IndexCodec     multicodec.Code
+	// LinkCount is the total number of links, i.e. the sum of out-degrees, of
+	// all decoded blocks. Only populated when the InspectLinks option is set.
+	LinkCount uint64
+	// ExternalLinkCount is the number of distinct link targets that are not
+	// present in the CAR, excluding IDENTITY CIDs. Only populated when the
+	// InspectLinks option is set.
+	ExternalLinkCount uint64
 }
 
 // Inspect does a quick scan of a CAR, performing basic validation of the format
@@ -159,6 +196,10 @@ type Stats struct {
 // Performing a full block hash validation is similar to using a BlockReader and
 // calling Next over all blocks.
 //
+// If the reader was constructed with the InspectLinks option, blocks of codecs
+// with a registered decoder are decoded to populate Stats.LinkCount and
+// Stats.ExternalLinkCount. Blocks that fail to decode result in an error.
+//
 // Inspect will perform a basic check of a CARv2 index, where present, but this
 // does not guarantee that the index is correct. Attempting to read index data
 // from untrusted sources is not recommended. If required, further validation of
@@ -194,23 +235,14 @@ type Stats struct {
 //   - DAG completeness is not checked. Any properties relating to the DAG, or
 //     DAGs contained within a CAR are the responsibility of the user to check.
 func (r *Reader) Inspect(validateBlockHash bool) (Stats, error) {
-	stats := Stats{
-		Version:      r.Version,
-		Header:       r.Header,
-		CodecCounts:  make(map[multicodec.Code]uint64),
-		MhTypeCounts: make(map[multicodec.Code]uint64),
-	}
-
-	var totalCidLength uint64
-	var totalBlockLength uint64
-	var minCidLength uint64 = math.MaxUint64
-	var minBlockLength uint64 = math.MaxUint64
+	stats := newStats()
+	stats.Version = r.Version
+	stats.Header = r.Header
 
 	dr, err := r.DataReader()
 	if err != nil {
 		return Stats{}, err
 	}
-	bdr := internalio.ToByteReader(dr)
 
 	// read roots, not using Roots(), because we need the offset setup in the data trader
 	header, err := carv1.ReadHeader(dr, r.opts.MaxAllowedHeaderSize)
@@ -218,134 +250,400 @@ func (r *Reader) Inspect(validateBlockHash bool) (Stats, error) {
 		return Stats{}, err
 	}
 	stats.Roots = header.Roots
-	var rootsPresentCount int
-	rootsPresent := make([]bool, len(stats.Roots))
+	if err := inspectSections(internalio.ToByteReadSeeker(dr), r.opts, validateBlockHash, &stats); err != nil {
+		return Stats{}, err
+	}
+
+	if stats.Version != 1 && stats.Header.HasIndex() {
+		idxr, err := r.IndexReader()
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.IndexCodec, err = index.ReadCodec(idxr)
+		if err != nil {
+			return Stats{}, err
+		}
+	}
+
+	return stats, nil
+}
+
+// InspectStream is like Reader.Inspect, but computes Stats in a single
+// forward-only pass over r, such that a CAR can be inspected as it arrives,
+// e.g. over HTTP, without being buffered to disk first. Block data is skipped
+// over by discarding it unless validateBlockHash is true or the InspectLinks
+// option is set.
+//
+// Unlike Reader.Inspect, the index of a CARv2 is not located via its offset
+// and checked; only its codec is read, once the stream reaches it.
+func InspectStream(r io.Reader, validateBlockHash bool, opts ...Option) (Stats, error) {
+	o := ApplyOptions(opts...)
+	stats := newStats()
+	rs := internalio.ToByteReadSeeker(r)
+
+	header, err := carv1.ReadHeader(rs, o.MaxAllowedHeaderSize)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.Version = header.Version
+	switch header.Version {
+	case 1:
+		stats.Roots = header.Roots
+		if err := inspectSections(rs, o, validateBlockHash, &stats); err != nil {
+			return Stats{}, err
+		}
+		return stats, nil
+	case 2:
+	default:
+		return Stats{}, fmt.Errorf("invalid car version: %d", header.Version)
+	}
+
+	if _, err := stats.Header.readFrom(rs, o.AllowIndexOnly); err != nil {
+		return Stats{}, err
+	}
+	// The offsets are skipped to relative to one another, so they must be in
+	// order for the stream to only be read forward.
+	if stats.Header.DataOffset < PragmaSize+HeaderSize {
+		return Stats{}, fmt.Errorf("invalid data payload offset: %v", stats.Header.DataOffset)
+	}
+	if stats.Header.HasIndex() && stats.Header.IndexOffset < stats.Header.DataOffset+stats.Header.DataSize {
+		return Stats{}, fmt.Errorf("invalid index offset: %v", stats.Header.IndexOffset)
+	}
+	if _, err := rs.Seek(int64(stats.Header.DataOffset)-PragmaSize-HeaderSize, io.SeekCurrent); err != nil {
+		return Stats{}, err
+	}
+	dr := io.LimitReader(rs, int64(stats.Header.DataSize))
+	if stats.Header.DataSize > 0 {
+		v1h, err := carv1.ReadHeader(dr, o.MaxAllowedHeaderSize)
+		if err != nil {
+			return Stats{}, err
+		}
+		if v1h.Version != 1 {
+			return Stats{}, fmt.Errorf("invalid data payload header version; expected 1, got %v", v1h.Version)
+		}
+		stats.Roots = v1h.Roots
+		if err := inspectSections(internalio.ToByteReadSeeker(dr), o, validateBlockHash, &stats); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	if stats.Header.HasIndex() {
+		// Skip what remains of the data payload, e.g. past null padding, and
+		// any padding up to the index.
+		if _, err := io.Copy(io.Discard, dr); err != nil {
+			return Stats{}, err
+		}
+		if _, err := rs.Seek(int64(stats.Header.IndexOffset-stats.Header.DataOffset-stats.Header.DataSize), io.SeekCurrent); err != nil {
+			return Stats{}, err
+		}
+		stats.IndexCodec, err = index.ReadCodec(rs)
+		if err != nil {
+			return Stats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// inspectSections accumulates the stats of the block sections read from dr,
+// which follow the CARv1 header of a data payload, until its end.
+func inspectSections(dr internalio.ByteReadSeeker, o Options, validateBlockHash bool, stats *Stats) error {
+	acc := newStatsAccumulator(stats, o.InspectLinks)
+	streaming := o.StreamingVerificationBufferSize > 0
+	var verifyBuf []byte
 
 	// read block sections
 	for {
-		sectionLength, err := varint.ReadUvarint(bdr)
+		sectionLength, err := varint.ReadUvarint(dr)
 		if err != nil {
 			if err == io.EOF {
 				// if the length of bytes read is non-zero when the error is EOF then signal an unclean EOF.
 				if sectionLength > 0 {
-					return Stats{}, io.ErrUnexpectedEOF
+					return io.ErrUnexpectedEOF
 				}
 				// otherwise, this is a normal ending
 				break
 			}
-			return Stats{}, err
+			return err
 		}
-		if sectionLength == 0 && r.opts.ZeroLengthSectionAsEOF {
+		if sectionLength == 0 && o.ZeroLengthSectionAsEOF {
 			// normal ending for this read mode
 			break
 		}
-		if sectionLength > r.opts.MaxAllowedSectionSize {
-			return Stats{}, util.ErrSectionTooLarge
+		if sectionLength > o.MaxAllowedSectionSize && !streaming {
+			return util.ErrSectionTooLarge
 		}
 
 		// decode just the CID bytes
 		cidLen, c, err := cid.CidFromReader(dr)
 		if err != nil {
-			return Stats{}, err
+			return err
 		}
 
 		if sectionLength < uint64(cidLen) {
 			// this case is handled different in the normal ReadNode() path since it
 			// slurps in the whole section bytes and decodes CID from there - so an
 			// error should come from a failing io.ReadFull
-			return Stats{}, errors.New("section length shorter than CID length")
+			return errors.New("section length shorter than CID length")
 		}
+		blockLength := sectionLength - uint64(cidLen)
 
-		// is this a root block? (also account for duplicate root CIDs)
-		if rootsPresentCount < len(stats.Roots) {
-			for i, r := range stats.Roots {
-				if !rootsPresent[i] && c == r {
-					rootsPresent[i] = true
-					rootsPresentCount++
+		// when decoding links, the block must be read into memory in its entirety
+		var blockReader io.Reader = io.LimitReader(dr, int64(blockLength))
+		var data []byte
+		if acc.decoder(c) != nil {
+			if sectionLength > o.MaxAllowedSectionSize {
+				return util.ErrSectionTooLarge
+			}
+			data = make([]byte, blockLength)
+			if _, err := io.ReadFull(dr, data); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
 				}
+				return err
 			}
+			blockReader = bytes.NewReader(data)
 		}
 
-		cp := c.Prefix()
-		codec := multicodec.Code(cp.Codec)
-		count := stats.CodecCounts[codec]
-		stats.CodecCounts[codec] = count + 1
-		mhtype := multicodec.Code(cp.MhType)
-		count = stats.MhTypeCounts[mhtype]
-		stats.MhTypeCounts[mhtype] = count + 1
-
-		blockLength := sectionLength - uint64(cidLen)
-
 		if validateBlockHash {
-			// Use multihash.SumStream to avoid having to copy the entire block content into memory.
-			// The SumStream uses a buffered copy to write bytes into the hasher which will take
-			// advantage of streaming hash calculation depending on the hash function.
-			// TODO: introduce SumStream in go-cid to simplify the code here.
-			blockReader := io.LimitReader(dr, int64(blockLength))
-			mhl := cp.MhLength
-			if mhtype == multicodec.Identity {
-				mhl = -1
+			// Stream the block through the hash function to avoid having to
+			// copy its entire content into memory.
+			if verifyBuf == nil {
+				verifyBuf = make([]byte, streamingVerificationBufferSize(o))
 			}
-			mh, err := multihash.SumStream(blockReader, cp.MhType, mhl)
+			gotCid, n, err := sumCidStream(blockReader, c.Prefix(), verifyBuf)
 			if err != nil {
-				return Stats{}, err
+				return err
 			}
-			var gotCid cid.Cid
-			switch cp.Version {
-			case 0:
-				gotCid = cid.NewCidV0(mh)
-			case 1:
-				gotCid = cid.NewCidV1(cp.Codec, mh)
-			default:
-				return Stats{}, fmt.Errorf("invalid cid version: %d", cp.Version)
+			if uint64(n) < blockLength {
+				return io.ErrUnexpectedEOF
 			}
 			if !gotCid.Equals(c) {
-				return Stats{}, fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, gotCid)
+				return fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, gotCid)
 			}
-		} else {
+		} else if data == nil {
 			// otherwise, skip over it
 			if _, err := dr.Seek(int64(blockLength), io.SeekCurrent); err != nil {
-				return Stats{}, err
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
 			}
 		}
 
-		stats.BlockCount++
-		totalCidLength += uint64(cidLen)
-		totalBlockLength += blockLength
-		if uint64(cidLen) < minCidLength {
-			minCidLength = uint64(cidLen)
-		}
-		if uint64(cidLen) > stats.MaxCidLength {
-			stats.MaxCidLength = uint64(cidLen)
+		if err := acc.add(c, uint64(cidLen), blockLength, data); err != nil {
+			return err
 		}
-		if blockLength < minBlockLength {
-			minBlockLength = blockLength
+	}
+	acc.finish()
+	return nil
+}
+
+// BlockReaderWithSkip is the interface of a reader of blocks which can also
+// skip over blocks without reading their data, as implemented by BlockReader.
+// It may be implemented by other types, e.g. to filter the blocks of a
+// BlockReader, so that their stats can be computed via InspectReader.
+type BlockReaderWithSkip interface {
+	Next() (blocks.Block, error)
+	SkipNext() (*BlockMetadata, error)
+}
+
+// InspectReader is like Reader.Inspect, but computes Stats in a single pass
+// over the remaining blocks of the given block reader, until it returns
+// io.EOF. This allows stats to be computed as a side-effect of other
+// processing of a CAR stream, including ones resumed part-way through or
+// filtered by a wrapping BlockReaderWithSkip.
+//
+// If br is a *BlockReader, Stats.Version, Stats.Roots and Stats.RootsPresent
+// are populated from it. Since no CARv2 header or index is read,
+// Stats.Header and Stats.IndexCodec are always left unset.
+//
+// Blocks are skipped over using SkipNext unless validateBlockHash is true or
+// the InspectLinks option is set, in which case they are read using Next. If
+// validateBlockHash is true, block data is hashed and compared to the CID
+// regardless of whether br itself validates blocks; if br has a VerifyNext
+// method, as *BlockReader does, and InspectLinks is not set, blocks are
+// verified using it instead, such that they are never held in memory. The
+// InspectLinks option is the only option honored.
+func InspectReader(br BlockReaderWithSkip, validateBlockHash bool, opts ...Option) (Stats, error) {
+	o := ApplyOptions(opts...)
+	stats := newStats()
+	if r, ok := br.(*BlockReader); ok {
+		stats.Version = r.Version
+		stats.Roots = r.Roots
+	}
+	acc := newStatsAccumulator(&stats, o.InspectLinks)
+
+	vr, canVerify := br.(interface {
+		VerifyNext() (*BlockMetadata, error)
+	})
+	for {
+		var c cid.Cid
+		var blockLength uint64
+		var data []byte
+		if validateBlockHash && canVerify && !o.InspectLinks {
+			md, err := vr.VerifyNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return Stats{}, err
+			}
+			c = md.Cid
+			blockLength = md.Size
+		} else if validateBlockHash || o.InspectLinks {
+			blk, err := br.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return Stats{}, err
+			}
+			c = blk.Cid()
+			data = blk.RawData()
+			blockLength = uint64(len(data))
+			if validateBlockHash {
+				hashed, err := c.Prefix().Sum(data)
+				if err != nil {
+					return Stats{}, err
+				}
+				if !hashed.Equals(c) {
+					return Stats{}, fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, hashed)
+				}
+			}
+		} else {
+			md, err := br.SkipNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return Stats{}, err
+			}
+			c = md.Cid
+			blockLength = md.Size
 		}
-		if blockLength > stats.MaxBlockLength {
-			stats.MaxBlockLength = blockLength
+		if err := acc.add(c, uint64(c.ByteLen()), blockLength, data); err != nil {
+			return Stats{}, err
 		}
 	}
+	acc.finish()
+	return stats, nil
+}
 
-	stats.RootsPresent = len(stats.Roots) == rootsPresentCount
-	if stats.BlockCount > 0 {
-		stats.MinCidLength = minCidLength
-		stats.MinBlockLength = minBlockLength
-		stats.AvgCidLength = totalCidLength / stats.BlockCount
-		stats.AvgBlockLength = totalBlockLength / stats.BlockCount
+func newStats() Stats {
+	return Stats{
+		CodecCounts:  make(map[multicodec.Code]uint64),
+		MhTypeCounts: make(map[multicodec.Code]uint64),
 	}
+}
 
-	if stats.Version != 1 && stats.Header.HasIndex() {
-		idxr, err := r.IndexReader()
-		if err != nil {
-			return Stats{}, err
+// statsAccumulator accumulates the per-block Stats of a CAR, shared by
+// Reader.Inspect and InspectReader.
+type statsAccumulator struct {
+	stats        *Stats
+	inspectLinks bool
+
+	totalCidLength    uint64
+	totalBlockLength  uint64
+	minCidLength      uint64
+	minBlockLength    uint64
+	rootsPresentCount int
+	rootsPresent      []bool
+
+	// when inspecting links, keep track of the multihashes of present blocks and
+	// link targets so that external links can be counted at the end
+	present, linked map[string]struct{}
+}
+
+// newStatsAccumulator returns a statsAccumulator adding to stats, whose Roots
+// must already be set.
+func newStatsAccumulator(stats *Stats, inspectLinks bool) *statsAccumulator {
+	acc := &statsAccumulator{
+		stats:          stats,
+		inspectLinks:   inspectLinks,
+		minCidLength:   math.MaxUint64,
+		minBlockLength: math.MaxUint64,
+		rootsPresent:   make([]bool, len(stats.Roots)),
+	}
+	if inspectLinks {
+		acc.present = make(map[string]struct{})
+		acc.linked = make(map[string]struct{})
+	}
+	return acc
+}
+
+// decoder returns the decoder to decode links from the block with the given
+// CID with, or nil if its links are not inspected. The data of such blocks
+// must be passed to add.
+func (acc *statsAccumulator) decoder(c cid.Cid) ipldcodec.Decoder {
+	if !acc.inspectLinks {
+		return nil
+	}
+	// blocks with no registered decoder are not decoded
+	return links.Decoder(c)
+}
+
+// add accounts for a block with the given CID, CID length and block length.
+// data may be nil, unless decoder returns a decoder for c.
+func (acc *statsAccumulator) add(c cid.Cid, cidLen, blockLength uint64, data []byte) error {
+	stats := acc.stats
+
+	// is this a root block? (also account for duplicate root CIDs)
+	if acc.rootsPresentCount < len(stats.Roots) {
+		for i, r := range stats.Roots {
+			if !acc.rootsPresent[i] && c == r {
+				acc.rootsPresent[i] = true
+				acc.rootsPresentCount++
+			}
 		}
-		stats.IndexCodec, err = index.ReadCodec(idxr)
-		if err != nil {
-			return Stats{}, err
+	}
+
+	cp := c.Prefix()
+	codec := multicodec.Code(cp.Codec)
+	stats.CodecCounts[codec]++
+	stats.MhTypeCounts[multicodec.Code(cp.MhType)]++
+
+	if acc.inspectLinks {
+		acc.present[string(c.Hash())] = struct{}{}
+		if decoder := acc.decoder(c); decoder != nil {
+			ls, err := links.Decode(decoder, data)
+			if err != nil {
+				return fmt.Errorf("failed to decode block %s: %w", c, err)
+			}
+			stats.LinkCount += uint64(len(ls))
+			for _, l := range ls {
+				if l.Prefix().MhType == multihash.IDENTITY {
+					continue
+				}
+				acc.linked[string(l.Hash())] = struct{}{}
+			}
 		}
 	}
 
-	return stats, nil
+	stats.BlockCount++
+	acc.totalCidLength += cidLen
+	acc.totalBlockLength += blockLength
+	acc.minCidLength = min(acc.minCidLength, cidLen)
+	stats.MaxCidLength = max(stats.MaxCidLength, cidLen)
+	acc.minBlockLength = min(acc.minBlockLength, blockLength)
+	stats.MaxBlockLength = max(stats.MaxBlockLength, blockLength)
+	return nil
+}
+
+// finish computes the Stats that are only known once all blocks were added.
+func (acc *statsAccumulator) finish() {
+	stats := acc.stats
+	stats.RootsPresent = len(stats.Roots) == acc.rootsPresentCount
+	for mh := range acc.linked {
+		if _, ok := acc.present[mh]; !ok {
+			stats.ExternalLinkCount++
+		}
+	}
+	if stats.BlockCount > 0 {
+		stats.MinCidLength = acc.minCidLength
+		stats.MinBlockLength = acc.minBlockLength
+		stats.AvgCidLength = acc.totalCidLength / stats.BlockCount
+		stats.AvgBlockLength = acc.totalBlockLength / stats.BlockCount
+	}
 }
 
 // Close closes the underlying reader if it was opened by OpenReader.
@@ -2,9 +2,12 @@ package car_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -12,6 +15,9 @@ import (
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
@@ -91,6 +97,42 @@ func TestReaderFailsOnUnknownVersion(t *testing.T) {
 	require.EqualError(t, err, "invalid car version: 42")
 }
 
+func TestReaderWithVersionHandler(t *testing.T) {
+	v3 := requireCarV3(t)
+	_, err := carv2.NewReader(bytes.NewReader(v3))
+	require.EqualError(t, err, "invalid car version: 3")
+
+	subject, err := carv2.NewReader(bytes.NewReader(v3), carv2.WithVersionHandler(3, func(r io.Reader) (io.Reader, error) {
+		return r, nil
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), subject.Version)
+	want, err := carv2.OpenReader("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, want.Close()) })
+
+	wantRoots, err := want.Roots()
+	require.NoError(t, err)
+	gotRoots, err := subject.Roots()
+	require.NoError(t, err)
+	require.Equal(t, wantRoots, gotRoots)
+	ir, err := subject.IndexReader()
+	require.NoError(t, err)
+	require.Nil(t, ir)
+
+	wantStats, err := want.Inspect(true)
+	require.NoError(t, err)
+	gotStats, err := subject.Inspect(true)
+	require.NoError(t, err)
+	wantStats.Version = 3
+	require.Equal(t, wantStats, gotStats)
+
+	_, err = carv2.NewReader(bytes.NewReader(v3), carv2.WithVersionHandler(3, func(r io.Reader) (io.Reader, error) {
+		return io.MultiReader(r), nil
+	}))
+	require.EqualError(t, err, "handler of car version 3 must return an io.ReaderAt")
+}
+
 func TestReaderFailsOnCorruptPragma(t *testing.T) {
 	_, err := carv2.OpenReader("testdata/sample-corrupt-pragma.car")
 	require.EqualError(t, err, "unexpected EOF")
@@ -188,6 +230,41 @@ func TestReader_WithCarV2Consistency(t *testing.T) {
 	}
 }
 
+func TestReader_IndexOnly(t *testing.T) {
+	wantIndex, err := carv2.GenerateIndexFromFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, carv2.WriteIndexOnly(wantIndex, &buf))
+
+	// Index-only CARv2s are rejected unless allowed.
+	_, err = carv2.NewReader(bytes.NewReader(buf.Bytes()))
+	require.ErrorContains(t, err, "invalid data payload size: 0")
+
+	subject, err := carv2.NewReader(bytes.NewReader(buf.Bytes()), carv2.AllowIndexOnly(true))
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), subject.Version)
+	require.True(t, subject.Header.IsIndexOnly())
+
+	ir, err := subject.IndexReader()
+	require.NoError(t, err)
+	gotIndex, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	require.Equal(t, wantIndex, gotIndex)
+
+	_, err = subject.DataReader()
+	require.ErrorIs(t, err, carv2.ErrIndexOnly)
+	_, err = subject.Roots()
+	require.ErrorIs(t, err, carv2.ErrIndexOnly)
+
+	// A zero data size is invalid without an index, even when allowed.
+	var header bytes.Buffer
+	header.Write(carv2.Pragma)
+	_, err = carv2.Header{DataOffset: carv2.PragmaSize + carv2.HeaderSize}.WriteTo(&header)
+	require.NoError(t, err)
+	_, err = carv2.NewReader(bytes.NewReader(header.Bytes()), carv2.AllowIndexOnly(true))
+	require.ErrorContains(t, err, "invalid data payload size: 0")
+}
+
 func TestOpenReader_DoesNotPanicForReadersCreatedBeforeClosure(t *testing.T) {
 	subject, err := carv2.OpenReader("testdata/sample-wrapped-v2.car")
 	require.NoError(t, err)
@@ -447,6 +524,224 @@ func TestInspect(t *testing.T) {
 	}
 }
 
+func TestInspectLinks(t *testing.T) {
+	// Count links of all dag-pb blocks independently of Inspect.
+	f, err := os.Open("testdata/sample-unixfs-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	br, err := carv2.NewBlockReader(f)
+	require.NoError(t, err)
+	var wantLinkCount uint64
+	var rootBlock []byte
+	var rootLinks []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if blk.Cid().Prefix().Codec != cid.DagProtobuf {
+			continue
+		}
+		nb := dagpb.Type.PBNode.NewBuilder()
+		require.NoError(t, dagpb.DecodeBytes(nb, blk.RawData()))
+		links := nb.Build().(dagpb.PBNode).FieldLinks()
+		wantLinkCount += uint64(links.Length())
+		if blk.Cid().Equals(br.Roots[0]) {
+			rootBlock = blk.RawData()
+			itr := links.Iterator()
+			for !itr.Done() {
+				_, l := itr.Next()
+				rootLinks = append(rootLinks, l.FieldHash().Link().(cidlink.Link).Cid)
+			}
+		}
+	}
+	require.NotZero(t, wantLinkCount)
+	require.NotEmpty(t, rootLinks)
+
+	t.Run("CompleteDAG", func(t *testing.T) {
+		reader, err := carv2.OpenReader("testdata/sample-unixfs-v2.car", carv2.InspectLinks(true))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, reader.Close()) })
+		stats, err := reader.Inspect(true)
+		require.NoError(t, err)
+		require.Equal(t, wantLinkCount, stats.LinkCount)
+		require.Zero(t, stats.ExternalLinkCount)
+	})
+
+	t.Run("RootOnly", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: br.Roots, Version: 1}, &buf))
+		require.NoError(t, util.LdWrite(&buf, br.Roots[0].Bytes(), rootBlock))
+		reader, err := carv2.NewReader(bytes.NewReader(buf.Bytes()), carv2.InspectLinks(true))
+		require.NoError(t, err)
+		stats, err := reader.Inspect(true)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(rootLinks)), stats.LinkCount)
+		require.Equal(t, uint64(len(rootLinks)), stats.ExternalLinkCount)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		reader, err := carv2.OpenReader("testdata/sample-unixfs-v2.car")
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, reader.Close()) })
+		stats, err := reader.Inspect(false)
+		require.NoError(t, err)
+		require.Zero(t, stats.LinkCount)
+		require.Zero(t, stats.ExternalLinkCount)
+	})
+}
+
+func TestInspectReader(t *testing.T) {
+	for _, path := range []string{
+		"testdata/sample-v1.car",
+		"testdata/sample-wrapped-v2.car",
+		"testdata/sample-unixfs-v2.car",
+	} {
+		for _, validate := range []bool{false, true} {
+			for _, links := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s/validate=%t/links=%t", filepath.Base(path), validate, links), func(t *testing.T) {
+					reader, err := carv2.OpenReader(path, carv2.InspectLinks(links))
+					require.NoError(t, err)
+					t.Cleanup(func() { require.NoError(t, reader.Close()) })
+					want, err := reader.Inspect(validate)
+					require.NoError(t, err)
+					// Neither the CARv2 header nor the index is read.
+					want.Header = carv2.Header{}
+					want.IndexCodec = 0
+
+					f, err := os.Open(path)
+					require.NoError(t, err)
+					t.Cleanup(func() { f.Close() })
+					br, err := carv2.NewBlockReader(f)
+					require.NoError(t, err)
+					got, err := carv2.InspectReader(br, validate, carv2.InspectLinks(links))
+					require.NoError(t, err)
+					require.Equal(t, want, got)
+				})
+			}
+		}
+	}
+
+	t.Run("Resumed", func(t *testing.T) {
+		reader, err := carv2.OpenReader("testdata/sample-v1.car")
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, reader.Close()) })
+		want, err := reader.Inspect(false)
+		require.NoError(t, err)
+
+		f, err := os.Open("testdata/sample-v1.car")
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		br, err := carv2.NewBlockReader(f)
+		require.NoError(t, err)
+		_, err = br.Next()
+		require.NoError(t, err)
+		got, err := carv2.InspectReader(br, false)
+		require.NoError(t, err)
+		require.Equal(t, want.BlockCount-1, got.BlockCount)
+	})
+
+	t.Run("ValidatesTrustedReader", func(t *testing.T) {
+		//           header                             cid                                                                          data
+		car, _ := hex.DecodeString("11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681caffffffffffffffffffff")
+		br, err := carv2.NewBlockReader(bytes.NewReader(car), carv2.WithTrustedCAR(true))
+		require.NoError(t, err)
+		_, err = carv2.InspectReader(br, true)
+		require.ErrorContains(t, err, "mismatch in content integrity")
+	})
+}
+
+func TestInspectStream(t *testing.T) {
+	for _, path := range []string{
+		"testdata/sample-v1.car",
+		"testdata/sample-wrapped-v2.car",
+		"testdata/sample-unixfs-v2.car",
+		"testdata/sample-v2-indexless.car",
+	} {
+		for _, validate := range []bool{false, true} {
+			for _, links := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s/validate=%t/links=%t", filepath.Base(path), validate, links), func(t *testing.T) {
+					reader, err := carv2.OpenReader(path, carv2.InspectLinks(links))
+					require.NoError(t, err)
+					t.Cleanup(func() { require.NoError(t, reader.Close()) })
+					want, err := reader.Inspect(validate)
+					require.NoError(t, err)
+
+					car, err := os.ReadFile(path)
+					require.NoError(t, err)
+					// Hide the io.Seeker of the reader, as a network stream would.
+					got, err := carv2.InspectStream(struct{ io.Reader }{bytes.NewReader(car)}, validate, carv2.InspectLinks(links))
+					require.NoError(t, err)
+					require.Equal(t, want, got)
+
+					// A stream truncated within the data payload is an error.
+					end := len(car)
+					if want.Version == 2 {
+						end = int(want.Header.DataOffset + want.Header.DataSize)
+					}
+					_, err = carv2.InspectStream(struct{ io.Reader }{bytes.NewReader(car[:end-3])}, validate, carv2.InspectLinks(links))
+					require.Error(t, err)
+				})
+			}
+		}
+	}
+}
+
+func TestInspectStreamInvalidOffsets(t *testing.T) {
+	car, err := os.ReadFile("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	// The data offset, data size and index offset follow the characteristics.
+	offsets := car[carv2.PragmaSize+16 : carv2.PragmaSize+carv2.HeaderSize]
+	dataOffset := binary.LittleEndian.Uint64(offsets[:8])
+	dataSize := binary.LittleEndian.Uint64(offsets[8:16])
+
+	for _, tt := range []struct {
+		name    string
+		field   int
+		value   uint64
+		wantErr string
+	}{
+		{"DataOffsetWithinHeader", 0, carv2.PragmaSize + carv2.HeaderSize - 1, "invalid data payload offset"},
+		{"IndexOffsetWithinData", 16, dataOffset + dataSize - 1, "invalid index offset"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			malformed := append([]byte(nil), car...)
+			binary.LittleEndian.PutUint64(malformed[carv2.PragmaSize+16+tt.field:], tt.value)
+			_, err := carv2.InspectStream(struct{ io.Reader }{bytes.NewReader(malformed)}, false)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestInspectStreamingVerification(t *testing.T) {
+	reader, err := carv2.OpenReader("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	want, err := reader.Inspect(true)
+	require.NoError(t, err)
+
+	reader, err = carv2.OpenReader("testdata/sample-v1.car", carv2.MaxAllowedSectionSize(64))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	_, err = reader.Inspect(true)
+	require.ErrorContains(t, err, "length of read beyond allowable maximum")
+
+	reader, err = carv2.OpenReader("testdata/sample-v1.car", carv2.MaxAllowedSectionSize(64), carv2.WithStreamingVerification(7))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	got, err := reader.Inspect(true)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// Blocks decoded for their links must still be read into memory.
+	reader, err = carv2.OpenReader("testdata/sample-v1.car", carv2.MaxAllowedSectionSize(64), carv2.WithStreamingVerification(7), carv2.InspectLinks(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	_, err = reader.Inspect(true)
+	require.ErrorContains(t, err, "length of read beyond allowable maximum")
+}
+
 func TestInspectError(t *testing.T) {
 	tests := []struct {
 		name                 string
@@ -525,6 +820,12 @@ func TestInspectError(t *testing.T) {
 			} else {
 				require.NoError(t, err)
 			}
+			_, err = carv2.InspectStream(bytes.NewReader(car), tt.validateBlockHash)
+			if tt.expectedInspectError != "" {
+				require.EqualError(t, err, tt.expectedInspectError)
+			} else {
+				require.NoError(t, err)
+			}
 		})
 	}
 }
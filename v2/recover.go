@@ -0,0 +1,294 @@
+package car
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// RecoverReport describes the problems found, and repaired, by Recover.
+type RecoverReport struct {
+	// Version is the version of the recovered CAR.
+	Version uint64
+	// Sections is the number of valid sections retained in the data payload.
+	Sections uint64
+	// DataSize is the size of the recovered CARv1 data payload.
+	DataSize uint64
+	// DiscardedBytes is the number of bytes at the end of the source that were
+	// discarded, such as trailing garbage after the last valid section or the
+	// index, or an index that was regenerated.
+	DiscardedBytes uint64
+	// DataSizeFixed is set if the data size in the CARv2 header did not match
+	// the size of the valid data payload.
+	DataSizeFixed bool
+	// IndexRegenerated is set if the CARv2 index was missing, corrupt or did
+	// not match the valid data payload, and was regenerated.
+	IndexRegenerated bool
+}
+
+// Repaired reports whether the recovered CAR differs from the source.
+func (r RecoverReport) Repaired() bool {
+	return r.DiscardedBytes > 0 || r.DataSizeFixed || r.IndexRegenerated
+}
+
+// Recover reads a possibly damaged CAR of the given size from src, and writes
+// a repaired copy of it to dst. The following problems are repaired:
+//
+// • Trailing bytes after the last valid section of the data payload are
+// removed. A section is valid if it is complete and its data matches its CID.
+//
+// • A CARv2 index that is missing, corrupt or does not match the valid data
+// payload is regenerated, using the codec of the existing index when known,
+// and otherwise the codec set via UseIndexCodec. Any bytes after the index
+// are removed. A CARv2 whose header declares no index is left without one,
+// unless an index codec is set via UseIndexCodec.
+//
+// • A CARv2 data size that does not match the valid data payload is fixed.
+//
+// CARv1 sources result in a CARv1, and CARv2 sources in a CARv2 with the same
+// data offset and characteristics. Damage to the pragma, CARv2 header offsets
+// or CARv1 header cannot be recovered from and results in an error.
+//
+// The MaxAllowedHeaderSize, MaxAllowedSectionSize, MaxIndexCidSize and
+// StoreIdentityCIDs options are honored. Since the purpose of recovery is to
+// discard invalid data, the TrustedCAR and ZeroLengthSectionAsEOF options are
+// ignored, such that any padding after the last section is discarded.
+func Recover(src io.ReaderAt, size int64, dst io.Writer, opts ...Option) (RecoverReport, error) {
+	o := ApplyOptions(opts...)
+
+	rs, err := internalio.NewOffsetReadSeeker(src, 0)
+	if err != nil {
+		return RecoverReport{}, err
+	}
+	pragma, err := carv1.ReadHeader(rs, o.MaxAllowedHeaderSize)
+	if err != nil {
+		return RecoverReport{}, fmt.Errorf("error reading car header: %w", err)
+	}
+
+	switch pragma.Version {
+	case 1:
+		dataSize, sections, _, err := recoverPayload(src, 0, size, 0, o)
+		if err != nil {
+			return RecoverReport{}, err
+		}
+		report := RecoverReport{
+			Version:        1,
+			Sections:       sections,
+			DataSize:       uint64(dataSize),
+			DiscardedBytes: uint64(size - dataSize),
+		}
+		_, err = io.Copy(dst, io.NewSectionReader(src, 0, dataSize))
+		return report, err
+	case 2:
+		// Tell an index codec set by the caller apart from the default one.
+		var set Options
+		for _, opt := range opts {
+			opt(&set)
+		}
+		addIndex := set.IndexCodec != 0 && set.IndexCodec != index.CarIndexNone
+		return recoverV2(src, rs, size, dst, addIndex, o)
+	default:
+		return RecoverReport{}, fmt.Errorf("unsupported car version: %d", pragma.Version)
+	}
+}
+
+func recoverV2(src io.ReaderAt, rs io.Reader, size int64, dst io.Writer, addIndex bool, o Options) (RecoverReport, error) {
+	// Decode the header by hand rather than via Header.ReadFrom, since the
+	// latter rejects the invalid data sizes we intend to fix.
+	var h Header
+	if _, err := h.Characteristics.ReadFrom(rs); err != nil {
+		return RecoverReport{}, fmt.Errorf("error reading carv2 header: %w", err)
+	}
+	buf := make([]byte, 24)
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		return RecoverReport{}, fmt.Errorf("error reading carv2 header: %w", err)
+	}
+	h.DataOffset = binary.LittleEndian.Uint64(buf[:8])
+	h.DataSize = binary.LittleEndian.Uint64(buf[8:16])
+	h.IndexOffset = binary.LittleEndian.Uint64(buf[16:])
+	if h.DataOffset < PragmaSize+HeaderSize || h.DataOffset > uint64(size) {
+		return RecoverReport{}, fmt.Errorf("invalid data payload offset: %v", h.DataOffset)
+	}
+
+	dataOffset := int64(h.DataOffset)
+	declaredEnd := int64(-1)
+	if h.DataSize > 0 && h.DataSize <= uint64(size-dataOffset) {
+		declaredEnd = dataOffset + int64(h.DataSize)
+	}
+	dataEnd, sections, records, err := recoverPayload(src, dataOffset, size, declaredEnd, o)
+	if err != nil {
+		return RecoverReport{}, err
+	}
+	dataSize := uint64(dataEnd - dataOffset)
+
+	report := RecoverReport{
+		Version:       2,
+		Sections:      sections,
+		DataSize:      dataSize,
+		DataSizeFixed: dataSize != h.DataSize,
+	}
+
+	// A CARv2 written without an index is left without one.
+	if !h.HasIndex() && !addIndex {
+		report.DiscardedBytes = uint64(size - dataEnd)
+		h.DataSize = dataSize
+		if _, err := dst.Write(Pragma); err != nil {
+			return report, err
+		}
+		if _, err := h.WriteTo(dst); err != nil {
+			return report, err
+		}
+		_, err := io.Copy(dst, io.NewSectionReader(src, PragmaSize+HeaderSize, dataEnd-(PragmaSize+HeaderSize)))
+		return report, err
+	}
+
+	// Regenerate the index with the codec of the existing one if possible,
+	// and compare it against the existing one.
+	codec := o.IndexCodec
+	if h.IndexOffset != 0 && h.IndexOffset < uint64(size) {
+		ir, err := internalio.NewOffsetReadSeeker(src, int64(h.IndexOffset))
+		if err != nil {
+			return RecoverReport{}, err
+		}
+		if c, err := index.ReadCodec(ir); err == nil {
+			if _, err := index.New(c); err == nil {
+				codec = c
+			}
+		}
+	}
+	if codec == index.CarIndexNone {
+		codec = multicodec.CarMultihashIndexSorted
+	}
+	idx, err := index.New(codec)
+	if err != nil {
+		return RecoverReport{}, err
+	}
+	if !o.StoreIdentityCIDs && !h.Characteristics.IsFullyIndexed() {
+		filtered := records[:0]
+		for _, r := range records {
+			if r.Cid.Prefix().MhType != multihash.IDENTITY {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+	}
+	if err := index.LoadSizedRecords(idx, records); err != nil {
+		return RecoverReport{}, err
+	}
+	var idxBuf bytes.Buffer
+	if _, err := index.WriteTo(idx, &idxBuf); err != nil {
+		return RecoverReport{}, err
+	}
+
+	indexOffset := h.IndexOffset
+	if report.DataSizeFixed || indexOffset < uint64(dataEnd) || indexOffset > uint64(size) ||
+		uint64(idxBuf.Len()) > uint64(size)-indexOffset {
+		report.IndexRegenerated = true
+	} else {
+		existing := make([]byte, idxBuf.Len())
+		if _, err := src.ReadAt(existing, int64(indexOffset)); err != nil {
+			return RecoverReport{}, err
+		}
+		report.IndexRegenerated = !bytes.Equal(existing, idxBuf.Bytes())
+	}
+	if report.IndexRegenerated {
+		indexOffset = uint64(dataEnd)
+		report.DiscardedBytes = uint64(size - dataEnd)
+	} else {
+		report.DiscardedBytes = uint64(size) - indexOffset - uint64(idxBuf.Len())
+	}
+
+	h.DataSize = dataSize
+	h.IndexOffset = indexOffset
+	if _, err := dst.Write(Pragma); err != nil {
+		return report, err
+	}
+	if _, err := h.WriteTo(dst); err != nil {
+		return report, err
+	}
+	if _, err := io.Copy(dst, io.NewSectionReader(src, PragmaSize+HeaderSize, int64(indexOffset)-(PragmaSize+HeaderSize))); err != nil {
+		return report, err
+	}
+	_, err = idxBuf.WriteTo(dst)
+	return report, err
+}
+
+// recoverPayload scans the CARv1 payload starting at the given offset of src
+// and returns the end offset of its last valid section, the number of valid
+// sections and their index records. Scanning stops at the first invalid
+// section, at size, or at declaredEnd if a section ends exactly there.
+func recoverPayload(src io.ReaderAt, offset, size, declaredEnd int64, o Options) (int64, uint64, []index.SizedRecord, error) {
+	rs, err := internalio.NewOffsetReadSeeker(src, offset)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := carv1.ReadHeader(rs, o.MaxAllowedHeaderSize); err != nil {
+		return 0, 0, nil, fmt.Errorf("error reading car data payload header: %w", err)
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	end := offset + pos
+
+	var sections uint64
+	var records []index.SizedRecord
+	for end < size && end != declaredEnd {
+		c, sectionLen, dataLen, ok := util.ValidSection(src, end, size, o.MaxAllowedSectionSize, o.MaxIndexCidSize)
+		if !ok {
+			break
+		}
+		records = append(records, index.SizedRecord{Record: index.Record{Cid: c, Offset: uint64(end - offset)}, Size: uint64(dataLen)})
+		sections++
+		end += sectionLen
+	}
+	return end, sections, records, nil
+}
+
+// RecoverFile is a wrapper around Recover that takes filesystem paths. The
+// recovered CAR is written to a temporary file alongside dstPath, which is
+// then renamed to dstPath, so that srcPath and dstPath may be the same to
+// repair a CAR in-place.
+func RecoverFile(srcPath, dstPath string, opts ...Option) (report RecoverReport, err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return RecoverReport{}, err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return RecoverReport{}, err
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".recover-*")
+	if err != nil {
+		return RecoverReport{}, err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(dst.Name())
+		}
+	}()
+	if report, err = Recover(src, stat.Size(), dst, opts...); err != nil {
+		return report, err
+	}
+	if err = dst.Chmod(stat.Mode().Perm()); err != nil {
+		return report, err
+	}
+	if err = dst.Close(); err != nil {
+		return report, err
+	}
+	err = os.Rename(dst.Name(), dstPath)
+	return report, err
+}
@@ -0,0 +1,188 @@
+package car_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecover(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	v2, err := os.ReadFile("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	tailingCorrupt, err := os.ReadFile("testdata/sample-v1-tailing-corrupt-section.car")
+	require.NoError(t, err)
+	indexless, err := os.ReadFile("testdata/sample-v2-indexless.car")
+	require.NoError(t, err)
+
+	v2Header := func(src []byte, f func(*carv2.Header)) []byte {
+		r, err := carv2.NewReader(bytes.NewReader(src))
+		require.NoError(t, err)
+		h := r.Header
+		f(&h)
+		var buf bytes.Buffer
+		_, err = h.WriteTo(&buf)
+		require.NoError(t, err)
+		out := append([]byte{}, src...)
+		copy(out[carv2.PragmaSize:], buf.Bytes())
+		return out
+	}
+	v2IndexOffset := int(binary.LittleEndian.Uint64(v2[carv2.PragmaSize+32:]))
+
+	tests := []struct {
+		name            string
+		src             []byte
+		want            []byte
+		wantDiscarded   uint64
+		wantDataSizeFix bool
+		wantIndexRegen  bool
+	}{
+		{
+			name: "IntactV1",
+			src:  v1,
+			want: v1,
+		},
+		{
+			name: "IntactV2",
+			src:  v2,
+			want: v2,
+		},
+		{
+			name:          "V1TrailingGarbage",
+			src:           append(append([]byte{}, v1...), "garbage"...),
+			want:          v1,
+			wantDiscarded: 7,
+		},
+		{
+			name:          "V2TrailingGarbage",
+			src:           append(append([]byte{}, v2...), "garbage"...),
+			want:          v2,
+			wantDiscarded: 7,
+		},
+		{
+			name:            "V2WrongDataSize",
+			src:             v2Header(v2, func(h *carv2.Header) { h.DataSize += 10 }),
+			want:            v2,
+			wantDiscarded:   uint64(len(v2) - v2IndexOffset),
+			wantDataSizeFix: true,
+			wantIndexRegen:  true,
+		},
+		{
+			name:            "V2ZeroDataSize",
+			src:             v2Header(v2, func(h *carv2.Header) { h.DataSize = 0 }),
+			want:            v2,
+			wantDiscarded:   uint64(len(v2) - v2IndexOffset),
+			wantDataSizeFix: true,
+			wantIndexRegen:  true,
+		},
+		{
+			name:           "V2CorruptIndex",
+			src:            append(append([]byte{}, v2[:v2IndexOffset]...), bytes.Repeat([]byte{0xff}, 20)...),
+			want:           v2,
+			wantDiscarded:  20,
+			wantIndexRegen: true,
+		},
+		{
+			name:           "V2MissingIndex",
+			src:            v2[:v2IndexOffset],
+			want:           v2,
+			wantIndexRegen: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			report, err := carv2.Recover(bytes.NewReader(tt.src), int64(len(tt.src)), &out)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantDiscarded, report.DiscardedBytes)
+			require.Equal(t, tt.wantDataSizeFix, report.DataSizeFixed)
+			require.Equal(t, tt.wantIndexRegen, report.IndexRegenerated)
+			require.Equal(t, tt.wantDiscarded > 0 || tt.wantDataSizeFix || tt.wantIndexRegen, report.Repaired())
+			require.Equal(t, tt.want, out.Bytes())
+		})
+	}
+
+	t.Run("V1TailingCorruptSection", func(t *testing.T) {
+		var out bytes.Buffer
+		report, err := carv2.Recover(bytes.NewReader(tailingCorrupt), int64(len(tailingCorrupt)), &out)
+		require.NoError(t, err)
+		require.True(t, report.Repaired())
+		require.EqualValues(t, 1, report.Version)
+		require.EqualValues(t, out.Len(), report.DataSize)
+		require.EqualValues(t, len(tailingCorrupt)-out.Len(), report.DiscardedBytes)
+
+		br, err := carv2.NewBlockReader(bytes.NewReader(out.Bytes()))
+		require.NoError(t, err)
+		var count uint64
+		for {
+			_, err := br.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			count++
+		}
+		require.Equal(t, report.Sections, count)
+	})
+
+	t.Run("V2Indexless", func(t *testing.T) {
+		// A CARv2 written without an index is left as it is.
+		var out bytes.Buffer
+		report, err := carv2.Recover(bytes.NewReader(indexless), int64(len(indexless)), &out)
+		require.NoError(t, err)
+		require.False(t, report.Repaired())
+		require.Equal(t, indexless, out.Bytes())
+
+		// Trailing garbage is still removed.
+		out.Reset()
+		garbage := append(append([]byte{}, indexless...), "garbage"...)
+		report, err = carv2.Recover(bytes.NewReader(garbage), int64(len(garbage)), &out)
+		require.NoError(t, err)
+		require.EqualValues(t, 7, report.DiscardedBytes)
+		require.False(t, report.IndexRegenerated)
+		require.Equal(t, indexless, out.Bytes())
+
+		// An index is added if an index codec is set.
+		out.Reset()
+		report, err = carv2.Recover(bytes.NewReader(indexless), int64(len(indexless)), &out, carv2.UseIndexCodec(multicodec.CarMultihashIndexSorted))
+		require.NoError(t, err)
+		require.True(t, report.IndexRegenerated)
+		require.False(t, report.DataSizeFixed)
+
+		r, err := carv2.NewReader(bytes.NewReader(out.Bytes()))
+		require.NoError(t, err)
+		require.True(t, r.Header.HasIndex())
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		require.NotNil(t, ir)
+	})
+}
+
+func TestRecoverFileInPlace(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "sample.car")
+	require.NoError(t, os.WriteFile(path, append(append([]byte{}, v1...), "garbage"...), 0o640))
+
+	report, err := carv2.RecoverFile(path, path)
+	require.NoError(t, err)
+	require.EqualValues(t, 7, report.DiscardedBytes)
+
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, v1, got)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
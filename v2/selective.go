@@ -1,35 +1,51 @@
 package car
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/loader"
+	dagpb "github.com/ipld/go-codec-dagpb"
 	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/traversal"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
 )
 
 // ErrSizeMismatch is returned when a written traversal realizes the written header size does not
-// match the actual number of car bytes written.
-var ErrSizeMismatch = fmt.Errorf("car-error-sizemismatch")
+// match the actual number of car bytes written. It is returned wrapped, with the
+// sizes at odds, by the writers of NewSelectiveWriter and TraverseToFile; test
+// for it with errors.Is.
+var ErrSizeMismatch = errors.New("car-error-sizemismatch")
 
 // ErrOffsetImpossible is returned when specified paddings or offsets of either a wrapped carv1
-// or index cannot be satisfied based on the data being written.
-var ErrOffsetImpossible = fmt.Errorf("car-error-offsetimpossible")
+// or index cannot be satisfied based on the data being written. It is returned
+// wrapped, with the offsets at odds, by the selective writers, including when
+// the CAR exceeds WithTargetPayloadSize; test for it with errors.Is.
+var ErrOffsetImpossible = errors.New("car-error-offsetimpossible")
 
 // MaxTraversalLinks changes the allowed number of links a selector traversal
-// can execute before failing.
+// can execute before failing. The root of the traversal counts as a link, and
+// traversals of several DAGs share the budget.
 //
 // Note that setting this option may cause an error to be returned from selector
 // execution when building a SelectiveCar.
@@ -39,40 +55,630 @@ func MaxTraversalLinks(MaxTraversalLinks uint64) Option {
 	}
 }
 
+// FlushEveryBytes makes selective CAR writes flush the underlying writer once at
+// least the given number of bytes have been written since the last flush.
+//
+// Flushing only happens if the writer implements either Flush() error, such as
+// bufio.Writer, or Flush(), such as http.Flusher. Flushes occur on block
+// boundaries, and once more when the CAR payload has been written entirely.
+// This is useful when streaming CARs over HTTP, where data sitting in buffers
+// may otherwise cause clients to time out.
+func FlushEveryBytes(n uint64) Option {
+	return func(o *Options) {
+		o.FlushEveryBytes = n
+	}
+}
+
+// FlushEveryBlocks makes selective CAR writes flush the underlying writer once
+// at least the given number of blocks have been written since the last flush.
+//
+// See FlushEveryBytes for the conditions under which flushing occurs.
+func FlushEveryBlocks(n uint64) Option {
+	return func(o *Options) {
+		o.FlushEveryBlocks = n
+	}
+}
+
+// FlushInterval makes selective CAR writes flush the underlying writer once at
+// least the given duration has elapsed since the last flush. Elapsed time is
+// checked whenever a block is written, so a slow block load delays the flush
+// until the block is written.
+//
+// See FlushEveryBytes for the conditions under which flushing occurs.
+func FlushInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.FlushInterval = d
+	}
+}
+
+// WithTraversalPrefetch makes selective CAR writes load blocks from the link
+// system using the given number of parallel workers, ahead of the selector
+// traversal, which hides the latency of link systems backed by slow or
+// networked storage.
+//
+// While traversing, the children of each block loaded are speculatively
+// prefetched, for blocks of codecs with a registered decoder. When writing a
+// PreparedSelectiveCar, whose blocks are known, the upcoming blocks are
+// prefetched instead. Either way, blocks are still written in the traversal
+// order, such that the CAR written is identical to the one written without
+// prefetching; only blocks which the selector does not match may be loaded
+// needlessly.
+//
+// The StorageReadOpener of the link system must be safe for concurrent use.
+// A number of workers of zero, the default, disables prefetching.
+func WithTraversalPrefetch(workers int) Option {
+	return func(o *Options) {
+		o.TraversalPrefetchWorkers = workers
+	}
+}
+
+// WithADLs makes selective traversals reify the blocks of the given codecs
+// they load with the given ADL reifiers, such that selectors apply to the
+// reified nodes rather than to the blocks themselves. The blocks loaded by the
+// reifiers, such as the shards of a HAMT, are written to the CAR along with the
+// blocks they were reified from. This spares callers from adding the
+// reification to their LinkSystem, or from wrapping their selectors in
+// ExploreInterpretAs clauses.
+//
+// Reified nodes of kind bytes, such as UnixFS files, are read in full whenever
+// the traversal reaches them, such that all the blocks they span are written,
+// since the selector cannot explore their links.
+//
+// Blocks of other codecs, as well as blocks loaded by the reifiers themselves,
+// are left to the NodeReifier of the LinkSystem, if any.
+func WithADLs(adls map[multicodec.Code]ipld.NodeReifier) Option {
+	return func(o *Options) {
+		o.TraversalADLs = adls
+	}
+}
+
+// WithUnixFSADL sets whether selective traversals reify dag-pb blocks as
+// UnixFS, via WithADLs, such that HAMT-sharded directories are traversed as
+// maps of their entries and sharded files as their bytes. Selectors then apply
+// to UnixFS paths, e.g. an ExploreFields over the name of an entry of a
+// sharded directory, and matching a file writes all of its blocks to the CAR.
+//
+// dag-pb blocks are loaded as dagpb.Type.PBNode, as the UnixFS ADL requires,
+// regardless of WithTraversalPrototypeChooser.
+func WithUnixFSADL(enable bool) Option {
+	return func(o *Options) {
+		adls := make(map[multicodec.Code]ipld.NodeReifier, len(o.TraversalADLs)+1)
+		for codec, reifier := range o.TraversalADLs {
+			adls[codec] = reifier
+		}
+		if enable {
+			adls[multicodec.DagPb] = unixfsnode.Reify
+		} else {
+			delete(adls, multicodec.DagPb)
+		}
+		o.TraversalADLs = adls
+	}
+}
+
+// adlLinkSystem sets up ls to reify the nodes it loads with the reifiers set
+// via WithADLs, and returns the prototype chooser to load them with.
+//
+// Nodes are reified according to the codec of the link they were loaded from,
+// which traversals carry in the LinkNode of the LinkContext; nodes loaded by
+// the reifiers themselves carry none and are left as is.
+func adlLinkSystem(ls *ipld.LinkSystem, chooser traversal.LinkTargetNodePrototypeChooser, adls map[multicodec.Code]ipld.NodeReifier) traversal.LinkTargetNodePrototypeChooser {
+	if len(adls) == 0 {
+		return chooser
+	}
+	fallback := ls.NodeReifier
+	ls.NodeReifier = func(lc linking.LinkContext, n datamodel.Node, lsys *ipld.LinkSystem) (datamodel.Node, error) {
+		if lc.LinkNode != nil {
+			if l, err := lc.LinkNode.AsLink(); err == nil {
+				if cl, ok := l.(cidlink.Link); ok {
+					if reifier, ok := adls[multicodec.Code(cl.Prefix().Codec)]; ok {
+						return reifier(lc, n, lsys)
+					}
+				}
+			}
+		}
+		if fallback == nil {
+			return n, nil
+		}
+		return fallback(lc, n, lsys)
+	}
+	if _, ok := adls[multicodec.DagPb]; ok {
+		chooser = dagpb.AddSupportToChooser(chooser)
+	}
+	return chooser
+}
+
+// SizeCache caches the size of the data payload of selective CARs across
+// calls to NewSelectiveWriter, keyed by the root and selector of the CAR, such
+// that repeated requests for the same content do not need to traverse it once
+// to compute its size and once more to write it. It may be backed by an
+// in-memory LRU or by a shared store, e.g. Redis, and must be safe for
+// concurrent use.
+//
+// Keys are opaque strings derived from the root, the selector, and the options
+// affecting which blocks are written; values are only valid for the content
+// behind the link system used to compute them.
+type SizeCache interface {
+	// GetSize returns the size cached under key, and whether it was found.
+	GetSize(ctx context.Context, key string) (size uint64, found bool, err error)
+	// PutSize caches the size under key.
+	PutSize(ctx context.Context, key string, size uint64) error
+}
+
+// WithSizeCache makes NewSelectiveWriter look up the size of the CAR in the
+// given cache, and write the CAR in a single traversal when found, rather than
+// traversing the DAG to prepare the CAR first. Sizes computed on a cache miss
+// are stored in the cache.
+//
+// Since the size is trusted, writing a CAR whose content no longer matches its
+// cached size fails with ErrSizeMismatch, after some of it has been written;
+// callers should then evict or overwrite the cache entry. The cache is not used
+// along with WithTargetPayloadSize, which requires preparing the CAR.
+func WithSizeCache(c SizeCache) Option {
+	return func(o *Options) {
+		o.SelectiveSizeCache = c
+	}
+}
+
+// sizeCacheKey returns the key under which the size of the selective CAR of
+// the given DAGs is cached.
+func sizeCacheKey(dags []Dag, opts Options) (string, error) {
+	keys := make([]string, 0, len(dags))
+	for _, dag := range dags {
+		var sel bytes.Buffer
+		if err := dagcbor.Encode(dag.Selector, &sel); err != nil {
+			return "", fmt.Errorf("failed to encode selector: %w", err)
+		}
+		keys = append(keys, fmt.Sprintf("%s/%x", dag.Root, sha256.Sum256(sel.Bytes())))
+	}
+	key := fmt.Sprintf("%s/%d/%t", strings.Join(keys, ","), opts.MaxTraversalLinks, opts.BlockstoreAllowDuplicatePuts)
+	if len(opts.TraversalADLs) > 0 {
+		// Reifiers cannot be told apart, so only their codecs are keyed on.
+		codecs := make([]string, 0, len(opts.TraversalADLs))
+		for codec := range opts.TraversalADLs {
+			codecs = append(codecs, codec.String())
+		}
+		sort.Strings(codecs)
+		key += "/" + strings.Join(codecs, ",")
+	}
+	return key, nil
+}
+
+// Dag is a root and a selector over the DAG under it, such that a selective CAR
+// may cover several DAGs. See NewSelectiveWriterDags.
+type Dag struct {
+	Root     cid.Cid
+	Selector ipld.Node
+}
+
+// TraversalReport describes the selector traversal performed to produce a
+// selective CAR, so that callers applying a MaxTraversalLinks budget can tell
+// how close the traversal came to exhausting it.
+type TraversalReport struct {
+	// LinksVisited is the number of links loaded by the traversal, including
+	// the root, each of which is charged to the MaxTraversalLinks budget.
+	LinksVisited uint64
+	// BytesWritten is the number of CAR bytes produced.
+	BytesWritten uint64
+	// BudgetRemaining is the number of further links the traversal could have
+	// followed before exceeding MaxTraversalLinks, or zero if it is not set.
+	BudgetRemaining uint64
+	// Truncated is set if the traversal stopped because it exceeded
+	// MaxTraversalLinks, in which case an error wrapping a
+	// traversal.ErrBudgetExceeded is returned alongside the report.
+	Truncated bool
+}
+
+// WithTargetPayloadSize makes selective CAR writes pad their output with the
+// given byte so that exactly n bytes are written in total, as required when
+// the CAR must fill a piece of a given size, e.g. the next power of two.
+//
+// The padding is written right after the last block. For a CARv2, it precedes
+// any index padding and the index, and the header accounts for it such that
+// the data payload and the index remain readable. For a CARv1, the padding
+// trails the data payload, and zero padding can be skipped by readers via the
+// ZeroLengthSectionAsEOF option.
+//
+// An error wrapping ErrOffsetImpossible is returned if the CAR is larger than
+// n bytes without any padding. Since TraverseV1 and TraverseToFile stream the
+// CAR, they can only detect this once the CAR has been written.
+func WithTargetPayloadSize(n uint64, padByte byte) Option {
+	return func(o *Options) {
+		o.TargetPayloadSize = n
+		o.PayloadPadByte = padByte
+	}
+}
+
 // NewSelectiveWriter walks through the proposed dag traversal to learn its total size in order to be able to
 // stream out a car to a writer in the expected traversal order in one go.
+//
+// The returned Writer is a PreparedSelectiveCar; see PrepareSelectiveCar. If
+// the size of the CAR is found in the cache set via WithSizeCache, the DAG is
+// not traversed up front, and is instead traversed when the returned Writer
+// writes the CAR.
 func NewSelectiveWriter(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, selector ipld.Node, opts ...Option) (Writer, error) {
-	cls, cntr := loader.CountingLinkSystem(*ls)
+	return NewSelectiveWriterDags(ctx, ls, []Dag{{Root: root, Selector: selector}}, opts...)
+}
 
-	c1h := carv1.CarHeader{Roots: []cid.Cid{root}, Version: 1}
-	headSize, err := carv1.HeaderSize(&c1h)
+// NewSelectiveWriterDags is like NewSelectiveWriter, but writes a CAR covering
+// several DAGs, each matching its own selector, in a single pass. The header
+// of the CAR lists the roots of the DAGs in order, and their blocks are written
+// in traversal order, one DAG after the other. Blocks shared by several DAGs
+// are only written once, where first reached.
+//
+// The MaxTraversalLinks budget applies to the traversals of all DAGs combined.
+func NewSelectiveWriterDags(ctx context.Context, ls *ipld.LinkSystem, dags []Dag, opts ...Option) (Writer, error) {
+	if len(dags) == 0 {
+		return nil, errNoDags
+	}
+	o := ApplyOptions(opts...)
+	if o.SelectiveSizeCache == nil || o.TargetPayloadSize > 0 {
+		return PrepareSelectiveCarDags(ctx, ls, dags, opts...)
+	}
+
+	key, err := sizeCacheKey(dags, o)
 	if err != nil {
 		return nil, err
 	}
-	if err := traverse(ctx, &cls, root, selector, ApplyOptions(opts...)); err != nil {
+	size, found, err := o.SelectiveSizeCache.GetSize(ctx, key)
+	if err != nil {
 		return nil, err
 	}
-	tc := traversalCar{
-		size:     headSize + cntr.Size(),
-		ctx:      ctx,
-		root:     root,
-		selector: selector,
-		ls:       ls,
-		opts:     ApplyOptions(opts...),
+	if found {
+		return &traversalCar{
+			size: size,
+			ctx:  ctx,
+			dags: dags,
+			ls:   ls,
+			opts: o,
+		}, nil
+	}
+	p, err := PrepareSelectiveCarDags(ctx, ls, dags, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.SelectiveSizeCache.PutSize(ctx, key, p.dataSize); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PreparedSelectiveCar is a CARv2 matching given roots and selectors whose
+// traversal has already been performed, such that its size and the CIDs of its
+// blocks are known before it is written. It can be written any number of
+// times, in full or in part, without repeating the selector traversal; only
+// the blocks themselves are loaded again from the link system.
+//
+// See PrepareSelectiveCar.
+type PreparedSelectiveCar struct {
+	ctx      context.Context
+	ls       *ipld.LinkSystem
+	opts     Options
+	sections []preparedSection
+	size     uint64
+	dataSize uint64
+	report   TraversalReport
+}
+
+// preparedSection is a contiguous part of a PreparedSelectiveCar. Sections
+// holding a block leave data nil and load it when written, while padding
+// sections leave data nil and repeat padByte.
+type preparedSection struct {
+	data    []byte
+	cid     cid.Cid
+	length  uint64
+	size    uint64 // of the block data, for blocks
+	padding bool
+	padByte byte
+}
+
+var _ Writer = (*PreparedSelectiveCar)(nil)
+
+// PrepareSelectiveCar walks through the proposed dag traversal once, caching
+// the CIDs and sizes of the blocks it visits, and returns a
+// PreparedSelectiveCar which can subsequently be written in the traversal
+// order via WriteTo or WriteRange.
+//
+// The given context is used whenever blocks are loaded from the link system,
+// including by subsequent writes.
+func PrepareSelectiveCar(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, selector ipld.Node, opts ...Option) (*PreparedSelectiveCar, error) {
+	return PrepareSelectiveCarDags(ctx, ls, []Dag{{Root: root, Selector: selector}}, opts...)
+}
+
+// PrepareSelectiveCarDags is like PrepareSelectiveCar, but prepares a CAR
+// covering several DAGs; see NewSelectiveWriterDags.
+func PrepareSelectiveCarDags(ctx context.Context, ls *ipld.LinkSystem, dags []Dag, opts ...Option) (*PreparedSelectiveCar, error) {
+	if len(dags) == 0 {
+		return nil, errNoDags
+	}
+	o := ApplyOptions(opts...)
+
+	pls, prefetcher := loader.PrefetchingLinkSystem(ctx, *ls, o.TraversalPrefetchWorkers, true)
+	defer prefetcher.Close()
+
+	var blks []preparedSection
+	seen := make(map[cid.Cid]struct{})
+	rls := pls
+	rls.StorageReadOpener = func(lc linking.LinkContext, l ipld.Link) (io.Reader, error) {
+		r, err := pls.StorageReadOpener(lc, l)
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(nil)
+		n, err := buf.ReadFrom(r)
+		if err != nil {
+			return nil, err
+		}
+		_, c, err := cid.CidFromBytes([]byte(l.Binary()))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[c]; !ok {
+			seen[c] = struct{}{}
+			sectionLen := uint64(len(l.Binary())) + uint64(n)
+			blks = append(blks, preparedSection{cid: c, length: uint64(varint.UvarintSize(sectionLen)) + sectionLen, size: uint64(n)})
+		}
+		return buf, nil
+	}
+	report, err := traverseDags(ctx, &rls, dags, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var v1h bytes.Buffer
+	if err := carv1.WriteHeader(&carv1.CarHeader{Roots: dagRoots(dags), Version: 1}, &v1h); err != nil {
+		return nil, err
+	}
+	records := make([]index.SizedRecord, 0, len(blks))
+	v1Size := uint64(v1h.Len())
+	for _, b := range blks {
+		records = append(records, index.SizedRecord{Record: index.Record{Cid: b.cid, Offset: v1Size}, Size: b.size})
+		v1Size += b.length
+	}
+
+	var idxBuf *bytes.Buffer
+	if o.IndexCodec != index.CarIndexNone {
+		idx, err := index.New(o.IndexCodec)
+		if err != nil {
+			return nil, err
+		}
+		if err := index.LoadSizedRecords(idx, records); err != nil {
+			return nil, err
+		}
+		idxBuf = bytes.NewBuffer(make([]byte, o.IndexPadding))
+		if _, err := index.WriteTo(idx, idxBuf); err != nil {
+			return nil, err
+		}
+	}
+
+	// Reuse traversalCar to encode the CARv2 header, now that the size of the
+	// CARv1 payload is known.
+	var v2h bytes.Buffer
+	tc := traversalCar{size: v1Size, opts: o}
+	if _, err := tc.WriteV2Header(&v2h); err != nil {
+		return nil, err
+	}
+	if o.TargetPayloadSize > 0 {
+		size := uint64(v2h.Len()) + v1Size
+		if idxBuf != nil {
+			size += uint64(idxBuf.Len())
+		}
+		if tc.padding, err = targetPadding(size, o); err != nil {
+			return nil, err
+		}
+		if tc.padding > 0 {
+			// Encode the header again, now including the padding.
+			v2h.Reset()
+			if _, err := tc.WriteV2Header(&v2h); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p := &PreparedSelectiveCar{ctx: ctx, ls: ls, opts: o, dataSize: v1Size, report: report}
+	p.appendBytes(v2h.Bytes())
+	p.appendBytes(v1h.Bytes())
+	p.sections = append(p.sections, blks...)
+	p.size += v1Size - uint64(v1h.Len())
+	if tc.padding > 0 {
+		p.sections = append(p.sections, preparedSection{length: tc.padding, padding: true, padByte: o.PayloadPadByte})
+		p.size += tc.padding
+	}
+	if idxBuf != nil {
+		p.appendBytes(idxBuf.Bytes())
+	}
+	p.report.BytesWritten = p.size
+	return p, nil
+}
+
+// targetPadding returns the amount of padding needed for a CAR of the given
+// size to reach the size set via WithTargetPayloadSize.
+func targetPadding(size uint64, opts Options) (uint64, error) {
+	if size > opts.TargetPayloadSize {
+		return 0, fmt.Errorf("%w: car size %d exceeds target payload size %d", ErrOffsetImpossible, size, opts.TargetPayloadSize)
 	}
-	return &tc, nil
+	return opts.TargetPayloadSize - size, nil
+}
+
+// writePadding writes n copies of the given byte to w.
+func writePadding(w io.Writer, n uint64, b byte) (int64, error) {
+	buf := bytes.Repeat([]byte{b}, int(min(n, 32<<10)))
+	var written int64
+	for n > 0 {
+		chunk := buf[:min(n, uint64(len(buf)))]
+		wn, err := w.Write(chunk)
+		written += int64(wn)
+		if err != nil {
+			return written, err
+		}
+		n -= uint64(wn)
+	}
+	return written, nil
+}
+
+func (p *PreparedSelectiveCar) appendBytes(b []byte) {
+	p.sections = append(p.sections, preparedSection{data: b, length: uint64(len(b))})
+	p.size += uint64(len(b))
+}
+
+// Size returns the total number of bytes written by WriteTo.
+func (p *PreparedSelectiveCar) Size() uint64 {
+	return p.size
+}
+
+// TraversalReport returns the report of the traversal performed when the CAR
+// was prepared, where BytesWritten is the size of the whole CAR.
+func (p *PreparedSelectiveCar) TraversalReport() TraversalReport {
+	return p.report
+}
+
+// Cids returns the CIDs of the blocks in the CAR, in the order in which they
+// are written.
+func (p *PreparedSelectiveCar) Cids() []cid.Cid {
+	var cids []cid.Cid
+	for _, s := range p.sections {
+		if s.data == nil && !s.padding {
+			cids = append(cids, s.cid)
+		}
+	}
+	return cids
+}
+
+// WriteTo writes the CARv2 to w, loading its blocks from the link system.
+// ErrSizeMismatch is returned if a loaded block does not match the size it had
+// when the CAR was prepared.
+func (p *PreparedSelectiveCar) WriteTo(w io.Writer) (int64, error) {
+	return p.WriteRange(w, 0, p.size)
+}
+
+// WriteRange writes length bytes of the CARv2 to w, starting at the given
+// offset, as if a slice of the output of WriteTo were taken. Only the blocks
+// overlapping the range are loaded from the link system.
+func (p *PreparedSelectiveCar) WriteRange(w io.Writer, offset, length uint64) (int64, error) {
+	if offset > p.size || length > p.size-offset {
+		return 0, fmt.Errorf("range [%d, %d) exceeds car size %d", offset, offset+length, p.size)
+	}
+
+	fw := newFlushingWriter(w, p.opts)
+	if fw != nil {
+		w = fw
+	}
+
+	// Blocks are loaded in order, so the upcoming ones can be prefetched
+	// while each is written.
+	ls, prefetcher := loader.PrefetchingLinkSystem(p.ctx, *p.ls, p.opts.TraversalPrefetchWorkers, false)
+	defer prefetcher.Close()
+	var upcoming []cid.Cid
+	if prefetcher != nil {
+		upcoming = p.blocksInRange(offset, offset+length)
+	}
+
+	var written int64
+	var start uint64
+	end := offset + length
+	for _, s := range p.sections {
+		sEnd := start + s.length
+		if sEnd <= offset {
+			start = sEnd
+			continue
+		}
+		if start >= end {
+			break
+		}
+		from, to := uint64(0), s.length
+		if offset > start {
+			from = offset - start
+		}
+		if end < sEnd {
+			to = end - start
+		}
+		if s.padding {
+			n, err := writePadding(w, to-from, s.padByte)
+			written += n
+			if err != nil {
+				return written, err
+			}
+			start = sEnd
+			continue
+		}
+		data := s.data
+		if data == nil {
+			if len(upcoming) > 0 {
+				for _, c := range upcoming[:min(len(upcoming), p.opts.TraversalPrefetchWorkers+1)] {
+					prefetcher.Prefetch(c)
+				}
+				upcoming = upcoming[1:]
+			}
+			var err error
+			if data, err = p.loadSection(&ls, s); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.Write(data[from:to])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if fw != nil && s.data == nil {
+			if err := fw.blockWritten(); err != nil {
+				return written, err
+			}
+		}
+		start = sEnd
+	}
+	if fw != nil {
+		if err := fw.flush(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// blocksInRange returns the CIDs of the blocks overlapping the given range of
+// the CAR, in the order in which they are written.
+func (p *PreparedSelectiveCar) blocksInRange(from, to uint64) []cid.Cid {
+	var cids []cid.Cid
+	var start uint64
+	for _, s := range p.sections {
+		sEnd := start + s.length
+		if sEnd > from && start < to && s.data == nil && !s.padding {
+			cids = append(cids, s.cid)
+		}
+		start = sEnd
+	}
+	return cids
+}
+
+// loadSection loads the block of the given section from ls, returning it
+// encoded as a CAR section.
+func (p *PreparedSelectiveCar) loadSection(ls *ipld.LinkSystem, s preparedSection) ([]byte, error) {
+	r, err := ls.StorageReadOpener(ipld.LinkContext{Ctx: p.ctx}, cidlink.Link{Cid: s.cid})
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sectionLen := uint64(s.cid.ByteLen()) + uint64(len(data))
+	buf := make([]byte, 0, s.length)
+	buf = append(buf, varint.ToUvarint(sectionLen)...)
+	buf = append(buf, s.cid.Bytes()...)
+	buf = append(buf, data...)
+	if uint64(len(buf)) != s.length {
+		return nil, fmt.Errorf("%w: section of %s is %d bytes, expected %d", ErrSizeMismatch, s.cid, len(buf), s.length)
+	}
+	return buf, nil
 }
 
 // TraverseToFile writes a car file matching a given root and selector to the
 // path at `destination` using one read of each block.
 func TraverseToFile(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, selector ipld.Node, destination string, opts ...Option) error {
 	tc := traversalCar{
-		size:     0,
-		ctx:      ctx,
-		root:     root,
-		selector: selector,
-		ls:       ls,
-		opts:     ApplyOptions(opts...),
+		size: 0,
+		ctx:  ctx,
+		dags: []Dag{{Root: root, Selector: selector}},
+		ls:   ls,
+		opts: ApplyOptions(opts...),
 	}
 
 	fp, err := os.Create(destination)
@@ -101,18 +707,47 @@ func TraverseToFile(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, sele
 
 // TraverseV1 walks through the proposed dag traversal and writes a carv1 to the provided io.Writer
 func TraverseV1(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, selector ipld.Node, writer io.Writer, opts ...Option) (uint64, error) {
+	n, _, err := TraverseV1WithReport(ctx, ls, root, selector, writer, opts...)
+	return n, err
+}
+
+// TraverseV1WithReport is like TraverseV1, but also returns a TraversalReport
+// describing the traversal. The report is returned even if an error occurs.
+func TraverseV1WithReport(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, selector ipld.Node, writer io.Writer, opts ...Option) (uint64, TraversalReport, error) {
+	return traverseV1(ctx, ls, []Dag{{Root: root, Selector: selector}}, writer, opts...)
+}
+
+// TraverseV1Dags is like TraverseV1, but writes a carv1 covering several DAGs
+// in a single pass; see NewSelectiveWriterDags.
+func TraverseV1Dags(ctx context.Context, ls *ipld.LinkSystem, dags []Dag, writer io.Writer, opts ...Option) (uint64, error) {
+	if len(dags) == 0 {
+		return 0, errNoDags
+	}
+	n, _, err := traverseV1(ctx, ls, dags, writer, opts...)
+	return n, err
+}
+
+func traverseV1(ctx context.Context, ls *ipld.LinkSystem, dags []Dag, writer io.Writer, opts ...Option) (uint64, TraversalReport, error) {
 	opts = append(opts, WithoutIndex())
 	tc := traversalCar{
-		size:     0,
-		ctx:      ctx,
-		root:     root,
-		selector: selector,
-		ls:       ls,
-		opts:     ApplyOptions(opts...),
+		size: 0,
+		ctx:  ctx,
+		dags: dags,
+		ls:   ls,
+		opts: ApplyOptions(opts...),
 	}
 
 	len, _, err := tc.WriteV1(writer)
-	return len, err
+	if err == nil && tc.opts.TargetPayloadSize > 0 {
+		var pad uint64
+		if pad, err = targetPadding(len, tc.opts); err == nil {
+			var n int64
+			n, err = writePadding(writer, pad, tc.opts.PayloadPadByte)
+			len += uint64(n)
+		}
+	}
+	tc.report.BytesWritten = len
+	return len, tc.report, err
 }
 
 // Writer is an interface allowing writing a car prepared by PrepareTraversal
@@ -123,12 +758,15 @@ type Writer interface {
 var _ Writer = (*traversalCar)(nil)
 
 type traversalCar struct {
-	size     uint64
-	ctx      context.Context
-	root     cid.Cid
-	selector ipld.Node
-	ls       *ipld.LinkSystem
-	opts     Options
+	size   uint64
+	ctx    context.Context
+	dags   []Dag
+	ls     *ipld.LinkSystem
+	opts   Options
+	report TraversalReport
+	// padding is written between the data payload and any index padding to
+	// reach the size set via WithTargetPayloadSize.
+	padding uint64
 }
 
 func (tc *traversalCar) WriteTo(w io.Writer) (int64, error) {
@@ -143,6 +781,28 @@ func (tc *traversalCar) WriteTo(w io.Writer) (int64, error) {
 		return n, err
 	}
 
+	// padding to the target size, which requires the size of the index
+	var idxBuf bytes.Buffer
+	if tc.opts.IndexCodec != index.CarIndexNone {
+		if _, err := index.WriteTo(idx, &idxBuf); err != nil {
+			return n, err
+		}
+	}
+	if tc.opts.TargetPayloadSize > 0 {
+		size := uint64(n) + uint64(idxBuf.Len())
+		if tc.opts.IndexCodec != index.CarIndexNone {
+			size += tc.opts.IndexPadding
+		}
+		if tc.padding, err = targetPadding(size, tc.opts); err != nil {
+			return n, err
+		}
+		pn, err := writePadding(w, tc.padding, tc.opts.PayloadPadByte)
+		n += pn
+		if err != nil {
+			return n, err
+		}
+	}
+
 	// index padding, then index
 	if tc.opts.IndexCodec != index.CarIndexNone {
 		if tc.opts.IndexPadding > 0 {
@@ -153,8 +813,8 @@ func (tc *traversalCar) WriteTo(w io.Writer) (int64, error) {
 				return n, err
 			}
 		}
-		in, err := index.WriteTo(idx, w)
-		n += int64(in)
+		in, err := idxBuf.WriteTo(w)
+		n += in
 		if err != nil {
 			return n, err
 		}
@@ -173,7 +833,7 @@ func (tc *traversalCar) WriteV2Header(w io.Writer) (int64, error) {
 	if p := tc.opts.DataPadding; p > 0 {
 		h = h.WithDataPadding(p)
 	}
-	if p := tc.opts.IndexPadding; p > 0 {
+	if p := tc.opts.IndexPadding + tc.padding; p > 0 {
 		h = h.WithIndexPadding(p)
 	}
 	if tc.opts.IndexCodec == index.CarIndexNone {
@@ -195,7 +855,7 @@ func (tc *traversalCar) WriteV2Header(w io.Writer) (int64, error) {
 			return hn, err
 		}
 	} else if h.DataOffset < uint64(hn) {
-		return hn, ErrOffsetImpossible
+		return hn, fmt.Errorf("%w: data offset %d is within the header of %d bytes", ErrOffsetImpossible, h.DataOffset, hn)
 	}
 
 	return hn, nil
@@ -203,7 +863,7 @@ func (tc *traversalCar) WriteV2Header(w io.Writer) (int64, error) {
 
 func (tc *traversalCar) WriteV1(w io.Writer) (uint64, index.Index, error) {
 	// write the v1 header
-	c1h := carv1.CarHeader{Roots: []cid.Cid{tc.root}, Version: 1}
+	c1h := carv1.CarHeader{Roots: dagRoots(tc.dags), Version: 1}
 	if err := carv1.WriteHeader(&c1h, w); err != nil {
 		return 0, nil, err
 	}
@@ -213,14 +873,27 @@ func (tc *traversalCar) WriteV1(w io.Writer) (uint64, index.Index, error) {
 	}
 
 	// write the block.
-	wls, writer := loader.TeeingLinkSystem(*tc.ls, w, v1Size, tc.opts.IndexCodec)
-	err = traverse(tc.ctx, &wls, tc.root, tc.selector, tc.opts)
+	var onBlock func() error
+	fw := newFlushingWriter(w, tc.opts)
+	if fw != nil {
+		w = fw
+		onBlock = fw.blockWritten
+	}
+	pls, prefetcher := loader.PrefetchingLinkSystem(tc.ctx, *tc.ls, tc.opts.TraversalPrefetchWorkers, true)
+	defer prefetcher.Close()
+	wls, writer := loader.TeeingLinkSystem(pls, w, v1Size, tc.opts.IndexCodec, onBlock)
+	tc.report, err = traverseDags(tc.ctx, &wls, tc.dags, tc.opts)
 	v1Size = writer.Size()
 	if err != nil {
 		return v1Size, nil, err
 	}
+	if fw != nil {
+		if err := fw.flush(); err != nil {
+			return v1Size, nil, err
+		}
+	}
 	if tc.size != 0 && tc.size != v1Size {
-		return v1Size, nil, ErrSizeMismatch
+		return v1Size, nil, fmt.Errorf("%w: wrote %d bytes of payload, expected %d", ErrSizeMismatch, v1Size, tc.size)
 	}
 	tc.size = v1Size
 
@@ -231,10 +904,116 @@ func (tc *traversalCar) WriteV1(w io.Writer) (uint64, index.Index, error) {
 	return v1Size, idx, err
 }
 
-func traverse(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, s ipld.Node, opts Options) error {
+// flushingWriter flushes the writer it wraps on block boundaries, according to
+// the configured flush options.
+type flushingWriter struct {
+	w           io.Writer
+	flushFn     func() error
+	everyBytes  uint64
+	everyBlocks uint64
+	interval    time.Duration
+
+	bytes     uint64
+	blocks    uint64
+	lastFlush time.Time
+}
+
+// newFlushingWriter returns a flushingWriter wrapping w, or nil if no flush
+// option is set or w cannot be flushed.
+func newFlushingWriter(w io.Writer, opts Options) *flushingWriter {
+	if opts.FlushEveryBytes == 0 && opts.FlushEveryBlocks == 0 && opts.FlushInterval == 0 {
+		return nil
+	}
+	var flushFn func() error
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		flushFn = f.Flush
+	case interface{ Flush() }:
+		flushFn = func() error {
+			f.Flush()
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &flushingWriter{
+		w:           w,
+		flushFn:     flushFn,
+		everyBytes:  opts.FlushEveryBytes,
+		everyBlocks: opts.FlushEveryBlocks,
+		interval:    opts.FlushInterval,
+		lastFlush:   time.Now(),
+	}
+}
+
+func (fw *flushingWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.bytes += uint64(n)
+	return n, err
+}
+
+func (fw *flushingWriter) blockWritten() error {
+	fw.blocks++
+	if (fw.everyBytes > 0 && fw.bytes >= fw.everyBytes) ||
+		(fw.everyBlocks > 0 && fw.blocks >= fw.everyBlocks) ||
+		(fw.interval > 0 && time.Since(fw.lastFlush) >= fw.interval) {
+		return fw.flush()
+	}
+	return nil
+}
+
+func (fw *flushingWriter) flush() error {
+	fw.bytes = 0
+	fw.blocks = 0
+	fw.lastFlush = time.Now()
+	return fw.flushFn()
+}
+
+// errNoDags is returned when a selective CAR is requested for no DAGs at all.
+var errNoDags = errors.New("no dags to traverse")
+
+// dagRoots returns the roots of dags, in order.
+func dagRoots(dags []Dag) []cid.Cid {
+	roots := make([]cid.Cid, 0, len(dags))
+	for _, dag := range dags {
+		roots = append(roots, dag.Root)
+	}
+	return roots
+}
+
+// traverseDags traverses each of dags in turn, through the same link system,
+// such that blocks already loaded by one traversal are recognised by the next.
+// The MaxTraversalLinks budget is shared by all traversals.
+func traverseDags(ctx context.Context, ls *ipld.LinkSystem, dags []Dag, opts Options) (TraversalReport, error) {
+	var report TraversalReport
+	for _, dag := range dags {
+		// traverse wraps the link system it is given, so give it a copy.
+		dls := *ls
+		r, err := traverse(ctx, &dls, dag.Root, dag.Selector, opts)
+		report.LinksVisited += r.LinksVisited
+		report.BudgetRemaining = r.BudgetRemaining
+		report.Truncated = r.Truncated
+		if err != nil {
+			return report, err
+		}
+		if opts.MaxTraversalLinks < math.MaxInt64 {
+			opts.MaxTraversalLinks = r.BudgetRemaining
+		}
+	}
+	return report, nil
+}
+
+func traverse(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, s ipld.Node, opts Options) (TraversalReport, error) {
+	var report TraversalReport
 	sel, err := selector.CompileSelector(s)
 	if err != nil {
-		return err
+		return report, err
+	}
+
+	readOpener := ls.StorageReadOpener
+	ls.StorageReadOpener = func(lc linking.LinkContext, l ipld.Link) (io.Reader, error) {
+		report.LinksVisited++
+		return readOpener(lc, l)
 	}
 
 	chooser := func(_ ipld.Link, _ linking.LinkContext) (ipld.NodePrototype, error) {
@@ -243,6 +1022,7 @@ func traverse(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, s ipld.Nod
 	if opts.TraversalPrototypeChooser != nil {
 		chooser = opts.TraversalPrototypeChooser
 	}
+	chooser = adlLinkSystem(ls, chooser, opts.TraversalADLs)
 
 	progress := traversal.Progress{
 		Cfg: &traversal.Config{
@@ -261,15 +1041,30 @@ func traverse(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, s ipld.Nod
 
 	lnk := cidlink.Link{Cid: root}
 	ls.TrustedStorage = true
-	rp, err := chooser(lnk, ipld.LinkContext{})
+	rootCtx := ipld.LinkContext{Ctx: ctx, LinkNode: basicnode.NewLink(lnk)}
+	rp, err := chooser(lnk, rootCtx)
 	if err != nil {
-		return err
+		return report, err
+	}
+	// Charge the root to the budget, as the traversal does the links it loads.
+	if progress.Budget != nil {
+		if progress.Budget.LinkBudget <= 0 {
+			report.Truncated = true
+			return report, fmt.Errorf("walk failed: %w", &traversal.ErrBudgetExceeded{BudgetKind: "link", Link: lnk})
+		}
+		progress.Budget.LinkBudget--
 	}
-	rootNode, err := ls.Load(ipld.LinkContext{}, lnk, rp)
+	rootNode, err := ls.Load(rootCtx, lnk, rp)
 	if err != nil {
-		return fmt.Errorf("root blk load failed: %s", err)
+		return report, fmt.Errorf("root blk load failed: %w", err)
 	}
-	err = progress.WalkMatching(rootNode, sel, func(_ traversal.Progress, node ipld.Node) error {
+	err = progress.WalkAdv(rootNode, sel, func(_ traversal.Progress, node ipld.Node, reason traversal.VisitReason) error {
+		// Reified nodes hide the links of the blocks they span, such that the
+		// bytes of those reached are read in full for their blocks to be
+		// written, whether matched or not.
+		if reason != traversal.VisitReason_SelectionMatch && len(opts.TraversalADLs) == 0 {
+			return nil
+		}
 		if lbn, ok := node.(datamodel.LargeBytesNode); ok {
 			s, err := lbn.AsLargeBytes()
 			if err != nil {
@@ -282,8 +1077,13 @@ func traverse(ctx context.Context, ls *ipld.LinkSystem, root cid.Cid, s ipld.Nod
 		}
 		return nil
 	})
+	if progress.Budget != nil {
+		report.BudgetRemaining = uint64(progress.Budget.LinkBudget)
+	}
 	if err != nil {
-		return fmt.Errorf("walk failed: %s", err)
+		var budgetErr *traversal.ErrBudgetExceeded
+		report.Truncated = errors.As(err, &budgetErr)
+		return report, fmt.Errorf("walk failed: %w", err)
 	}
-	return nil
+	return report, nil
 }
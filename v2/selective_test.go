@@ -3,10 +3,13 @@ package car_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -15,13 +18,17 @@ import (
 	"github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
 	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/storage/bsadapter"
+	"github.com/ipld/go-ipld-prime/traversal"
 	sb "github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 
 	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
@@ -55,6 +62,262 @@ func TestPrepareTraversal(t *testing.T) {
 	require.Equal(t, buf.Bytes()[:h1h.Len()], h1h.Bytes())
 }
 
+func TestPrepareSelectiveCar(t *testing.T) {
+	from, err := blockstore.OpenReadOnly("testdata/sample-unixfs-v2.car")
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	bsa := bsadapter.Adapter{Wrapped: from}
+	ls.SetReadStorage(&bsa)
+
+	rts, _ := from.Roots()
+	prepared, err := car.PrepareSelectiveCar(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively)
+	require.NoError(t, err)
+
+	// The prepared car matches one written by a single traversal.
+	outPath := path.Join(t.TempDir(), "out.car")
+	err = car.TraverseToFile(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, outPath)
+	require.NoError(t, err)
+	want, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(want)), prepared.Size())
+
+	// It can be written many times.
+	for i := 0; i < 2; i++ {
+		buf := bytes.Buffer{}
+		n, err := prepared.WriteTo(&buf)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(want)), n)
+		require.Equal(t, want, buf.Bytes())
+	}
+
+	br, err := car.NewBlockReader(bytes.NewReader(want))
+	require.NoError(t, err)
+	var wantCids []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		wantCids = append(wantCids, blk.Cid())
+	}
+	require.Equal(t, wantCids, prepared.Cids())
+
+	for _, r := range [][2]uint64{{0, 11}, {5, 100}, {51, 300}, {uint64(len(want)) - 30, 30}, {0, uint64(len(want))}} {
+		buf := bytes.Buffer{}
+		n, err := prepared.WriteRange(&buf, r[0], r[1])
+		require.NoError(t, err)
+		require.Equal(t, int64(r[1]), n)
+		require.Equal(t, want[r[0]:r[0]+r[1]], buf.Bytes())
+	}
+	_, err = prepared.WriteRange(io.Discard, 1, uint64(len(want)))
+	require.Error(t, err)
+}
+
+func TestTraversalPrefetch(t *testing.T) {
+	from, err := blockstore.OpenReadOnly("testdata/sample-unixfs-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { from.Close() })
+	rts, err := from.Roots()
+	require.NoError(t, err)
+
+	// A link system with slow loads, recording how many are in flight.
+	var inFlight, maxInFlight atomic.Int32
+	ls := cidlink.DefaultLinkSystem()
+	bsa := bsadapter.Adapter{Wrapped: from}
+	ls.SetReadStorage(&bsa)
+	readOpener := ls.StorageReadOpener
+	ls.StorageReadOpener = func(lc linking.LinkContext, l datamodel.Link) (io.Reader, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+		time.Sleep(time.Millisecond)
+		return readOpener(lc, l)
+	}
+
+	writeAll := func(opts ...car.Option) (v1, v2, prepared []byte) {
+		var buf bytes.Buffer
+		_, err := car.TraverseV1(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, &buf, opts...)
+		require.NoError(t, err)
+		v1 = buf.Bytes()
+
+		outPath := path.Join(t.TempDir(), "out.car")
+		err = car.TraverseToFile(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, outPath, opts...)
+		require.NoError(t, err)
+		v2, err = os.ReadFile(outPath)
+		require.NoError(t, err)
+
+		p, err := car.PrepareSelectiveCar(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, opts...)
+		require.NoError(t, err)
+		buf = bytes.Buffer{}
+		_, err = p.WriteTo(&buf)
+		require.NoError(t, err)
+		return v1, v2, buf.Bytes()
+	}
+
+	wantV1, wantV2, wantPrepared := writeAll()
+	require.EqualValues(t, 1, maxInFlight.Load())
+
+	// The output is identical when prefetching, though blocks are loaded in
+	// parallel.
+	gotV1, gotV2, gotPrepared := writeAll(car.WithTraversalPrefetch(4))
+	require.Equal(t, wantV1, gotV1)
+	require.Equal(t, wantV2, gotV2)
+	require.Equal(t, wantPrepared, gotPrepared)
+	require.Greater(t, maxInFlight.Load(), int32(1))
+	require.EqualValues(t, 0, inFlight.Load())
+}
+
+type mapSizeCache map[string]uint64
+
+func (c mapSizeCache) GetSize(_ context.Context, key string) (uint64, bool, error) {
+	size, ok := c[key]
+	return size, ok, nil
+}
+
+func (c mapSizeCache) PutSize(_ context.Context, key string, size uint64) error {
+	c[key] = size
+	return nil
+}
+
+func TestSelectiveWriterSizeCache(t *testing.T) {
+	from, err := blockstore.OpenReadOnly("testdata/sample-unixfs-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { from.Close() })
+	rts, err := from.Roots()
+	require.NoError(t, err)
+
+	var loads int
+	ls := cidlink.DefaultLinkSystem()
+	bsa := bsadapter.Adapter{Wrapped: from}
+	ls.SetReadStorage(&bsa)
+	readOpener := ls.StorageReadOpener
+	ls.StorageReadOpener = func(lc linking.LinkContext, l datamodel.Link) (io.Reader, error) {
+		loads++
+		return readOpener(lc, l)
+	}
+	sel := selectorparse.CommonSelector_ExploreAllRecursively
+
+	cache := mapSizeCache{}
+
+	// On a miss, the DAG is traversed to prepare the CAR, and its size cached.
+	writer, err := car.NewSelectiveWriter(context.Background(), &ls, rts[0], sel, car.WithSizeCache(cache))
+	require.NoError(t, err)
+	require.Len(t, cache, 1)
+	require.NotZero(t, loads)
+	var buf bytes.Buffer
+	_, err = writer.WriteTo(&buf)
+	require.NoError(t, err)
+	want := bytes.Clone(buf.Bytes())
+
+	// On a hit, nothing is loaded until the CAR is written, in one traversal.
+	loads = 0
+	writer, err = car.NewSelectiveWriter(context.Background(), &ls, rts[0], sel, car.WithSizeCache(cache))
+	require.NoError(t, err)
+	require.Zero(t, loads)
+	buf.Reset()
+	n, err := writer.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(want)), n)
+	require.Equal(t, want, buf.Bytes())
+
+	// Other selectors are cached separately.
+	other := sb.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	_, err = car.NewSelectiveWriter(context.Background(), &ls, rts[0], other, car.WithSizeCache(cache))
+	require.NoError(t, err)
+	require.Len(t, cache, 2)
+
+	// A stale size fails the write.
+	for k := range cache {
+		cache[k]++
+	}
+	writer, err = car.NewSelectiveWriter(context.Background(), &ls, rts[0], sel, car.WithSizeCache(cache))
+	require.NoError(t, err)
+	_, err = writer.WriteTo(io.Discard)
+	require.ErrorIs(t, err, car.ErrSizeMismatch)
+}
+
+func TestSelectiveCarTargetPayloadSize(t *testing.T) {
+	ctx := context.Background()
+	from, err := blockstore.OpenReadOnly("testdata/sample-unixfs-v2.car")
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	bsa := bsadapter.Adapter{Wrapped: from}
+	ls.SetReadStorage(&bsa)
+	rts, _ := from.Roots()
+	sel := selectorparse.CommonSelector_ExploreAllRecursively
+
+	natural, err := car.PrepareSelectiveCar(ctx, &ls, rts[0], sel)
+	require.NoError(t, err)
+	target := natural.Size() + 1000
+
+	// requireReadable checks that every block can be read via the index.
+	requireReadable := func(t *testing.T, carBytes []byte) {
+		bs, err := blockstore.NewReadOnly(bytes.NewReader(carBytes), nil)
+		require.NoError(t, err)
+		for _, c := range natural.Cids() {
+			_, err := bs.Get(ctx, c)
+			require.NoError(t, err)
+		}
+	}
+
+	prepared, err := car.PrepareSelectiveCar(ctx, &ls, rts[0], sel, car.WithTargetPayloadSize(target, 0xfe))
+	require.NoError(t, err)
+	require.Equal(t, target, prepared.Size())
+	require.Equal(t, natural.Cids(), prepared.Cids())
+	var buf bytes.Buffer
+	n, err := prepared.WriteTo(&buf)
+	require.NoError(t, err)
+	require.EqualValues(t, target, n)
+	padded := append([]byte{}, buf.Bytes()...)
+	requireReadable(t, padded)
+
+	// The padding directly follows the last block.
+	r, err := car.NewReader(bytes.NewReader(padded))
+	require.NoError(t, err)
+	dataEnd := r.Header.DataOffset + r.Header.DataSize
+	require.Equal(t, bytes.Repeat([]byte{0xfe}, 1000), padded[dataEnd:dataEnd+1000])
+	require.Equal(t, dataEnd+1000, r.Header.IndexOffset)
+
+	buf.Reset()
+	_, err = prepared.WriteRange(&buf, dataEnd-10, 20)
+	require.NoError(t, err)
+	require.Equal(t, padded[dataEnd-10:dataEnd+10], buf.Bytes())
+
+	// Streaming the CAR to a file gives the same result.
+	outPath := path.Join(t.TempDir(), "padded.car")
+	require.NoError(t, car.TraverseToFile(ctx, &ls, rts[0], sel, outPath, car.WithTargetPayloadSize(target, 0xfe)))
+	got, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(padded, got))
+
+	// A CARv1 is padded after its last block, and zero padding reads as EOF.
+	buf.Reset()
+	v1n, err := car.TraverseV1(ctx, &ls, rts[0], sel, &buf, car.WithTargetPayloadSize(target, 0))
+	require.NoError(t, err)
+	require.Equal(t, target, v1n)
+	require.Equal(t, int(target), buf.Len())
+	br, err := car.NewBlockReader(&buf, car.ZeroLengthSectionAsEOF(true))
+	require.NoError(t, err)
+	var count int
+	for {
+		_, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		count++
+	}
+	require.Equal(t, len(natural.Cids()), count)
+
+	// Targets smaller than the natural size are rejected.
+	_, err = car.PrepareSelectiveCar(ctx, &ls, rts[0], sel, car.WithTargetPayloadSize(natural.Size()-1, 0))
+	require.ErrorIs(t, err, car.ErrOffsetImpossible)
+	_, err = car.TraverseV1(ctx, &ls, rts[0], sel, io.Discard, car.WithTargetPayloadSize(10, 0))
+	require.ErrorIs(t, err, car.ErrOffsetImpossible)
+}
+
 func TestFileTraversal(t *testing.T) {
 	from, err := blockstore.OpenReadOnly("testdata/sample-unixfs-v2.car")
 	require.NoError(t, err)
@@ -91,6 +354,203 @@ func TestV1Traversal(t *testing.T) {
 	require.Equal(t, fa.Size(), int64(n))
 }
 
+type flushCountingWriter struct {
+	bytes.Buffer
+	flushes   int
+	unflushed int
+	flushedAt []int
+}
+
+func (w *flushCountingWriter) Write(p []byte) (int, error) {
+	w.unflushed += len(p)
+	return w.Buffer.Write(p)
+}
+
+func (w *flushCountingWriter) Flush() {
+	w.flushes++
+	w.flushedAt = append(w.flushedAt, w.unflushed)
+	w.unflushed = 0
+}
+
+func TestV1TraversalReport(t *testing.T) {
+	from, err := blockstore.OpenReadOnly("testdata/sample-v1.car")
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	bsa := bsadapter.Adapter{Wrapped: from}
+	ls.SetReadStorage(&bsa)
+	rts, _ := from.Roots()
+
+	var buf bytes.Buffer
+	n, report, err := car.TraverseV1WithReport(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, &buf)
+	require.NoError(t, err)
+	require.Equal(t, n, report.BytesWritten)
+	require.False(t, report.Truncated)
+	visited := report.LinksVisited
+	require.Greater(t, visited, uint64(1))
+
+	// The root is charged to the budget like any other link.
+	buf.Reset()
+	_, report, err = car.TraverseV1WithReport(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, &buf, car.MaxTraversalLinks(visited+10))
+	require.NoError(t, err)
+	require.Equal(t, visited, report.LinksVisited)
+	require.EqualValues(t, 10, report.BudgetRemaining)
+	require.False(t, report.Truncated)
+
+	buf.Reset()
+	n, report, err = car.TraverseV1WithReport(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, &buf, car.MaxTraversalLinks(2))
+	var budgetErr *traversal.ErrBudgetExceeded
+	require.ErrorAs(t, err, &budgetErr)
+	require.True(t, report.Truncated)
+	require.EqualValues(t, 2, report.LinksVisited)
+	require.Zero(t, report.BudgetRemaining)
+	require.Equal(t, n, report.BytesWritten)
+	require.EqualValues(t, buf.Len(), n)
+
+	// Preparing a selective CAR records the report of its traversal.
+	p, err := car.PrepareSelectiveCar(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively)
+	require.NoError(t, err)
+	require.Equal(t, visited, p.TraversalReport().LinksVisited)
+	require.Equal(t, p.Size(), p.TraversalReport().BytesWritten)
+}
+
+func TestV1TraversalFlushes(t *testing.T) {
+	from, err := blockstore.OpenReadOnly("testdata/sample-v1.car")
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	bsa := bsadapter.Adapter{Wrapped: from}
+	ls.SetReadStorage(&bsa)
+	rts, _ := from.Roots()
+
+	unflushed := bytes.NewBuffer(nil)
+	_, err = car.TraverseV1(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, unflushed)
+	require.NoError(t, err)
+	loaded, err := car.NewBlockReader(bytes.NewReader(unflushed.Bytes()))
+	require.NoError(t, err)
+	var blockCount int
+	for {
+		if _, err := loaded.Next(); err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		blockCount++
+	}
+
+	t.Run("EveryBlock", func(t *testing.T) {
+		w := &flushCountingWriter{}
+		_, err = car.TraverseV1(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, w, car.FlushEveryBlocks(1))
+		require.NoError(t, err)
+		require.Equal(t, unflushed.Bytes(), w.Bytes())
+		// one flush per block, plus a final one.
+		require.Equal(t, blockCount+1, w.flushes)
+	})
+
+	t.Run("EveryBytes", func(t *testing.T) {
+		w := &flushCountingWriter{}
+		_, err = car.TraverseV1(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, w, car.FlushEveryBytes(10000))
+		require.NoError(t, err)
+		require.Equal(t, unflushed.Bytes(), w.Bytes())
+		require.Greater(t, w.flushes, 1)
+		require.Less(t, w.flushes, blockCount+1)
+		for _, n := range w.flushedAt[:len(w.flushedAt)-1] {
+			require.GreaterOrEqual(t, n, 10000)
+		}
+	})
+
+	t.Run("UnflushableWriter", func(t *testing.T) {
+		w := bytes.NewBuffer(nil)
+		_, err = car.TraverseV1(context.Background(), &ls, rts[0], selectorparse.CommonSelector_ExploreAllRecursively, w, car.FlushEveryBlocks(1))
+		require.NoError(t, err)
+		require.Equal(t, unflushed.Bytes(), w.Bytes())
+	})
+}
+
+func TestSelectiveWriterDags(t *testing.T) {
+	store := cidlink.Memory{Bag: make(map[string][]byte)}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = store.OpenRead
+	ls.StorageWriteOpener = store.OpenWrite
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: uint64(multicodec.DagCbor), MhType: uint64(multicodec.Sha2_256), MhLength: -1}}
+	storeNode := func(build func(ma datamodel.MapAssembler)) cid.Cid {
+		n, err := qp.BuildMap(basicnode.Prototype.Any, -1, build)
+		require.NoError(t, err)
+		l, err := ls.Store(ipld.LinkContext{}, lp, n)
+		require.NoError(t, err)
+		return l.(cidlink.Link).Cid
+	}
+
+	// Two DAGs sharing a leaf.
+	leaf := storeNode(func(ma datamodel.MapAssembler) { qp.MapEntry(ma, "leaf", qp.Bool(true)) })
+	a := storeNode(func(ma datamodel.MapAssembler) { qp.MapEntry(ma, "a", qp.Link(cidlink.Link{Cid: leaf})) })
+	b := storeNode(func(ma datamodel.MapAssembler) { qp.MapEntry(ma, "b", qp.Link(cidlink.Link{Cid: leaf})) })
+
+	ssb := sb.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	dags := []car.Dag{
+		{Root: a, Selector: ssb.Matcher().Node()},
+		{Root: b, Selector: selectorparse.CommonSelector_ExploreAllRecursively},
+		{Root: a, Selector: selectorparse.CommonSelector_ExploreAllRecursively},
+	}
+
+	readBlocks := func(r io.Reader) ([]cid.Cid, []cid.Cid) {
+		br, err := car.NewBlockReader(r)
+		require.NoError(t, err)
+		var cids []cid.Cid
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			cids = append(cids, blk.Cid())
+		}
+		return br.Roots, cids
+	}
+
+	// Each DAG is traversed with its own selector, and the leaf is written
+	// once, although reached by two of them.
+	var v1 bytes.Buffer
+	n, err := car.TraverseV1Dags(context.Background(), &ls, dags, &v1)
+	require.NoError(t, err)
+	require.EqualValues(t, v1.Len(), n)
+	roots, cids := readBlocks(bytes.NewReader(v1.Bytes()))
+	require.Equal(t, []cid.Cid{a, b, a}, roots)
+	require.Equal(t, []cid.Cid{a, b, leaf}, cids)
+
+	// A CARv2 holds the same payload.
+	w, err := car.NewSelectiveWriterDags(context.Background(), &ls, dags)
+	require.NoError(t, err)
+	var v2 bytes.Buffer
+	_, err = w.WriteTo(&v2)
+	require.NoError(t, err)
+	r, err := car.NewReader(bytes.NewReader(v2.Bytes()))
+	require.NoError(t, err)
+	dr, err := r.DataReader()
+	require.NoError(t, err)
+	payload, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	require.Equal(t, v1.Bytes(), payload)
+
+	// Without a budget, no budget is carried from one DAG to the next.
+	p, err := car.PrepareSelectiveCarDags(context.Background(), &ls, dags)
+	require.NoError(t, err)
+	report := p.TraversalReport()
+	require.False(t, report.Truncated)
+	require.Zero(t, report.BudgetRemaining)
+
+	// The budget is shared by the traversals of all DAGs, including their roots.
+	p, err = car.PrepareSelectiveCarDags(context.Background(), &ls, dags, car.MaxTraversalLinks(report.LinksVisited+1))
+	require.NoError(t, err)
+	require.Equal(t, report.LinksVisited, p.TraversalReport().LinksVisited)
+	require.EqualValues(t, 1, p.TraversalReport().BudgetRemaining)
+	_, err = car.PrepareSelectiveCarDags(context.Background(), &ls, dags, car.MaxTraversalLinks(report.LinksVisited-1))
+	var budgetErr *traversal.ErrBudgetExceeded
+	require.ErrorAs(t, err, &budgetErr)
+	_, err = car.TraverseV1Dags(context.Background(), &ls, dags, io.Discard, car.MaxTraversalLinks(1))
+	require.ErrorAs(t, err, &budgetErr)
+
+	_, err = car.NewSelectiveWriterDags(context.Background(), &ls, nil)
+	require.Error(t, err)
+}
+
 func TestPartialTraversal(t *testing.T) {
 	store := cidlink.Memory{Bag: make(map[string][]byte)}
 	ls := cidlink.DefaultLinkSystem()
@@ -135,3 +595,87 @@ func TestPartialTraversal(t *testing.T) {
 	}
 	require.Equal(t, 2, len(fnd))
 }
+
+func TestTraversalADLs(t *testing.T) {
+	store := cidlink.Memory{Bag: make(map[string][]byte)}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = store.OpenRead
+	ls.StorageWriteOpener = store.OpenWrite
+
+	// A HAMT-sharded directory with many small files, and a file sharded
+	// across several blocks.
+	files := make(map[string]cid.Cid)
+	var entries []dagpb.PBLink
+	addEntry := func(name string, data []byte) {
+		l, size, err := builder.BuildUnixFSFile(bytes.NewReader(data), "", &ls)
+		require.NoError(t, err)
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), l)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+		files[name] = l.(cidlink.Link).Cid
+	}
+	for i := 0; i < 50; i++ {
+		addEntry(fmt.Sprintf("file-%d", i), []byte(fmt.Sprintf("content %d", i)))
+	}
+	addEntry("big", bytes.Repeat([]byte("big"), 200000))
+	rl, _, err := builder.BuildUnixFSShardedDirectory(16, uint64(multicodec.Murmur3X64_64), entries, &ls)
+	require.NoError(t, err)
+	root := rl.(cidlink.Link).Cid
+
+	traverse := func(t *testing.T, sel datamodel.Node, opts ...car.Option) map[cid.Cid]struct{} {
+		var buf bytes.Buffer
+		_, err := car.TraverseV1(context.Background(), &ls, root, sel, &buf, opts...)
+		require.NoError(t, err)
+		br, err := car.NewBlockReader(&buf)
+		require.NoError(t, err)
+		got := make(map[cid.Cid]struct{})
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				return got
+			}
+			require.NoError(t, err)
+			got[blk.Cid()] = struct{}{}
+		}
+	}
+	ssb := sb.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+
+	t.Run("ExploreFields", func(t *testing.T) {
+		sel := ssb.ExploreFields(func(efsb sb.ExploreFieldsSpecBuilder) {
+			efsb.Insert("file-7", ssb.Matcher())
+			efsb.Insert("big", ssb.Matcher())
+		}).Node()
+
+		// Without the ADL, the entries are not fields of the root block.
+		got := traverse(t, sel)
+		require.Equal(t, map[cid.Cid]struct{}{root: {}}, got)
+
+		got = traverse(t, sel, car.WithUnixFSADL(true))
+		require.Contains(t, got, root)
+		require.Contains(t, got, files["file-7"])
+		require.Contains(t, got, files["big"])
+		require.NotContains(t, got, files["file-8"])
+		// The chunks of the big file are written along with it.
+		n, err := ls.Load(linking.LinkContext{}, cidlink.Link{Cid: files["big"]}, dagpb.Type.PBNode)
+		require.NoError(t, err)
+		chunks := n.(dagpb.PBNode).Links
+		require.Greater(t, chunks.Length(), int64(1))
+		for it := chunks.Iterator(); !it.Done(); {
+			_, l := it.Next()
+			require.Contains(t, got, l.Hash.Link().(cidlink.Link).Cid)
+		}
+		// As are the shards leading to both, but not all of them.
+		require.Less(t, len(got), len(store.Bag))
+
+		// Disabling the ADL again leaves the blocks as they are.
+		got = traverse(t, sel, car.WithUnixFSADL(true), car.WithUnixFSADL(false))
+		require.Equal(t, map[cid.Cid]struct{}{root: {}}, got)
+	})
+
+	t.Run("ExploreAllRecursively", func(t *testing.T) {
+		got := traverse(t, selectorparse.CommonSelector_ExploreAllRecursively, car.WithADLs(map[multicodec.Code]ipld.NodeReifier{
+			multicodec.DagPb: unixfsnode.Reify,
+		}))
+		require.Len(t, got, len(store.Bag))
+	})
+}
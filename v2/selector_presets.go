@@ -0,0 +1,77 @@
+package car
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-unixfsnode"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+// Names of the selector presets understood by SelectorPreset.
+const (
+	// SelectorPresetAll selects the entire DAG under the root.
+	SelectorPresetAll = "all"
+	// SelectorPresetShallow selects the root block only.
+	SelectorPresetShallow = "shallow"
+	// SelectorPresetUnixFSFile selects all the blocks of the UnixFS file at
+	// the root. For a UnixFS directory, it selects the blocks of the possibly
+	// sharded directory itself, but not those of its entries.
+	SelectorPresetUnixFSFile = "unixfs-file"
+	// SelectorPresetUnixFSDirListing selects the blocks of the possibly
+	// sharded UnixFS directory at the root, along with the root block of each
+	// of its entries.
+	SelectorPresetUnixFSDirListing = "unixfs-dir-listing"
+)
+
+var selectorPresets = map[string]ipld.Node{
+	SelectorPresetAll:        selectorparse.CommonSelector_ExploreAllRecursively,
+	SelectorPresetShallow:    selectorparse.CommonSelector_MatchPoint,
+	SelectorPresetUnixFSFile: unixfsnode.MatchUnixFSEntitySelector.Node(),
+	SelectorPresetUnixFSDirListing: func() ipld.Node {
+		ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+		return ssb.ExploreInterpretAs("unixfs", ssb.ExploreUnion(
+			ssb.Matcher(),
+			ssb.ExploreAll(ssb.Matcher()),
+		)).Node()
+	}(),
+}
+
+// SelectorPresets returns the names of the selector presets understood by
+// SelectorPreset, in lexical order.
+func SelectorPresets() []string {
+	names := make([]string, 0, len(selectorPresets))
+	for name := range selectorPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SelectorPreset returns the selector with the given preset name, suitable for
+// passing to the selective traversal functions, such as TraverseV1.
+//
+// The UnixFS presets interpret the DAG using the "unixfs" ADL, which must be known to the LinkSystem used for traversal, e.g. via
+// unixfsnode.AddUnixFSReificationToLinkSystem, and require dag-pb blocks to be
+// loaded as dagpb.Type.PBNode, e.g. via WithTraversalPrototypeChooser.
+func SelectorPreset(name string) (ipld.Node, error) {
+	sel, ok := selectorPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown selector preset %q; must be one of %v", name, SelectorPresets())
+	}
+	return sel, nil
+}
+
+// CompileSelectorPreset is like SelectorPreset, but returns the compiled
+// selector.
+func CompileSelectorPreset(name string) (selector.Selector, error) {
+	sel, err := SelectorPreset(name)
+	if err != nil {
+		return nil, err
+	}
+	return selector.CompileSelector(sel)
+}
@@ -0,0 +1,56 @@
+package car_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/storage/bsadapter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectorPresets(t *testing.T) {
+	require.Equal(t, []string{"all", "shallow", "unixfs-dir-listing", "unixfs-file"}, car.SelectorPresets())
+	for _, name := range car.SelectorPresets() {
+		_, err := car.CompileSelectorPreset(name)
+		require.NoError(t, err, name)
+	}
+	_, err := car.SelectorPreset("nope")
+	require.ErrorContains(t, err, `unknown selector preset "nope"`)
+
+	from, err := blockstore.OpenReadOnly("testdata/sample-unixfs-v2.car")
+	require.NoError(t, err)
+	ls := cidlink.DefaultLinkSystem()
+	ls.SetReadStorage(&bsadapter.Adapter{Wrapped: from})
+	unixfsnode.AddUnixFSReificationToLinkSystem(&ls)
+	chooser := func(l datamodel.Link, _ linking.LinkContext) (datamodel.NodePrototype, error) {
+		if cl, ok := l.(cidlink.Link); ok && cl.Cid.Prefix().Codec == 0x70 {
+			return dagpb.Type.PBNode, nil
+		}
+		return basicnode.Prototype.Any, nil
+	}
+	rts, err := from.Roots()
+	require.NoError(t, err)
+
+	visited := func(name string) uint64 {
+		sel, err := car.SelectorPreset(name)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		_, report, err := car.TraverseV1WithReport(context.Background(), &ls, rts[0], sel, &buf, car.WithTraversalPrototypeChooser(chooser))
+		require.NoError(t, err, name)
+		return report.LinksVisited
+	}
+	// The root is a directory holding a directory holding a file.
+	require.EqualValues(t, 3, visited(car.SelectorPresetAll))
+	require.EqualValues(t, 1, visited(car.SelectorPresetShallow))
+	require.EqualValues(t, 1, visited(car.SelectorPresetUnixFSFile))
+	require.EqualValues(t, 2, visited(car.SelectorPresetUnixFSDirListing))
+}
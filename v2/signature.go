@@ -0,0 +1,253 @@
+package car
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+	mhreg "github.com/multiformats/go-multihash/core"
+	"github.com/multiformats/go-varint"
+)
+
+// SignatureAlgorithmEd25519 is the algorithm of payload signatures made with
+// an Ed25519 key.
+const SignatureAlgorithmEd25519 = "ed25519"
+
+var (
+	// ErrSignatureMismatch is returned when verifying a payload signature that
+	// does not match the payload of a CAR or the given public key.
+	ErrSignatureMismatch = errors.New("signature does not match car payload")
+	// ErrNoSignature is returned by ReadEmbeddedSignature when no signature is
+	// embedded in a CAR.
+	ErrNoSignature = errors.New("no signature embedded in car")
+	// ErrNotEnoughPadding is returned by EmbedSignature when a signature does
+	// not fit in the padding preceding the data payload of a CARv2.
+	ErrNotEnoughPadding = errors.New("not enough padding to embed signature")
+)
+
+// signatureMagic marks the start of a signature embedded in the padding which
+// precedes the data payload of a CARv2.
+var signatureMagic = []byte("carsig")
+
+func init() {
+	cbor.RegisterCborType(PayloadSignature{})
+}
+
+// PayloadSignature is a signature over the CARv1 data payload of a CAR, i.e.
+// the whole of a CARv1 or the inner CARv1 of a CARv2, which allows verifying
+// the provenance of a CAR independently of its CARv2 header and index.
+//
+// The signed message is the multihash of the payload, which is SHA2-256 for
+// signatures made by SignPayload.
+type PayloadSignature struct {
+	// Algorithm is the signature algorithm, e.g. SignatureAlgorithmEd25519.
+	Algorithm string
+	// PublicKey is the public key of the signer, encoded as per the algorithm.
+	PublicKey []byte
+	// Digest is the multihash of the payload that was signed.
+	Digest []byte
+	// Signature is the signature over Digest.
+	Signature []byte
+}
+
+// PayloadDigest returns the SHA2-256 multihash of the CARv1 data payload of the
+// CAR read from r.
+func PayloadDigest(r io.ReaderAt, opts ...Option) (multihash.Multihash, error) {
+	return payloadDigest(r, multihash.SHA2_256, opts...)
+}
+
+func payloadDigest(r io.ReaderAt, code uint64, opts ...Option) (multihash.Multihash, error) {
+	cr, err := NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := cr.DataReader()
+	if err != nil {
+		return nil, err
+	}
+	h, err := mhreg.GetHasher(code)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, dr); err != nil {
+		return nil, err
+	}
+	return multihash.Encode(h.Sum(nil), code)
+}
+
+// SignPayload signs the CARv1 data payload of the CAR read from r with the
+// given key. Only Ed25519 keys, i.e. ed25519.PrivateKey, are supported.
+//
+// The signature may be stored alongside the CAR, using
+// PayloadSignature.MarshalBinary, or embedded in a CARv2 using EmbedSignature.
+func SignPayload(r io.ReaderAt, key crypto.Signer, opts ...Option) (PayloadSignature, error) {
+	pub, ok := key.Public().(ed25519.PublicKey)
+	if !ok {
+		return PayloadSignature{}, fmt.Errorf("unsupported signing key type: %T", key.Public())
+	}
+	digest, err := PayloadDigest(r, opts...)
+	if err != nil {
+		return PayloadSignature{}, err
+	}
+	sig, err := key.Sign(nil, digest, crypto.Hash(0))
+	if err != nil {
+		return PayloadSignature{}, err
+	}
+	return PayloadSignature{
+		Algorithm: SignatureAlgorithmEd25519,
+		PublicKey: pub,
+		Digest:    digest,
+		Signature: sig,
+	}, nil
+}
+
+// Verify checks that s is a valid signature over the CARv1 data payload of the
+// CAR read from r, made by the given public key, returning
+// ErrSignatureMismatch otherwise.
+//
+// If key is nil, the signature is verified against its own PublicKey, which
+// only proves that the payload was not modified since it was signed by whoever
+// holds that key; callers wishing to check provenance must pass the public key
+// they trust.
+func (s PayloadSignature) Verify(r io.ReaderAt, key crypto.PublicKey, opts ...Option) error {
+	if s.Algorithm != SignatureAlgorithmEd25519 {
+		return fmt.Errorf("unsupported signature algorithm: %q", s.Algorithm)
+	}
+	if len(s.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid %s public key size: %d", s.Algorithm, len(s.PublicKey))
+	}
+	pub := ed25519.PublicKey(s.PublicKey)
+	if key != nil {
+		want, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("unsupported public key type: %T", key)
+		}
+		if !pub.Equal(want) {
+			return ErrSignatureMismatch
+		}
+	}
+
+	decoded, err := multihash.Decode(s.Digest)
+	if err != nil {
+		return fmt.Errorf("invalid signed digest: %w", err)
+	}
+	digest, err := payloadDigest(r, decoded.Code, opts...)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, s.Digest) || !ed25519.Verify(pub, s.Digest, s.Signature) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+// MarshalBinary encodes s as DAG-CBOR, e.g. to store it as a detached
+// signature alongside a CAR.
+func (s PayloadSignature) MarshalBinary() ([]byte, error) {
+	return cbor.DumpObject(s)
+}
+
+// UnmarshalBinary decodes a signature encoded by MarshalBinary.
+func (s *PayloadSignature) UnmarshalBinary(data []byte) error {
+	var decoded PayloadSignature
+	if err := cbor.DecodeInto(data, &decoded); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	*s = decoded
+	return nil
+}
+
+// EmbedSignature writes the given signature into the padding which precedes
+// the data payload of the CARv2 in rw, replacing any signature previously
+// embedded there. Since readers skip the padding, the CAR remains readable as
+// before, and the signature can be read back using ReadEmbeddedSignature.
+//
+// The CARv2 must have enough padding for the signature, e.g. as set via
+// UseDataPadding when the CAR is written; an Ed25519 signature takes less than
+// 256 bytes. ErrNotEnoughPadding is returned otherwise. CARv1s cannot embed a
+// signature.
+func EmbedSignature(rw interface {
+	io.ReaderAt
+	io.WriterAt
+}, sig PayloadSignature, opts ...Option) error {
+	start, end, err := signatureRegion(rw, opts...)
+	if err != nil {
+		return err
+	}
+	encoded, err := sig.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	embedded := append(append([]byte{}, signatureMagic...), varint.ToUvarint(uint64(len(encoded)))...)
+	embedded = append(embedded, encoded...)
+	if int64(len(embedded)) > end-start {
+		return fmt.Errorf("%w: need %d bytes, have %d", ErrNotEnoughPadding, len(embedded), end-start)
+	}
+
+	// Clear any longer signature embedded previously.
+	if _, previous, err := readEmbedded(rw, start, end); err == nil && previous > int64(len(embedded)) {
+		embedded = append(embedded, make([]byte, previous-int64(len(embedded)))...)
+	}
+	_, err = rw.WriteAt(embedded, start)
+	return err
+}
+
+// ReadEmbeddedSignature reads the signature embedded in the CARv2 read from r
+// by EmbedSignature, returning ErrNoSignature if there is none.
+func ReadEmbeddedSignature(r io.ReaderAt, opts ...Option) (PayloadSignature, error) {
+	start, end, err := signatureRegion(r, opts...)
+	if err != nil {
+		return PayloadSignature{}, err
+	}
+	encoded, _, err := readEmbedded(r, start, end)
+	if err != nil {
+		return PayloadSignature{}, err
+	}
+	var sig PayloadSignature
+	err = sig.UnmarshalBinary(encoded)
+	return sig, err
+}
+
+// signatureRegion returns the start and end offsets of the padding preceding
+// the data payload of the CARv2 read from r.
+func signatureRegion(r io.ReaderAt, opts ...Option) (int64, int64, error) {
+	cr, err := NewReader(r, opts...)
+	if err != nil {
+		return 0, 0, err
+	}
+	if cr.Version != 2 {
+		return 0, 0, fmt.Errorf("signatures can only be embedded in a CARv2, got version %d", cr.Version)
+	}
+	return PragmaSize + HeaderSize, int64(cr.Header.DataOffset), nil
+}
+
+// readEmbedded returns the encoded signature embedded between offsets start
+// and end of r, along with the size it takes including its magic and length
+// prefix.
+func readEmbedded(r io.ReaderAt, start, end int64) ([]byte, int64, error) {
+	prefix := make([]byte, min(int64(len(signatureMagic)+varint.MaxLenUvarint63), end-start))
+	if _, err := r.ReadAt(prefix, start); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.HasPrefix(prefix, signatureMagic) {
+		return nil, 0, ErrNoSignature
+	}
+	length, n, err := varint.FromUvarint(prefix[len(signatureMagic):])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid embedded signature: %w", err)
+	}
+	offset := start + int64(len(signatureMagic)+n)
+	if length > uint64(end-offset) {
+		return nil, 0, errors.New("invalid embedded signature: exceeds padding")
+	}
+	encoded := make([]byte, length)
+	if _, err := r.ReadAt(encoded, offset); err != nil {
+		return nil, 0, err
+	}
+	return encoded, offset + int64(length) - start, nil
+}
@@ -0,0 +1,101 @@
+package car_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadSignature(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize))
+
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	sig, err := carv2.SignPayload(bytes.NewReader(v1), key)
+	require.NoError(t, err)
+	require.Equal(t, carv2.SignatureAlgorithmEd25519, sig.Algorithm)
+	require.NoError(t, sig.Verify(bytes.NewReader(v1), nil))
+	require.NoError(t, sig.Verify(bytes.NewReader(v1), key.Public()))
+	require.ErrorIs(t, sig.Verify(bytes.NewReader(v1), other.Public()), carv2.ErrSignatureMismatch)
+
+	encoded, err := sig.MarshalBinary()
+	require.NoError(t, err)
+	var decoded carv2.PayloadSignature
+	require.NoError(t, decoded.UnmarshalBinary(encoded))
+	require.Equal(t, sig, decoded)
+
+	// Any change to the payload invalidates the signature.
+	tampered := bytes.Clone(v1)
+	tampered[len(tampered)-1] ^= 0xff
+	require.ErrorIs(t, sig.Verify(bytes.NewReader(tampered), nil), carv2.ErrSignatureMismatch)
+	forged := sig
+	forged.Digest, err = carv2.PayloadDigest(bytes.NewReader(tampered))
+	require.NoError(t, err)
+	require.ErrorIs(t, forged.Verify(bytes.NewReader(tampered), nil), carv2.ErrSignatureMismatch)
+
+	// CARv1s cannot embed a signature.
+	_, err = carv2.ReadEmbeddedSignature(bytes.NewReader(v1))
+	require.ErrorContains(t, err, "can only be embedded in a CARv2")
+
+	wrap := func(t *testing.T, padding uint64) *os.File {
+		var buf bytes.Buffer
+		buf.Write(carv2.Pragma)
+		header := carv2.NewHeader(uint64(len(v1))).WithDataPadding(padding)
+		header.IndexOffset = 0
+		_, err := header.WriteTo(&buf)
+		require.NoError(t, err)
+		buf.Write(make([]byte, padding))
+		buf.Write(v1)
+		path := filepath.Join(t.TempDir(), "signed.car")
+		require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	t.Run("Embedded", func(t *testing.T) {
+		f := wrap(t, 256)
+		_, err := carv2.ReadEmbeddedSignature(f)
+		require.ErrorIs(t, err, carv2.ErrNoSignature)
+
+		// The payload of the CARv2 is the same as that of the CARv1.
+		sig2, err := carv2.SignPayload(f, key)
+		require.NoError(t, err)
+		require.Equal(t, sig.Digest, sig2.Digest)
+
+		require.NoError(t, carv2.EmbedSignature(f, sig2))
+		got, err := carv2.ReadEmbeddedSignature(f)
+		require.NoError(t, err)
+		require.Equal(t, sig2, got)
+		require.NoError(t, got.Verify(f, key.Public()))
+
+		// Embedding leaves the CAR readable, and replaces the signature.
+		cr, err := carv2.NewReader(f)
+		require.NoError(t, err)
+		stats, err := cr.Inspect(true)
+		require.NoError(t, err)
+		require.EqualValues(t, 1049, stats.BlockCount)
+		sig3, err := carv2.SignPayload(f, other)
+		require.NoError(t, err)
+		require.NoError(t, carv2.EmbedSignature(f, sig3))
+		got, err = carv2.ReadEmbeddedSignature(f)
+		require.NoError(t, err)
+		require.Equal(t, sig3, got)
+	})
+
+	t.Run("NotEnoughPadding", func(t *testing.T) {
+		f := wrap(t, 16)
+		err := carv2.EmbedSignature(f, sig)
+		require.ErrorIs(t, err, carv2.ErrNotEnoughPadding)
+		require.ErrorContains(t, err, "have 16")
+		_, err = carv2.ReadEmbeddedSignature(f)
+		require.ErrorIs(t, err, carv2.ErrNoSignature)
+	})
+}
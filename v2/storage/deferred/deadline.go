@@ -0,0 +1,157 @@
+package deferred
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrWriteStalled is returned, wrapped, when a write to the output stream of a
+// DeferredCarWriter does not complete before the context of the Put that
+// triggered it is done, or before the write timeout set via SetWriteTimeout,
+// or when that context is done part-way through the writes of a Put. The CAR
+// output is then incomplete, and every subsequent Put fails.
+var ErrWriteStalled = errors.New("write to car output stalled")
+
+// deadlineWriter bounds the writes to a stream by the context of the current
+// Put and the write timeout, whichever ends first.
+//
+// If the stream supports write deadlines, such as a net.Conn, or an
+// http.ResponseWriter via http.ResponseController, the deadline is set on it.
+// Otherwise, the context is only checked between writes, unless a write
+// timeout is set, in which case writes are performed in a separate goroutine
+// which is abandoned once the deadline passes, since a blocked write cannot be
+// interrupted; it keeps running until the write returns, but no longer holds
+// up the writer.
+type deadlineWriter struct {
+	w           io.Writer
+	setDeadline func(time.Time) error
+	ctx         context.Context
+	timeout     time.Duration
+	// written is set once the current Put has written to the stream, such
+	// that it cannot stop part-way through without leaving the output
+	// incomplete.
+	written bool
+	// err is set once a write fails, as the output is then incomplete.
+	err error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+func newDeadlineWriter(w io.Writer) *deadlineWriter {
+	dw := &deadlineWriter{w: w, ctx: context.Background()}
+	switch w := w.(type) {
+	case writeDeadliner:
+		dw.setDeadline = w.SetWriteDeadline
+	case http.ResponseWriter:
+		// Whether the response supports deadlines is only known once one is
+		// set; see writeWithDeadline.
+		dw.setDeadline = http.NewResponseController(w).SetWriteDeadline
+	}
+	return dw
+}
+
+// bound makes the writes bounded by ctx until the returned function is
+// called.
+func (dw *deadlineWriter) bound(ctx context.Context) func() {
+	dw.ctx = ctx
+	dw.written = false
+	return func() { dw.ctx = context.Background() }
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if dw.err != nil {
+		return 0, dw.err
+	}
+	if err := dw.ctx.Err(); err != nil {
+		if dw.written {
+			dw.err = stalled(dw.ctx)
+			return 0, dw.err
+		}
+		return 0, err
+	}
+
+	ctx := dw.ctx
+	if dw.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dw.timeout)
+		defer cancel()
+	}
+	n, err := dw.write(ctx, p)
+	if n > 0 {
+		dw.written = true
+	}
+	if err != nil {
+		dw.err = err
+	}
+	return n, err
+}
+
+func (dw *deadlineWriter) write(ctx context.Context, p []byte) (int, error) {
+	switch {
+	case ctx.Done() == nil:
+		return dw.w.Write(p)
+	case dw.setDeadline != nil:
+		return dw.writeWithDeadline(ctx, p)
+	case dw.timeout > 0:
+		return dw.writeAsync(ctx, p)
+	default:
+		return dw.w.Write(p)
+	}
+}
+
+func (dw *deadlineWriter) writeWithDeadline(ctx context.Context, p []byte) (int, error) {
+	deadline, _ := ctx.Deadline()
+	if err := dw.setDeadline(deadline); err != nil {
+		// The stream does not support deadlines after all.
+		dw.setDeadline = nil
+		return dw.write(ctx, p)
+	}
+	// Clear the deadline, such that it does not affect other writes to the
+	// stream, and interrupt the write if ctx is cancelled before it.
+	stop := context.AfterFunc(ctx, func() { dw.setDeadline(time.Now()) })
+	defer func() {
+		stop()
+		dw.setDeadline(time.Time{})
+	}()
+	n, err := dw.w.Write(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = stalled(ctx)
+	}
+	return n, err
+}
+
+func (dw *deadlineWriter) writeAsync(ctx context.Context, p []byte) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	// The abandoned write may still read p after returning, so write a copy.
+	buf := append([]byte(nil), p...)
+	done := make(chan result, 1)
+	go func() {
+		n, err := dw.w.Write(buf)
+		done <- result{n, err}
+	}()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-ctx.Done():
+		return 0, stalled(ctx)
+	}
+}
+
+func stalled(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if cause == nil {
+		// The write deadline of the stream passed just ahead of ctx.
+		cause = context.DeadlineExceeded
+	}
+	return fmt.Errorf("%w: %w", ErrWriteStalled, cause)
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
-	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
@@ -44,12 +44,19 @@ var _ io.Closer = (*DeferredCarWriter)(nil)
 // just track the first Put() operation, which can be useful for setting
 // HTTP headers in the assumption that the beginning of a valid CAR is about to
 // be streamed.
+//
+// Writes to an output stream are bounded by the context passed to Put, and by
+// the timeout set via SetWriteTimeout, such that a stalled stream, e.g. the
+// connection to an unresponsive client, does not block other users of the
+// writer indefinitely; see ErrWriteStalled. Waiting for another Put to
+// complete is also bounded by the context passed to Put or Has.
 type DeferredCarWriter struct {
 	roots     []cid.Cid
 	outPath   string
-	outStream io.Writer
+	outStream *deadlineWriter
 
-	lk     sync.Mutex
+	// lk is a mutex which can be waited for until a context is done.
+	lk     chan struct{}
 	f      *os.File
 	closed bool
 	w      carstorage.WritableCar
@@ -64,7 +71,7 @@ type DeferredCarWriter struct {
 // No options are supplied to carstorage.NewWritable by default, add
 // the car.WriteAsCarV1(true) option to write a CARv1 file.
 func NewDeferredCarWriterForPath(outPath string, roots []cid.Cid, opts ...carv2.Option) *DeferredCarWriter {
-	return &DeferredCarWriter{roots: roots, outPath: outPath, opts: opts}
+	return &DeferredCarWriter{roots: roots, outPath: outPath, lk: make(chan struct{}, 1), opts: opts}
 }
 
 // NewDeferredCarWriterForStream creates a DeferredCarWriter that will write to
@@ -76,7 +83,47 @@ func NewDeferredCarWriterForPath(outPath string, roots []cid.Cid, opts ...carv2.
 // header.
 func NewDeferredCarWriterForStream(outStream io.Writer, roots []cid.Cid, opts ...carv2.Option) *DeferredCarWriter {
 	opts = append([]carv2.Option{carv2.WriteAsCarV1(true)}, opts...)
-	return &DeferredCarWriter{roots: roots, outStream: outStream, opts: opts}
+	return &DeferredCarWriter{
+		roots:     roots,
+		outStream: newDeadlineWriter(outStream),
+		lk:        make(chan struct{}, 1),
+		opts:      opts,
+	}
+}
+
+// SetWriteTimeout bounds the duration of each write to the output stream, in
+// addition to the context passed to Put. Once a write times out, Put returns
+// an error wrapping ErrWriteStalled, as does every subsequent Put. A timeout
+// of zero, the default, only bounds writes by the context passed to Put.
+//
+// If the stream supports write deadlines, such as a net.Conn or an
+// http.ResponseWriter, they are used to interrupt writes. Otherwise, a write
+// which times out is abandoned rather than interrupted: it keeps running
+// against the stream in the background until it returns, so the stream must
+// remain usable until then. Without a timeout, writes to such streams are
+// never abandoned, and the context passed to Put is only checked between them.
+//
+// Write timeouts only apply to writers created with
+// NewDeferredCarWriterForStream. SetWriteTimeout waits for any ongoing Put.
+func (dcw *DeferredCarWriter) SetWriteTimeout(timeout time.Duration) {
+	dcw.lock(context.Background())
+	defer dcw.unlock()
+	if dcw.outStream != nil {
+		dcw.outStream.timeout = timeout
+	}
+}
+
+func (dcw *DeferredCarWriter) lock(ctx context.Context) error {
+	select {
+	case dcw.lk <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (dcw *DeferredCarWriter) unlock() {
+	<-dcw.lk
 }
 
 // OnPut will call a callback when each Put() operation is started. The argument
@@ -91,8 +138,10 @@ func (dcw *DeferredCarWriter) OnPut(cb func(int), once bool) {
 
 // Has returns false if the key was not already written to the CAR output.
 func (dcw *DeferredCarWriter) Has(ctx context.Context, key string) (bool, error) {
-	dcw.lk.Lock()
-	defer dcw.lk.Unlock()
+	if err := dcw.lock(ctx); err != nil {
+		return false, err
+	}
+	defer dcw.unlock()
 
 	if dcw.closed {
 		return false, carstorage.ErrClosed
@@ -113,8 +162,10 @@ func (dcw *DeferredCarWriter) Has(ctx context.Context, key string) (bool, error)
 // Put writes the given content to the CAR output stream, creating it if it
 // doesn't exist yet.
 func (dcw *DeferredCarWriter) Put(ctx context.Context, key string, content []byte) error {
-	dcw.lk.Lock()
-	defer dcw.lk.Unlock()
+	if err := dcw.lock(ctx); err != nil {
+		return err
+	}
+	defer dcw.unlock()
 
 	if dcw.closed {
 		return carstorage.ErrClosed
@@ -132,6 +183,11 @@ func (dcw *DeferredCarWriter) Put(ctx context.Context, key string, content []byt
 		}
 	}
 
+	if dcw.outStream != nil {
+		// Bound the writes of this Put by its context.
+		defer dcw.outStream.bound(ctx)()
+	}
+
 	// first Put() call, initialise writer, which will write a CAR header
 	writer, err := dcw.writer()
 	if err != nil {
@@ -144,8 +200,8 @@ func (dcw *DeferredCarWriter) Put(ctx context.Context, key string, content []byt
 // writer()
 func (dcw *DeferredCarWriter) writer() (carstorage.WritableCar, error) {
 	if dcw.w == nil {
-		outStream := dcw.outStream
-		if outStream == nil {
+		var outStream io.Writer = dcw.outStream
+		if dcw.outStream == nil {
 			openedFile, err := os.OpenFile(dcw.outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 			if err != nil {
 				return nil, err
@@ -164,8 +220,10 @@ func (dcw *DeferredCarWriter) writer() (carstorage.WritableCar, error) {
 
 // Close closes the underlying file, if one was created.
 func (dcw *DeferredCarWriter) Close() (err error) {
-	dcw.lk.Lock()
-	defer dcw.lk.Unlock()
+	// Close has no context; it waits for any ongoing Put, which is itself
+	// bounded by its context and the write timeout.
+	dcw.lock(context.Background())
+	defer dcw.unlock()
 
 	if dcw.closed {
 		return carstorage.ErrClosed
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
+	"net/http/httptest"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
@@ -251,6 +254,124 @@ func TestDeferredCarWriterWriteAfterClose(t *testing.T) {
 	req.ErrorIs(cw.Close(), storage.ErrClosed)
 }
 
+// stallingWriter blocks writes until released.
+type stallingWriter struct {
+	release chan struct{}
+}
+
+func (w *stallingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestDeferredCarWriterStalledStream(t *testing.T) {
+	testCid1, testData1 := randBlock()
+	testCid2, testData2 := randBlock()
+
+	t.Run("context", func(t *testing.T) {
+		// Nothing reads from the other end of the pipe, which supports write
+		// deadlines.
+		conn, other := net.Pipe()
+		t.Cleanup(func() {
+			conn.Close()
+			other.Close()
+		})
+		cw := deferred.NewDeferredCarWriterForStream(conn, []cid.Cid{testCid1})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := cw.Put(ctx, testCid1.KeyString(), testData1)
+		require.ErrorIs(t, err, deferred.ErrWriteStalled)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// The output is incomplete, so further puts fail straight away.
+		err = cw.Put(context.Background(), testCid2.KeyString(), testData2)
+		require.ErrorIs(t, err, deferred.ErrWriteStalled)
+	})
+
+	t.Run("context without write deadlines", func(t *testing.T) {
+		w := &stallingWriter{release: make(chan struct{})}
+		cw := deferred.NewDeferredCarWriterForStream(w, []cid.Cid{testCid1})
+
+		// Without a write timeout, a write is never abandoned, such that the
+		// stream is not written to once Put returns; the context is checked
+		// between writes instead.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		stalled := make(chan error)
+		go func() { stalled <- cw.Put(ctx, testCid1.KeyString(), testData1) }()
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case err := <-stalled:
+			t.Fatalf("put returned while writing: %v", err)
+		default:
+		}
+		close(w.release)
+		err := <-stalled
+		require.ErrorIs(t, err, deferred.ErrWriteStalled)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("response writer", func(t *testing.T) {
+		// A response writer without deadline support is written to directly.
+		rec := httptest.NewRecorder()
+		cw := deferred.NewDeferredCarWriterForStream(rec, []cid.Cid{testCid1})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, cw.Put(ctx, testCid1.KeyString(), testData1))
+		require.NoError(t, cw.Close())
+		require.NotZero(t, rec.Body.Len())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		w := &stallingWriter{release: make(chan struct{})}
+		t.Cleanup(func() { close(w.release) })
+		cw := deferred.NewDeferredCarWriterForStream(w, []cid.Cid{testCid1})
+		cw.SetWriteTimeout(20 * time.Millisecond)
+
+		err := cw.Put(context.Background(), testCid1.KeyString(), testData1)
+		require.ErrorIs(t, err, deferred.ErrWriteStalled)
+	})
+
+	t.Run("waiting", func(t *testing.T) {
+		w := &stallingWriter{release: make(chan struct{})}
+		cw := deferred.NewDeferredCarWriterForStream(w, []cid.Cid{testCid1})
+
+		// A put stalled without bound only holds up other users until their
+		// context is done.
+		stalled := make(chan error)
+		go func() { stalled <- cw.Put(context.Background(), testCid1.KeyString(), testData1) }()
+		time.Sleep(10 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, cw.Put(ctx, testCid2.KeyString(), testData2), context.DeadlineExceeded)
+		_, err := cw.Has(ctx, testCid1.KeyString())
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		close(w.release)
+		require.NoError(t, <-stalled)
+		require.NoError(t, cw.Put(context.Background(), testCid2.KeyString(), testData2))
+		require.NoError(t, cw.Close())
+	})
+
+	t.Run("write deadline", func(t *testing.T) {
+		// Nothing reads from the other end of the pipe, which supports write
+		// deadlines.
+		conn, other := net.Pipe()
+		t.Cleanup(func() {
+			conn.Close()
+			other.Close()
+		})
+		cw := deferred.NewDeferredCarWriterForStream(conn, []cid.Cid{testCid1})
+		cw.SetWriteTimeout(20 * time.Millisecond)
+
+		err := cw.Put(context.Background(), testCid1.KeyString(), testData1)
+		require.ErrorIs(t, err, deferred.ErrWriteStalled)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
 func randBlock() (cid.Cid, []byte) {
 	data := make([]byte, 1024)
 	rngLk.Lock()
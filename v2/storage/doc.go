@@ -48,11 +48,18 @@
 // an io.File) in order to properly manage CAR lifecycle and avoid writing a
 // corrupt CAR.
 //
+// • NewOverlay requires an io.ReaderAt for a base CAR and the same IO object
+// as NewReadableWritable for the CAR written alongside it, holding only the
+// blocks put which the base does not hold. Once finalized, the blocks of both
+// may also be written out as a single merged CAR.
+//
 // The following options are available to customize the behavior of the
 // StorageCar:
 //
 // • WriteAsCarV1
 //
+// • WithDetachedIndexPath
+//
 // • StoreIdentityCIDs
 //
 // • AllowDuplicatePuts
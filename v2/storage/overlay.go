@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+)
+
+var _ RangeReadableCar = (*OverlayCar)(nil)
+var _ WritableCar = (*OverlayCar)(nil)
+
+// OverlayCar is a copy-on-write overlay over a base CAR, which is only read
+// from. Blocks put into the overlay are written to a new CAR, the delta,
+// unless the base already holds them, while reads are served from the delta
+// and then the base. This allows editing a snapshot held in a CAR without
+// copying its unchanged blocks during the session.
+//
+// Once writing is done, either Finalize is called to keep the delta alone, or
+// FinalizeMerged to also write a CAR holding the blocks of both the base and
+// the delta.
+type OverlayCar struct {
+	base       RangeReadableCar
+	baseReader io.ReaderAt
+	delta      *StorageCar
+	deltaRW    ReaderAtWriterAt
+	opts       []carv2.Option
+}
+
+// NewOverlay creates an OverlayCar over the base CAR read from base, writing
+// the delta to rw as NewReadableWritable would, with the given roots, e.g. the
+// root of the edited DAG. The options apply to both the base and the delta,
+// as well as to the merged CAR written by FinalizeMerged.
+func NewOverlay(base io.ReaderAt, rw ReaderAtWriterAt, roots []cid.Cid, opts ...carv2.Option) (*OverlayCar, error) {
+	// Read the base from its start, regardless of the position of base if it
+	// is also an io.Seeker, e.g. an os.File.
+	br, err := internalio.NewOffsetReadSeeker(base, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := OpenReadable(br, opts...)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := NewReadableWritable(rw, roots, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &OverlayCar{base: b.(RangeReadableCar), baseReader: base, delta: delta, deltaRW: rw, opts: opts}, nil
+}
+
+// Roots returns the roots of the delta, which are also those of the merged
+// CAR.
+func (oc *OverlayCar) Roots() []cid.Cid {
+	return oc.delta.Roots()
+}
+
+// Index gives direct access to the index of the delta, i.e. of the blocks
+// written to it. It should be used with care; see StorageCar.Index.
+func (oc *OverlayCar) Index() index.Index {
+	return oc.delta.Index()
+}
+
+// Put adds a block to the delta, unless the base already holds it. The keyStr
+// value must be a valid CID binary string, i.e. generated with CID#KeyString().
+func (oc *OverlayCar) Put(ctx context.Context, keyStr string, data []byte) error {
+	if has, err := oc.base.Has(ctx, keyStr); err != nil {
+		return err
+	} else if has {
+		return nil
+	}
+	return oc.delta.Put(ctx, keyStr, data)
+}
+
+// Has returns true if either the delta or the base holds the block identified
+// by the given CID provided in string form.
+func (oc *OverlayCar) Has(ctx context.Context, keyStr string) (bool, error) {
+	if has, err := oc.delta.Has(ctx, keyStr); err != nil || has {
+		return has, err
+	}
+	return oc.base.Has(ctx, keyStr)
+}
+
+// Get returns the block bytes identified by the given CID provided in string
+// form, from the delta if it holds the block, or else from the base.
+func (oc *OverlayCar) Get(ctx context.Context, keyStr string) ([]byte, error) {
+	rdr, err := oc.GetStream(ctx, keyStr)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rdr)
+}
+
+// GetStream is like Get, but returns a stream of the block bytes.
+func (oc *OverlayCar) GetStream(ctx context.Context, keyStr string) (io.ReadCloser, error) {
+	rdr, err := oc.delta.GetStream(ctx, keyStr)
+	if errors.Is(err, ErrNotFound{}) {
+		return oc.base.GetStream(ctx, keyStr)
+	}
+	return rdr, err
+}
+
+// GetRange is like StorageCar.GetRange, reading from the delta if it holds
+// the block, or else from the base.
+func (oc *OverlayCar) GetRange(ctx context.Context, keyStr string, offset, length int64) ([]byte, error) {
+	data, err := oc.delta.GetRange(ctx, keyStr, offset, length)
+	if errors.Is(err, ErrNotFound{}) {
+		return oc.base.GetRange(ctx, keyStr, offset, length)
+	}
+	return data, err
+}
+
+// Finalize finalizes the delta as StorageCar.Finalize does, such that it holds
+// the blocks put into the overlay which the base did not hold. The overlay can
+// no longer be used afterwards.
+func (oc *OverlayCar) Finalize() error {
+	return oc.delta.Finalize()
+}
+
+// FinalizeMerged finalizes the delta, and writes a CAR holding the blocks of
+// the base followed by those of the delta to w, as NewWritable would, with the
+// roots of the delta. As with NewWritable, a CARv2 can only be written if w is
+// an io.WriterAt; see WriteAsCarV1.
+func (oc *OverlayCar) FinalizeMerged(w io.Writer) error {
+	if err := oc.Finalize(); err != nil {
+		return err
+	}
+	merged, err := NewWritable(w, oc.Roots(), oc.opts...)
+	if err != nil {
+		return err
+	}
+	for _, r := range []io.ReaderAt{oc.baseReader, oc.deltaRW} {
+		rs, err := internalio.NewOffsetReadSeeker(r, 0)
+		if err != nil {
+			return err
+		}
+		br, err := carv2.NewBlockReader(rs, oc.opts...)
+		if err != nil {
+			return err
+		}
+		for {
+			blk, err := br.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := merged.Put(context.Background(), blk.Cid().KeyString(), blk.RawData()); err != nil {
+				return err
+			}
+		}
+	}
+	return merged.Finalize()
+}
@@ -33,6 +33,16 @@ type ReadableCar interface {
 	Index() index.Index
 }
 
+// RangeReadableCar is a ReadableCar which can also read part of the data of a
+// block, reading only that part, as implemented by StorageCar and OverlayCar.
+// It is kept apart from ReadableCar, such that existing implementations of
+// the latter remain valid; callers can type-assert a ReadableCar to it.
+type RangeReadableCar interface {
+	ReadableCar
+	// GetRange returns part of the data of a block; see StorageCar.GetRange.
+	GetRange(ctx context.Context, key string, offset, length int64) ([]byte, error)
+}
+
 // WritableCar is compatible with storage.WritableStorage but also returns
 // the roots of the CAR. It does not implement ipld.StreamingWritableStorage
 // as the CAR format does not support streaming data followed by its CID, so
@@ -45,11 +55,13 @@ type WritableCar interface {
 	Finalize() error
 }
 
-var _ ReadableCar = (*StorageCar)(nil)
+var _ RangeReadableCar = (*StorageCar)(nil)
 var _ WritableCar = (*StorageCar)(nil)
 
 type StorageCar struct {
 	idx        index.Index
+	lazy       *store.LazyIndex
+	spill      *store.SpillIndex
 	reader     io.ReaderAt
 	writer     positionedWriter
 	dataWriter *internalio.OffsetWriteSeeker
@@ -82,6 +94,12 @@ type positionedWriter interface {
 // The Readable supports StreamingReadableStorage, which allows for efficient
 // GetStreaming operations straight out of the underlying CAR where the
 // linksystem can make use of it.
+//
+// The reading options are honored as they are by the blockstore package and
+// BlockReader, namely ZeroLengthSectionAsEOF, MaxAllowedHeaderSize,
+// MaxAllowedSectionSize, MaxIndexCidSize, StoreIdentityCIDs, UseWholeCIDs and
+// UseLazyIndex. As with the blockstore, blocks are verified against their CID
+// when generating an index, regardless of WithTrustedCAR, but not when read.
 func OpenReadable(reader io.ReaderAt, opts ...carv2.Option) (ReadableCar, error) {
 	sc := &StorageCar{opts: carv2.ApplyOptions(opts...)}
 
@@ -94,6 +112,12 @@ func OpenReadable(reader io.ReaderAt, opts ...carv2.Option) (ReadableCar, error)
 	case 1:
 		sc.roots = header.Roots
 		sc.reader = reader
+		if sc.opts.BlockstoreLazyIndex {
+			if err := sc.initLazyIndex(); err != nil {
+				return nil, err
+			}
+			return sc, nil
+		}
 		rr.Seek(0, io.SeekStart)
 		sc.idx = index.NewInsertionIndex()
 		if err := carv2.LoadIndex(sc.idx, rr, opts...); err != nil {
@@ -117,6 +141,14 @@ func OpenReadable(reader io.ReaderAt, opts ...carv2.Option) (ReadableCar, error)
 			if err != nil {
 				return nil, err
 			}
+		} else if sc.opts.BlockstoreLazyIndex {
+			if sc.reader, err = v2r.DataReader(); err != nil {
+				return nil, err
+			}
+			if err := sc.initLazyIndex(); err != nil {
+				return nil, err
+			}
+			return sc, nil
 		} else {
 			dr, err := v2r.DataReader()
 			if err != nil {
@@ -137,6 +169,16 @@ func OpenReadable(reader io.ReaderAt, opts ...carv2.Option) (ReadableCar, error)
 	return sc, nil
 }
 
+func (sc *StorageCar) initLazyIndex() error {
+	lazy, err := store.NewLazyIndex(sc.reader, sc.opts.MaxAllowedHeaderSize, sc.opts.ReadAheadSize)
+	if err != nil {
+		return err
+	}
+	sc.lazy = lazy
+	sc.idx = lazy.Index()
+	return nil
+}
+
 // NewWritable creates a new WritableStorage as defined by
 // github.com/ipld/go-ipld-prime/storage that writes a CARv1 or CARv2 format to
 // the given io.Writer.
@@ -165,7 +207,11 @@ func NewWritable(writer io.Writer, roots []cid.Cid, opts ...carv2.Option) (Writa
 	if err != nil {
 		return nil, err
 	}
-	return sc.init()
+	if _, err := sc.init(); err != nil {
+		sc.Discard()
+		return nil, err
+	}
+	return sc, nil
 }
 
 func newWritable(writer io.Writer, roots []cid.Cid, opts ...carv2.Option) (*StorageCar, error) {
@@ -176,6 +222,9 @@ func newWritable(writer io.Writer, roots []cid.Cid, opts ...carv2.Option) (*Stor
 		opts:   carv2.ApplyOptions(opts...),
 		roots:  roots,
 	}
+	if sc.opts.NormalizeRoots {
+		sc.roots, _ = carv2.SortAndDedupeRoots(roots)
+	}
 
 	if p := sc.opts.DataPadding; p > 0 {
 		sc.header = sc.header.WithDataPadding(p)
@@ -197,6 +246,18 @@ func newWritable(writer io.Writer, roots []cid.Cid, opts ...carv2.Option) (*Stor
 		}
 	}
 
+	if sc.opts.IndexSpillRecords > 0 {
+		if store.IsSizedIndexCodec(sc.opts.IndexCodec) {
+			// The records of spill runs do not hold block sizes.
+			return nil, fmt.Errorf("index codec %v cannot be used with an index spill", sc.opts.IndexCodec)
+		}
+		spill, err := store.NewSpillIndex(sc.opts.IndexSpillDir, sc.opts.IndexSpillRecords)
+		if err != nil {
+			return nil, err
+		}
+		sc.spill = spill
+	}
+
 	return sc, nil
 }
 
@@ -210,6 +271,7 @@ func newReadableWritable(rw ReaderAtWriterAt, roots []cid.Cid, opts ...carv2.Opt
 	if !sc.opts.WriteAsCarV1 {
 		sc.reader, err = internalio.NewOffsetReadSeeker(rw, int64(sc.header.DataOffset))
 		if err != nil {
+			sc.Discard()
 			return nil, err
 		}
 	}
@@ -231,6 +293,7 @@ func NewReadableWritable(rw ReaderAtWriterAt, roots []cid.Cid, opts ...carv2.Opt
 		return nil, err
 	}
 	if _, err := sc.init(); err != nil {
+		sc.Discard()
 		return nil, err
 	}
 	return sc, nil
@@ -253,9 +316,11 @@ func OpenReadableWritable(rw ReaderAtWriterAt, roots []cid.Cid, opts ...carv2.Op
 	// attempt to resume
 	rs, err := internalio.NewOffsetReadSeeker(rw, 0)
 	if err != nil {
+		sc.Discard()
 		return nil, err
 	}
 	if err := store.ResumableVersion(rs, sc.opts.WriteAsCarV1); err != nil {
+		sc.Discard()
 		return nil, err
 	}
 	if err := store.Resume(
@@ -263,12 +328,14 @@ func OpenReadableWritable(rw ReaderAtWriterAt, roots []cid.Cid, opts ...carv2.Op
 		sc.reader,
 		sc.dataWriter,
 		sc.idx.(*index.InsertionIndex),
-		roots,
+		sc.roots,
 		sc.header.DataOffset,
 		sc.opts.WriteAsCarV1,
 		sc.opts.MaxAllowedHeaderSize,
 		sc.opts.ZeroLengthSectionAsEOF,
+		0,
 	); err != nil {
+		sc.Discard()
 		return nil, err
 	}
 	return sc, nil
@@ -295,7 +362,15 @@ func (sc *StorageCar) Roots() []cid.Cid {
 	return sc.roots
 }
 
-// Index gives direct access to the index. It should be used with care.
+// IdentityCIDPolicy returns how this CAR treats blocks with identity CIDs, as
+// set via the StoreIdentityCIDs option.
+func (sc *StorageCar) IdentityCIDPolicy() carv2.IdentityCIDPolicy {
+	return store.IdentityPolicy(sc.opts.StoreIdentityCIDs)
+}
+
+// Index gives direct access to the index. It should be used with care. With
+// the UseLazyIndex option, it only holds the sections indexed so far, and with
+// the WithIndexSpill option, only the records not spilled to disk yet.
 // Modifying the index may result corruption or invalid reads.
 func (sc *StorageCar) Index() index.Index {
 	return sc.idx
@@ -323,7 +398,7 @@ func (sc *StorageCar) Put(ctx context.Context, keyStr string, data []byte) error
 	}
 
 	if should, err := store.ShouldPut(
-		idx,
+		sc.lookupIndex(idx),
 		keyCid,
 		sc.opts.MaxIndexCidSize,
 		sc.opts.StoreIdentityCIDs,
@@ -343,11 +418,27 @@ func (sc *StorageCar) Put(ctx context.Context, keyStr string, data []byte) error
 	if err := util.LdWrite(w, keyCid.Bytes(), data); err != nil {
 		return err
 	}
-	idx.InsertNoReplace(keyCid, n)
+	idx.InsertSizedNoReplace(keyCid, n, uint64(len(data)))
+	if sc.spill != nil {
+		next, err := sc.spill.Put(idx)
+		if err != nil {
+			return err
+		}
+		sc.idx = next
+	}
 
 	return nil
 }
 
+// lookupIndex returns the index to look up the blocks written to the CAR with,
+// given the index of those not spilled to disk.
+func (sc *StorageCar) lookupIndex(idx *index.InsertionIndex) store.LookupIndex {
+	if sc.spill != nil {
+		return sc.spill.With(idx)
+	}
+	return idx
+}
+
 // Has returns true if the CAR contains a block identified by the given CID
 // provided in string form. The keyStr value must be a valid CID binary string
 // (not a multibase string representation), i.e. generated with CID#KeyString().
@@ -367,7 +458,7 @@ func (sc *StorageCar) Has(ctx context.Context, keyStr string) (bool, error) {
 	if idx, ok := sc.idx.(*index.InsertionIndex); ok && sc.writer != nil {
 		// writable CAR, fast path using InsertionIndex
 		return store.Has(
-			idx,
+			sc.lookupIndex(idx),
 			keyCid,
 			sc.opts.MaxIndexCidSize,
 			sc.opts.StoreIdentityCIDs,
@@ -376,26 +467,16 @@ func (sc *StorageCar) Has(ctx context.Context, keyStr string) (bool, error) {
 		)
 	}
 
-	if !sc.opts.StoreIdentityCIDs {
-		// If we don't store identity CIDs then we can return them straight away as if they are here,
-		// otherwise we need to check for their existence.
-		// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
-		if _, ok, err := store.IsIdentity(keyCid); err != nil {
-			return false, err
-		} else if ok {
-			return true, nil
-		}
+	// If we don't store identity CIDs then we can return them straight away as if they are here,
+	// otherwise we need to check for their existence.
+	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
+	if _, ok, err := store.InlineIdentity(keyCid, sc.opts.StoreIdentityCIDs); err != nil {
+		return false, err
+	} else if ok {
+		return true, nil
 	}
 
-	_, _, size, err := store.FindCid(
-		sc.reader,
-		sc.idx,
-		keyCid,
-		sc.opts.BlockstoreUseWholeCIDs,
-		sc.opts.ZeroLengthSectionAsEOF,
-		sc.opts.MaxAllowedSectionSize,
-		false,
-	)
+	_, size, err := sc.findCid(keyCid)
 	if errors.Is(err, index.ErrNotFound) {
 		return false, nil
 	} else if err != nil {
@@ -428,15 +509,58 @@ func (sc *StorageCar) GetStream(ctx context.Context, keyStr string) (io.ReadClos
 		return nil, fmt.Errorf("bad CID key: %w", err)
 	}
 
-	if !sc.opts.StoreIdentityCIDs {
-		// If we don't store identity CIDs then we can return them straight away as if they are here,
-		// otherwise we need to check for their existence.
-		// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
-		if digest, ok, err := store.IsIdentity(keyCid); err != nil {
+	// If we don't store identity CIDs then we can return them straight away as if they are here,
+	// otherwise we need to check for their existence.
+	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
+	if digest, ok, err := store.InlineIdentity(keyCid, sc.opts.StoreIdentityCIDs); err != nil {
+		return nil, err
+	} else if ok {
+		return io.NopCloser(bytes.NewReader(digest)), nil
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	if sc.closed {
+		return nil, ErrClosed
+	}
+
+	offset, size, err := sc.findCid(keyCid)
+	if errors.Is(err, index.ErrNotFound) {
+		return nil, ErrNotFound{Cid: keyCid}
+	} else if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&blockDataReader{r: io.NewSectionReader(sc.reader, offset, int64(size)), remaining: size}), nil
+}
+
+// GetRange returns length bytes of the data of the block identified by the
+// given CID provided in string form, starting at offset, or fewer if the block
+// ends before. Only the bytes of the range are read from the CAR. The keyStr
+// value must be a valid CID binary string (not a multibase string
+// representation), i.e. generated with CID#KeyString().
+//
+// An offset equal to the size of the block yields no bytes, while an offset
+// beyond it is an error. Since the block is only partially read, its data is
+// not checked against its CID.
+func (sc *StorageCar) GetRange(ctx context.Context, keyStr string, offset, length int64) ([]byte, error) {
+	if sc.reader == nil {
+		return nil, fmt.Errorf("cannot read from a write-only CAR")
+	}
+
+	keyCid, err := cid.Cast([]byte(keyStr))
+	if err != nil {
+		return nil, fmt.Errorf("bad CID key: %w", err)
+	}
+
+	if digest, ok, err := store.InlineIdentity(keyCid, sc.opts.StoreIdentityCIDs); err != nil {
+		return nil, err
+	} else if ok {
+		length, err := store.ClipRange(len(digest), offset, length)
+		if err != nil {
 			return nil, err
-		} else if ok {
-			return io.NopCloser(bytes.NewReader(digest)), nil
 		}
+		return digest[offset : offset+length], nil
 	}
 
 	sc.mu.RLock()
@@ -446,27 +570,62 @@ func (sc *StorageCar) GetStream(ctx context.Context, keyStr string) (io.ReadClos
 		return nil, ErrClosed
 	}
 
+	dataOffset, size, err := sc.findCid(keyCid)
+	if errors.Is(err, index.ErrNotFound) {
+		return nil, ErrNotFound{Cid: keyCid}
+	} else if err != nil {
+		return nil, err
+	}
+	return store.ReadRange(sc.reader, dataOffset, size, offset, length)
+}
+
+// findCid returns the offset and size of the data of the block with the given
+// CID, indexing further sections of the CAR if it has a lazy index.
+func (sc *StorageCar) findCid(keyCid cid.Cid) (int64, int, error) {
+	if sc.lazy != nil {
+		_, offset, size, err := sc.lazy.FindCid(sc.reader, keyCid, sc.opts, false)
+		return offset, size, err
+	}
+	var idx interface {
+		GetAll(cid.Cid, func(uint64) bool) error
+	} = sc.idx
+	if sc.spill != nil {
+		idx = sc.spill.With(sc.idx.(*index.InsertionIndex))
+	}
 	_, offset, size, err := store.FindCid(
 		sc.reader,
-		sc.idx,
+		idx,
 		keyCid,
 		sc.opts.BlockstoreUseWholeCIDs,
 		sc.opts.ZeroLengthSectionAsEOF,
 		sc.opts.MaxAllowedSectionSize,
 		false,
 	)
-	if errors.Is(err, index.ErrNotFound) {
-		return nil, ErrNotFound{Cid: keyCid}
-	} else if err != nil {
-		return nil, err
+	return offset, size, err
+}
+
+// blockDataReader reads the data of a block, returning io.ErrUnexpectedEOF if
+// the CAR ends before all of it is read, such that a block of a truncated CAR
+// is reported as an error rather than as short data.
+type blockDataReader struct {
+	r         io.Reader
+	remaining int
+}
+
+func (b *blockDataReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.remaining -= n
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
 	}
-	return io.NopCloser(io.NewSectionReader(sc.reader, offset, int64(size))), nil
+	return n, err
 }
 
 // Finalize writes the CAR index to the underlying writer if the CAR being
 // written is a CARv2. It also writes a finalized CARv2 header which details
 // payload location. This should be called on a writable StorageCar in order to
-// avoid data loss.
+// avoid data loss. If the WithSyncOnFinalize option is set, the writer is also
+// flushed to stable storage before returning.
 func (sc *StorageCar) Finalize() error {
 	idx, ok := sc.idx.(*index.InsertionIndex)
 	if !ok || sc.writer == nil {
@@ -475,6 +634,18 @@ func (sc *StorageCar) Finalize() error {
 	}
 
 	if sc.opts.WriteAsCarV1 {
+		err := sc.writeDetachedIndex(idx)
+		if sc.spill != nil {
+			if cerr := sc.spill.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if sc.opts.SyncOnFinalize {
+			return store.Sync(sc.writer.(*positionTrackingWriter).w)
+		}
 		return nil
 	}
 
@@ -494,7 +665,57 @@ func (sc *StorageCar) Finalize() error {
 
 	sc.closed = true
 
-	return store.Finalize(wat, sc.header, idx, uint64(sc.dataWriter.Position()), sc.opts.StoreIdentityCIDs, sc.opts.IndexCodec)
+	if sc.spill != nil {
+		err := sc.spill.Finalize(wat, sc.header, idx, uint64(sc.dataWriter.Position()), sc.opts.StoreIdentityCIDs, sc.opts.IndexCodec)
+		if cerr := sc.spill.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := store.Finalize(wat, sc.header, idx, uint64(sc.dataWriter.Position()), sc.opts.StoreIdentityCIDs, sc.opts.IndexCodec); err != nil {
+		return err
+	}
+	if sc.opts.SyncOnFinalize {
+		return store.Sync(wat)
+	}
+	return nil
+}
+
+// writeDetachedIndex writes the index of the blocks put, given those not
+// spilled to disk, to the file set via WithDetachedIndexPath, if any.
+func (sc *StorageCar) writeDetachedIndex(idx *index.InsertionIndex) error {
+	if sc.opts.DetachedIndexPath == "" || sc.opts.IndexCodec == index.CarIndexNone {
+		return nil
+	}
+	if sc.spill != nil {
+		var err error
+		if idx, err = sc.spill.Merged(idx); err != nil {
+			return err
+		}
+	}
+	return store.WriteDetachedIndex(sc.opts.DetachedIndexPath, idx, sc.opts.IndexCodec, sc.opts.SyncOnFinalize)
+}
+
+// Discard releases the resources held by a writable StorageCar without
+// finalizing it, such as the temporary files of the WithIndexSpill option. It
+// should be called instead of Finalize when a CAR is abandoned, e.g. after a
+// Put failed. The StorageCar can no longer be written to afterwards, nor be
+// finalized as a CARv2, and subsequent calls to Discard are no-ops.
+//
+// The WritableCar returned by NewWritable is a *StorageCar, and may thus be
+// discarded too.
+func (sc *StorageCar) Discard() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.closed = true
+	if sc.spill == nil {
+		return nil
+	}
+	err := sc.spill.Close()
+	sc.spill = nil
+	return err
 }
 
 type positionTrackingWriter struct {
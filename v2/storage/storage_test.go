@@ -12,12 +12,14 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/store"
@@ -93,6 +95,8 @@ func TestReadable(t *testing.T) {
 			t.Cleanup(func() { require.NoError(t, inputReader.Close()) })
 			readable, err := storage.OpenReadable(inputReader, tt.opts...)
 			require.NoError(t, err)
+			ranged, ok := readable.(storage.RangeReadableCar)
+			require.True(t, ok)
 
 			// Setup BlockReader to compare against
 			actualReader, err := os.Open(tt.inputPath)
@@ -135,6 +139,16 @@ func TestReadable(t *testing.T) {
 					data, err := io.ReadAll(reader)
 					require.NoError(t, err)
 					require.Equal(t, wantBlock.RawData(), data)
+
+					raw := wantBlock.RawData()
+					part, err := ranged.GetRange(ctx, key.KeyString(), int64(len(raw)/3), int64(len(raw)/2))
+					require.NoError(t, err)
+					require.Equal(t, raw[len(raw)/3:len(raw)/3+len(raw)/2], part)
+					part, err = ranged.GetRange(ctx, key.KeyString(), int64(len(raw)/2), int64(len(raw)))
+					require.NoError(t, err)
+					require.Equal(t, raw[len(raw)/2:], part)
+					_, err = ranged.GetRange(ctx, key.KeyString(), int64(len(raw)+1), 1)
+					require.ErrorContains(t, err, "beyond the block size")
 				}
 			}
 
@@ -148,6 +162,8 @@ func TestReadable(t *testing.T) {
 			require.True(t, errors.Is(err, storage.ErrNotFound{}))
 			require.True(t, storage.IsNotFound(err))
 			require.Contains(t, err.Error(), c.String())
+			_, err = ranged.GetRange(ctx, c.KeyString(), 0, 1)
+			require.True(t, storage.IsNotFound(err))
 
 			// random identity, should only find this if we _don't_ store identity CIDs
 			storeIdentity := carv2.ApplyOptions(tt.opts...).StoreIdentityCIDs
@@ -460,6 +476,78 @@ func TestNullPadding(t *testing.T) {
 	}
 }
 
+func TestReadableOptionParity(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		path string
+		opts []carv2.Option
+	}{
+		{"v1", "../testdata/sample-v1.car", nil},
+		{"v1 lazy", "../testdata/sample-v1.car", []carv2.Option{carv2.UseLazyIndex(true)}},
+		{"v1 max section size", "../testdata/sample-v1.car", []carv2.Option{carv2.MaxAllowedSectionSize(100)}},
+		{"v1 padded", "../testdata/sample-v1-with-zero-len-section.car", []carv2.Option{carv2.ZeroLengthSectionAsEOF(true)}},
+		{"v1 padded lazy", "../testdata/sample-v1-with-zero-len-section2.car", []carv2.Option{carv2.ZeroLengthSectionAsEOF(true), carv2.UseLazyIndex(true)}},
+		{"v1 truncated", "../testdata/sample-v1-tailing-corrupt-section.car", nil},
+		{"v2 indexless lazy", "../testdata/sample-v2-indexless.car", []carv2.Option{carv2.UseLazyIndex(true)}},
+		{"v2 max section size", "../testdata/sample-wrapped-v2.car", []carv2.Option{carv2.MaxAllowedSectionSize(100)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.path)
+			require.NoError(t, err)
+			readable, err := storage.OpenReadable(bufferReaderAt(data), tt.opts...)
+			require.NoError(t, err)
+			bs, err := blockstore.NewReadOnly(bufferReaderAt(data), nil, tt.opts...)
+			require.NoError(t, err)
+
+			// Reads must succeed or fail alike for every block of the CAR,
+			// including a truncated block, which AllKeysChan reports as an
+			// error, but which the generated index holds.
+			var asyncErr error
+			keys, err := bs.AllKeysChan(blockstore.WithAsyncErrorHandler(ctx, func(err error) { asyncErr = err }))
+			require.NoError(t, err)
+			var cids []cid.Cid
+			for c := range keys {
+				cids = append(cids, c)
+			}
+			if tt.name == "v1 truncated" {
+				require.ErrorIs(t, asyncErr, io.ErrUnexpectedEOF)
+				require.NoError(t, bs.Index().(index.IterableIndex).ForEach(func(mh multihash.Multihash, _ uint64) error {
+					if c := cid.NewCidV1(cid.Raw, mh); !slices.Contains(cids, c) {
+						cids = append(cids, c)
+					}
+					return nil
+				}))
+			} else {
+				require.NoError(t, asyncErr)
+			}
+			var failures int
+			for _, c := range cids {
+				want, wantErr := bs.Get(ctx, c)
+				got, gotErr := readable.Get(ctx, c.KeyString())
+				if wantErr != nil {
+					require.Error(t, gotErr, c)
+					failures++
+					continue
+				}
+				require.NoError(t, gotErr, c)
+				require.Equal(t, want.RawData(), got)
+
+				_, wantErr = bs.Has(ctx, c)
+				_, gotErr = readable.Has(ctx, c.KeyString())
+				require.Equal(t, wantErr == nil, gotErr == nil, c)
+			}
+			switch tt.name {
+			case "v1 truncated", "v1 max section size", "v2 max section size":
+				require.NotZero(t, failures)
+			default:
+				require.Zero(t, failures)
+			}
+		})
+	}
+}
+
 func TestPutSameHashes(t *testing.T) {
 	tdir := t.TempDir()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -1378,3 +1466,411 @@ func listCids(t *testing.T, v1r *carv1.CarReader) (cids []cid.Cid) {
 	}
 	return
 }
+
+func TestWritableNormalizeRoots(t *testing.T) {
+	a := cid.MustParse("bafy2bzaced4ueelaegfs5fqu4tzsh6ywbbpfk3cxppupmxfdhbpbhzawfw5oy")
+	b := cid.MustParse("bafkqaaa")
+	want, changed := carv2.SortAndDedupeRoots([]cid.Cid{a, b, a})
+	require.True(t, changed)
+
+	buf := bytes.Buffer{}
+	writable, err := storage.NewWritable(&buf, []cid.Cid{a, b, a}, carv2.WriteAsCarV1(true), carv2.NormalizeRoots(true))
+	require.NoError(t, err)
+	require.Equal(t, want, writable.Roots())
+	require.NoError(t, writable.Finalize())
+
+	header, err := carv1.ReadHeader(&buf, carv2.DefaultMaxAllowedHeaderSize)
+	require.NoError(t, err)
+	require.Equal(t, want, header.Roots)
+}
+
+func TestWritableSyncOnFinalize(t *testing.T) {
+	root := cid.MustParse("bafkqaaa")
+	for _, opts := range [][]carv2.Option{
+		{carv2.WithSyncOnFinalize(true)},
+		{carv2.WithSyncOnFinalize(true), carv2.WriteAsCarV1(true)},
+	} {
+		f, err := os.Create(filepath.Join(t.TempDir(), "synced.car"))
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		writable, err := storage.NewWritable(f, []cid.Cid{root}, opts...)
+		require.NoError(t, err)
+		require.NoError(t, writable.Finalize())
+	}
+
+	// Writers that cannot be synced are left as they are.
+	buf := bytes.Buffer{}
+	writable, err := storage.NewWritable(&buf, []cid.Cid{root}, carv2.WriteAsCarV1(true), carv2.WithSyncOnFinalize(true))
+	require.NoError(t, err)
+	require.NoError(t, writable.Finalize())
+}
+
+func TestWritableIndexSpill(t *testing.T) {
+	ctx := context.Background()
+	// Blocks of two hash functions, where some share a digest, such that they
+	// are spread over buckets of both sorted index codecs.
+	type block struct {
+		c    cid.Cid
+		data []byte
+		// shared is set on blocks which share a digest with an earlier one,
+		// which are deduplicated unless whole CIDs are used.
+		shared bool
+	}
+	var blocks []block
+	for i := 0; i < 300; i++ {
+		data := []byte(fmt.Sprintf("spilled block %d", i))
+		code := uint64(multihash.SHA2_256)
+		if i%3 == 0 {
+			code = multihash.BLAKE2B_MIN + 31
+		}
+		mh, err := multihash.Sum(data, code, -1)
+		require.NoError(t, err)
+		blocks = append(blocks, block{c: cid.NewCidV1(cid.Raw, mh), data: data})
+		if i%50 == 0 {
+			// The same digest under another hash function.
+			d, err := multihash.Decode(mh)
+			require.NoError(t, err)
+			other, err := multihash.Encode(d.Digest, multihash.SHA3_256)
+			require.NoError(t, err)
+			blocks = append(blocks, block{c: cid.NewCidV1(cid.DagCBOR, other), data: data, shared: true})
+		}
+	}
+
+	write := func(t *testing.T, spillDir string, opts ...carv2.Option) []byte {
+		path := filepath.Join(t.TempDir(), "spilled.car")
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { f.Close() })
+		if spillDir != "" {
+			opts = append(opts, carv2.WithIndexSpill(spillDir, 7))
+		}
+		subject, err := storage.NewReadableWritable(f, []cid.Cid{blocks[0].c}, opts...)
+		require.NoError(t, err)
+		for i, blk := range blocks {
+			require.NoError(t, subject.Put(ctx, blk.c.KeyString(), blk.data))
+			// Blocks spilled to disk are still found, and deduplicated.
+			prev := blocks[i/2]
+			if prev.shared {
+				continue
+			}
+			has, err := subject.Has(ctx, prev.c.KeyString())
+			require.NoError(t, err)
+			require.True(t, has)
+			got, err := subject.Get(ctx, prev.c.KeyString())
+			require.NoError(t, err)
+			require.Equal(t, prev.data, got)
+			require.NoError(t, subject.Put(ctx, prev.c.KeyString(), prev.data))
+		}
+		missing, err := multihash.Sum([]byte("missing"), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		has, err := subject.Has(ctx, cid.NewCidV1(cid.Raw, missing).KeyString())
+		require.NoError(t, err)
+		require.False(t, has)
+		require.NoError(t, subject.Finalize())
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return b
+	}
+
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, multicodec.CarIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			for _, opts := range [][]carv2.Option{
+				{carv2.UseIndexCodec(codec)},
+				{carv2.UseIndexCodec(codec), carv2.UseWholeCIDs(true)},
+			} {
+				spillDir := t.TempDir()
+				// The index merged from the runs is the one built in memory.
+				require.Equal(t, write(t, "", opts...), write(t, spillDir, opts...))
+				entries, err := os.ReadDir(spillDir)
+				require.NoError(t, err)
+				require.Empty(t, entries)
+			}
+		})
+	}
+}
+
+func TestWritableDetachedIndex(t *testing.T) {
+	ctx := context.Background()
+	for _, spill := range []bool{false, true} {
+		t.Run(fmt.Sprintf("spill=%t", spill), func(t *testing.T) {
+			dir := t.TempDir()
+			f, err := os.Create(filepath.Join(dir, "detached.car"))
+			require.NoError(t, err)
+			t.Cleanup(func() { f.Close() })
+			idxPath := filepath.Join(dir, "detached.car.idx")
+			opts := []carv2.Option{carv2.WriteAsCarV1(true), carv2.WithDetachedIndexPath(idxPath)}
+			if spill {
+				opts = append(opts, carv2.WithIndexSpill(dir, 3))
+			}
+
+			subject, err := storage.NewWritable(f, []cid.Cid{}, opts...)
+			require.NoError(t, err)
+			var cids []cid.Cid
+			for i := 0; i < 10; i++ {
+				c, data := randBlock()
+				require.NoError(t, subject.Put(ctx, c.KeyString(), data))
+				cids = append(cids, c)
+			}
+			require.NoError(t, subject.Finalize())
+
+			idxFile, err := os.Open(idxPath)
+			require.NoError(t, err)
+			t.Cleanup(func() { idxFile.Close() })
+			idx, err := index.ReadFrom(idxFile)
+			require.NoError(t, err)
+			require.Equal(t, multicodec.CarMultihashIndexSorted, idx.Codec())
+			for _, c := range cids {
+				offset, err := index.GetFirst(idx, c)
+				require.NoError(t, err)
+				// The offset is that of the section of the block in the CARv1.
+				br, err := carv2.NewBlockReader(io.MultiReader(bytes.NewReader(emptyV1Header(t)), io.NewSectionReader(f, int64(offset), 1<<20)))
+				require.NoError(t, err)
+				blk, err := br.Next()
+				require.NoError(t, err)
+				require.Equal(t, c, blk.Cid())
+			}
+		})
+	}
+}
+
+func emptyV1Header(t *testing.T) []byte {
+	header, err := carv2.EncodeV1Header(1, []cid.Cid{})
+	require.NoError(t, err)
+	return header
+}
+
+func TestWritableIndexWithSize(t *testing.T) {
+	ctx := context.Background()
+	f, err := os.Create(filepath.Join(t.TempDir(), "sized.car"))
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	subject, err := storage.NewWritable(f, []cid.Cid{}, carv2.UseIndexCodec(index.CarMultihashIndexSortedWithSize))
+	require.NoError(t, err)
+	sizes := make(map[cid.Cid]uint64)
+	for i := 0; i < 10; i++ {
+		c, data := randBlock()
+		data = data[:i*50]
+		require.NoError(t, subject.Put(ctx, c.KeyString(), data))
+		sizes[c] = uint64(len(data))
+	}
+	require.NoError(t, subject.Finalize())
+
+	reader, err := carv2.NewReader(f)
+	require.NoError(t, err)
+	ir, err := reader.IndexReader()
+	require.NoError(t, err)
+	idx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	for c, want := range sizes {
+		var got uint64
+		require.NoError(t, idx.(index.SizedIndex).GetAllSized(c, func(_, size uint64) bool {
+			got = size
+			return false
+		}))
+		require.Equal(t, want, got)
+	}
+
+	// Spilled records do not hold sizes.
+	_, err = storage.NewWritable(f, []cid.Cid{}, carv2.UseIndexCodec(index.CarMultihashIndexSortedWithSize), carv2.WithIndexSpill(t.TempDir(), 2))
+	require.Error(t, err)
+}
+
+func TestWritableIndexSpillDiscard(t *testing.T) {
+	ctx := context.Background()
+	spillDir := t.TempDir()
+	requireSpillDirs := func(n int) {
+		entries, err := os.ReadDir(spillDir)
+		require.NoError(t, err)
+		require.Len(t, entries, n)
+	}
+
+	// A failed constructor releases the spill directory it created.
+	f, err := os.Create(filepath.Join(t.TempDir(), "discarded.car"))
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	_, err = storage.NewWritable(&failingWriterAt{File: f, limit: 0}, []cid.Cid{}, carv2.WithIndexSpill(spillDir, 2))
+	require.Error(t, err)
+	requireSpillDirs(0)
+
+	// As does discarding a writer abandoned after a failed Put.
+	subject, err := storage.NewWritable(&failingWriterAt{File: f, limit: 8 << 10}, []cid.Cid{}, carv2.WithIndexSpill(spillDir, 2))
+	require.NoError(t, err)
+	requireSpillDirs(1)
+	for err == nil {
+		c, data := randBlock()
+		err = subject.Put(ctx, c.KeyString(), data)
+	}
+	require.ErrorIs(t, err, errWriteLimit)
+	sc := subject.(*storage.StorageCar)
+	require.NoError(t, sc.Discard())
+	requireSpillDirs(0)
+	require.NoError(t, sc.Discard())
+	c, data := randBlock()
+	require.ErrorIs(t, sc.Put(ctx, c.KeyString(), data), storage.ErrClosed)
+	require.Error(t, sc.Finalize())
+}
+
+var errWriteLimit = errors.New("write limit reached")
+
+// failingWriterAt fails writes reaching past limit.
+type failingWriterAt struct {
+	*os.File
+	limit int64
+}
+
+func (w *failingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > w.limit {
+		return 0, errWriteLimit
+	}
+	return w.File.WriteAt(p, off)
+}
+
+func (w *failingWriterAt) Write(p []byte) (int, error) {
+	off, err := w.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if off+int64(len(p)) > w.limit {
+		return 0, errWriteLimit
+	}
+	return w.File.Write(p)
+}
+
+func TestIdentityCIDPolicy(t *testing.T) {
+	identityCid := func(data string) cid.Cid {
+		mh, err := multihash.Sum([]byte(data), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	stored := identityCid("stored")
+	missing := identityCid("missing")
+
+	check := func(t *testing.T, sc *storage.StorageCar, policy carv2.IdentityCIDPolicy) {
+		ctx := context.Background()
+		require.Equal(t, policy, sc.IdentityCIDPolicy())
+
+		has, err := sc.Has(ctx, stored.KeyString())
+		require.NoError(t, err)
+		require.True(t, has)
+		got, err := sc.Get(ctx, stored.KeyString())
+		require.NoError(t, err)
+		require.Equal(t, []byte("stored"), got)
+
+		has, err = sc.Has(ctx, missing.KeyString())
+		require.NoError(t, err)
+		got, err = sc.Get(ctx, missing.KeyString())
+		if policy == carv2.IdentityCIDsInlined {
+			require.True(t, has)
+			require.NoError(t, err)
+			require.Equal(t, []byte("missing"), got)
+		} else {
+			require.False(t, has)
+			require.True(t, errors.Is(err, storage.ErrNotFound{}))
+		}
+	}
+
+	for _, policy := range []carv2.IdentityCIDPolicy{carv2.IdentityCIDsInlined, carv2.IdentityCIDsStored} {
+		t.Run(policy.String(), func(t *testing.T) {
+			opt := carv2.StoreIdentityCIDs(policy == carv2.IdentityCIDsStored)
+			path := filepath.Join(t.TempDir(), "identity.car")
+			f, err := os.Create(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			rw, err := storage.NewReadableWritable(f, []cid.Cid{}, opt)
+			require.NoError(t, err)
+			require.NoError(t, rw.Put(context.Background(), stored.KeyString(), []byte("stored")))
+			check(t, rw, policy)
+			require.NoError(t, rw.Finalize())
+
+			reopen, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { reopen.Close() })
+			ro, err := storage.OpenReadable(reopen, opt)
+			require.NoError(t, err)
+			check(t, ro.(*storage.StorageCar), policy)
+		})
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range [][]carv2.Option{nil, {carv2.WriteAsCarV1(true)}} {
+		t.Run(fmt.Sprintf("v1=%t", len(opts) > 0), func(t *testing.T) {
+			base, err := os.Open("../testdata/sample-v1.car")
+			require.NoError(t, err)
+			t.Cleanup(func() { base.Close() })
+			baseCids := listCids(t, newV1ReaderFromV1File(t, "../testdata/sample-v1.car", false))
+			baseBlk, err := blockstore.NewReadOnly(base, nil)
+			require.NoError(t, err)
+			existing, err := baseBlk.Get(ctx, baseCids[3])
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			deltaFile, err := os.Create(filepath.Join(dir, "delta.car"))
+			require.NoError(t, err)
+			t.Cleanup(func() { deltaFile.Close() })
+			added := []string{"overlay block 1", "overlay block 2"}
+			var addedCids []cid.Cid
+			for _, data := range added {
+				mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+				require.NoError(t, err)
+				addedCids = append(addedCids, cid.NewCidV1(cid.Raw, mh))
+			}
+			subject, err := storage.NewOverlay(base, deltaFile, addedCids[:1], opts...)
+			require.NoError(t, err)
+			require.Equal(t, addedCids[:1], subject.Roots())
+
+			// Blocks already in the base are not written to the delta.
+			require.NoError(t, subject.Put(ctx, existing.Cid().KeyString(), existing.RawData()))
+			for i, data := range added {
+				require.NoError(t, subject.Put(ctx, addedCids[i].KeyString(), []byte(data)))
+			}
+			for _, c := range append([]cid.Cid{baseCids[0], existing.Cid()}, addedCids...) {
+				has, err := subject.Has(ctx, c.KeyString())
+				require.NoError(t, err)
+				require.True(t, has, c)
+				_, err = subject.Get(ctx, c.KeyString())
+				require.NoError(t, err)
+			}
+			got, err := subject.GetRange(ctx, addedCids[1].KeyString(), 8, 5)
+			require.NoError(t, err)
+			require.Equal(t, "block", string(got))
+			missingMh, err := multihash.Sum([]byte("missing"), multihash.SHA2_256, -1)
+			require.NoError(t, err)
+			_, err = subject.Get(ctx, cid.NewCidV1(cid.Raw, missingMh).KeyString())
+			require.True(t, storage.IsNotFound(err))
+
+			mergedFile, err := os.Create(filepath.Join(dir, "merged.car"))
+			require.NoError(t, err)
+			t.Cleanup(func() { mergedFile.Close() })
+			require.NoError(t, subject.FinalizeMerged(mergedFile))
+
+			// The delta only holds the blocks the base did not.
+			deltaReader, err := carv2.NewBlockReader(io.NewSectionReader(deltaFile, 0, 1<<40))
+			require.NoError(t, err)
+			require.Equal(t, addedCids[:1], deltaReader.Roots)
+			var deltaCids []cid.Cid
+			for {
+				blk, err := deltaReader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				deltaCids = append(deltaCids, blk.Cid())
+			}
+			require.Equal(t, addedCids, deltaCids)
+
+			// The merged CAR holds the blocks of both, without duplicates.
+			merged, err := storage.OpenReadable(io.NewSectionReader(mergedFile, 0, 1<<40))
+			require.NoError(t, err)
+			require.Equal(t, addedCids[:1], merged.Roots())
+			for _, c := range append(baseCids, addedCids...) {
+				has, err := merged.Has(ctx, c.KeyString())
+				require.NoError(t, err)
+				require.True(t, has, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Package traversal provides the IPLD LinkSystem wrappers used by the
+// selective CAR writers of this module, such that embedders can build their own
+// CAR streamers on top of a traversal and measure what they emit.
+//
+// Unlike the internal packages it exposes, this package follows the semantic
+// versioning of the module: its API only changes in a backwards-compatible
+// manner within v2.
+package traversal
+
+import (
+	"io"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/loader"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ReadCounter reports the number of bytes of CAR data accounted for by a link
+// system, that is the blocks it loaded as they would appear in a CAR payload,
+// including the varint length and CID of each section.
+type ReadCounter interface {
+	Size() uint64
+}
+
+// IndexTracker is a ReadCounter which also records the offsets of the sections
+// written, from which it builds an index of them.
+type IndexTracker interface {
+	ReadCounter
+
+	// Index returns an index of the sections written so far, in the codec the
+	// tracker was created with.
+	Index() (index.Index, error)
+}
+
+// CountingLinkSystem wraps ls such that the size of every block loaded from it,
+// as it would appear in a CAR payload, is added to the returned ReadCounter.
+func CountingLinkSystem(ls ipld.LinkSystem) (ipld.LinkSystem, ReadCounter) {
+	return loader.CountingLinkSystem(ls)
+}
+
+// TeeingLinkSystem wraps ls such that each block loaded from it is also written
+// to w as a CAR section, the first time it is loaded. The returned IndexTracker
+// reports the size of the payload written, starting from initialOffset, such as
+// the size of the CARv1 header written to w beforehand. The offsets recorded
+// for its index are likewise relative to the start of the payload.
+//
+// An indexCodec of index.CarIndexNone can be given if no index is needed, in
+// which case IndexTracker.Index returns an error. If non-nil, onBlock is called
+// each time a section has been written in full.
+//
+// The returned link system must not be used concurrently.
+func TeeingLinkSystem(ls ipld.LinkSystem, w io.Writer, initialOffset uint64, indexCodec multicodec.Code, onBlock func() error) (ipld.LinkSystem, IndexTracker) {
+	return loader.TeeingLinkSystem(ls, w, initialOffset, indexCodec, onBlock)
+}
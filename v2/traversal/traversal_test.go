@@ -0,0 +1,99 @@
+package traversal_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/traversal"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	ipldtraversal "github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeeingLinkSystem(t *testing.T) {
+	store := cidlink.Memory{Bag: make(map[string][]byte)}
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = store.OpenRead
+	ls.StorageWriteOpener = store.OpenWrite
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: uint64(multicodec.DagCbor), MhType: uint64(multicodec.Sha2_256), MhLength: -1}}
+	storeNode := func(build func(ma datamodel.MapAssembler)) cid.Cid {
+		n, err := qp.BuildMap(basicnode.Prototype.Any, -1, build)
+		require.NoError(t, err)
+		l, err := ls.Store(ipld.LinkContext{}, lp, n)
+		require.NoError(t, err)
+		return l.(cidlink.Link).Cid
+	}
+	leaf := storeNode(func(ma datamodel.MapAssembler) { qp.MapEntry(ma, "leaf", qp.Bool(true)) })
+	root := storeNode(func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "a", qp.Link(cidlink.Link{Cid: leaf}))
+		qp.MapEntry(ma, "b", qp.Link(cidlink.Link{Cid: leaf}))
+	})
+
+	// Write a CARv1 header followed by the blocks of a traversal.
+	var buf bytes.Buffer
+	header, err := carv2.EncodeV1Header(1, []cid.Cid{root})
+	require.NoError(t, err)
+	buf.Write(header)
+	headerSize := uint64(buf.Len())
+	var written int
+	tls, tracker := traversal.TeeingLinkSystem(ls, &buf, headerSize, multicodec.CarMultihashIndexSorted, func() error {
+		written++
+		return nil
+	})
+	cls, counter := traversal.CountingLinkSystem(tls)
+	walk(t, &cls, root)
+
+	require.Equal(t, 2, written)
+	require.Equal(t, uint64(buf.Len()), tracker.Size())
+	// The leaf is only counted once by the tee, but each time it is loaded by
+	// the counter.
+	require.Greater(t, counter.Size(), tracker.Size()-headerSize)
+
+	idx, err := tracker.Index()
+	require.NoError(t, err)
+	br, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{root}, br.Roots)
+	var got []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, blk.Cid())
+
+		offset, err := index.GetFirst(idx, blk.Cid())
+		require.NoError(t, err)
+		section, err := carv2.NewBlockReader(io.MultiReader(bytes.NewReader(buf.Bytes()[:headerSize]), bytes.NewReader(buf.Bytes()[offset:])))
+		require.NoError(t, err)
+		sblk, err := section.Next()
+		require.NoError(t, err)
+		require.Equal(t, blk.Cid(), sblk.Cid())
+	}
+	require.Equal(t, []cid.Cid{root, leaf}, got)
+}
+
+func walk(t *testing.T, ls *ipld.LinkSystem, root cid.Cid) {
+	sel, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	require.NoError(t, err)
+	n, err := ls.Load(ipld.LinkContext{Ctx: context.Background()}, cidlink.Link{Cid: root}, basicnode.Prototype.Any)
+	require.NoError(t, err)
+	progress := ipldtraversal.Progress{Cfg: &ipldtraversal.Config{
+		LinkSystem:                     *ls,
+		LinkTargetNodePrototypeChooser: basicnode.Chooser,
+	}}
+	require.NoError(t, progress.WalkAdv(n, sel, func(ipldtraversal.Progress, datamodel.Node, ipldtraversal.VisitReason) error { return nil }))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/index"
@@ -13,7 +14,8 @@ import (
 	internalio "github.com/ipld/go-car/v2/internal/io"
 )
 
-// ErrAlreadyV1 signals that the given payload is already in CARv1 format.
+// ErrAlreadyV1 signals that the given payload is already in CARv1 format. It is
+// returned by ExtractV1File.
 var ErrAlreadyV1 = errors.New("already a CARv1")
 
 // WrapV1File is a wrapper around WrapV1 that takes filesystem paths.
@@ -46,6 +48,137 @@ func WrapV1File(srcPath, dstPath string) error {
 	return nil
 }
 
+// WrapV1FileInPlace is like WrapV1File, but wraps the CARv1 file at path as a
+// CARv2 in place. The CARv2 is written as per WrapV1 to a temporary file
+// alongside path, which then replaces it, such that path holds either the CARv1
+// or the CARv2 should the wrapping be interrupted.
+//
+// With UseInsertRange, and where the filesystem supports inserting a range at
+// the start of a file, as ext4 and XFS do on Linux, a full copy of the CARv1
+// is avoided instead: the CARv2 header is written to a range inserted before
+// the CARv1, whose bytes are therefore left where they are on disk, and the
+// data payload is padded to the block size of the filesystem, which the range
+// must be a multiple of. This is not crash-safe; see UseInsertRange.
+func WrapV1FileInPlace(path string, opts ...Option) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version, err := ReadVersion(f, opts...)
+	if err != nil {
+		return err
+	}
+	if version != 1 {
+		return fmt.Errorf("source version must be 1; got: %d", version)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	o := ApplyOptions(opts...)
+	idx, err := index.New(o.IndexCodec)
+	if err != nil {
+		return err
+	}
+	if err := LoadIndex(idx, f, opts...); err != nil {
+		return err
+	}
+	v1Size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if !o.InsertRange {
+		return wrapV1FileByCopy(f, path, idx, v1Size)
+	}
+
+	// Write the index past the CARv1 before inserting the range, such that
+	// the file is left with a zero-filled start only for as long as it takes
+	// to write the pragma and header.
+	if _, err := index.WriteTo(idx, internalio.NewOffsetWriter(f, v1Size)); err != nil {
+		f.Truncate(v1Size)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Truncate(v1Size)
+		return err
+	}
+
+	dataOffset, err := internalio.PrependHole(f, PragmaSize+HeaderSize)
+	if err != nil {
+		// The range was not inserted, so the file still starts with the CARv1.
+		if terr := f.Truncate(v1Size); terr != nil {
+			return terr
+		}
+		if errors.Is(err, errors.ErrUnsupported) {
+			return wrapV1FileByCopy(f, path, idx, v1Size)
+		}
+		return err
+	}
+
+	v2Header := NewHeader(uint64(v1Size)).WithDataPadding(uint64(dataOffset) - PragmaSize - HeaderSize)
+	if _, err := f.WriteAt(Pragma, 0); err != nil {
+		return err
+	}
+	if _, err := v2Header.WriteTo(internalio.NewOffsetWriter(f, PragmaSize)); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// wrapV1FileByCopy wraps the CARv1 of the given size read from src, along with
+// its index, as a CARv2 written to a temporary file which then replaces the
+// file at path.
+func wrapV1FileByCopy(src *os.File, path string, idx index.Index, v1Size int64) (err error) {
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".wrap-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(dst.Name())
+		}
+	}()
+	if err := dst.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if _, err := dst.Write(Pragma); err != nil {
+		return err
+	}
+	if _, err := NewHeader(uint64(v1Size)).WriteTo(dst); err != nil {
+		return err
+	}
+	// Copy from the *os.File itself, allowing for copy_file_range on Linux.
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, io.LimitReader(src, v1Size)); err != nil {
+		return err
+	}
+	if _, err := index.WriteTo(idx, dst); err != nil {
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	// Files cannot be replaced while open on Windows.
+	src.Close()
+	return os.Rename(dst.Name(), path)
+}
+
 // WrapV1 takes a CARv1 file and wraps it as a CARv2 file with an index.
 // The resulting CARv2 file's inner CARv1 payload is left unmodified,
 // and does not use any padding before the innner CARv1 or index.
@@ -200,6 +333,24 @@ func ExtractV1File(srcPath, dstPath string, opts ...Option) (err error) {
 	return err
 }
 
+// WriteIndexOnly writes a CARv2 holding the given index and no data payload to
+// w, for indexes shipped separately from the data payload they refer to. As in
+// any CARv2, the offsets of the index are relative to the start of the CARv1
+// data payload.
+//
+// The resulting CARv2 can only be read with the AllowIndexOnly option set.
+func WriteIndexOnly(idx index.Index, w io.Writer) error {
+	header := NewHeader(0)
+	if _, err := w.Write(Pragma); err != nil {
+		return err
+	}
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+	_, err := index.WriteTo(idx, w)
+	return err
+}
+
 // AttachIndex attaches a given index to an existing CARv2 file at given path and offset.
 func AttachIndex(path string, idx index.Index, offset uint64) error {
 	// TODO: instead of offset, maybe take padding?
@@ -215,6 +366,93 @@ func AttachIndex(path string, idx index.Index, offset uint64) error {
 	return err
 }
 
+// RegenerateIndexInFile regenerates the index of the CARv2 file at path from
+// its data payload, and writes it in place of any index the file already has,
+// such as to index a CARv2 written without one, or to replace an index that is
+// suspected to be corrupt. The data payload is left untouched.
+//
+// The index is written with the codec set via UseIndexCodec, after the padding
+// set via UseIndexPadding, and the file is truncated to its end. As with
+// GenerateIndex, the StoreIdentityCIDs option determines whether the index is
+// full, as recorded in the characteristics of the header. If WithoutIndex is
+// set, the index is removed instead.
+//
+// The header is updated to hold no index while the index is written, such that
+// should this be interrupted, the CARv2 remains readable without one.
+func RegenerateIndexInFile(path string, opts ...Option) (err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Close file and override return error type if it is nil.
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	r, err := NewReader(f, opts...)
+	if err != nil {
+		return err
+	}
+	if r.Version != 2 {
+		return fmt.Errorf("cannot regenerate the index of a CARv1 in place; wrap it as a CARv2 instead")
+	}
+	header := r.Header
+
+	o := ApplyOptions(opts...)
+	var idx index.Index
+	if o.IndexCodec != index.CarIndexNone {
+		dr, err := r.DataReader()
+		if err != nil {
+			return err
+		}
+		if idx, err = index.New(o.IndexCodec); err != nil {
+			return err
+		}
+		if err := LoadIndex(idx, dr, opts...); err != nil {
+			return err
+		}
+	}
+
+	// Detach the existing index before overwriting it.
+	header.IndexOffset = 0
+	header.Characteristics.SetFullyIndexed(false)
+	if err := writeHeaderInFile(f, header); err != nil {
+		return err
+	}
+
+	end := int64(header.DataOffset + header.DataSize)
+	if idx != nil {
+		header.IndexOffset = header.DataOffset + header.DataSize + o.IndexPadding
+		header.Characteristics.SetFullyIndexed(o.StoreIdentityCIDs)
+		n, err := index.WriteTo(idx, internalio.NewOffsetWriter(f, int64(header.IndexOffset)))
+		if err != nil {
+			return err
+		}
+		end = int64(header.IndexOffset) + int64(n)
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	if idx != nil {
+		return writeHeaderInFile(f, header)
+	}
+	return nil
+}
+
+// writeHeaderInFile writes the given CARv2 header in place of the one in f,
+// making sure it reaches stable storage, since it describes the rest of f.
+func writeHeaderInFile(f *os.File, header Header) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if _, err := header.WriteTo(internalio.NewOffsetWriter(f, PragmaSize)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
 // ReplaceRootsInFile replaces the root CIDs in CAR file at given path with the given roots.
 // This function accepts both CARv1 and CARv2 files.
 //
@@ -287,6 +525,9 @@ func ReplaceRootsInFile(path string, roots []cid.Cid, opts ...Option) (err error
 		return err
 	}
 
+	if options.NormalizeRoots {
+		roots, _ = SortAndDedupeRoots(roots)
+	}
 	newHeader := &carv1.CarHeader{
 		Roots:   roots,
 		Version: 1,
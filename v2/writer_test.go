@@ -13,6 +13,7 @@ import (
 	"github.com/ipld/go-car/v2/index"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -67,6 +68,103 @@ func TestWrapV1(t *testing.T) {
 	require.NoError(t, sf.Close())
 }
 
+func TestWrapV1FileInPlace(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []car.Option
+	}{
+		{name: "ByCopy"},
+		{name: "InsertRange", opts: []car.Option{car.UseInsertRange(true)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			wantPayload, err := os.ReadFile("testdata/sample-v1.car")
+			require.NoError(t, err)
+			path := filepath.Join(t.TempDir(), "sample.car")
+			require.NoError(t, os.WriteFile(path, wantPayload, 0o644))
+
+			require.NoError(t, car.WrapV1FileInPlace(path, tt.opts...))
+
+			// Whether or not the payload was padded, it is left unmodified.
+			subject, err := car.OpenReader(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			require.Equal(t, uint64(2), subject.Version)
+			require.Equal(t, uint64(len(wantPayload)), subject.Header.DataSize)
+			dr, err := subject.DataReader()
+			require.NoError(t, err)
+			gotPayload, err := io.ReadAll(dr)
+			require.NoError(t, err)
+			require.Equal(t, wantPayload, gotPayload)
+
+			wantIdx, err := car.GenerateIndexFromFile("testdata/sample-v1.car")
+			require.NoError(t, err)
+			ir, err := subject.IndexReader()
+			require.NoError(t, err)
+			gotIdx, err := index.ReadFrom(ir)
+			require.NoError(t, err)
+			require.Equal(t, wantIdx, gotIdx)
+
+			// A CARv2 is not wrapped again.
+			require.EqualError(t, car.WrapV1FileInPlace(path, tt.opts...), "source version must be 1; got: 2")
+		})
+	}
+}
+
+func TestRegenerateIndexInFile(t *testing.T) {
+	want, err := os.ReadFile("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+
+	// An index is attached to an indexless CARv2.
+	path := requireTmpCopy(t, "testdata/sample-v2-indexless.car")
+	require.NoError(t, car.RegenerateIndexInFile(path))
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// A corrupt index is replaced.
+	subject, err := car.OpenReader(path)
+	require.NoError(t, err)
+	indexOffset := subject.Header.IndexOffset
+	require.NoError(t, subject.Close())
+	copy(got[indexOffset+8:], "not an index")
+	require.NoError(t, os.WriteFile(path, got, 0o644))
+	require.NoError(t, car.RegenerateIndexInFile(path))
+	got, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// The index is padded and written with the given codec, replacing a
+	// longer one.
+	require.NoError(t, car.RegenerateIndexInFile(path, car.UseIndexCodec(multicodec.CarIndexSorted), car.UseIndexPadding(42)))
+	require.NoError(t, car.RegenerateIndexInFile(path, car.UseIndexPadding(42)))
+	subject, err = car.OpenReader(path)
+	require.NoError(t, err)
+	require.Equal(t, indexOffset+42, subject.Header.IndexOffset)
+	ir, err := subject.IndexReader()
+	require.NoError(t, err)
+	gotIdx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	require.NoError(t, subject.Close())
+	wantIdx, err := car.GenerateIndexFromFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	require.Equal(t, wantIdx, gotIdx)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(want)+42), info.Size())
+
+	// The index is removed.
+	require.NoError(t, car.RegenerateIndexInFile(path, car.WithoutIndex()))
+	got, err = os.ReadFile(path)
+	require.NoError(t, err)
+	wantIndexless, err := os.ReadFile("testdata/sample-v2-indexless.car")
+	require.NoError(t, err)
+	require.Equal(t, wantIndexless, got)
+
+	// A CARv1 has no index to regenerate.
+	path = requireTmpCopy(t, "testdata/sample-v1.car")
+	require.Error(t, car.RegenerateIndexInFile(path))
+}
+
 func TestExtractV1(t *testing.T) {
 	// Produce a CARv1 file to test.
 	v1f, err := os.CreateTemp("", "example")